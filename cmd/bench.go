@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const benchUsage = `Usage: .bench "<sql1>" "<sql2>" N`
+
+// benchStats summarizes the durations of the runs of one statement.
+type benchStats struct {
+	Min, Avg, Max time.Duration
+}
+
+// computeBenchStats returns the minimum, average and maximum of durations,
+// which must not be empty.
+func computeBenchStats(durations []time.Duration) benchStats {
+	stats := benchStats{Min: durations[0], Max: durations[0]}
+	var total time.Duration
+	for _, d := range durations {
+		stats.Min = min(stats.Min, d)
+		stats.Max = max(stats.Max, d)
+		total += d
+	}
+	stats.Avg = total / time.Duration(len(durations))
+	return stats
+}
+
+// timeRuns executes sql n times and returns how long each run took.
+func timeRuns(execute func(string) string, sql string, n int) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		start := time.Now()
+		execute(sql)
+		durations[i] = time.Since(start)
+	}
+	return durations
+}
+
+// parseBenchArgs parses the arguments of .bench: two double-quoted
+// statements, in which \" stands for a double quote, and a run count.
+func parseBenchArgs(args string) (sql1, sql2 string, n int, err error) {
+	var sqls [2]string
+	for i := range sqls {
+		args = strings.TrimSpace(args)
+		quoted, qerr := strconv.QuotedPrefix(args)
+		if qerr != nil || quoted[0] != '"' {
+			return "", "", 0, errors.New(benchUsage)
+		}
+		sqls[i], _ = strconv.Unquote(quoted)
+		args = args[len(quoted):]
+	}
+	n, err = strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || n <= 0 {
+		return "", "", 0, errors.New(benchUsage + " (N must be a positive integer)")
+	}
+	return sqls[0], sqls[1], n, nil
+}
+
+// runBench runs .bench: each statement n times, reporting the minimum,
+// average and maximum duration of each and how their averages compare.
+// Side effects of the statements are not undone.
+func runBench(execute func(string) string, args string) string {
+	sql1, sql2, n, err := parseBenchArgs(args)
+	if err != nil {
+		return err.Error()
+	}
+	stats1 := computeBenchStats(timeRuns(execute, sql1, n))
+	stats2 := computeBenchStats(timeRuns(execute, sql2, n))
+
+	var sb strings.Builder
+	for i, s := range []benchStats{stats1, stats2} {
+		fmt.Fprintf(&sb, "%d: min %v  avg %v  max %v  (%d runs)\n", i+1, s.Min, s.Avg, s.Max, n)
+	}
+	switch {
+	case stats1.Avg == 0 || stats2.Avg == 0:
+		sb.WriteString("Too fast to compare; increase N")
+	case stats1.Avg <= stats2.Avg:
+		fmt.Fprintf(&sb, "1 is %.1fx faster on average", float64(stats2.Avg)/float64(stats1.Avg))
+	default:
+		fmt.Fprintf(&sb, "2 is %.1fx faster on average", float64(stats1.Avg)/float64(stats2.Avg))
+	}
+	return sb.String()
+}
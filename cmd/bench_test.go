@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeBenchStats(t *testing.T) {
+	durations := []time.Duration{3 * time.Millisecond, time.Millisecond, 8 * time.Millisecond}
+	expected := benchStats{Min: time.Millisecond, Avg: 4 * time.Millisecond, Max: 8 * time.Millisecond}
+	if stats := computeBenchStats(durations); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+
+	single := []time.Duration{5 * time.Microsecond}
+	if stats := computeBenchStats(single); stats != (benchStats{Min: single[0], Avg: single[0], Max: single[0]}) {
+		t.Errorf("Expected every statistic of a single run to be its duration, got %+v", stats)
+	}
+}
+
+func TestParseBenchArgs(t *testing.T) {
+	sql1, sql2, n, err := parseBenchArgs(` "SELECT * FROM t WHERE value = 'a b'"  "SELECT \"x\" FROM t" 20`)
+	if err != nil {
+		t.Fatalf("parseBenchArgs: %v", err)
+	}
+	if sql1 != `SELECT * FROM t WHERE value = 'a b'` || sql2 != `SELECT "x" FROM t` || n != 20 {
+		t.Errorf("Unexpected arguments %q, %q, %d", sql1, sql2, n)
+	}
+
+	for _, args := range []string{``, `"SELECT 1" 5`, `"a" "b"`, `"a" "b" 0`, `"a" "b" x`, `'a' 'b' 3`} {
+		if _, _, _, err := parseBenchArgs(args); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestRunBenchExecutesEachStatementNTimes(t *testing.T) {
+	runs := make(map[string]int)
+	execute := func(sql string) string {
+		runs[sql]++
+		return ""
+	}
+	runBench(execute, `"a" "b" 3`)
+	if runs["a"] != 3 || runs["b"] != 3 {
+		t.Errorf("Expected 3 runs of each statement, got %v", runs)
+	}
+}
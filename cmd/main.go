@@ -5,14 +5,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/chzyer/readline" // Import the readline library
 )
 
 func main() {
 	// Initialize your database engine
-	engine := db.NewEngine("data.log")
+	// Compact the log on exit so the next session starts with a short replay
+	engine := db.NewEngineWithOptions("data.log", db.EngineOptions{CompactOnClose: true})
+	shutdown := newShutdown(engine)
+	defer shutdown() // Flush the WAL and release its lock so other processes can open the database
+
+	// Flush and close the engine if the process is asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		fmt.Println("\nBye!")
+		shutdown()
+		os.Exit(0)
+	}()
 
 	fmt.Println("Welcome to TinyDB! Type 'QUIT' or 'EXIT' to exit.")
 
@@ -41,7 +58,7 @@ func main() {
 		}
 		if err == readline.ErrInterrupt { // Ctrl+C pressed
 			// Clear the current line and continue to the next prompt, or exit if pressed again
-			if line == "" { // If Ctrl+C is pressed on an empty line, exit
+			if line == "" { // If Ctrl+C is pressed on an empty line, exit (the deferred shutdown flushes the WAL)
 				fmt.Println("Bye!")
 				break
 			} else { // If Ctrl+C is pressed with text, clear the text but stay in loop
@@ -59,6 +76,12 @@ func main() {
 			continue
 		}
 
+		// Handle REPL meta-commands such as ".mode typed"
+		if strings.HasPrefix(input, ".") {
+			fmt.Println(handleMetaCommand(engine, input))
+			continue
+		}
+
 		// Handle exit commands
 		if strings.EqualFold(input, "QUIT") || strings.EqualFold(input, "EXIT") {
 			fmt.Println("Bye!")
@@ -70,3 +93,93 @@ func main() {
 		fmt.Println(result)
 	}
 }
+
+// handleMetaCommand runs a REPL-only command (one starting with ".") and
+// returns the text to print.
+func handleMetaCommand(engine *db.Engine, input string) string {
+	fields := strings.Fields(input)
+	switch strings.ToLower(fields[0]) {
+	case ".mode":
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "typed":
+				engine.SetTypedOutput(true)
+				return "Output mode: typed"
+			case "plain":
+				engine.SetTypedOutput(false)
+				return "Output mode: plain"
+			}
+		}
+		return "Usage: .mode typed|plain"
+	case ".width":
+		if len(fields) == 2 {
+			if width, err := strconv.Atoi(fields[1]); err == nil && width >= 0 {
+				engine.SetValueWidth(width)
+				if width == 0 {
+					return "Value width: unlimited"
+				}
+				return fmt.Sprintf("Value width: %d", width)
+			}
+		}
+		return "Usage: .width N (0 disables truncation)"
+	case ".stats":
+		if len(fields) == 2 {
+			stats, ok := engine.TableStats(fields[1])
+			if !ok {
+				return fmt.Sprintf("Table '%s' not found", fields[1])
+			}
+			return formatStats(fields[1], stats)
+		}
+		return "Usage: .stats TABLE"
+	case ".tree":
+		if len(fields) == 2 {
+			var out strings.Builder
+			if err := engine.PrintTree(fields[1], &out); err != nil {
+				return fmt.Sprintf("Table '%s' not found", fields[1])
+			}
+			return strings.TrimSuffix(out.String(), "\n")
+		}
+		return "Usage: .tree TABLE"
+	case ".bench":
+		return runBench(engine.Execute, input[len(fields[0]):])
+	default:
+		return fmt.Sprintf("Unknown command: %s", fields[0])
+	}
+}
+
+// formatStats renders the tree statistics of a table as a small report.
+func formatStats(table string, stats db.TreeStats) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Tree statistics for '%s':\n", table)
+	fmt.Fprintf(&sb, "  height:      %d\n", stats.Height)
+	fmt.Fprintf(&sb, "  depth:       %d\n", stats.Depth)
+	fmt.Fprintf(&sb, "  nodes:       %d\n", stats.Nodes)
+	fmt.Fprintf(&sb, "  leaves:      %d\n", stats.Leaves)
+	fmt.Fprintf(&sb, "  keys:        %d\n", stats.Keys)
+	fmt.Fprintf(&sb, "  fill factor: %.0f%%", stats.FillFactor*100)
+	return sb.String()
+}
+
+// flushCloser is the part of the engine needed to shut it down cleanly.
+type flushCloser interface {
+	Barrier() error
+	Close() error
+}
+
+// newShutdown returns a function that flushes the engine's WAL to disk and
+// closes it. The returned function may be called from several goroutines
+// (e.g. the signal handler and the deferred exit path); only the first call
+// has an effect.
+func newShutdown(engine flushCloser) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := engine.Barrier(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to flush WAL: %v\n", err)
+			}
+			if err := engine.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to close database: %v\n", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// countingEngine records how often the shutdown routine flushes and closes it.
+type countingEngine struct {
+	mu       sync.Mutex
+	barriers int
+	closes   int
+}
+
+func (c *countingEngine) Barrier() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closes > 0 {
+		panic("Barrier called after Close")
+	}
+	c.barriers++
+	return nil
+}
+
+func (c *countingEngine) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closes++
+	return nil
+}
+
+func TestShutdownFlushesAndClosesOnce(t *testing.T) {
+	engine := &countingEngine{}
+	shutdown := newShutdown(engine)
+
+	// The signal handler and the deferred exit path may race to shut down
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shutdown()
+		}()
+	}
+	wg.Wait()
+	shutdown()
+
+	if engine.barriers != 1 || engine.closes != 1 {
+		t.Errorf("Expected exactly one flush and one close, got %d flushes and %d closes", engine.barriers, engine.closes)
+	}
+}
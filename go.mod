@@ -2,6 +2,9 @@ module TinySQL
 
 go 1.24.3
 
-require github.com/chzyer/readline v1.5.1
+require (
+	github.com/chzyer/readline v1.5.1
+	golang.org/x/text v0.30.0
+)
 
 require golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5 // indirect
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pastEngine returns a read-only engine over the tables as they were at
+// sequence number seq of the log, rebuilt by replaying the log up to there
+// into fresh trees. It has no log and no transaction of its own, and shares
+// the engine's codecs to decode the stored values.
+func (e *Engine) pastEngine(seq int) (*Engine, error) {
+	tablesData, err := e.wal.ReplayUpTo(seq)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %v", err)
+	}
+	past := &Engine{
+		tables: make(map[string]*BPlusTree, len(tablesData)),
+		opts:   EngineOptions{Codecs: e.opts.Codecs},
+	}
+	for tableName, entries := range tablesData {
+		tree := NewBPlusTree()
+		for _, entry := range entries {
+			tree.Insert(entry[0], entry[1])
+		}
+		past.tables[tableName] = tree
+	}
+	return past, nil
+}
+
+// keyVersions lists the committed values key has had in table, oldest
+// first, one "<sequence>: <value>" line each. A deletion, or a drop of the
+// table, shows as "(deleted)". Changes a transaction has not committed yet
+// are not part of the history.
+func (e *Engine) keyVersions(key, table string) string {
+	if isReservedTable(table) {
+		return fmt.Sprintf("Table '%s' not found", table)
+	}
+	versions, err := e.wal.KeyVersions(table, key)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	if len(versions) == 0 {
+		return "No results"
+	}
+	lines := make([]string, len(versions))
+	for i, v := range versions {
+		value := "(deleted)"
+		if !v.Deleted {
+			value = e.decodeValue(table, v.Value)
+		}
+		lines[i] = fmt.Sprintf("%d: %s", v.Seq, value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// selectAsOf answers a SELECT ... AS OF SEQUENCE n from the state of the
+// tables at sequence n. Views are resolved as they were then, too.
+func (e *Engine) selectAsOf(s *SelectStatement) string {
+	past, err := e.pastEngine(s.AsOf)
+	if err != nil {
+		return err.Error()
+	}
+	present := *s
+	present.AsOf = 0
+	return past.executeSelect(&present)
+}
+
+// selectRowsAsOf is selectRows for a SELECT ... AS OF SEQUENCE n.
+func (e *Engine) selectRowsAsOf(s *SelectStatement) ([]resultRow, error) {
+	past, err := e.pastEngine(s.AsOf)
+	if err != nil {
+		return nil, err
+	}
+	present := *s
+	present.AsOf = 0
+	return past.selectRows(&present)
+}
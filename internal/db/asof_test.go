@@ -0,0 +1,103 @@
+package db
+
+import "testing"
+
+func TestSelectAsOfSequence(t *testing.T) {
+	opts := EngineOptions{NewTxID: sequentialTxIDs()}
+	e := setupTestEngineWithOptions(t, opts)
+	// Log records: 1 SET t a 1, 2 SET t b 2, 3 SET t a 10,
+	// 4 BEGIN_TX tx_1, 5 DELETE tx_1 t b, 6 COMMIT_TX tx_1
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	e.Execute(`UPDATE t SET (a, 10)`)
+	e.Execute(`BEGIN`)
+	e.Execute(`DELETE b FROM t`)
+	e.Execute(`COMMIT`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`SELECT * FROM t`, "a: 10"},
+		{`SELECT * FROM t AS OF SEQUENCE 1`, "a: 1"},
+		{`SELECT * FROM t AS OF SEQUENCE 2`, "a: 1\nb: 2"},
+		{`SELECT * FROM t AS OF SEQUENCE 3`, "a: 10\nb: 2"},
+		{`SELECT * FROM t AS OF SEQUENCE 5`, "a: 10\nb: 2"}, // the delete is not committed yet
+		{`SELECT * FROM t AS OF SEQUENCE 6`, "a: 10"},
+		{`SELECT a FROM t WHERE value = '1' AS OF SEQUENCE 2`, "a: 1"},
+		{`SELECT COUNT(*) FROM t AS OF SEQUENCE 2`, "count: 2"},
+		{`SELECT * FROM t AS OF SEQUENCE 7`, "Error: sequence 7 is beyond the end of the log (6 records)"},
+		{`SELECT * FROM other AS OF SEQUENCE 2`, "Table 'other' not found"},
+		{`SELECT * FROM t AS OF SEQUENCE 0`, `Parse error: invalid SELECT syntax: AS OF SEQUENCE must be a positive integer, got "0"`},
+		{`SELECT * FROM t AS OF 2`, "Parse error: invalid SELECT syntax: expected AS OF SEQUENCE <n>"},
+		{`EXPLAIN SELECT * FROM t AS OF SEQUENCE 2`, "REPLAY LOG TO SEQUENCE 2\nFULL SCAN t"},
+		{`DECLARE c CURSOR FOR SELECT * FROM t AS OF SEQUENCE 2`, "Error: a cursor reads the current tables, not AS OF SEQUENCE"},
+		{`INSERT INTO restored SELECT * FROM t AS OF SEQUENCE 2`, "Inserted 2 key(s) into table 'restored'"},
+		{`SELECT * FROM restored`, "a: 1\nb: 2"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectAsOfSequenceDecodesValues(t *testing.T) {
+	opts := EngineOptions{Codecs: map[string]Codec{"docs": GzipCodec{Threshold: 4}}}
+	e := setupTestEngineWithOptions(t, opts)
+	e.Execute(`INSERT (readme, first_long_document) INTO docs`)
+	e.Execute(`UPDATE docs SET (readme, second_long_document)`)
+
+	cmd := `SELECT * FROM docs AS OF SEQUENCE 1`
+	if resp, expected := e.Execute(cmd), "readme: first_long_document"; resp != expected {
+		t.Errorf("%s:\nexpected %q\ngot      %q", cmd, expected, resp)
+	}
+}
+
+func TestSelectVersions(t *testing.T) {
+	opts := EngineOptions{NewTxID: sequentialTxIDs()}
+	e := setupTestEngineWithOptions(t, opts)
+	// Log records: 1 SET t k v1, 2 SET t other x, 3 SET t k v2,
+	// 4 BEGIN_TX tx_1, 5 SET tx_1 t k v3, 6 COMMIT_TX tx_1,
+	// 7 BEGIN_TX tx_2, 8 ROLLBACK_TX tx_2, 9 DELETE t k, 10 SET t k v4
+	e.Execute(`INSERT (k, v1), (other, x) INTO t`)
+	e.Execute(`UPDATE t SET (k, v2)`)
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE t SET (k, v3)`)
+	e.Execute(`COMMIT`)
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE t SET (k, lost)`)
+	e.Execute(`ROLLBACK`)
+	e.Execute(`DELETE k FROM t`)
+	e.Execute(`DELETE k FROM t`)
+	e.Execute(`INSERT (k, v4) INTO t`)
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE t SET (k, pending)`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`SELECT VERSIONS OF 'k' IN t`, "1: v1\n3: v2\n6: v3\n9: (deleted)\n10: v4"},
+		{`SELECT VERSIONS OF other IN t`, "2: x"},
+		{`SELECT VERSIONS OF 'missing' IN t`, "No results"},
+		{`SELECT * FROM t AS OF SEQUENCE 6`, "k: v3\nother: x"},
+		{`SELECT VERSIONS OF 'k' t`, "Parse error: invalid SELECT VERSIONS syntax: expected 'SELECT VERSIONS OF '<key>' IN <table_name>'"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	// The history survives a restart; the open transaction was rolled back
+	// (11, 12) and a dropped table (13) ends every key's history
+	e.Execute(`ROLLBACK`)
+	e.Close()
+	restarted := NewEngineWithOptions("test_wal.log", opts)
+	defer restarted.Close()
+	restarted.Execute(`DROP t`)
+	cmd := `SELECT VERSIONS OF 'k' IN t`
+	if resp, expected := restarted.Execute(cmd), "1: v1\n3: v2\n6: v3\n9: (deleted)\n10: v4\n13: (deleted)"; resp != expected {
+		t.Errorf("%s:\nexpected %q\ngot      %q", cmd, expected, resp)
+	}
+}
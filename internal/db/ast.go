@@ -13,16 +13,140 @@ type KeyValue struct {
 type InsertStatement struct {
 	Table  string
 	Values []KeyValue
+	Source *SelectStatement // INSERT INTO <table> SELECT ...; Values are filled from its rows
 }
 
 func (s *InsertStatement) StmtType() string {
 	return "INSERT"
 }
 
+// --- APPEND STATEMENT ---
+// APPEND (key, suffix), ... IN <table> concatenates each suffix to the
+// current value of its key, creating keys that do not exist yet.
+type AppendStatement struct {
+	Table  string
+	Values []KeyValue // key -> suffix
+}
+
+func (s *AppendStatement) StmtType() string { return "APPEND" }
+
+// --- INCR STATEMENT ---
+// INCR <key> IN <table> [BY n] and DECR <key> IN <table> [BY n] add By to
+// the integer value of Key; DECR is parsed with By negated.
+type IncrStatement struct {
+	Table string
+	Key   string
+	By    int64
+	Decr  bool // written as DECR
+}
+
+func (s *IncrStatement) StmtType() string {
+	if s.Decr {
+		return "DECR"
+	}
+	return "INCR"
+}
+
+// --- SWAP STATEMENT ---
+// SWAP <key1> <key2> IN <table> exchanges the values of two existing keys.
+type SwapStatement struct {
+	Table string
+	Key1  string
+	Key2  string
+}
+
+func (s *SwapStatement) StmtType() string { return "SWAP" }
+
+// --- MOVE STATEMENT ---
+// MOVE <key> FROM <table> TO <table> [OVERWRITE] deletes Key from From and
+// inserts it with its value into To. Without Overwrite the key must not
+// exist in To yet.
+type MoveStatement struct {
+	Key       string
+	From      string
+	To        string
+	Overwrite bool
+}
+
+func (s *MoveStatement) StmtType() string { return "MOVE" }
+
 // --- SELECT STATEMENT ---
 type SelectStatement struct {
+	Table  string
+	Keys   []string
+	Where  *Predicate // optional WHERE filter
+	Format string     // output format: "" for key/value lines, or "JSON"
+
+	// PrefixSep is set by SELECT COUNT(DISTINCT PREFIX '<sep>'): instead of
+	// rows, the result is the number of distinct key prefixes before the
+	// first occurrence of PrefixSep.
+	PrefixSep string
+
+	// Aggregate is set by SELECT COUNT(*), SUM(value) or AVG(value) to
+	// "COUNT", "SUM" or "AVG": the result is that single number over the
+	// matched rows instead of the rows. SELECT LCP sets it to "LCP": the
+	// result is the longest common prefix of the matched keys. SELECT
+	// BUCKETS n sets it to "BUCKETS" and Buckets to n: the matched keys are
+	// split into n ranges of about the same number of keys.
+	Aggregate string
+	Buckets   int
+
+	// DistinctOnValue is set by SELECT DISTINCT ON value: only the first row
+	// (in result order) for each distinct value is returned.
+	DistinctOnValue bool
+
+	// ValueFunc is the scalar function applied to every returned value by
+	// SELECT <func>(value): "UPPER", "LOWER", "LENGTH" or "TRIM". Empty
+	// returns values as stored.
+	ValueFunc string
+
+	// Segment is set by SELECT SEGMENT n OF key [DELIMITER '<sep>']: every
+	// returned value is replaced by the nth (1-based) segment of its key,
+	// split on SegmentSep, or "" if the key has fewer segments.
+	Segment    int
+	SegmentSep string
+
+	// Every is set by SELECT ... EVERY n: only every nth matched row is
+	// returned, starting with the first, to downsample ordered data. Zero
+	// returns every row.
+	Every int
+
+	// After is set by SELECT ... AFTER '<key>' for keyset pagination: only
+	// keys strictly greater than After are returned. Empty means no bound.
+	After string
+
+	// Limit is set by SELECT ... LIMIT n: at most n rows are returned. Zero
+	// means no limit.
+	Limit int
+
+	// Top is set by SELECT TOP n BY value [ASC|DESC]: only the n rows with
+	// the largest values (or with TopAsc the smallest) are returned, ordered
+	// by value. Zero means rows are returned in key order.
+	Top    int
+	TopAsc bool
+
+	// AsOf is set by SELECT ... AS OF SEQUENCE n: the query reads the tables
+	// as they were after the first n records of the log. Zero reads the
+	// current tables.
+	AsOf int
+}
+
+// --- SELECT VERSIONS STATEMENT ---
+// SELECT VERSIONS OF '<key>' IN <table> lists every committed change to Key
+// recorded in the log, oldest first, with its sequence number.
+type VersionsStatement struct {
+	Key   string
 	Table string
-	Keys  []string
+}
+
+func (s *VersionsStatement) StmtType() string { return "SELECT VERSIONS" }
+
+// Predicate is a single WHERE condition on a row's key or value.
+type Predicate struct {
+	Field   string // "KEY" or "VALUE"
+	Op      string // "=", "LIKE", "REGEXP", "STARTS WITH", "ENDS WITH", "BETWEEN", "IS EMPTY", "IS NOT EMPTY", "IS DUPLICATED" (no Operand) or "EXISTS IN" (Operand is a table)
+	Operand string
+	High    string // upper bound of BETWEEN, whose lower bound is Operand; both are inclusive
 }
 
 func (s *SelectStatement) StmtType() string {
@@ -31,8 +155,9 @@ func (s *SelectStatement) StmtType() string {
 
 // --- DELETE STATEMENT ---
 type DeleteStatement struct {
-	Table string
-	Keys  []string
+	Table     string
+	Keys      []string
+	Returning bool // DELETE ... RETURNING: list the keys deleted and those not found
 }
 
 func (s *DeleteStatement) StmtType() string {
@@ -41,7 +166,8 @@ func (s *DeleteStatement) StmtType() string {
 
 // --- DROP STATEMENT ---
 type DropStatement struct {
-	Table string
+	Table   string
+	Cascade bool // DROP t CASCADE: also drop the views that read from t
 }
 
 func (s *DropStatement) StmtType() string {
@@ -52,6 +178,11 @@ func (s *DropStatement) StmtType() string {
 type UpdateStatement struct {
 	Table  string
 	Values []KeyValue
+
+	// Expected is set by UPDATE ... IF (key, value), ...: the update is
+	// applied only if every key currently has its expected value, and
+	// otherwise changes nothing.
+	Expected map[string]string
 }
 
 func (s *UpdateStatement) StmtType() string {
@@ -64,7 +195,11 @@ type BeginStatement struct{}
 func (s *BeginStatement) StmtType() string { return "BEGIN" }
 
 // --- COMMIT STATEMENT ---
-type CommitStatement struct{}
+// TxID is optional. When set, it names the transaction the client expects to
+// commit, which lets a retried COMMIT be recognized as already applied.
+type CommitStatement struct {
+	TxID string
+}
 
 func (s *CommitStatement) StmtType() string { return "COMMIT" }
 
@@ -73,7 +208,160 @@ type RollbackStatement struct{}
 
 func (s *RollbackStatement) StmtType() string { return "ROLLBACK" }
 
+// --- PREPARE TRANSACTION / COMMIT PREPARED / ROLLBACK PREPARED STATEMENTS ---
+// PREPARE TRANSACTION '<gid>' makes the current transaction durable without
+// committing it, for two-phase commit; COMMIT PREPARED and ROLLBACK PREPARED
+// finish it later, possibly after a restart.
+type PrepareStatement struct {
+	GID string
+}
+
+func (s *PrepareStatement) StmtType() string { return "PREPARE TRANSACTION" }
+
+type CommitPreparedStatement struct {
+	GID string
+}
+
+func (s *CommitPreparedStatement) StmtType() string { return "COMMIT PREPARED" }
+
+type RollbackPreparedStatement struct {
+	GID string
+}
+
+func (s *RollbackPreparedStatement) StmtType() string { return "ROLLBACK PREPARED" }
+
 // --- SHOW TABLES STATEMENT ---
 type ShowTablesStatement struct{}
 
 func (s *ShowTablesStatement) StmtType() string { return "SHOW TABLES" }
+
+// --- SHOW TABLE SIZES STATEMENT ---
+type ShowTableSizesStatement struct{}
+
+func (s *ShowTableSizesStatement) StmtType() string { return "SHOW TABLE SIZES" }
+
+// --- NEXTVAL STATEMENT ---
+type NextValStatement struct {
+	Sequence string
+}
+
+func (s *NextValStatement) StmtType() string { return "NEXTVAL" }
+
+// --- ANALYZE STATEMENT ---
+type AnalyzeStatement struct{}
+
+func (s *AnalyzeStatement) StmtType() string { return "ANALYZE" }
+
+// --- SYNC STATEMENT ---
+type SyncStatement struct{}
+
+func (s *SyncStatement) StmtType() string { return "SYNC" }
+
+// --- CREATE TABLE STATEMENT ---
+type CreateTableStatement struct {
+	Table string
+}
+
+func (s *CreateTableStatement) StmtType() string { return "CREATE TABLE" }
+
+// --- CREATE VIEW STATEMENT ---
+type CreateViewStatement struct {
+	Name       string
+	Query      *SelectStatement
+	Definition string // the SELECT text, stored so the view can be re-parsed after a restart
+}
+
+func (s *CreateViewStatement) StmtType() string { return "CREATE VIEW" }
+
+// --- DROP VIEW STATEMENT ---
+type DropViewStatement struct {
+	Name string
+}
+
+func (s *DropViewStatement) StmtType() string { return "DROP VIEW" }
+
+// --- EXPLAIN STATEMENT ---
+type ExplainStatement struct {
+	Query *SelectStatement
+}
+
+func (s *ExplainStatement) StmtType() string { return "EXPLAIN" }
+
+// --- EXPLAIN KEY STATEMENT ---
+// EXPLAIN KEY '<key>' IN <table> shows the B+ tree nodes a lookup of Key visits.
+type ExplainKeyStatement struct {
+	Key   string
+	Table string
+}
+
+func (s *ExplainKeyStatement) StmtType() string { return "EXPLAIN KEY" }
+
+// --- EXPLAIN COMMIT STATEMENT ---
+// EXPLAIN COMMIT summarizes what COMMIT would apply, without committing.
+type ExplainCommitStatement struct{}
+
+func (s *ExplainCommitStatement) StmtType() string { return "EXPLAIN COMMIT" }
+
+// --- RUN STATEMENT ---
+// RUN '<file>' [ATOMIC] executes the statements of a script file. With
+// Atomic, they run in one transaction that is rolled back if any fails.
+type RunStatement struct {
+	Path   string
+	Atomic bool
+}
+
+func (s *RunStatement) StmtType() string { return "RUN" }
+
+// --- DECLARE / FETCH / CLOSE STATEMENTS ---
+// DeclareCursorStatement opens a named cursor over the rows of Query, which
+// FetchStatement then returns Count at a time until CloseCursorStatement.
+type DeclareCursorStatement struct {
+	Name  string
+	Query *SelectStatement
+}
+
+func (s *DeclareCursorStatement) StmtType() string { return "DECLARE" }
+
+type FetchStatement struct {
+	Name  string
+	Count int
+}
+
+func (s *FetchStatement) StmtType() string { return "FETCH" }
+
+type CloseCursorStatement struct {
+	Name string
+}
+
+func (s *CloseCursorStatement) StmtType() string { return "CLOSE" }
+
+// --- CUSTOM STATEMENT ---
+// CustomStatement invokes a command registered with Engine.RegisterCommand.
+// Keyword is upper-case; Args are the statement's remaining tokens.
+type CustomStatement struct {
+	Keyword string
+	Args    []string
+}
+
+func (s *CustomStatement) StmtType() string { return s.Keyword }
+
+// --- VACUUM STATEMENT ---
+// VACUUM removes the records of transactions that never committed from the log.
+type VacuumStatement struct{}
+
+func (s *VacuumStatement) StmtType() string { return "VACUUM" }
+
+// --- REORGANIZE STATEMENT ---
+type ReorganizeStatement struct {
+	Table string
+}
+
+func (s *ReorganizeStatement) StmtType() string { return "REORGANIZE" }
+
+// --- GENERATE STATEMENT ---
+type GenerateStatement struct {
+	Count int
+	Table string
+}
+
+func (s *GenerateStatement) StmtType() string { return "GENERATE" }
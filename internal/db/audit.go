@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time      string `json:"time"`
+	Statement string `json:"statement"`
+	Status    string `json:"status"`           // "ok" or "error"
+	Result    string `json:"result,omitempty"` // the response; left out when redacting
+}
+
+// audit writes a record of an Execute call to the AuditWriter option: the
+// statement as given, when it ran and what it returned. stmt is nil if cmd
+// did not parse. The engine has no sessions, so none is recorded. Write
+// errors are ignored; the audit log never fails a statement.
+func (e *Engine) audit(cmd string, stmt Statement, resp string) {
+	record := auditRecord{
+		Time:      e.opts.Now().UTC().Format(time.RFC3339Nano),
+		Statement: strings.TrimSpace(cmd),
+		Status:    "ok",
+		Result:    resp,
+	}
+	if stmt == nil || statementFailed(stmt, resp) {
+		record.Status = "error"
+	}
+	if e.opts.AuditRedactValues {
+		record.Statement = redactValues(record.Statement)
+		record.Result = ""
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	_, _ = e.opts.AuditWriter.Write(append(line, '\n'))
+}
+
+// redactValues returns cmd with the values it carries replaced by "?": the
+// second element of every (key, value) pair and the operand after =, LIKE,
+// REGEXP, STARTS/ENDS WITH and both bounds of BETWEEN ... AND. It works on
+// tokens, so it also redacts statements that fail to parse; the result is
+// re-joined with normalized spacing.
+func redactValues(cmd string) string {
+	tokens := tokenize(escapeReplacer.Replace(cmd))
+	var sb strings.Builder
+	inPair, inValue, redactNext := false, false, false
+	for i, tok := range tokens {
+		switch {
+		case tok == "(":
+			inPair, inValue = true, false
+		case tok == ")":
+			inPair, inValue = false, false
+		case tok == "," && inPair && !inValue:
+			inValue = true
+		case inValue || redactNext:
+			if inValue && tokens[i-1] != "," {
+				continue // A value spread over several tokens is replaced once
+			}
+			tok = "?"
+			redactNext = false
+		}
+		if sb.Len() > 0 && tok != ")" && tok != "," && tokens[max(i-1, 0)] != "(" {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(reescapeReplacer.Replace(tok))
+		switch strings.ToUpper(tok) {
+		case "=", "LIKE", "REGEXP", "WITH", "BETWEEN", "AND":
+			redactNext = true
+		}
+	}
+	return sb.String()
+}
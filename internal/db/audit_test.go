@@ -0,0 +1,56 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var audit bytes.Buffer
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := setupTestEngineWithOptions(t, EngineOptions{AuditWriter: &audit, Now: func() time.Time { return now }})
+
+	e.Execute(`INSERT (a, secret) INTO t`)
+	e.Execute(`SELEC * FROM t`)
+	e.Execute(`SELECT * FROM t;`)
+	e.Execute(`COMMIT`)
+
+	expected := []string{
+		`{"time":"2024-05-01T12:00:00Z","statement":"INSERT (a, secret) INTO t","status":"ok","result":"Inserted 1 key(s) into table 't'"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"SELEC * FROM t","status":"error","result":"Parse error: unsupported statement: SELEC"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"SELECT * FROM t;","status":"ok","result":"a: secret"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"COMMIT","status":"error","result":"Error: No active transaction to commit."}`,
+	}
+	if got := audit.String(); got != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("expected audit log:\n%s\ngot:\n%s", strings.Join(expected, "\n"), got)
+	}
+}
+
+func TestAuditLogRedactsValues(t *testing.T) {
+	var audit bytes.Buffer
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := setupTestEngineWithOptions(t, EngineOptions{AuditWriter: &audit, AuditRedactValues: true, Now: func() time.Time { return now }})
+
+	e.Execute(`INSERT (a, secret), (b, 'two words') INTO t`)
+	e.Execute(`UPDATE t SET (a, other)`)
+	e.Execute(`SELECT * FROM t WHERE value = secret`)
+	e.Execute(`SELECT * FROM t WHERE value BETWEEN secret AND secret2`)
+	e.Execute(`INSERT (c, oops INTO t`)
+
+	expected := []string{
+		`{"time":"2024-05-01T12:00:00Z","statement":"INSERT (a, ?), (b, ?) INTO t","status":"ok"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"UPDATE t SET (a, ?)","status":"ok"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"SELECT * FROM t WHERE value = ?","status":"ok"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"SELECT * FROM t WHERE value BETWEEN ? AND ?","status":"ok"}`,
+		`{"time":"2024-05-01T12:00:00Z","statement":"INSERT (c, ?","status":"error"}`,
+	}
+	got := audit.String()
+	if got != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("expected audit log:\n%s\ngot:\n%s", strings.Join(expected, "\n"), got)
+	}
+	if strings.Contains(got, "secret") || strings.Contains(got, "two") || strings.Contains(got, "oops") {
+		t.Errorf("Expected values to be redacted, got:\n%s", got)
+	}
+}
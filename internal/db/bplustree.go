@@ -1,14 +1,30 @@
 package db
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
 
-const ORDER = 4 // B+ Tree order - max children per internal node
+const ORDER = 4 // Default B+ Tree order - max children per internal node
 
 // Minimum number of keys for a node to be valid (not underflowing)
-const MIN_KEYS = (ORDER / 2) - 1 // For ORDER=4, MIN_KEYS = 1
+const MIN_KEYS = (ORDER+1)/2 - 1 // For ORDER=4, MIN_KEYS = 1
+
+// minKeys returns the minimum number of keys of a non-root node in a tree of
+// the given order: half full, rounded up, as in MIN_KEYS.
+func minKeys(order int) int {
+	return (order+1)/2 - 1
+}
 
 type BPlusTree struct {
-	root *BPlusTreeNode
+	root              *BPlusTreeNode
+	prefixCompression bool
+	pool              *nodePool // recycles nodes freed by merges; nil disables pooling
+	appendSplits      bool      // split the rightmost leaf append-style; see NewBPlusTreeWithAppendSplits
+	order             int       // max children per internal node; see NewBPlusTreeWithOrder
 }
 
 type BPlusTreeNode struct {
@@ -17,16 +33,139 @@ type BPlusTreeNode struct {
 	children []*BPlusTreeNode // for internal nodes
 	values   []string         // for leaf nodes
 	next     *BPlusTreeNode   // leaf node chaining
+	size     int              // keys in the subtree, for internal nodes; see subtreeSize
+
+	// Leaf key prefix compression. When compress is set, prefix holds the
+	// common prefix of all keys in the leaf and keys holds only the suffixes.
+	// An uncompressed leaf always has an empty prefix.
+	compress bool
+	prefix   string
 }
 
 func NewBPlusTree() *BPlusTree {
+	t := &BPlusTree{order: ORDER}
+	t.root = t.newLeaf()
+	return t
+}
+
+// NewBPlusTreeWithOrder returns a tree whose internal nodes hold up to order
+// children and whose nodes hold up to order-1 keys, instead of ORDER. A
+// higher order gives a shallower tree with wider nodes. It panics if order is
+// less than 3, the smallest order a node can split at.
+func NewBPlusTreeWithOrder(order int) *BPlusTree {
+	if order < 3 {
+		panic(fmt.Sprintf("bplustree: order %d is less than 3", order))
+	}
+	t := &BPlusTree{order: order}
+	t.root = t.newLeaf()
+	return t
+}
+
+// NewBPlusTreeWithPrefixCompression returns a tree whose leaves store the
+// common prefix of their keys once. This saves memory for key spaces with long
+// shared prefixes (e.g. "com.example.service.metric.*"); keys are reconstructed
+// transparently by Get, Update, Delete and RangeQuery. The saving grows with the
+// number of keys per leaf; internal separator keys are still stored in full.
+func NewBPlusTreeWithPrefixCompression() *BPlusTree {
+	t := &BPlusTree{prefixCompression: true, order: ORDER}
+	t.root = t.newLeaf()
+	return t
+}
+
+// NewBPlusTreeWithNodePool returns a tree that keeps nodes freed by merges
+// and root collapses on a free list and reuses them for splits, instead of
+// leaving them to the garbage collector. This cuts allocations for churny
+// insert/delete workloads.
+func NewBPlusTreeWithNodePool() *BPlusTree {
+	t := &BPlusTree{pool: &nodePool{}, order: ORDER}
+	t.root = t.newLeaf()
+	return t
+}
+
+// NewBPlusTreeWithAppendSplits returns a tree optimized for keys inserted in
+// increasing order. When a key goes past the end of the rightmost leaf and
+// the leaf overflows, the leaf keeps ORDER-1 keys and the new key starts a
+// fresh leaf, instead of splitting in half. Sequential loads then leave full
+// leaves behind rather than half-empty ones, with fewer splits; all other
+// inserts split as usual.
+func NewBPlusTreeWithAppendSplits() *BPlusTree {
+	t := &BPlusTree{appendSplits: true, order: ORDER}
+	t.root = t.newLeaf()
+	return t
+}
+
+// newLeaf returns an empty leaf configured for this tree.
+func (t *BPlusTree) newLeaf() *BPlusTreeNode {
+	n := t.pool.get(true, t.order)
+	n.compress = t.prefixCompression
+	return n
+}
+
+// maxPooledNodes bounds each free list, so a tree that shrinks a lot does not
+// hold on to all of its former nodes.
+const maxPooledNodes = 1024
+
+// nodePool is a free list of leaf and internal nodes. A nil *nodePool is
+// valid: get allocates fresh nodes and put discards them.
+type nodePool struct {
+	leaves   []*BPlusTreeNode
+	internal []*BPlusTreeNode
+}
+
+// get returns an empty leaf or internal node, reusing a pooled one if possible.
+// Fresh nodes are sized for a tree of the given order.
+func (p *nodePool) get(leaf bool, order int) *BPlusTreeNode {
+	if p != nil {
+		free := &p.internal
+		if leaf {
+			free = &p.leaves
+		}
+		if k := len(*free); k > 0 {
+			n := (*free)[k-1]
+			(*free)[k-1] = nil
+			*free = (*free)[:k-1]
+			return n
+		}
+	}
 	// Initialize slices to avoid nil panics later
-	leaf := &BPlusTreeNode{
-		isLeaf: true,
-		keys:   make([]string, 0, ORDER-1), // Pre-allocate capacity
-		values: make([]string, 0, ORDER-1), // Pre-allocate capacity
+	if leaf {
+		return &BPlusTreeNode{
+			isLeaf: true,
+			keys:   make([]string, 0, order-1), // Pre-allocate capacity
+			values: make([]string, 0, order-1), // Pre-allocate capacity
+		}
+	}
+	return &BPlusTreeNode{
+		isLeaf:   false,
+		keys:     make([]string, 0, order-1),
+		children: make([]*BPlusTreeNode, 0, order),
 	}
-	return &BPlusTree{root: leaf}
+}
+
+// put resets n and returns it to the pool. The caller must no longer
+// reference n. Backing arrays are cleared in full so a pooled node pins no
+// keys, values or children.
+func (p *nodePool) put(n *BPlusTreeNode) {
+	if p == nil {
+		return
+	}
+	free := &p.internal
+	if n.isLeaf {
+		free = &p.leaves
+	}
+	if len(*free) >= maxPooledNodes {
+		return
+	}
+	clear(n.keys[:cap(n.keys)])
+	clear(n.values[:cap(n.values)])
+	clear(n.children[:cap(n.children)])
+	*n = BPlusTreeNode{
+		isLeaf:   n.isLeaf,
+		keys:     n.keys[:0],
+		values:   n.values[:0],
+		children: n.children[:0],
+	}
+	*free = append(*free, n)
 }
 
 // --- INSERT IMPLEMENTATION ---
@@ -39,17 +178,14 @@ func (t *BPlusTree) Insert(key, value string) bool {
 	}
 
 	// If key does not exist, proceed with the insertion logic
-	_, midKey, sibling := t.root.insert(key, value)
+	_, midKey, sibling := t.root.insert(key, value, t.order, t.pool, t.appendSplits)
 
 	if sibling != nil {
 		// Root split: create a new root
-		newRoot := &BPlusTreeNode{
-			isLeaf:   false,
-			keys:     make([]string, 0, ORDER-1),
-			children: make([]*BPlusTreeNode, 0, ORDER),
-		}
+		newRoot := t.pool.get(false, t.order)
 		newRoot.keys = append(newRoot.keys, midKey)
 		newRoot.children = append(newRoot.children, t.root, sibling)
+		newRoot.recount()
 		t.root = newRoot
 	}
 	return true
@@ -61,34 +197,43 @@ func (t *BPlusTree) Insert(key, value string) bool {
 // - promotedKey: the key that needs to be promoted to the parent
 // - newSibling: the new node created due to a split
 // This function assumes the key does NOT already exist in the leaf.
-func (n *BPlusTreeNode) insert(key, value string) (*BPlusTreeNode, string, *BPlusTreeNode) {
+// A node splits once it reaches order keys.
+// appendSplit is set while descending the rightmost path of a tree with
+// append splits, where a key placed last in the leaf splits append-style.
+func (n *BPlusTreeNode) insert(key, value string, order int, pool *nodePool, appendSplit bool) (*BPlusTreeNode, string, *BPlusTreeNode) {
 	if n.isLeaf {
 		i := 0
-		for i < len(n.keys) && n.keys[i] < key {
+		for i < len(n.keys) && n.key(i) < key {
 			i++
 		}
 
 		// Insert key and value at the correct position
-		n.keys = append(n.keys[:i], append([]string{key}, n.keys[i:]...)...)
+		keys := n.leafKeys()
+		n.setLeafKeys(append(keys[:i], append([]string{key}, keys[i:]...)...))
 		n.values = append(n.values[:i], append([]string{value}, n.values[i:]...)...)
 
 		// Check if split is needed
-		if len(n.keys) < ORDER { // Node is not full
+		if len(n.keys) < order { // Node is not full
 			return nil, "", nil
 		}
 
 		// Split the leaf node
-		return n.splitLeaf()
+		if appendSplit && i == len(n.keys)-1 {
+			return n.splitLeaf(pool, order, len(n.keys)-1) // Keep the leaf full; the new key starts the next one
+		}
+		return n.splitLeaf(pool, order, len(n.keys)/2)
 	}
 
-	// Internal node insert
+	// Internal node insert. Use >= like Get: a separator can equal a key that
+	// was deleted and is now being re-inserted, which belongs on its right.
 	i := 0
-	for i < len(n.keys) && key > n.keys[i] {
+	for i < len(n.keys) && key >= n.keys[i] {
 		i++
 	}
 
 	// Recursively insert into the appropriate child
-	_, midKey, sibling := n.children[i].insert(key, value)
+	_, midKey, sibling := n.children[i].insert(key, value, order, pool, appendSplit && i == len(n.children)-1)
+	n.size++ // The key is new, so it always lands in this subtree
 	if sibling == nil {
 		return nil, "", nil // Child did not split
 	}
@@ -98,48 +243,41 @@ func (n *BPlusTreeNode) insert(key, value string) (*BPlusTreeNode, string, *BPlu
 	n.children = append(n.children[:i+1], append([]*BPlusTreeNode{sibling}, n.children[i+1:]...)...)
 
 	// Check if this internal node needs to split
-	if len(n.keys) < ORDER { // Node is not full (remember keys = ORDER -1, children = ORDER)
+	if len(n.keys) < order { // Node is not full (remember keys = order-1, children = order)
 		return nil, "", nil
 	}
 
 	// Split the internal node
-	return n.splitInternal()
+	return n.splitInternal(pool, order)
 }
 
-func (n *BPlusTreeNode) splitLeaf() (*BPlusTreeNode, string, *BPlusTreeNode) {
-	mid := len(n.keys) / 2
-
-	// Initialize slices for the new sibling node
-	sibling := &BPlusTreeNode{
-		isLeaf: true,
-		keys:   make([]string, 0, ORDER-1),
-		values: make([]string, 0, ORDER-1),
-		next:   n.next,
-	}
+// splitLeaf moves the keys from mid on to a new right sibling.
+func (n *BPlusTreeNode) splitLeaf(pool *nodePool, order, mid int) (*BPlusTreeNode, string, *BPlusTreeNode) {
+	// Initialize the new sibling node
+	sibling := pool.get(true, order)
+	sibling.next = n.next
+	sibling.compress = n.compress
 
 	// Copy the latter half of keys and values to the sibling
-	sibling.keys = append(sibling.keys, n.keys[mid:]...)
+	keys := n.leafKeys()
+	sibling.setLeafKeys(append(sibling.keys, keys[mid:]...))
 	sibling.values = append(sibling.values, n.values[mid:]...)
 
 	// Truncate the original node's keys and values
-	n.keys = n.keys[:mid]
+	n.setLeafKeys(keys[:mid])
 	n.values = n.values[:mid]
 	n.next = sibling
 
 	// Promote the first key of the sibling
-	return nil, sibling.keys[0], sibling
+	return nil, keys[mid], sibling
 }
 
-func (n *BPlusTreeNode) splitInternal() (*BPlusTreeNode, string, *BPlusTreeNode) {
+func (n *BPlusTreeNode) splitInternal(pool *nodePool, order int) (*BPlusTreeNode, string, *BPlusTreeNode) {
 	// Mid point for keys (remember, this key will be promoted)
 	midKeyIndex := len(n.keys) / 2
 
-	// Initialize slices for the new sibling node
-	sibling := &BPlusTreeNode{
-		isLeaf:   false,
-		keys:     make([]string, 0, ORDER-1),
-		children: make([]*BPlusTreeNode, 0, ORDER),
-	}
+	// Initialize the new sibling node
+	sibling := pool.get(false, order)
 
 	// The promoted key is the middle key
 	promotedKey := n.keys[midKeyIndex]
@@ -151,6 +289,8 @@ func (n *BPlusTreeNode) splitInternal() (*BPlusTreeNode, string, *BPlusTreeNode)
 	// Truncate the original node's keys and children
 	n.keys = n.keys[:midKeyIndex]
 	n.children = n.children[:midKeyIndex+1] // Important: children count is always one more than keys
+	n.recount()
+	sibling.recount()
 
 	return nil, promotedKey, sibling
 }
@@ -171,11 +311,9 @@ func (t *BPlusTree) Update(key, newValue string) bool {
 	}
 
 	// Now 'node' is the leaf node that should contain the key
-	for i, k := range node.keys {
-		if k == key {
-			node.values[i] = newValue // Update the value
-			return true
-		}
+	if i := node.indexOf(key); i >= 0 {
+		node.values[i] = newValue // Update the value
+		return true
 	}
 	return false // Key not found
 }
@@ -193,15 +331,37 @@ func (t *BPlusTree) Get(key string) (string, bool) {
 		node = node.children[i]
 	}
 
-	for i, k := range node.keys {
-		if k == key {
-			return node.values[i], true
-		}
+	if i := node.indexOf(key); i >= 0 {
+		return node.values[i], true
 	}
 
 	return "", false
 }
 
+// PathStep is one node visited by a lookup, as reported by Path.
+type PathStep struct {
+	Keys  []string // the node's keys, in full for leaves
+	Leaf  bool
+	Child int // index of the child descended into; -1 for the leaf
+}
+
+// Path returns the nodes a lookup of key visits from the root down to the
+// leaf that holds, or would hold, key. It follows the same descent as Get
+// and is meant for diagnostics.
+func (t *BPlusTree) Path(key string) []PathStep {
+	var path []PathStep
+	node := t.root
+	for !node.isLeaf {
+		i := 0
+		for i < len(node.keys) && key >= node.keys[i] {
+			i++
+		}
+		path = append(path, PathStep{Keys: slices.Clone(node.keys), Child: i})
+		node = node.children[i]
+	}
+	return append(path, PathStep{Keys: slices.Clone(node.leafKeys()), Leaf: true, Child: -1})
+}
+
 // --- END GET IMPLEMENTATION ---
 
 // --- DELETION IMPLEMENTATION ---
@@ -213,7 +373,8 @@ func (t *BPlusTree) Delete(key string) bool {
 		deleted := t.root.deleteFromLeaf(key)
 		// If root becomes empty after deletion, re-initialize to an empty leaf root
 		if deleted && len(t.root.keys) == 0 {
-			t.root = NewBPlusTree().root
+			t.pool.put(t.root)
+			t.root = t.newLeaf()
 		}
 		return deleted
 	}
@@ -221,17 +382,25 @@ func (t *BPlusTree) Delete(key string) bool {
 	// Recursive deletion starting from the root
 	// We need to pass a pointer to a boolean to track if a key was actually deleted anywhere in the subtree
 	keyDeleted := false
-	underflow := t.root.delete(key, nil, 0, &keyDeleted) // Pass keyDeleted by reference
+	// The root may hold fewer than the minimum keys, so its underflow result is
+	// ignored; a keyless root is collapsed below
+	t.root.delete(key, nil, 0, &keyDeleted, minKeys(t.order), t.pool) // Pass keyDeleted by reference
+	t.collapseRoot()
+	return keyDeleted
+}
 
-	// If the root underflows and has only one child, that child becomes the new root
-	if underflow && len(t.root.keys) == 0 {
-		if len(t.root.children) == 1 {
-			t.root = t.root.children[0]
-		} else if len(t.root.children) == 0 { // Should only happen if the tree becomes completely empty
-			t.root = NewBPlusTree().root // Tree became empty
+// collapseRoot removes degenerate root levels: while the root is an internal
+// node without keys, its only child becomes the new root.
+func (t *BPlusTree) collapseRoot() {
+	for !t.root.isLeaf && len(t.root.keys) == 0 {
+		oldRoot := t.root
+		if len(oldRoot.children) == 0 { // Should only happen if the tree becomes completely empty
+			t.root = t.newLeaf() // Tree became empty
+		} else {
+			t.root = oldRoot.children[0]
 		}
+		t.pool.put(oldRoot)
 	}
-	return keyDeleted
 }
 
 // delete recursively deletes a key from the node.
@@ -239,13 +408,15 @@ func (t *BPlusTree) Delete(key string) bool {
 // parent: the parent node (needed for redistribution/merge)
 // childIndex: the index of 'n' in parent's children array
 // keyDeleted: a pointer to a boolean indicating if the key was successfully deleted at any point
-func (n *BPlusTreeNode) delete(key string, parent *BPlusTreeNode, childIndex int, keyDeleted *bool) bool {
+// least: the minimum number of keys of a non-root node
+// pool: receives nodes freed by merges
+func (n *BPlusTreeNode) delete(key string, parent *BPlusTreeNode, childIndex int, keyDeleted *bool, least int, pool *nodePool) bool {
 	if n.isLeaf {
 		deletedInLeaf := n.deleteFromLeaf(key)
 		if deletedInLeaf {
 			*keyDeleted = true // Mark that a key was deleted
 		}
-		return len(n.keys) < MIN_KEYS // Return true if leaf underflowed
+		return len(n.keys) < least // Return true if leaf underflowed
 	}
 
 	// Internal node traversal
@@ -255,10 +426,13 @@ func (n *BPlusTreeNode) delete(key string, parent *BPlusTreeNode, childIndex int
 	}
 
 	// Recursively delete from the child
-	childUnderflow := n.children[i].delete(key, n, i, keyDeleted)
+	childUnderflow := n.children[i].delete(key, n, i, keyDeleted, least, pool)
+	if *keyDeleted {
+		n.size-- // Only this path was searched, so the key came from this subtree
+	}
 
 	if childUnderflow {
-		return n.handleUnderflow(i) // Handle underflow of child at index i
+		return n.handleUnderflow(i, least, pool) // Handle underflow of child at index i
 	}
 	return false // No underflow
 }
@@ -266,27 +440,28 @@ func (n *BPlusTreeNode) delete(key string, parent *BPlusTreeNode, childIndex int
 // deleteFromLeaf removes a key from a leaf node.
 // Returns true if the key was found and removed, false otherwise.
 func (n *BPlusTreeNode) deleteFromLeaf(key string) bool {
-	for i, k := range n.keys {
-		if k == key {
-			// Remove key and value
-			n.keys = append(n.keys[:i], n.keys[i+1:]...)
-			n.values = append(n.values[:i], n.values[i+1:]...)
-			return true // Key found and removed
-		}
+	i := n.indexOf(key)
+	if i < 0 {
+		return false // Key not found
 	}
-	return false // Key not found
+	// Remove key and value
+	keys := n.leafKeys()
+	n.setLeafKeys(append(keys[:i], keys[i+1:]...))
+	n.values = append(n.values[:i], n.values[i+1:]...)
+	return true // Key found and removed
 }
 
 // handleUnderflow attempts to redistribute or merge children.
 // childIndex: the index of the child that underflowed.
+// least: the minimum number of keys of a non-root node.
 // Returns true if this node (parent) also underflows after redistribution/merge.
-func (n *BPlusTreeNode) handleUnderflow(childIndex int) bool {
+func (n *BPlusTreeNode) handleUnderflow(childIndex, least int, pool *nodePool) bool {
 	underflowingChild := n.children[childIndex]
 
 	// Try to redistribute with left sibling
 	if childIndex > 0 {
 		leftSibling := n.children[childIndex-1]
-		if len(leftSibling.keys) > MIN_KEYS {
+		if len(leftSibling.keys) > least {
 			n.redistributeFromLeft(leftSibling, underflowingChild, childIndex-1)
 			return false // Redistribution successful, no underflow
 		}
@@ -295,7 +470,7 @@ func (n *BPlusTreeNode) handleUnderflow(childIndex int) bool {
 	// Try to redistribute with right sibling
 	if childIndex < len(n.children)-1 {
 		rightSibling := n.children[childIndex+1]
-		if len(rightSibling.keys) > MIN_KEYS {
+		if len(rightSibling.keys) > least {
 			n.redistributeFromRight(underflowingChild, rightSibling, childIndex)
 			return false // Redistribution successful, no underflow
 		}
@@ -303,13 +478,13 @@ func (n *BPlusTreeNode) handleUnderflow(childIndex int) bool {
 
 	// If redistribution not possible, merge
 	if childIndex > 0 { // Merge with left sibling
-		n.merge(n.children[childIndex-1], underflowingChild, childIndex-1)
+		n.merge(n.children[childIndex-1], underflowingChild, childIndex-1, pool)
 	} else { // Merge with right sibling (must have one if childIndex is 0 and no left sibling)
-		n.merge(underflowingChild, n.children[childIndex+1], childIndex)
+		n.merge(underflowingChild, n.children[childIndex+1], childIndex, pool)
 	}
 
 	// After merge, check if this parent node underflows
-	return len(n.keys) < MIN_KEYS
+	return len(n.keys) < least
 }
 
 // redistributeFromLeft borrows a key/value/child from the leftSibling to the underflowingChild.
@@ -319,16 +494,17 @@ func (n *BPlusTreeNode) handleUnderflow(childIndex int) bool {
 func (n *BPlusTreeNode) redistributeFromLeft(leftSibling, underflowingChild *BPlusTreeNode, separatorIndex int) {
 	if underflowingChild.isLeaf {
 		// Move last key/value from leftSibling to underflowingChild
-		keyToMove := leftSibling.keys[len(leftSibling.keys)-1]
+		leftKeys := leftSibling.leafKeys()
+		keyToMove := leftKeys[len(leftKeys)-1]
 		valueToMove := leftSibling.values[len(leftSibling.values)-1]
-		leftSibling.keys = leftSibling.keys[:len(leftSibling.keys)-1]
+		leftSibling.setLeafKeys(leftKeys[:len(leftKeys)-1])
 		leftSibling.values = leftSibling.values[:len(leftSibling.values)-1]
 
-		underflowingChild.keys = append([]string{keyToMove}, underflowingChild.keys...)
+		underflowingChild.setLeafKeys(append([]string{keyToMove}, underflowingChild.leafKeys()...))
 		underflowingChild.values = append([]string{valueToMove}, underflowingChild.values...)
 
 		// Update parent's separator key: it should be the new first key of the now-augmented underflowingChild
-		n.keys[separatorIndex] = underflowingChild.keys[0]
+		n.keys[separatorIndex] = underflowingChild.key(0)
 	} else { // Internal node redistribution
 		// Pull down parent's separator key
 		promotedKey := n.keys[separatorIndex]
@@ -341,6 +517,8 @@ func (n *BPlusTreeNode) redistributeFromLeft(leftSibling, underflowingChild *BPl
 
 		underflowingChild.keys = append([]string{promotedKey}, underflowingChild.keys...)
 		underflowingChild.children = append([]*BPlusTreeNode{childToMove}, underflowingChild.children...)
+		leftSibling.size -= childToMove.subtreeSize()
+		underflowingChild.size += childToMove.subtreeSize()
 	}
 }
 
@@ -351,16 +529,17 @@ func (n *BPlusTreeNode) redistributeFromLeft(leftSibling, underflowingChild *BPl
 func (n *BPlusTreeNode) redistributeFromRight(underflowingChild, rightSibling *BPlusTreeNode, separatorIndex int) {
 	if underflowingChild.isLeaf {
 		// Take first key/value from rightSibling, add to end of underflowingChild
-		keyToMove := rightSibling.keys[0]
+		rightKeys := rightSibling.leafKeys()
+		keyToMove := rightKeys[0]
 		valueToMove := rightSibling.values[0]
-		rightSibling.keys = rightSibling.keys[1:]
+		rightSibling.setLeafKeys(rightKeys[1:])
 		rightSibling.values = rightSibling.values[1:]
 
-		underflowingChild.keys = append(underflowingChild.keys, keyToMove)
+		underflowingChild.setLeafKeys(append(underflowingChild.leafKeys(), keyToMove))
 		underflowingChild.values = append(underflowingChild.values, valueToMove)
 
 		// Update parent's separator key: it should be the new first key of the (now-reduced) rightSibling
-		n.keys[separatorIndex] = rightSibling.keys[0]
+		n.keys[separatorIndex] = rightSibling.key(0)
 	} else { // Internal node redistribution
 		// Pull down parent's separator key
 		promotedKey := n.keys[separatorIndex]
@@ -373,6 +552,8 @@ func (n *BPlusTreeNode) redistributeFromRight(underflowingChild, rightSibling *B
 
 		underflowingChild.keys = append(underflowingChild.keys, promotedKey)
 		underflowingChild.children = append(underflowingChild.children, childToMove)
+		rightSibling.size -= childToMove.subtreeSize()
+		underflowingChild.size += childToMove.subtreeSize()
 	}
 }
 
@@ -380,9 +561,10 @@ func (n *BPlusTreeNode) redistributeFromRight(underflowingChild, rightSibling *B
 // sibling1: the first sibling (will contain merged content)
 // sibling2: the second sibling (will be removed)
 // separatorIndex: the index of the key in parent that separates sibling1 and sibling2
-func (n *BPlusTreeNode) merge(sibling1, sibling2 *BPlusTreeNode, separatorIndex int) {
+// pool: receives sibling2 once it is unlinked
+func (n *BPlusTreeNode) merge(sibling1, sibling2 *BPlusTreeNode, separatorIndex int, pool *nodePool) {
 	if sibling1.isLeaf {
-		sibling1.keys = append(sibling1.keys, sibling2.keys...)
+		sibling1.setLeafKeys(append(sibling1.leafKeys(), sibling2.leafKeys()...))
 		sibling1.values = append(sibling1.values, sibling2.values...)
 		sibling1.next = sibling2.next // Crucial: Update leaf chaining
 	} else { // Internal node merge
@@ -391,19 +573,26 @@ func (n *BPlusTreeNode) merge(sibling1, sibling2 *BPlusTreeNode, separatorIndex
 		sibling1.keys = append(sibling1.keys, promotedKey) // Key from parent goes into sibling1
 		sibling1.keys = append(sibling1.keys, sibling2.keys...)
 		sibling1.children = append(sibling1.children, sibling2.children...)
+		sibling1.size += sibling2.size
 	}
 
 	// Remove the separator key and the second sibling from the parent
 	n.keys = append(n.keys[:separatorIndex], n.keys[separatorIndex+1:]...)
 	n.children = append(n.children[:separatorIndex+1], n.children[separatorIndex+2:]...) // Remove sibling2
+	pool.put(sibling2)
 }
 
 // --- END DELETION IMPLEMENTATION ---
 
 // --- RANGE QUERY/SCAN IMPLEMENTATION ---
+
+// RangeQuery returns every key/value pair with startKey <= key <= endKey. An
+// empty bound is unbounded on that side. Reversed bounds (startKey > endKey,
+// both non-empty) describe an empty range and return an empty map rather than
+// being swapped.
 func (t *BPlusTree) RangeQuery(startKey, endKey string) map[string]string {
 	results := make(map[string]string)
-	if t.root == nil {
+	if t.root == nil || (startKey != "" && endKey != "" && startKey > endKey) {
 		return results
 	}
 
@@ -413,7 +602,8 @@ func (t *BPlusTree) RangeQuery(startKey, endKey string) map[string]string {
 		node = node.children[0]
 	}
 	for node != nil {
-		for i, k := range node.keys {
+		for i := range node.keys {
+			k := node.key(i)
 			if (startKey == "" || k >= startKey) && (endKey == "" || k <= endKey) {
 				results[k] = node.values[i]
 			}
@@ -423,17 +613,394 @@ func (t *BPlusTree) RangeQuery(startKey, endKey string) map[string]string {
 	return results
 }
 
+// AscendFrom is Ascend starting at the first key >= start. It descends
+// straight to the leaf that would hold start instead of walking the leaf
+// chain from the beginning.
+func (t *BPlusTree) AscendFrom(start string, fn func(key, value string) bool) {
+	node := t.root
+	for !node.isLeaf {
+		i := 0
+		for i < len(node.keys) && start >= node.keys[i] {
+			i++
+		}
+		node = node.children[i]
+	}
+	for ; node != nil; node = node.next {
+		for i := range node.keys {
+			key := node.key(i)
+			if key < start {
+				continue
+			}
+			if !fn(key, node.values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// PrefixScan returns every key/value pair whose key starts with prefix. Keys
+// with a common prefix are adjacent in key order, so it descends to the
+// first key >= prefix and walks the leaf chain only while keys still match.
+// An empty prefix returns every pair.
+func (t *BPlusTree) PrefixScan(prefix string) map[string]string {
+	results := make(map[string]string)
+	if t.root == nil {
+		return results
+	}
+	t.AscendFrom(prefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		results[key] = value
+		return true
+	})
+	return results
+}
+
+// Ascend calls fn for every key/value pair in ascending key order, walking the
+// leaf chain. Iteration stops early if fn returns false.
+func (t *BPlusTree) Ascend(fn func(key, value string) bool) {
+	for node := t.firstLeaf(); node != nil; node = node.next {
+		for i := range node.keys {
+			if !fn(node.key(i), node.values[i]) {
+				return
+			}
+		}
+	}
+}
+
 // --- END RANGE QUERY/SCAN IMPLEMENTATION ---
 
+// --- BULK LOAD IMPLEMENTATION ---
+
+// BulkLoad replaces the contents of the tree with pairs, which must be sorted
+// by strictly increasing key. Instead of inserting the pairs one at a time it
+// builds the tree bottom-up with every node filled to capacity, except that
+// the last node of a level may take from its neighbor to keep the minimum
+// fill. The result is as shallow and densely packed as the tree allows.
+func (t *BPlusTree) BulkLoad(pairs []KeyValue) error {
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i-1].Key >= pairs[i].Key {
+			return fmt.Errorf("bulk load: keys not strictly increasing at %q, %q", pairs[i-1].Key, pairs[i].Key)
+		}
+	}
+	if len(pairs) == 0 {
+		t.root = t.newLeaf()
+		return nil
+	}
+
+	// Build the leaves and remember the smallest key under each node, which
+	// becomes the node's separator in its parent
+	var level []*BPlusTreeNode
+	var lowest []string
+	var prev *BPlusTreeNode
+	for _, size := range chunkSizes(len(pairs), t.order-1, minKeys(t.order)) {
+		leaf := t.newLeaf()
+		keys := make([]string, 0, t.order-1)
+		for _, kv := range pairs[:size] {
+			keys = append(keys, kv.Key)
+			leaf.values = append(leaf.values, kv.Value)
+		}
+		leaf.setLeafKeys(keys)
+		if prev != nil {
+			prev.next = leaf
+		}
+		prev = leaf
+		level = append(level, leaf)
+		lowest = append(lowest, pairs[0].Key)
+		pairs = pairs[size:]
+	}
+
+	// Group each level under internal nodes until a single root remains
+	for len(level) > 1 {
+		var parents []*BPlusTreeNode
+		var parentLowest []string
+		for _, size := range chunkSizes(len(level), t.order, minKeys(t.order)+1) {
+			node := t.pool.get(false, t.order)
+			node.children = append(node.children, level[:size]...)
+			node.keys = append(node.keys, lowest[1:size]...)
+			node.recount()
+			parents = append(parents, node)
+			parentLowest = append(parentLowest, lowest[0])
+			level, lowest = level[size:], lowest[size:]
+		}
+		level, lowest = parents, parentLowest
+	}
+	t.root = level[0]
+	return nil
+}
+
+// chunkSizes splits n items into as few groups of at most most items as
+// possible, filling all but the last group. If the last group would hold
+// fewer than least items, it takes the shortfall from the one before it.
+func chunkSizes(n, most, least int) []int {
+	var sizes []int
+	for ; n > most; n -= most {
+		sizes = append(sizes, most)
+	}
+	sizes = append(sizes, n)
+	if k := len(sizes); k > 1 && sizes[k-1] < least {
+		sizes[k-2] -= least - sizes[k-1]
+		sizes[k-1] = least
+	}
+	return sizes
+}
+
+// --- END BULK LOAD IMPLEMENTATION ---
+
+// --- STATS IMPLEMENTATION ---
+
+// Count returns the number of keys stored in the tree.
+func (t *BPlusTree) Count() int {
+	return t.root.subtreeSize()
+}
+
+// subtreeSize returns the number of keys stored under n. Internal nodes keep
+// it up to date through inserts, deletes, splits, merges and redistributions.
+func (n *BPlusTreeNode) subtreeSize() int {
+	if n.isLeaf {
+		return len(n.keys)
+	}
+	return n.size
+}
+
+// recount sets the size of internal node n from its children's sizes.
+func (n *BPlusTreeNode) recount() {
+	n.size = 0
+	for _, child := range n.children {
+		n.size += child.subtreeSize()
+	}
+}
+
+// EstimateRangeCount returns the number of keys with start <= key <= end,
+// with RangeQuery's treatment of empty and reversed bounds. It is meant for
+// query planning: rather than visiting the matching keys, it descends to the
+// two bounds and adds up the subtree sizes kept in the internal nodes
+// between them, in O(height) node visits. The count is exact as long as
+// those sizes are, which the tree's own operations guarantee.
+func (t *BPlusTree) EstimateRangeCount(start, end string) int {
+	if start != "" && end != "" && start > end {
+		return 0
+	}
+	upper := t.Count()
+	if end != "" {
+		upper = t.countBelow(end, true)
+	}
+	return upper - t.countBelow(start, false)
+}
+
+// countBelow returns the number of keys less than key, or with inclusive
+// less than or equal to key, from the subtree sizes along key's path.
+func (t *BPlusTree) countBelow(key string, inclusive bool) int {
+	count := 0
+	node := t.root
+	for !node.isLeaf {
+		i := 0
+		for i < len(node.keys) && key >= node.keys[i] {
+			count += node.children[i].subtreeSize() // Every key under it is below the separator, so below key
+			i++
+		}
+		node = node.children[i]
+	}
+	for i := range node.keys {
+		if k := node.key(i); k > key || (k == key && !inclusive) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// SizeBytes estimates the memory used by the stored keys and values. Only the
+// string payloads in the leaves are counted, not node or slice overhead.
+func (t *BPlusTree) SizeBytes() int {
+	size := 0
+	for node := t.firstLeaf(); node != nil; node = node.next {
+		size += len(node.prefix)
+		for i := range node.keys {
+			size += len(node.keys[i]) + len(node.values[i])
+		}
+	}
+	return size
+}
+
+// Height returns the number of levels in the tree; a tree whose root is a
+// leaf has height 1.
+func (t *BPlusTree) Height() int {
+	height := 1
+	for node := t.root; !node.isLeaf; node = node.children[0] {
+		height++
+	}
+	return height
+}
+
+// TreeStats describes the shape of a tree, as returned by Stats.
+type TreeStats struct {
+	Height     int     // number of levels; a lone leaf root has height 1
+	Depth      int     // edges from the root to every leaf (Height - 1)
+	Nodes      int     // internal and leaf nodes
+	Leaves     int     // leaf nodes
+	Keys       int     // keys stored in the leaves
+	FillFactor float64 // average keys per node divided by the node capacity (order-1)
+}
+
+// Stats collects the tree's shape metrics in a single walk over all nodes.
+func (t *BPlusTree) Stats() TreeStats {
+	var stats TreeStats
+	nodeKeys := 0 // keys held by all nodes, separators included
+	var walk func(n *BPlusTreeNode, depth int)
+	walk = func(n *BPlusTreeNode, depth int) {
+		stats.Nodes++
+		nodeKeys += len(n.keys)
+		if n.isLeaf {
+			stats.Leaves++
+			stats.Keys += len(n.keys)
+			stats.Depth = depth
+			return
+		}
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(t.root, 0)
+	stats.Height = stats.Depth + 1
+	stats.FillFactor = float64(nodeKeys) / float64(stats.Nodes) / float64(t.order-1)
+	return stats
+}
+
+// Validate checks the structural invariants of the tree and returns an error
+// describing the first violation found:
+//   - all leaves are at the same depth
+//   - keys are strictly increasing within every node and along the leaf chain
+//   - every key in a subtree lies within the bounds set by its parent's separators
+//   - internal nodes have one more child than keys
+//   - non-root nodes hold between the minimum for the tree's order and order-1 keys
+//   - an internal root has at least one key
+//   - every internal node records the number of keys in its subtree
+func (t *BPlusTree) Validate() error {
+	if !t.root.isLeaf && len(t.root.keys) == 0 {
+		return fmt.Errorf("root is an internal node without keys")
+	}
+	leafDepth := -1
+	var walk func(n *BPlusTreeNode, depth int, lower, upper *string) error
+	walk = func(n *BPlusTreeNode, depth int, lower, upper *string) error {
+		keys := n.keys
+		if n.isLeaf {
+			keys = n.leafKeys()
+		}
+		if n != t.root && (len(keys) < minKeys(t.order) || len(keys) > t.order-1) {
+			return fmt.Errorf("node %v at depth %d has %d keys, want between %d and %d", keys, depth, len(keys), minKeys(t.order), t.order-1)
+		}
+		for i, k := range keys {
+			if i > 0 && keys[i-1] >= k {
+				return fmt.Errorf("node %v at depth %d has keys out of order", keys, depth)
+			}
+			if lower != nil && k < *lower {
+				return fmt.Errorf("key %q at depth %d is below its lower bound %q", k, depth, *lower)
+			}
+			if upper != nil && k >= *upper {
+				return fmt.Errorf("key %q at depth %d is not below its upper bound %q", k, depth, *upper)
+			}
+		}
+		if n.isLeaf {
+			if len(n.values) != len(n.keys) {
+				return fmt.Errorf("leaf %v has %d values for %d keys", keys, len(n.values), len(n.keys))
+			}
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				return fmt.Errorf("leaf %v is at depth %d, other leaves are at depth %d", keys, depth, leafDepth)
+			}
+			return nil
+		}
+		if len(n.children) != len(n.keys)+1 {
+			return fmt.Errorf("internal node %v has %d children, want %d", n.keys, len(n.children), len(n.keys)+1)
+		}
+		for i, child := range n.children {
+			childLower, childUpper := lower, upper
+			if i > 0 {
+				childLower = &n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				childUpper = &n.keys[i]
+			}
+			if err := walk(child, depth+1, childLower, childUpper); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(t.root, 0, nil, nil); err != nil {
+		return err
+	}
+
+	// The leaf chain must visit every key exactly once, in order
+	var prev *string
+	chained := 0
+	for node := t.firstLeaf(); node != nil; node = node.next {
+		for i := range node.keys {
+			k := node.key(i)
+			if prev != nil && *prev >= k {
+				return fmt.Errorf("leaf chain is out of order at key %q", k)
+			}
+			prev = &k
+			chained++
+		}
+	}
+	var sizeErr error
+	var countKeys func(n *BPlusTreeNode) int
+	countKeys = func(n *BPlusTreeNode) int {
+		if n.isLeaf {
+			return len(n.keys)
+		}
+		total := 0
+		for _, child := range n.children {
+			total += countKeys(child)
+		}
+		if n.size != total && sizeErr == nil {
+			sizeErr = fmt.Errorf("internal node %v records %d keys in its subtree, holds %d", n.keys, n.size, total)
+		}
+		return total
+	}
+	if total := countKeys(t.root); total != chained {
+		return fmt.Errorf("leaf chain holds %d keys, tree holds %d", chained, total)
+	}
+	return sizeErr
+}
+
+// firstLeaf returns the leftmost leaf of the tree.
+func (t *BPlusTree) firstLeaf() *BPlusTreeNode {
+	node := t.root
+	for !node.isLeaf {
+		node = node.children[0]
+	}
+	return node
+}
+
+// --- END STATS IMPLEMENTATION ---
+
 // --- PrintTree IMPLEMENTATION ---
+
+// PrintTree prints the keys of every node to standard output, one line per
+// level. Like every BPlusTree method it is not synchronized: a tree owned by
+// an Engine must be printed with Engine.PrintTree instead.
 func (t *BPlusTree) PrintTree() {
+	t.WriteTree(os.Stdout)
+}
+
+// WriteTree writes the keys of every node to w, one line per level.
+func (t *BPlusTree) WriteTree(w io.Writer) {
 	var levels [][]string
 	var collect func(n *BPlusTreeNode, level int)
 	collect = func(n *BPlusTreeNode, level int) {
 		if len(levels) <= level {
 			levels = append(levels, []string{})
 		}
-		levels[level] = append(levels[level], fmt.Sprintf("[%v]", n.keys))
+		keys := n.keys
+		if n.isLeaf {
+			keys = n.leafKeys()
+		}
+		levels[level] = append(levels[level], fmt.Sprintf("[%v]", keys))
 		if !n.isLeaf {
 			for _, c := range n.children {
 				collect(c, level+1)
@@ -442,8 +1009,73 @@ func (t *BPlusTree) PrintTree() {
 	}
 	collect(t.root, 0)
 	for i, lvl := range levels {
-		fmt.Printf("Level %d: %s\n", i, lvl)
+		fmt.Fprintf(w, "Level %d: %s\n", i, lvl)
 	}
 }
 
 // --- END PrintTree IMPLEMENTATION ---
+
+// --- LEAF KEY PREFIX COMPRESSION ---
+
+// key returns the full i-th key of a leaf node.
+func (n *BPlusTreeNode) key(i int) string {
+	return n.prefix + n.keys[i]
+}
+
+// indexOf returns the position of key in a leaf node, or -1 if it is absent.
+func (n *BPlusTreeNode) indexOf(key string) int {
+	if !strings.HasPrefix(key, n.prefix) {
+		return -1
+	}
+	suffix := key[len(n.prefix):]
+	for i, k := range n.keys {
+		if k == suffix {
+			return i
+		}
+	}
+	return -1
+}
+
+// leafKeys returns the full keys of a leaf node. For an uncompressed leaf this
+// is the keys slice itself, so callers may modify it in place.
+func (n *BPlusTreeNode) leafKeys() []string {
+	if n.prefix == "" {
+		return n.keys
+	}
+	keys := make([]string, len(n.keys), cap(n.keys))
+	for i := range n.keys {
+		keys[i] = n.key(i)
+	}
+	return keys
+}
+
+// setLeafKeys stores full keys in a leaf node, splitting off their common
+// prefix when the leaf is compressed.
+func (n *BPlusTreeNode) setLeafKeys(keys []string) {
+	if !n.compress || len(keys) == 0 {
+		n.prefix = ""
+		n.keys = keys
+		return
+	}
+	prefix := keys[0]
+	for _, k := range keys[1:] {
+		prefix = commonPrefix(prefix, k)
+	}
+	// Clone so the stored prefix and suffixes don't pin the full key strings.
+	n.prefix = strings.Clone(prefix)
+	for i, k := range keys {
+		keys[i] = strings.Clone(k[len(prefix):])
+	}
+	n.keys = keys
+}
+
+// commonPrefix returns the longest common prefix of a and b.
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// --- END LEAF KEY PREFIX COMPRESSION ---
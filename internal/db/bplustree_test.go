@@ -1,6 +1,11 @@
 package db
 
 import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -105,6 +110,26 @@ func TestRangeQuery(t *testing.T) {
 	}
 }
 
+func TestRangeQueryReversedBounds(t *testing.T) {
+	tree := NewBPlusTree()
+	for c := 'a'; c <= 'z'; c++ {
+		tree.Insert(string(c), "v")
+	}
+
+	if result := tree.RangeQuery("m", "a"); len(result) != 0 {
+		t.Errorf("Expected no results for reversed bounds, got %v", result)
+	}
+	if result := tree.RangeQuery("m", "m"); len(result) != 1 || result["m"] != "v" {
+		t.Errorf("Expected only 'm' for equal bounds, got %v", result)
+	}
+	if got := len(tree.RangeQuery("m", "")); got != 14 {
+		t.Errorf("Expected 14 results with an open end, got %d", got)
+	}
+	if got := len(tree.RangeQuery("", "m")); got != 13 {
+		t.Errorf("Expected 13 results with an open start, got %d", got)
+	}
+}
+
 func TestInsertSplitRoot(t *testing.T) {
 	tree := NewBPlusTree()
 	keys := []string{"d", "b", "a", "c", "e"} // Will cause multiple splits
@@ -185,3 +210,589 @@ func TestUnderflowMerge(t *testing.T) {
 		}
 	}
 }
+
+func TestPrefixCompression(t *testing.T) {
+	tree := NewBPlusTreeWithPrefixCompression()
+
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("com.example.service.metric.%03d", i))
+	}
+	keys = append(keys, "a", "zz") // keys sharing no prefix with the rest
+	for _, k := range keys {
+		if !tree.Insert(k, k+"-val") {
+			t.Fatalf("Insert(%q) reported an existing key", k)
+		}
+	}
+
+	for _, k := range keys {
+		val, ok := tree.Get(k)
+		if !ok || val != k+"-val" {
+			t.Errorf("Get(%q) = (%q, %v), want %q", k, val, ok, k+"-val")
+		}
+	}
+	if _, ok := tree.Get("com.example.service.metric."); ok {
+		t.Error("Expected the bare common prefix not to be found as a key")
+	}
+
+	result := tree.RangeQuery("com.example.service.metric.010", "com.example.service.metric.019")
+	if len(result) != 10 {
+		t.Fatalf("Expected 10 results from RangeQuery, got %d", len(result))
+	}
+	for i := 10; i < 20; i++ {
+		k := fmt.Sprintf("com.example.service.metric.%03d", i)
+		if result[k] != k+"-val" {
+			t.Errorf("RangeQuery: expected %q = %q, got %q", k, k+"-val", result[k])
+		}
+	}
+
+	// Delete every other key to exercise redistribution and merges on compressed leaves
+	for i := 0; i < 100; i += 2 {
+		k := fmt.Sprintf("com.example.service.metric.%03d", i)
+		if !tree.Delete(k) {
+			t.Errorf("Delete(%q) = false, want true", k)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("com.example.service.metric.%03d", i)
+		_, ok := tree.Get(k)
+		if ok != (i%2 == 1) {
+			t.Errorf("After deletes: Get(%q) found = %v, want %v", k, ok, i%2 == 1)
+		}
+	}
+	if !tree.Update("com.example.service.metric.051", "updated") {
+		t.Error("Expected Update of a compressed key to succeed")
+	}
+	if val, _ := tree.Get("com.example.service.metric.051"); val != "updated" {
+		t.Errorf("Expected updated value, got %q", val)
+	}
+	if got := len(tree.RangeQuery("", "")); got != 52 {
+		t.Errorf("Expected 52 remaining keys, got %d", got)
+	}
+}
+
+func BenchmarkPrefixCompressionMemory(b *testing.B) {
+	const n = 10000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("com.example.service.metric.requests.latency.p99.host-%06d", i)
+	}
+
+	for _, bc := range []struct {
+		name    string
+		newTree func() *BPlusTree
+	}{
+		{"Plain", NewBPlusTree},
+		{"PrefixCompressed", NewBPlusTreeWithPrefixCompression},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var heapBytes uint64
+			var keyBytes int
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				tree := bc.newTree()
+				for _, k := range keys {
+					// Build fresh key strings so the tree doesn't share the benchmark's copies
+					tree.Insert(strings.Clone(k), "v")
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heapBytes += after.HeapAlloc - before.HeapAlloc
+				keyBytes = leafKeyBytes(tree)
+			}
+			b.ReportMetric(float64(heapBytes)/float64(b.N), "heap-bytes/op")
+			b.ReportMetric(float64(keyBytes), "leaf-key-bytes")
+		})
+	}
+}
+
+// leafKeyBytes sums the bytes used to store keys in the leaves of tree.
+func leafKeyBytes(tree *BPlusTree) int {
+	node := tree.root
+	for !node.isLeaf {
+		node = node.children[0]
+	}
+	total := 0
+	for ; node != nil; node = node.next {
+		total += len(node.prefix)
+		for _, k := range node.keys {
+			total += len(k)
+		}
+	}
+	return total
+}
+
+func TestCountAndSizeBytes(t *testing.T) {
+	tree := NewBPlusTree()
+	if tree.Count() != 0 || tree.SizeBytes() != 0 {
+		t.Fatalf("Expected empty tree stats, got count=%d size=%d", tree.Count(), tree.SizeBytes())
+	}
+
+	for i := 0; i < 20; i++ {
+		tree.Insert(fmt.Sprintf("k%02d", i), "val") // 3 + 3 bytes each
+	}
+	tree.Delete("k05")
+
+	if got := tree.Count(); got != 19 {
+		t.Errorf("Count() = %d, want 19", got)
+	}
+	if got := tree.SizeBytes(); got != 19*6 {
+		t.Errorf("SizeBytes() = %d, want %d", got, 19*6)
+	}
+}
+
+func TestDeleteHeavyRootCollapse(t *testing.T) {
+	tree := NewBPlusTree()
+	const n = 200
+	for i := 0; i < n; i++ {
+		tree.Insert(fmt.Sprintf("k%03d", i), "v")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatalf("Validate after inserts: %v", err)
+	}
+
+	// Delete from both ends towards the middle, validating after every step
+	for lo, hi := 0, n-1; lo < hi; lo, hi = lo+1, hi-1 {
+		for _, i := range []int{lo, hi} {
+			key := fmt.Sprintf("k%03d", i)
+			if !tree.Delete(key) {
+				t.Fatalf("Delete(%q) = false, want true", key)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("Validate after Delete(%q): %v", key, err)
+			}
+		}
+	}
+	if got := tree.Count(); got != 0 {
+		t.Fatalf("Expected an empty tree, got %d keys", got)
+	}
+	if got := tree.Height(); got != 1 {
+		t.Errorf("Expected the emptied tree to collapse to height 1, got %d", got)
+	}
+
+	// Refill and drain in a shuffled order to hit merges at every level
+	for i := 0; i < n; i++ {
+		tree.Insert(fmt.Sprintf("k%03d", i), "v")
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("k%03d", (i*37)%n)
+		tree.Delete(key)
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Validate after Delete(%q): %v", key, err)
+		}
+		// A single remaining key can only live in a leaf root
+		if remaining := n - i - 1; remaining == 1 && tree.Height() != 1 {
+			t.Fatalf("Expected height 1 with one key left, got %d", tree.Height())
+		}
+	}
+}
+
+func TestNodePoolChurn(t *testing.T) {
+	tree := NewBPlusTreeWithNodePool()
+	model := make(map[string]string)
+
+	// Repeatedly fill and drain overlapping key ranges so merged nodes are
+	// recycled into later splits
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 300; i++ {
+			k := fmt.Sprintf("k%04d", (i*7+round*50)%500)
+			v := fmt.Sprintf("v%d-%d", round, i)
+			if tree.Insert(k, v) {
+				model[k] = v
+			}
+		}
+		for i := 0; i < 250; i++ {
+			k := fmt.Sprintf("k%04d", (i*13+round*31)%500)
+			if tree.Delete(k) != (model[k] != "") {
+				t.Fatalf("Round %d: Delete(%q) disagreed with the model", round, k)
+			}
+			delete(model, k)
+		}
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Round %d: Validate: %v", round, err)
+		}
+		if got := tree.Count(); got != len(model) {
+			t.Fatalf("Round %d: Count() = %d, want %d", round, got, len(model))
+		}
+		for k, v := range model {
+			if got, ok := tree.Get(k); !ok || got != v {
+				t.Fatalf("Round %d: Get(%q) = (%q, %v), want %q", round, k, got, ok, v)
+			}
+		}
+	}
+	if len(tree.pool.leaves) == 0 {
+		t.Error("Expected merges to return leaves to the pool")
+	}
+}
+
+func TestReinsertAtStaleSeparator(t *testing.T) {
+	tree := NewBPlusTree()
+	for _, k := range []string{"k08", "k06", "k00", "k12"} {
+		tree.Insert(k, "v")
+	}
+	// Deleting k08 leaves it as the root's separator; re-inserting it must
+	// route to the right of that separator, where Get looks for it
+	tree.Delete("k08")
+	tree.Insert("k08", "again")
+	if err := tree.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if val, ok := tree.Get("k08"); !ok || val != "again" {
+		t.Errorf("Get(k08) = (%q, %v), want \"again\"", val, ok)
+	}
+}
+
+func TestNodePoolResetsNodes(t *testing.T) {
+	pool := &nodePool{}
+	leaf := pool.get(true, ORDER)
+	leaf.keys = append(leaf.keys, "a", "b")
+	leaf.values = append(leaf.values, "1", "2")
+	leaf.prefix = "p"
+	leaf.compress = true
+	leaf.next = &BPlusTreeNode{}
+	pool.put(leaf)
+
+	reused := pool.get(true, ORDER)
+	if reused != leaf {
+		t.Fatal("Expected the pooled leaf to be reused")
+	}
+	if len(reused.keys) != 0 || len(reused.values) != 0 || reused.next != nil || reused.prefix != "" || reused.compress {
+		t.Errorf("Expected a fully reset leaf, got %+v", reused)
+	}
+	for i, k := range reused.keys[:cap(reused.keys)] {
+		if k != "" || reused.values[:cap(reused.values)][i] != "" {
+			t.Errorf("Expected cleared backing arrays, found stale data at %d", i)
+		}
+	}
+	if pool.get(false, ORDER).isLeaf {
+		t.Error("Expected an internal node from an empty internal free list")
+	}
+}
+
+func BenchmarkNodePoolChurn(b *testing.B) {
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%05d", i)
+	}
+
+	for _, bc := range []struct {
+		name    string
+		newTree func() *BPlusTree
+	}{
+		{"Plain", NewBPlusTree},
+		{"NodePool", NewBPlusTreeWithNodePool},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tree := bc.newTree()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, k := range keys {
+					tree.Insert(k, "v")
+				}
+				for _, k := range keys {
+					tree.Delete(k)
+				}
+			}
+		})
+	}
+}
+
+func TestAscendFrom(t *testing.T) {
+	tree := NewBPlusTree()
+	for i := 0; i < 50; i += 2 {
+		tree.Insert(fmt.Sprintf("k%02d", i), "v")
+	}
+
+	for _, tt := range []struct {
+		start string
+		first string
+		count int
+	}{
+		{"", "k00", 25},
+		{"k10", "k10", 20},
+		{"k11", "k12", 19}, // start between keys
+		{"k48", "k48", 1},
+		{"k49", "", 0},
+	} {
+		var keys []string
+		tree.AscendFrom(tt.start, func(key, value string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if len(keys) != tt.count || (tt.count > 0 && keys[0] != tt.first) {
+			t.Errorf("AscendFrom(%q) visited %v, want %d keys starting at %q", tt.start, keys, tt.count, tt.first)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	tree := NewBPlusTree()
+	if got, want := tree.Stats(), (TreeStats{Height: 1, Nodes: 1, Leaves: 1}); got != want {
+		t.Errorf("Stats of an empty tree = %+v, want %+v", got, want)
+	}
+
+	// Inserting a fifth key splits the leaf: root [c], leaves [a b] and [c d e]
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tree.Insert(k, "v")
+	}
+	want := TreeStats{Height: 2, Depth: 1, Nodes: 3, Leaves: 2, Keys: 5, FillFactor: 6.0 / 3 / (ORDER - 1)}
+	if got := tree.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	if got := tree.Stats().Height; got != tree.Height() {
+		t.Errorf("Stats height %d disagrees with Height() %d", got, tree.Height())
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, newTree := range []func() *BPlusTree{NewBPlusTree, NewBPlusTreeWithPrefixCompression, NewBPlusTreeWithNodePool} {
+		for n := 0; n <= 70; n++ {
+			pairs := make([]KeyValue, n)
+			for i := range pairs {
+				pairs[i] = KeyValue{Key: fmt.Sprintf("key%03d", i), Value: fmt.Sprintf("v%d", i)}
+			}
+			tree := newTree()
+			tree.Insert("stale", "x")
+			if err := tree.BulkLoad(pairs); err != nil {
+				t.Fatalf("BulkLoad(%d pairs): %v", n, err)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("Validate after BulkLoad(%d pairs): %v", n, err)
+			}
+			if got := tree.Count(); got != n {
+				t.Fatalf("Expected %d keys after BulkLoad, got %d", n, got)
+			}
+			for _, kv := range pairs {
+				if v, ok := tree.Get(kv.Key); !ok || v != kv.Value {
+					t.Fatalf("Get(%q) = %q, %v after BulkLoad(%d pairs)", kv.Key, v, ok, n)
+				}
+			}
+
+			// The loaded tree keeps working as a normal tree
+			tree.Insert("key000a", "new")
+			tree.Delete("key000")
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("Validate after modifying a bulk-loaded tree of %d pairs: %v", n, err)
+			}
+		}
+	}
+
+	if err := NewBPlusTree().BulkLoad([]KeyValue{{"b", "1"}, {"a", "2"}}); err == nil {
+		t.Error("Expected an error for unsorted pairs")
+	}
+}
+
+func TestPath(t *testing.T) {
+	tree := NewBPlusTree()
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tree.Insert(k, "v")
+	}
+
+	// Root [c] over leaves [a b] and [c d e]; a key equal to a separator goes right
+	want := []PathStep{
+		{Keys: []string{"c"}, Child: 1},
+		{Keys: []string{"c", "d", "e"}, Leaf: true, Child: -1},
+	}
+	for _, key := range []string{"c", "d", "zzz"} {
+		if got := tree.Path(key); !reflect.DeepEqual(got, want) {
+			t.Errorf("Path(%q) = %+v, want %+v", key, got, want)
+		}
+	}
+	want = []PathStep{
+		{Keys: []string{"c"}, Child: 0},
+		{Keys: []string{"a", "b"}, Leaf: true, Child: -1},
+	}
+	if got := tree.Path("bb"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Path(%q) = %+v, want %+v", "bb", got, want)
+	}
+}
+
+func TestAppendSplits(t *testing.T) {
+	sequential := func(tree *BPlusTree) *BPlusTree {
+		for i := 0; i < 1000; i++ {
+			tree.Insert(fmt.Sprintf("k%04d", i), "v")
+		}
+		return tree
+	}
+	plain, appended := sequential(NewBPlusTree()).Stats(), sequential(NewBPlusTreeWithAppendSplits()).Stats()
+	if appended.Leaves*3 > 1000+2 {
+		t.Errorf("Expected sequential inserts to fill leaves with %d keys each, got %d leaves", ORDER-1, appended.Leaves)
+	}
+	if appended.Leaves >= plain.Leaves || appended.FillFactor <= plain.FillFactor {
+		t.Errorf("Expected append splits to pack leaves tighter: plain %+v, append %+v", plain, appended)
+	}
+
+	// Out-of-order inserts and deletes still split and merge as usual
+	tree := sequential(NewBPlusTreeWithAppendSplits())
+	for i := 0; i < 999; i += 3 {
+		tree.Insert(fmt.Sprintf("k%04d.5", i), "v")
+		tree.Delete(fmt.Sprintf("k%04d", i+1))
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got, want := tree.Count(), 1000; got != want {
+		t.Errorf("Expected %d keys, got %d", want, got)
+	}
+}
+
+func BenchmarkSequentialInsert(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%05d", i)
+	}
+
+	for _, bc := range []struct {
+		name    string
+		newTree func() *BPlusTree
+	}{
+		{"Plain", NewBPlusTree},
+		{"AppendSplits", NewBPlusTreeWithAppendSplits},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var tree *BPlusTree
+			for i := 0; i < b.N; i++ {
+				tree = bc.newTree()
+				for _, k := range keys {
+					tree.Insert(k, "v")
+				}
+			}
+			b.ReportMetric(float64(tree.Stats().Nodes), "nodes")
+		})
+	}
+}
+
+func TestBPlusTreeWithOrder(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 16} {
+		tree := NewBPlusTreeWithOrder(order)
+		const n = 300
+		for _, i := range rand.New(rand.NewSource(int64(order))).Perm(n) {
+			tree.Insert(fmt.Sprintf("key%03d", i), fmt.Sprintf("v%d", i))
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("order %d: Validate after insert: %v", order, err)
+			}
+		}
+		if got := tree.Count(); got != n {
+			t.Fatalf("order %d: expected %d keys, got %d", order, n, got)
+		}
+		for i := 0; i < n; i += 2 {
+			if !tree.Delete(fmt.Sprintf("key%03d", i)) {
+				t.Fatalf("order %d: Delete(key%03d) failed", order, i)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("order %d: Validate after delete: %v", order, err)
+			}
+		}
+		for i := 0; i < n; i++ {
+			_, ok := tree.Get(fmt.Sprintf("key%03d", i))
+			if ok != (i%2 == 1) {
+				t.Fatalf("order %d: Get(key%03d) found = %v", order, i, ok)
+			}
+		}
+
+		pairs := make([]KeyValue, 50)
+		for i := range pairs {
+			pairs[i] = KeyValue{Key: fmt.Sprintf("key%03d", i), Value: "v"}
+		}
+		if err := tree.BulkLoad(pairs); err != nil {
+			t.Fatalf("order %d: BulkLoad: %v", order, err)
+		}
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("order %d: Validate after BulkLoad: %v", order, err)
+		}
+	}
+
+	// A wider tree is shallower
+	narrow, wide := NewBPlusTreeWithOrder(3), NewBPlusTreeWithOrder(16)
+	for i := 0; i < 100; i++ {
+		narrow.Insert(fmt.Sprintf("%03d", i), "v")
+		wide.Insert(fmt.Sprintf("%03d", i), "v")
+	}
+	if narrow.Height() <= wide.Height() {
+		t.Errorf("Expected order 3 to be taller than order 16, got heights %d and %d", narrow.Height(), wide.Height())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected NewBPlusTreeWithOrder(2) to panic")
+		}
+	}()
+	NewBPlusTreeWithOrder(2)
+}
+
+func TestEstimateRangeCount(t *testing.T) {
+	bounds := []string{"", "k000", "k050", "k050a", "k123", "k299", "k300", "zzz"}
+	check := func(name string, tree *BPlusTree) {
+		t.Helper()
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, start := range bounds {
+			for _, end := range bounds {
+				want := len(tree.RangeQuery(start, end))
+				if got := tree.EstimateRangeCount(start, end); got != want {
+					t.Fatalf("%s: EstimateRangeCount(%q, %q) = %d, want %d", name, start, end, got, want)
+				}
+			}
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, newTree := range []func() *BPlusTree{NewBPlusTree, NewBPlusTreeWithNodePool, NewBPlusTreeWithAppendSplits, func() *BPlusTree { return NewBPlusTreeWithOrder(7) }} {
+		tree := newTree()
+		check("empty tree", tree)
+		for _, i := range rng.Perm(300) {
+			tree.Insert(fmt.Sprintf("k%03d", i), "v")
+		}
+		check("after inserts", tree)
+		for _, i := range rng.Perm(300)[:200] {
+			tree.Delete(fmt.Sprintf("k%03d", i))
+		}
+		check("after deletes", tree)
+
+		pairs := make([]KeyValue, 250)
+		for i := range pairs {
+			pairs[i] = KeyValue{Key: fmt.Sprintf("k%03d", i), Value: "v"}
+		}
+		tree.BulkLoad(pairs)
+		check("after BulkLoad", tree)
+		tree.Delete("k100")
+		tree.Insert("k050a", "v")
+		check("after modifying a bulk-loaded tree", tree)
+	}
+}
+
+func TestPrefixScan(t *testing.T) {
+	tree := NewBPlusTree()
+	for i := 0; i < 50; i++ {
+		tree.Insert(fmt.Sprintf("item_%02d", i), "v")
+	}
+	tree.Insert("user_1", "alice")
+	tree.Insert("user_2", "bob")
+	tree.Insert("userx", "carol")
+
+	result := tree.PrefixScan("user_")
+	expected := map[string]string{"user_1": "alice", "user_2": "bob"}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d results, got %v", len(expected), result)
+	}
+	for k, v := range expected {
+		if result[k] != v {
+			t.Errorf("Expected key %q = %q, got %q", k, v, result[k])
+		}
+	}
+	if got := len(tree.PrefixScan("item_1")); got != 10 {
+		t.Errorf("Expected 10 results for prefix item_1, got %d", got)
+	}
+	if got := len(tree.PrefixScan("")); got != 53 {
+		t.Errorf("Expected every key for an empty prefix, got %d", got)
+	}
+	if result := tree.PrefixScan("zzz"); len(result) != 0 {
+		t.Errorf("Expected no results for an unmatched prefix, got %v", result)
+	}
+}
@@ -0,0 +1,30 @@
+package db
+
+import "errors"
+
+// SetBytes stores value under key in table, inserting the key or updating it
+// as needed, like APPEND or INCR. Values are held as Go strings, which are
+// arbitrary byte sequences, so binary data such as null bytes is stored
+// as-is without an encoding step; the log escapes it (see walField). The
+// error carries the statement response when the write is refused.
+func (e *Engine) SetBytes(table, key string, value []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if resp := e.writeValues(table, []KeyValue{{Key: e.normalizeKey(table, key), Value: string(value)}}); resp != "" {
+		return errors.New(resp)
+	}
+	return nil
+}
+
+// GetBytes returns the value of key in table as stored by SetBytes or any
+// other write, and whether the key exists. Inside a transaction it sees the
+// transaction's buffered changes.
+func (e *Engine) GetBytes(table, key string) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, _, ok := e.getVisible(table, e.normalizeKey(table, key))
+	if !ok {
+		return nil, false
+	}
+	return []byte(value), true
+}
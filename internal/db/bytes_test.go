@@ -0,0 +1,55 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEngineSetGetBytes(t *testing.T) {
+	e := setupTestEngine(t)
+	blob := []byte{'a', 0, 'b', 0, 0, 0xff, '\n', ' ', '"'}
+
+	if err := e.SetBytes("blobs", "k", blob); err != nil {
+		t.Fatalf("SetBytes failed: %v", err)
+	}
+	if got, ok := e.GetBytes("blobs", "k"); !ok || !bytes.Equal(got, blob) {
+		t.Errorf("Expected %q, got %q (found %v)", blob, got, ok)
+	}
+	if _, ok := e.GetBytes("blobs", "missing"); ok {
+		t.Error("Expected a missing key not to be found")
+	}
+
+	log, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.IndexByte(log, 0) >= 0 {
+		t.Errorf("Expected null bytes to be escaped in the log, got %q", log)
+	}
+
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if got, ok := e.GetBytes("blobs", "k"); !ok || !bytes.Equal(got, blob) {
+		t.Errorf("Expected %q after restart, got %q (found %v)", blob, got, ok)
+	}
+}
+
+func TestEngineLargeBlobSurvivesRestart(t *testing.T) {
+	e := setupTestEngine(t)
+	blob := bytes.Repeat([]byte{0, 0xff, '\n', 'x'}, 20000) // 80KB, longer still once escaped
+
+	if err := e.SetBytes("blobs", "big", blob); err != nil {
+		t.Fatalf("SetBytes failed: %v", err)
+	}
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if got, ok := e.GetBytes("blobs", "big"); !ok || !bytes.Equal(got, blob) {
+		t.Errorf("Expected the %d-byte blob after restart, got %d bytes (found %v)", len(blob), len(got), ok)
+	}
+	if value, ok, err := e.wal.LastValue("blobs", "big"); err != nil || !ok || len(value) == 0 {
+		t.Errorf("Expected LastValue to read the blob, got %d bytes, %v, %v", len(value), ok, err)
+	}
+}
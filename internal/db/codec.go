@@ -0,0 +1,89 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Codec transforms a table's values between their logical form and the form
+// kept in the tree and written to the WAL. Decode must invert Encode.
+type Codec interface {
+	Encode(value string) string
+	Decode(stored string) (string, error)
+}
+
+// Encoded values start with codecMarker followed by a tag byte, so a codec
+// can store small values verbatim and still tell them apart on decode. A
+// plain value that happens to start with the marker is stored tagged as raw.
+const (
+	codecMarker = "\x00"
+	codecRaw    = codecMarker + "r"
+	codecGzip   = codecMarker + "z"
+)
+
+// GzipCodec compresses values of at least Threshold bytes with gzip and
+// stores smaller values unchanged, avoiding the gzip overhead for them.
+type GzipCodec struct {
+	Threshold int
+}
+
+func (c GzipCodec) Encode(value string) string {
+	if len(value) < c.Threshold {
+		if strings.HasPrefix(value, codecMarker) {
+			return codecRaw + value
+		}
+		return value
+	}
+	var buf bytes.Buffer
+	buf.WriteString(codecGzip)
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(value)) // Writes to a bytes.Buffer cannot fail
+	zw.Close()
+	return buf.String()
+}
+
+func (c GzipCodec) Decode(stored string) (string, error) {
+	switch {
+	case strings.HasPrefix(stored, codecGzip):
+		zr, err := gzip.NewReader(strings.NewReader(stored[len(codecGzip):]))
+		if err != nil {
+			return "", err
+		}
+		value, err := io.ReadAll(zr)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	case strings.HasPrefix(stored, codecRaw):
+		return stored[len(codecRaw):], nil
+	case strings.HasPrefix(stored, codecMarker):
+		return "", errors.New("unknown codec tag")
+	default:
+		return stored, nil
+	}
+}
+
+// encodeValue converts a value of table to its stored form.
+func (e *Engine) encodeValue(table, value string) string {
+	if codec, ok := e.opts.Codecs[table]; ok {
+		return codec.Encode(value)
+	}
+	return value
+}
+
+// decodeValue converts a stored value of table back to its logical form. A
+// value that fails to decode is returned as stored rather than hidden.
+func (e *Engine) decodeValue(table, stored string) string {
+	codec, ok := e.opts.Codecs[table]
+	if !ok {
+		return stored
+	}
+	value, err := codec.Decode(stored)
+	if err != nil {
+		return stored
+	}
+	return value
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// RegisterCommand adds a custom statement to the engine, so embedders can
+// provide domain-specific verbs without changing the parser. A statement
+// whose leading keyword matches (case-insensitively) is passed to handler
+// with its remaining tokens, and handler's return value is the response.
+// Tokens are split as for built-in statements: "(", ")" and "," are tokens
+// of their own and quoted spans keep their quotes. A trailing semicolon is
+// dropped.
+//
+// The handler runs while the engine is locked, like any statement, so it
+// must not call back into the engine. Its response counts as an error (for
+// RUN and the audit log) if it starts with "Error". Built-in keywords
+// cannot be overridden, and a keyword can only be registered once.
+func (e *Engine) RegisterCommand(keyword string, handler func(args []string) string) error {
+	if keyword == "" || strings.ContainsFunc(keyword, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`(),;'"`+"`", r)
+	}) {
+		return fmt.Errorf("invalid command keyword %q", keyword)
+	}
+	if handler == nil {
+		return errors.New("command handler must not be nil")
+	}
+	keyword = strings.ToUpper(keyword)
+	if _, err := Parse(keyword); !errors.Is(err, errUnsupportedStatement) {
+		return fmt.Errorf("%s is a built-in statement", keyword)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.commands[keyword]; ok {
+		return fmt.Errorf("command %s is already registered", keyword)
+	}
+	if e.commands == nil {
+		e.commands = make(map[string]func(args []string) string)
+	}
+	e.commands[keyword] = handler
+	return nil
+}
+
+// parse is Parse that also recognizes the engine's custom commands. The
+// caller holds e.mu.
+func (e *Engine) parse(cmd string) (Statement, error) {
+	stmt, err := Parse(cmd)
+	if !errors.Is(err, errUnsupportedStatement) {
+		if err == nil {
+			e.normalizeStatement(stmt)
+		}
+		return stmt, err
+	}
+	tokens := statementTokens(cmd)
+	keyword := strings.ToUpper(tokens[0])
+	if _, ok := e.commands[keyword]; !ok {
+		return nil, err
+	}
+	args := make([]string, len(tokens)-1)
+	for i, tok := range tokens[1:] {
+		args[i] = unescape(tok)
+	}
+	return &CustomStatement{Keyword: keyword, Args: args}, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEngineRegisterCommand(t *testing.T) {
+	e := setupTestEngine(t)
+	var calls [][]string
+	err := e.RegisterCommand("expire", func(args []string) string {
+		calls = append(calls, args)
+		if len(args) != 3 || strings.ToUpper(args[1]) != "AFTER" {
+			return "Error: expected EXPIRE <key> AFTER <seconds>"
+		}
+		return "Expiring " + args[0] + " after " + args[2] + "s"
+	})
+	if err != nil {
+		t.Fatalf("RegisterCommand failed: %v", err)
+	}
+
+	if resp := e.Execute("EXPIRE 'session 1' AFTER 60;"); resp != "Expiring 'session 1' after 60s" {
+		t.Errorf("Unexpected response %q", resp)
+	}
+	if resp := e.Execute("Expire x"); resp != "Error: expected EXPIRE <key> AFTER <seconds>" {
+		t.Errorf("Unexpected response %q", resp)
+	}
+	if resp := e.Execute("UNKNOWN x"); resp != "Parse error: unsupported statement: UNKNOWN" {
+		t.Errorf("Expected unregistered keywords to stay unsupported, got %q", resp)
+	}
+	if len(calls) != 2 {
+		t.Errorf("Expected 2 calls, got %d", len(calls))
+	}
+
+	script := "test_commands.sql"
+	defer os.Remove(script)
+	if err := os.WriteFile(script, []byte("INSERT (a, 1) INTO t;\nEXPIRE a AFTER 5;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := e.Execute("RUN '" + script + "'"); resp != "Ran 2 statement(s) from '"+script+"'" {
+		t.Errorf("Expected custom commands to run in scripts, got %q", resp)
+	}
+
+	for _, tt := range []struct{ keyword, expected string }{
+		{"select", "SELECT is a built-in statement"},
+		{"Expire", "command EXPIRE is already registered"},
+		{"two words", `invalid command keyword "two words"`},
+		{"", `invalid command keyword ""`},
+	} {
+		if err := e.RegisterCommand(tt.keyword, func([]string) string { return "" }); err == nil || err.Error() != tt.expected {
+			t.Errorf("RegisterCommand(%q): expected error %q, got %v", tt.keyword, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+)
+
+// cursor is a named, server-side position in the result of a SELECT. It
+// remembers the last key returned instead of a pointer into the tree, so
+// every FETCH seeks straight past it the way AFTER does, and tree splits or
+// merges in between cannot leave it dangling.
+type cursor struct {
+	query       *SelectStatement
+	last        string   // key of the last row fetched; "" before the first FETCH
+	started     bool     // a FETCH has returned rows, so last is set
+	exhausted   bool     // a FETCH returned fewer rows than asked for
+	tables      []string // tables and views the query reads from
+	invalidated string   // table whose change invalidated the cursor, if any
+}
+
+// declareCursor opens a cursor over s. Only plain row queries can be paged
+// by key: key lists, DISTINCT ON, TOP, LIMIT and aggregates are rejected.
+func (e *Engine) declareCursor(name string, s *SelectStatement) string {
+	if _, exists := e.cursors[name]; exists {
+		return fmt.Sprintf("Error: cursor '%s' already exists", name)
+	}
+	if len(s.Keys) > 0 || s.DistinctOnValue || s.Top > 0 || s.Limit > 0 || s.Every > 0 || s.Aggregate != "" || s.PrefixSep != "" {
+		return "Error: a cursor must select all rows (SELECT *, SELECT <func>(value) or SELECT SEGMENT), without DISTINCT ON, TOP, LIMIT, EVERY or aggregates"
+	}
+	if s.Where != nil && s.Where.Op == "IS DUPLICATED" {
+		return "Error: a cursor pages in key order, which WHERE value IS DUPLICATED does not keep"
+	}
+	if s.AsOf > 0 {
+		return "Error: a cursor reads the current tables, not AS OF SEQUENCE"
+	}
+	if !e.tableVisible(s.Table) && !e.isView(s.Table) {
+		return fmt.Sprintf("Table '%s' not found", s.Table)
+	}
+	if e.cursors == nil {
+		e.cursors = make(map[string]*cursor)
+	}
+	e.cursors[name] = &cursor{query: s, tables: e.selectDependencies(s, nil)}
+	return fmt.Sprintf("Cursor '%s' declared", name)
+}
+
+// fetchCursor returns the next count rows of a cursor, in key order.
+func (e *Engine) fetchCursor(name string, count int) string {
+	c, ok := e.cursors[name]
+	if !ok {
+		return fmt.Sprintf("Error: cursor '%s' does not exist", name)
+	}
+	if c.invalidated != "" {
+		return fmt.Sprintf("Error: cursor '%s' is invalid because table '%s' changed; CLOSE and DECLARE it again", name, c.invalidated)
+	}
+	if c.exhausted {
+		return e.renderRows(nil, c.query.Format)
+	}
+
+	page := *c.query
+	page.Limit = count
+	if c.started {
+		page.After = max(page.After, c.last)
+	}
+	rows, err := e.selectRows(&page)
+	if err != nil {
+		return err.Error()
+	}
+	if len(rows) > 0 {
+		c.last, c.started = rows[len(rows)-1].Key, true
+	}
+	c.exhausted = len(rows) < count
+	return e.renderRows(rows, c.query.Format)
+}
+
+// closeCursor releases a cursor.
+func (e *Engine) closeCursor(name string) string {
+	if _, ok := e.cursors[name]; !ok {
+		return fmt.Sprintf("Error: cursor '%s' does not exist", name)
+	}
+	delete(e.cursors, name)
+	return fmt.Sprintf("Cursor '%s' closed", name)
+}
+
+// invalidateCursors marks the cursors reading from table as invalid, since
+// the rows they have not returned yet may no longer match what their
+// earlier pages were part of.
+func (e *Engine) invalidateCursors(table string) {
+	for _, c := range e.cursors {
+		if c.invalidated == "" && slices.Contains(c.tables, table) {
+			c.invalidated = table
+		}
+	}
+}
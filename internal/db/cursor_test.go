@@ -0,0 +1,70 @@
+package db
+
+import "testing"
+
+func TestCursor(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute("INSERT (a, 1), (b, 2), (c, 3), (d, 4), (e, 5) INTO t")
+
+	steps := []struct {
+		cmd      string
+		expected string
+	}{
+		{"DECLARE cur CURSOR FOR SELECT * FROM t AFTER a", "Cursor 'cur' declared"},
+		{"FETCH 2 FROM cur", "b: 2\nc: 3"},
+		{"FETCH 2 FROM cur", "d: 4\ne: 5"},
+		{"FETCH 2 FROM cur", "No results"},
+		{"FETCH 2 FROM cur", "No results"},
+		{"DECLARE cur FOR SELECT * FROM t", "Error: cursor 'cur' already exists"},
+		{"CLOSE cur", "Cursor 'cur' closed"},
+		{"FETCH 1 FROM cur", "Error: cursor 'cur' does not exist"},
+		{"CLOSE cur", "Error: cursor 'cur' does not exist"},
+		{"DECLARE j FOR SELECT * FROM t FORMAT JSON", "Cursor 'j' declared"},
+		{"FETCH 1 FROM j", `[{"key":"a","value":"1"}]`},
+		{"DECLARE bad FOR SELECT * FROM t LIMIT 2", "Error: a cursor must select all rows (SELECT *, SELECT <func>(value) or SELECT SEGMENT), without DISTINCT ON, TOP, LIMIT, EVERY or aggregates"},
+		{"DECLARE bad FOR SELECT a, b FROM t", "Error: a cursor must select all rows (SELECT *, SELECT <func>(value) or SELECT SEGMENT), without DISTINCT ON, TOP, LIMIT, EVERY or aggregates"},
+		{"DECLARE bad FOR SELECT * FROM nope", "Table 'nope' not found"},
+	}
+	for _, step := range steps {
+		if got := e.Execute(step.cmd); got != step.expected {
+			t.Errorf("%s: expected %q, got %q", step.cmd, step.expected, got)
+		}
+	}
+}
+
+func TestCursorInvalidatedByWrite(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute("INSERT (a, 1), (b, 2), (c, 3) INTO t")
+	e.Execute("INSERT (x, 1) INTO other")
+	e.Execute("DECLARE cur FOR SELECT * FROM t")
+
+	if got := e.Execute("FETCH 1 FROM cur"); got != "a: 1" {
+		t.Fatalf("Expected first row, got %q", got)
+	}
+	e.Execute("INSERT (y, 2) INTO other")
+	if got := e.Execute("FETCH 1 FROM cur"); got != "b: 2" {
+		t.Errorf("Expected a write to another table to leave the cursor valid, got %q", got)
+	}
+
+	e.Execute("DELETE c FROM t")
+	expected := "Error: cursor 'cur' is invalid because table 't' changed; CLOSE and DECLARE it again"
+	if got := e.Execute("FETCH 1 FROM cur"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	e.Execute("CLOSE cur")
+	e.Execute("DECLARE cur FOR SELECT * FROM t")
+	e.Execute("BEGIN")
+	e.Execute("INSERT (z, 9) INTO t")
+	if got := e.Execute("FETCH 5 FROM cur"); got != expected {
+		t.Errorf("Expected a write inside a transaction to invalidate the cursor, got %q", got)
+	}
+}
+
+func TestParseCursorStatements(t *testing.T) {
+	for _, cmd := range []string{"DECLARE", "DECLARE cur", "DECLARE cur FOR", "DECLARE cur FOR DELETE a FROM t", "FETCH 0 FROM cur", "FETCH x FROM cur", "FETCH 1 cur", "CLOSE", "CLOSE a b"} {
+		if _, err := Parse(cmd); err == nil {
+			t.Errorf("Expected %q to fail to parse", cmd)
+		}
+	}
+}
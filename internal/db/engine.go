@@ -1,8 +1,14 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +17,7 @@ import (
 type Engine struct {
 	wal    *WAL
 	tables map[string]*BPlusTree
+	opts   EngineOptions
 
 	// Transaction management
 	mu              sync.Mutex // Global mutex for simplified concurrency control
@@ -18,42 +25,295 @@ type Engine struct {
 	txChanges       map[string]map[string]string   // table -> key -> value (for SET/INSERT/UPDATE)
 	txDeletes       map[string]map[string]struct{} // table -> key -> {} (for DELETE)
 	txDroppedTables map[string]struct{}            // table -> {} (for DROP)
+	txLastActive    time.Time                      // time of the last statement in the current transaction
+	expiredTxID     string                         // transaction rolled back for idling, reported on the next statement
+	ctx             context.Context                // context of the statement being executed, see ExecuteContext
+
+	// Display settings
+	typedOutput bool // annotate SELECT values with their inferred type
+	valueWidth  int  // truncate displayed SELECT values to this many characters; 0 disables
+
+	queryCache *queryCache                           // cached SELECT results; nil unless QueryCacheSize is set
+	listeners  map[string]map[*Listener]struct{}     // table -> listeners, see Listen
+	replicas   []io.Writer                           // see AddReplica
+	cursors    map[string]*cursor                    // open cursors by name, see DECLARE
+	commands   map[string]func(args []string) string // custom commands by keyword, see RegisterCommand
+	lru        map[string]*keyLRU                    // table -> key access order; used with MaxKeys
+	prepared   map[string]*preparedTx                // prepared transactions by global ID, see PREPARE TRANSACTION
+
+	// Recently committed transaction IDs, so a retried COMMIT <txID> is a no-op
+	committedTxIDs   map[string]struct{}
+	committedTxOrder []string // oldest first, bounded by maxRecentCommits
+
+	recovery ReplayStats // what replaying the log at startup skipped
+
+	// Activity counters, see Stats
+	statements       int64
+	failedStatements int64
+	ops              map[string]int64 // statement type -> count
+
+	// Periodic metrics, see EngineOptions.MetricsWriter
+	metricsStop chan struct{}
+	metricsDone chan struct{}
+	metricsOnce sync.Once
 }
 
+// sequenceTable is the reserved table holding the current value of every
+// sequence used by NEXTVAL. It is hidden from SHOW TABLES.
+const sequenceTable = "__sequences"
+
+// maxRecentCommits bounds how many committed transaction IDs are remembered
+// for detecting duplicate COMMITs.
+const maxRecentCommits = 256
+
 func NewEngine(logPath string) *Engine {
-	wal := NewWAL(logPath)
+	return NewEngineWithOptions(logPath, EngineOptions{})
+}
+
+func NewEngineWithOptions(logPath string, opts EngineOptions) *Engine {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.NewTxID == nil {
+		opts.NewTxID = func() string { return fmt.Sprintf("tx_%d", time.Now().UnixNano()) }
+	}
+
+	if opts.PerTableLogs && opts.CompactCommits {
+		panic("PerTableLogs cannot be combined with CompactCommits")
+	}
+	open := func() (*WAL, error) { return OpenWAL(logPath) }
+	if opts.PerTableLogs {
+		open = func() (*WAL, error) { return OpenPerTableWAL(logPath) }
+	}
+	wal, err := openWithBusyTimeout(open, opts.BusyTimeout)
+	if err != nil {
+		panic(err)
+	}
 	engine := &Engine{
 		wal:             wal,
-		tables:          make(map[string]*BPlusTree),
+		tables:          make(map[string]*BPlusTree, opts.PreallocTables),
+		opts:            opts,
 		txChanges:       make(map[string]map[string]string),
 		txDeletes:       make(map[string]map[string]struct{}),
 		txDroppedTables: make(map[string]struct{}),
+		committedTxIDs:  make(map[string]struct{}),
+	}
+	if opts.QueryCacheSize > 0 {
+		engine.queryCache = newQueryCache(opts.QueryCacheSize)
 	}
 
-	tablesData, err := wal.Replay()
+	tablesData, recovery, prepared, err := wal.replay(opts.ReplayProgress, 0)
 	if err != nil {
 		panic("Failed to replay WAL: " + err.Error())
 	}
+	engine.recovery = recovery
+	if recovery.openBatch {
+		if err := wal.AbortBatch(); err != nil {
+			panic("Failed to abort the incomplete batch at the end of the WAL: " + err.Error())
+		}
+	}
+	for _, p := range prepared {
+		engine.restorePrepared(p)
+	}
 
 	for tableName, entries := range tablesData {
-		tree := NewBPlusTree()
+		tree := engine.newTree()
 		for _, entry := range entries {
-			tree.Insert(entry[0], entry[1])
+			tree.Insert(engine.normalizeKey(tableName, entry[0]), entry[1])
 		}
 		engine.tables[tableName] = tree
 	}
+	if opts.MaxKeys > 0 {
+		engine.lru = make(map[string]*keyLRU)
+		for tableName, tree := range engine.tables {
+			tree.Ascend(func(key, _ string) bool {
+				engine.touchKey(tableName, key)
+				return true
+			})
+			engine.evictKeys(tableName) // MaxKeys may have been lowered since the last run
+		}
+	}
+	if opts.MetricsWriter != nil && opts.MetricsInterval > 0 {
+		engine.startMetrics(opts.MetricsWriter, opts.MetricsInterval)
+	}
 	return engine
 }
 
-func (e *Engine) Execute(cmd string) string {
+// RecoveryStats reports the transaction markers that replaying the log at
+// startup skipped, such as a stray or duplicate COMMIT_TX.
+func (e *Engine) RecoveryStats() ReplayStats {
+	return e.recovery
+}
+
+// newTree returns an empty tree for a new table.
+func (e *Engine) newTree() *BPlusTree {
+	tree := NewBPlusTree()
+	if e.opts.PoolNodes {
+		tree = NewBPlusTreeWithNodePool()
+	}
+	tree.appendSplits = e.opts.AppendSplits
+	return tree
+}
+
+// Close closes the engine's write-ahead log and releases its lock. With
+// CompactOnClose set and no transaction open, the log is compacted first.
+func (e *Engine) Close() error {
+	e.stopMetrics()
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if e.opts.CompactOnClose && e.wal.file != nil && e.currentTxID == "" && len(e.prepared) == 0 {
+		if err := e.checkpoint(); err != nil {
+			e.wal.Close()
+			return fmt.Errorf("compact on close: %w", err)
+		}
+	}
+	return e.wal.Close()
+}
+
+// ErrTxActive is returned by Checkpoint, CompactNow and Vacuum while a
+// transaction is open.
+var ErrTxActive = errors.New("cannot checkpoint while a transaction is active")
+
+// ErrTxPrepared is returned by Checkpoint and CompactNow while a prepared
+// transaction is waiting for COMMIT PREPARED or ROLLBACK PREPARED, since
+// rewriting the log from the table contents would lose it.
+var ErrTxPrepared = errors.New("cannot checkpoint while a prepared transaction is pending")
+
+// Checkpoint rewrites the write-ahead log to contain only the current
+// contents of every table, dropping overwritten values, deleted keys,
+// dropped tables and finished transactions. It fails with ErrTxActive while
+// a transaction is open, since its buffered changes are not yet part of the
+// table contents.
+func (e *Engine) Checkpoint() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.currentTxID != "" {
+		return ErrTxActive
+	}
+	return e.checkpoint()
+}
 
-	stmt, err := Parse(cmd)
+// CompactNow rewrites the write-ahead log as the minimal set of autocommit
+// SET records for the state it replays to, dropping transaction markers,
+// rolled-back or unfinished transactions and superseded writes. Unlike
+// Checkpoint, the new log is derived from the log itself rather than from
+// the in-memory tables. The old log is replaced atomically and the WAL
+// continues on the compacted file. It fails with ErrTxActive while a
+// transaction is open.
+func (e *Engine) CompactNow() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.currentTxID != "" {
+		return ErrTxActive
+	}
+	if len(e.prepared) > 0 {
+		return ErrTxPrepared
+	}
+	tablesData, err := e.wal.Replay()
+	if err != nil {
+		return err
+	}
+	for _, entries := range tablesData {
+		sort.Slice(entries, func(i, j int) bool { return entries[i][0] < entries[j][0] })
+	}
+	return e.wal.Compact(tablesData)
+}
+
+// Vacuum removes the records of transactions that never committed from the
+// write-ahead log (see WAL.Vacuum) and returns how many were removed. Unlike
+// Checkpoint, it keeps the log's history of committed changes. It fails with
+// ErrTxActive while a transaction is open, since that transaction has not
+// committed yet either.
+func (e *Engine) Vacuum() (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.vacuum()
+}
+
+// SealLog compresses the write-ahead log with gzip to save disk space (see
+// WAL.Seal). Records written afterwards are appended uncompressed, so it can
+// be called again from time to time, for example after a large import.
+func (e *Engine) SealLog() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.wal.Seal()
+}
+
+func (e *Engine) vacuum() (int, error) {
+	if e.currentTxID != "" {
+		return 0, ErrTxActive
+	}
+	return e.wal.Vacuum()
+}
+
+func (e *Engine) checkpoint() error {
+	if len(e.prepared) > 0 {
+		return ErrTxPrepared
+	}
+	snapshot := make(map[string][][2]string, len(e.tables))
+	for name, tree := range e.tables {
+		snapshot[name] = nil // Keep tables that are empty
+		tree.Ascend(func(key, value string) bool {
+			snapshot[name] = append(snapshot[name], [2]string{key, value})
+			return true
+		})
+	}
+	return e.wal.Compact(snapshot)
+}
+
+// Barrier blocks until every change logged so far is durable on disk. Use it
+// to force durability at chosen checkpoints instead of relying on the sync
+// performed by each COMMIT.
+func (e *Engine) Barrier() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.wal.Sync()
+}
+
+func (e *Engine) Execute(cmd string) (resp string) {
+	return e.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext is Execute with a context that can cancel a long-running
+// aggregate, such as a SUM over a large table, while it scans. The scan
+// then stops with an error and nothing is changed. Other statements run to
+// completion.
+func (e *Engine) ExecuteContext(ctx context.Context, cmd string) (resp string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ctx = ctx
+	defer func() { e.ctx = nil }()
+
+	var stmt Statement
+	defer func() { e.countStatement(stmt, resp) }()
+	defer e.shipToReplicas()
+	if e.opts.AuditWriter != nil {
+		defer func() { e.audit(cmd, stmt, resp) }()
+	}
+
+	stmt, err := e.parse(cmd)
 	if err != nil {
 		return "Parse error: " + err.Error()
 	}
 
+	e.expireIdleTx()
+	if e.expiredTxID != "" {
+		expiredTxID := e.expiredTxID
+		e.expiredTxID = ""
+		// A new BEGIN doesn't refer to the expired transaction, so let it through
+		if _, ok := stmt.(*BeginStatement); !ok {
+			return fmt.Sprintf("Error: Transaction %s was rolled back after being idle for more than %s.", expiredTxID, e.opts.IdleTxTimeout)
+		}
+	}
+	if e.currentTxID != "" {
+		e.txLastActive = e.opts.Now()
+	}
+	return e.execute(cmd, stmt)
+}
+
+// execute runs a parsed statement; cmd is its text, used as the query cache
+// key. The caller holds e.mu.
+func (e *Engine) execute(cmd string, stmt Statement) string {
 	// Handle transaction control statements and new SHOW TABLES first
 	switch s := stmt.(type) {
 	case *BeginStatement:
@@ -61,32 +321,652 @@ func (e *Engine) Execute(cmd string) string {
 		if e.currentTxID != "" {
 			return "Error: A transaction is already active. Commit or rollback the current transaction first."
 		}
-		e.currentTxID = fmt.Sprintf("tx_%d", time.Now().UnixNano())
+		e.currentTxID = e.opts.NewTxID()
+		e.txLastActive = e.opts.Now()
 		e.txChanges = make(map[string]map[string]string)
 		e.txDeletes = make(map[string]map[string]struct{})
 		e.txDroppedTables = make(map[string]struct{})
-		e.wal.BeginTx(e.currentTxID) // Updated WAL call
+		if !e.opts.CompactCommits {
+			e.wal.BeginTx(e.currentTxID) // Updated WAL call
+		}
 		return "Transaction started: " + e.currentTxID
 
 	case *CommitStatement:
-		_ = s // Acknowledge 's' is declared but not directly used
+		if s.TxID != "" && s.TxID != e.currentTxID {
+			// A retried COMMIT for a transaction that was already applied is a no-op
+			if _, done := e.committedTxIDs[s.TxID]; done {
+				return fmt.Sprintf("Transaction %s already committed.", s.TxID)
+			}
+			return fmt.Sprintf("Error: Unknown transaction %s.", s.TxID)
+		}
 		if e.currentTxID == "" {
 			return "Error: No active transaction to commit."
 		}
 		txIDToCommit := e.currentTxID
+		if err := e.commit(); err != nil {
+			return fmt.Sprintf("Error: COMMIT failed: %v. The transaction is still open.", err)
+		}
+		return fmt.Sprintf("Transaction %s committed.", txIDToCommit)
+
+	case *RollbackStatement:
+		_ = s // Acknowledge 's' is declared but not directly used
+		if e.currentTxID == "" {
+			return "Error: No active transaction to rollback."
+		}
+		return fmt.Sprintf("Transaction %s rolled back.", e.rollback())
+
+	case *PrepareStatement:
+		return e.prepare(s.GID)
+
+	case *CommitPreparedStatement:
+		return e.finishPrepared(s.GID, true)
+
+	case *RollbackPreparedStatement:
+		return e.finishPrepared(s.GID, false)
+
+	case *ShowTablesStatement: // Handle new SHOW TABLES statement
+		return e.showTables()
+
+	case *ShowTableSizesStatement:
+		return e.showTableSizes()
+
+	case *NextValStatement:
+		return e.nextVal(s.Sequence)
+
+	case *AnalyzeStatement:
+		return e.analyze()
+
+	case *SyncStatement:
+		if err := e.wal.Sync(); err != nil {
+			return "Error: WAL sync failed: " + err.Error()
+		}
+		return "WAL synced to disk."
+
+	case *ExplainStatement:
+		return e.explainSelect(s.Query)
+
+	case *ExplainKeyStatement:
+		return e.explainKey(s.Key, s.Table)
+
+	case *VersionsStatement:
+		return e.keyVersions(s.Key, s.Table)
+
+	case *ExplainCommitStatement:
+		if e.currentTxID == "" {
+			return "Error: No active transaction to explain."
+		}
+		return e.explainCommit()
+
+	case *CreateTableStatement:
+		if e.currentTxID != "" {
+			return "Error: CREATE TABLE is not allowed inside a transaction."
+		}
+		return e.createTable(s.Table)
+
+	case *CreateViewStatement:
+		if e.currentTxID != "" {
+			return "Error: CREATE VIEW is not allowed inside a transaction."
+		}
+		return e.createView(s)
+
+	case *DropViewStatement:
+		if e.currentTxID != "" {
+			return "Error: DROP VIEW is not allowed inside a transaction."
+		}
+		return e.dropView(s.Name)
+
+	case *SelectStatement:
+		if e.queryCache != nil && e.currentTxID == "" && s.AsOf == 0 { // Compaction renumbers the log under AS OF
+			return e.cachedSelect(cmd, s)
+		}
+		return e.executeData(stmt)
+
+	case *RunStatement:
+		return e.runScript(s)
+
+	case *AppendStatement:
+		return e.appendValues(s)
+
+	case *IncrStatement:
+		return e.incrValue(s)
+
+	case *SwapStatement:
+		return e.swapValues(s)
+
+	case *MoveStatement:
+		return e.moveKey(s)
+
+	case *DeclareCursorStatement:
+		return e.declareCursor(s.Name, s.Query)
+
+	case *FetchStatement:
+		return e.fetchCursor(s.Name, s.Count)
+
+	case *CloseCursorStatement:
+		return e.closeCursor(s.Name)
+
+	case *CustomStatement:
+		return e.commands[s.Keyword](s.Args)
+
+	case *VacuumStatement:
+		if e.currentTxID != "" {
+			return "Error: VACUUM is not allowed inside a transaction."
+		}
+		removed, err := e.vacuum()
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		return fmt.Sprintf("Log vacuumed: removed %d record(s) of uncommitted transactions", removed)
+
+	case *ReorganizeStatement:
+		before, after, err := e.rebuild(s.Table)
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		return fmt.Sprintf("Table '%s' reorganized: %d key(s), height %d -> %d, fill factor %.0f%% -> %.0f%%",
+			s.Table, after.Keys, before.Height, after.Height, before.FillFactor*100, after.FillFactor*100)
+
+	case *GenerateStatement:
+		start := e.opts.Now()
+		resp := e.insertBatch(s.Table, generateRows(s.Count))
+		return fmt.Sprintf("%s in %s", resp, e.opts.Now().Sub(start).Round(time.Millisecond))
+
+	default:
+		return e.executeData(stmt)
+	}
+}
+
+// createTable creates an empty table, which is logged so that it survives a
+// restart even before any key is inserted.
+func (e *Engine) createTable(table string) string {
+	if isReservedTable(table) || strings.HasPrefix(table, "__") {
+		return fmt.Sprintf("Error: '%s' is a reserved name", table)
+	}
+	if e.isView(table) {
+		return fmt.Sprintf("Error: a view named '%s' already exists", table)
+	}
+	if _, ok := e.tables[table]; ok {
+		return fmt.Sprintf("Error: table '%s' already exists", table)
+	}
+	e.tables[table] = e.newTree()
+	e.wal.CreateTable(table)
+	e.tableChanged(table)
+	return fmt.Sprintf("Table '%s' created", table)
+}
+
+// tableChanged drops the cached results that depend on table and notifies
+// its listeners. It must be called whenever committed data, a table or a
+// view definition changes.
+func (e *Engine) tableChanged(table string) {
+	if e.queryCache != nil {
+		e.queryCache.invalidate(table)
+	}
+	e.notifyListeners(table)
+	e.invalidateCursors(table)
+	e.shipToReplicas()
+}
+
+// executeData runs a statement that reads or writes table data, in the
+// current transaction if there is one.
+func (e *Engine) executeData(stmt Statement) string {
+	if table := modifiedTable(stmt); isReservedTable(table) {
+		return fmt.Sprintf("Error: '%s' is a reserved name", table)
+	}
+	if table := modifiedTable(stmt); table != "" && e.isView(table) {
+		return fmt.Sprintf("Error: '%s' is a view and cannot be modified", table)
+	}
+	if drop, ok := stmt.(*DropStatement); ok && e.tableVisible(drop.Table) {
+		if views := e.dependentViews(drop.Table); len(views) > 0 {
+			if !drop.Cascade {
+				return fmt.Sprintf("Error: table '%s' is used by views %s; use DROP %s CASCADE to drop them too",
+					drop.Table, strings.Join(views, ", "), drop.Table)
+			}
+			if e.currentTxID != "" {
+				return "Error: DROP CASCADE of a table with views is not allowed inside a transaction."
+			}
+			return e.dropCascade(drop.Table, views)
+		}
+	}
+	if e.opts.StrictTables {
+		switch s := stmt.(type) {
+		case *InsertStatement, *UpdateStatement:
+			if table := modifiedTable(s); !e.tableVisible(table) {
+				return fmt.Sprintf("Error: table '%s' does not exist; create it with CREATE TABLE first", table)
+			}
+		}
+	}
+	if ins, ok := stmt.(*InsertStatement); ok && ins.Source != nil {
+		// INSERT INTO ... SELECT: materialize the source rows as the values to insert
+		rows, err := e.selectRows(ins.Source)
+		if err != nil {
+			return err.Error()
+		}
+		ins.Values = make([]KeyValue, 0, len(rows))
+		for _, row := range rows {
+			ins.Values = append(ins.Values, KeyValue{Key: row.Key, Value: row.Value})
+		}
+	}
+	if err := e.validateKeys(stmt); err != nil {
+		return "Error: " + err.Error()
+	}
+	if update, ok := stmt.(*UpdateStatement); ok && update.Expected != nil {
+		if conflict := e.updateConflict(update); conflict != "" {
+			return conflict
+		}
+	}
+	if e.currentTxID == "" {
+		resp := e.executeAutocommit(stmt)
+		if table := modifiedTable(stmt); table != "" {
+			e.tableChanged(table)
+		}
+		return resp
+	} else {
+		if table := modifiedTable(stmt); table != "" {
+			e.invalidateCursors(table)
+		}
+		return e.executeInTransaction(stmt)
+	}
+}
+
+// updateConflict checks the IF guards of a conditional UPDATE against the
+// values the current transaction sees. It returns the response reporting
+// every key without its expected value, or "" if all of them match. The
+// caller holds the engine lock until the update is applied, so the values
+// cannot change in between.
+func (e *Engine) updateConflict(s *UpdateStatement) string {
+	var mismatches []string
+	for _, key := range slices.Sorted(maps.Keys(s.Expected)) {
+		value, _, ok := e.getVisible(s.Table, key)
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' does not exist", key))
+		} else if value != s.Expected[key] {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' is '%s', expected '%s'", key, value, s.Expected[key]))
+		}
+	}
+	if len(mismatches) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Error: Conflict in table '%s': %s. No keys were updated.", s.Table, strings.Join(mismatches, ", "))
+}
+
+// appendValues runs APPEND by reading the visible value of every key and
+// writing the concatenation back as an UPDATE, or as an INSERT for keys that
+// do not exist yet. Both run under the engine lock, so concurrent appends to
+// the same key are applied one after the other.
+func (e *Engine) appendValues(s *AppendStatement) string {
+	if isReservedTable(s.Table) {
+		return fmt.Sprintf("Error: '%s' is a reserved name", s.Table)
+	}
+	// Collapse repeated keys, whose suffixes are appended in order
+	index := make(map[string]int, len(s.Values))
+	var suffixes []KeyValue
+	for _, kv := range s.Values {
+		if i, seen := index[kv.Key]; seen {
+			suffixes[i].Value += kv.Value
+			continue
+		}
+		index[kv.Key] = len(suffixes)
+		suffixes = append(suffixes, kv)
+	}
+
+	for i, kv := range suffixes {
+		if current, _, visible := e.getVisible(s.Table, kv.Key); visible {
+			suffixes[i].Value = current + kv.Value
+		}
+	}
+	if resp := e.writeValues(s.Table, suffixes); resp != "" {
+		return resp
+	}
+	return e.message(MsgAppended, len(suffixes), s.Table)
+}
+
+// incrValue runs INCR and DECR: it adds s.By to the integer value of the key,
+// taking a missing key as 0, and returns the new value.
+func (e *Engine) incrValue(s *IncrStatement) string {
+	if isReservedTable(s.Table) {
+		return fmt.Sprintf("Error: '%s' is a reserved name", s.Table)
+	}
+	current := int64(0)
+	if value, _, visible := e.getVisible(s.Table, s.Key); visible {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("Error: value of key '%s' is not an integer: %q", s.Key, value)
+		}
+		current = n
+	}
+	next := current + s.By
+	if (s.By > 0 && next < current) || (s.By < 0 && next > current) {
+		return fmt.Sprintf("Error: %s of key '%s' would overflow", s.StmtType(), s.Key)
+	}
+	value := strconv.FormatInt(next, 10)
+	if resp := e.writeValues(s.Table, []KeyValue{{Key: s.Key, Value: value}}); resp != "" {
+		return resp
+	}
+	return value
+}
+
+// swapValues runs SWAP: it exchanges the values of two keys, which must
+// both exist. Both writes are logged in one batch, so replay never sees
+// only one of them.
+func (e *Engine) swapValues(s *SwapStatement) string {
+	if isReservedTable(s.Table) {
+		return fmt.Sprintf("Error: '%s' is a reserved name", s.Table)
+	}
+	if !e.tableVisible(s.Table) {
+		return fmt.Sprintf("Table '%s' not found", s.Table)
+	}
+	value1, _, ok := e.getVisible(s.Table, s.Key1)
+	if !ok {
+		return fmt.Sprintf("Error: key '%s' not found in table '%s'", s.Key1, s.Table)
+	}
+	value2, _, ok := e.getVisible(s.Table, s.Key2)
+	if !ok {
+		return fmt.Sprintf("Error: key '%s' not found in table '%s'", s.Key2, s.Table)
+	}
+	if s.Key1 != s.Key2 {
+		if resp := e.writeValues(s.Table, []KeyValue{{Key: s.Key1, Value: value2}, {Key: s.Key2, Value: value1}}); resp != "" {
+			return resp
+		}
+	}
+	return fmt.Sprintf("Swapped '%s' and '%s' in table '%s'", s.Key1, s.Key2, s.Table)
+}
+
+// moveKey runs MOVE: it deletes the key from one table and inserts it with
+// its value into another. Inside a transaction both writes are buffered as
+// usual; otherwise they are logged as a transaction of their own, so after a
+// crash the key is never found in both tables or in neither.
+func (e *Engine) moveKey(s *MoveStatement) string {
+	if s.From == s.To {
+		return "Error: MOVE needs two different tables"
+	}
+	for _, table := range []string{s.From, s.To} {
+		if e.isView(table) {
+			return fmt.Sprintf("Error: '%s' is a view and cannot be modified", table)
+		}
+		if isReservedTable(table) {
+			return fmt.Sprintf("Error: '%s' is a reserved name", table)
+		}
+	}
+	if !e.tableVisible(s.From) {
+		return fmt.Sprintf("Table '%s' not found", s.From)
+	}
+	if e.opts.StrictTables && !e.tableVisible(s.To) {
+		return fmt.Sprintf("Error: table '%s' does not exist; create it with CREATE TABLE first", s.To)
+	}
+	value, _, ok := e.getVisible(s.From, s.Key)
+	if !ok {
+		return fmt.Sprintf("Error: key '%s' not found in table '%s'", s.Key, s.From)
+	}
+	if _, _, exists := e.getVisible(s.To, s.Key); exists && !s.Overwrite {
+		return fmt.Sprintf("Error: key '%s' already exists in table '%s'; use MOVE ... OVERWRITE to replace it", s.Key, s.To)
+	}
+	moved := fmt.Sprintf("Moved '%s' from table '%s' to table '%s'", s.Key, s.From, s.To)
+
+	if e.currentTxID != "" {
+		if resp := e.executeData(&DeleteStatement{Table: s.From, Keys: []string{s.Key}}); !e.wroteRows(resp) {
+			return resp
+		}
+		if resp := e.writeValues(s.To, []KeyValue{{Key: s.Key, Value: value}}); resp != "" {
+			return resp
+		}
+		return moved
+	}
+
+	stored := e.encodeValue(s.To, value)
+	err := e.logTx(func(txID string) {
+		e.wal.Delete(txID, s.From, s.Key)
+		e.wal.Append(txID, s.To, s.Key, stored)
+	})
+	if err != nil {
+		return fmt.Sprintf("Error: MOVE failed: %v", err)
+	}
+
+	e.tables[s.From].Delete(s.Key)
+	e.forgetKey(s.From, s.Key)
+	tree, ok := e.tables[s.To]
+	if !ok {
+		tree = e.newTree()
+		e.tables[s.To] = tree
+	}
+	if !tree.Insert(s.Key, stored) {
+		tree.Update(s.Key, stored)
+	}
+	e.touchKey(s.To, s.Key)
+	e.tableChanged(s.From)
+	e.tableChanged(s.To)
+	e.evictKeys(s.To)
+	return moved
+}
+
+// writeValues stores values in table in one log batch, as an UPDATE of the
+// keys that are visible and an INSERT of the rest, so the usual checks,
+// logging and transaction buffering apply. It returns "" on success and
+// the failing statement's response otherwise.
+func (e *Engine) writeValues(table string, values []KeyValue) string {
+	var inserts, updates []KeyValue
+	for _, kv := range values {
+		if _, _, visible := e.getVisible(table, kv.Key); visible {
+			updates = append(updates, kv)
+		} else {
+			inserts = append(inserts, kv)
+		}
+	}
+
+	var resp string
+	if err := e.batch(func() {
+		if len(inserts) > 0 {
+			if resp = e.executeData(&InsertStatement{Table: table, Values: inserts}); !e.wroteRows(resp) {
+				return
+			}
+		}
+		if len(updates) > 0 {
+			resp = e.executeData(&UpdateStatement{Table: table, Values: updates})
+		}
+	}); err != nil {
+		return "Error: WAL write failed: " + err.Error()
+	}
+	if !e.wroteRows(resp) {
+		return resp
+	}
+	return ""
+}
+
+// deleteReport adds to a DELETE response, for DELETE ... RETURNING, the keys
+// that were deleted and those that were not found, in statement order.
+func deleteReport(s *DeleteStatement, resp string, deleted, absent []string) string {
+	if !s.Returning {
+		return resp
+	}
+	list := func(keys []string) string {
+		if len(keys) == 0 {
+			return "(none)"
+		}
+		return strings.Join(keys, ", ")
+	}
+	return fmt.Sprintf("%s\nDeleted: %s\nNot found: %s", resp, list(deleted), list(absent))
+}
+
+// validateKeys runs the KeyValidator option over the keys an INSERT or
+// UPDATE writes. Internal tables are not checked.
+func (e *Engine) validateKeys(stmt Statement) error {
+	if e.opts.KeyValidator == nil {
+		return nil
+	}
+	var table string
+	var values []KeyValue
+	switch s := stmt.(type) {
+	case *InsertStatement:
+		table, values = s.Table, s.Values
+	case *UpdateStatement:
+		table, values = s.Table, s.Values
+	}
+	if isReservedTable(table) {
+		return nil
+	}
+	for _, kv := range values {
+		if err := e.opts.KeyValidator(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertBatch inserts values into table as a single INSERT statement, with
+// the same duplicate handling and transaction behavior, but without going
+// through the parser and with the log records written in large chunks. It
+// returns the INSERT response.
+func (e *Engine) InsertBatch(table string, values []KeyValue) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.insertBatch(table, e.normalizeKeys(table, values))
+}
+
+func (e *Engine) insertBatch(table string, values []KeyValue) string {
+	var resp string
+	if err := e.batch(func() {
+		resp = e.executeData(&InsertStatement{Table: table, Values: values})
+	}); err != nil {
+		return "Error: WAL write failed: " + err.Error()
+	}
+	return resp
+}
+
+// generateRows returns n synthetic rows key_000001 -> value_000001 and so on,
+// zero-padded to at least six digits so they sort in numeric order.
+func generateRows(n int) []KeyValue {
+	width := max(6, len(strconv.Itoa(n)))
+	rows := make([]KeyValue, n)
+	for i := range rows {
+		rows[i] = KeyValue{
+			Key:   fmt.Sprintf("key_%0*d", width, i+1),
+			Value: fmt.Sprintf("value_%0*d", width, i+1),
+		}
+	}
+	return rows
+}
 
-		for tableName := range e.txDroppedTables {
+// modifiedTable returns the table written by an INSERT, UPDATE, DELETE or
+// DROP statement, or "" for other statements.
+func modifiedTable(stmt Statement) string {
+	switch s := stmt.(type) {
+	case *InsertStatement:
+		return s.Table
+	case *UpdateStatement:
+		return s.Table
+	case *DeleteStatement:
+		return s.Table
+	case *DropStatement:
+		return s.Table
+	}
+	return ""
+}
+
+// checkDuplicates enforces ErrorOnDuplicate: it returns an error message if
+// any key of the INSERT is already visible in the table (including the
+// transaction's own buffered changes) or repeated within the statement, and
+// "" otherwise. Nothing is written when it fails.
+func (e *Engine) checkDuplicates(s *InsertStatement) string {
+	if e.opts.OnDuplicate != ErrorOnDuplicate {
+		return ""
+	}
+	seen := make(map[string]struct{}, len(s.Values))
+	for _, kv := range s.Values {
+		_, repeated := seen[kv.Key]
+		if _, _, visible := e.getVisible(s.Table, kv.Key); visible || repeated {
+			return fmt.Sprintf("Error: key '%s' already exists in table '%s'", kv.Key, s.Table)
+		}
+		seen[kv.Key] = struct{}{}
+	}
+	return ""
+}
+
+// commit commits the current transaction. The whole transaction is made
+// durable before memory is touched, so a crash at any point either loses it
+// entirely or replays all of it. If the log cannot be synced the
+// transaction stays open and nothing is applied.
+func (e *Engine) commit() error {
+	txID := e.currentTxID
+	if err := e.logCommit(txID); err != nil {
+		return err
+	}
+	e.applyCommit()
+	e.rememberCommit(txID)
+	e.currentTxID = ""
+	e.txChanges = nil
+	e.txDeletes = nil
+	e.txDroppedTables = nil
+	return nil
+}
+
+// logCommit writes every buffered change of the transaction followed by its
+// COMMIT_TX record, which syncs the log. With CompactCommits the changes are
+// written as autocommit records in a batch instead.
+func (e *Engine) logCommit(txID string) error {
+	return e.logRecords(txID, e.logChanges)
+}
+
+// logTx logs the records written by write as a transaction of their own,
+// committed like logCommit does. write logs them under the ID it is given.
+func (e *Engine) logTx(write func(txID string)) error {
+	txID := e.opts.NewTxID()
+	if !e.opts.CompactCommits {
+		e.wal.BeginTx(txID) // Otherwise logged by BEGIN
+	}
+	return e.logRecords(txID, write)
+}
+
+// logRecords writes the records of transaction txID with write, followed by
+// its COMMIT_TX record, or as autocommit records in a batch with
+// CompactCommits. Either way the log is synced, and the transaction is only
+// committed if that succeeds.
+func (e *Engine) logRecords(txID string, write func(txID string)) error {
+	if e.opts.CompactCommits {
+		e.wal.BeginBatch()
+		write("")
+		return e.wal.EndBatch()
+	}
+	write(txID)
+	return e.wal.CommitTx(txID)
+}
+
+// logChanges writes every buffered change of the transaction. Records are
+// written table by table in txTables order, each table's drop before its
+// sets and deletes; Replay applies the whole transaction at COMMIT_TX, so
+// the order across tables only makes the log reproducible.
+func (e *Engine) logChanges(txID string) {
+	for _, tableName := range e.txTables() {
+		if _, dropped := e.txDroppedTables[tableName]; dropped {
+			e.wal.DropTable(txID, tableName)
+		}
+		kvs := e.txChanges[tableName]
+		for _, key := range slices.Sorted(maps.Keys(kvs)) {
+			e.wal.Append(txID, tableName, key, e.encodeValue(tableName, kvs[key]))
+		}
+		for _, key := range slices.Sorted(maps.Keys(e.txDeletes[tableName])) {
+			e.wal.Delete(txID, tableName, key)
+		}
+	}
+}
+
+// applyCommit applies the transaction's buffered changes to the in-memory
+// tables, in the same order as logCommit.
+func (e *Engine) applyCommit() {
+	for _, tableName := range e.txTables() {
+		e.tableChanged(tableName)
+		if _, dropped := e.txDroppedTables[tableName]; dropped {
 			delete(e.tables, tableName)
-			e.wal.DropTable(txIDToCommit, tableName) // Updated WAL call
+			delete(e.lru, tableName)
 		}
 
-		for tableName, kvs := range e.txChanges {
+		if kvs, ok := e.txChanges[tableName]; ok {
 			tree, ok := e.tables[tableName]
 			if !ok {
-				tree = NewBPlusTree()
+				tree = e.newTree()
 				e.tables[tableName] = tree
 			}
-			for key, value := range kvs {
+			// In key order, so keys written together are evicted in a fixed order
+			for _, key := range slices.Sorted(maps.Keys(kvs)) {
+				value := e.encodeValue(tableName, kvs[key])
 				// Check if the key already exists in the BPlusTree.
 				// If it does, call Update; otherwise, call Insert.
 				if _, exists := tree.Get(key); exists {
@@ -94,112 +974,205 @@ func (e *Engine) Execute(cmd string) string {
 				} else {
 					tree.Insert(key, value)
 				}
-				e.wal.Append(txIDToCommit, tableName, key, value) // Updated WAL call
+				e.touchKey(tableName, key)
 			}
 		}
 
-		for tableName, keysToDelete := range e.txDeletes {
-			tree, ok := e.tables[tableName]
-			if !ok {
-				continue
+		if tree, ok := e.tables[tableName]; ok {
+			for key := range e.txDeletes[tableName] {
+				tree.Delete(key)
+				e.forgetKey(tableName, key)
 			}
-			for key := range keysToDelete {
-				if tree.Delete(key) {
-					e.wal.Delete(txIDToCommit, tableName, key) // Updated WAL call
-				}
+		}
+		e.evictKeys(tableName)
+	}
+}
+
+// explainCommit reports what COMMIT would do to each table the current
+// transaction touches, without applying anything. It mirrors applyCommit: a
+// dropped table is emptied before the transaction's writes are applied, so
+// writes to it count as inserts and it counts as created again. Deletes of
+// keys that would not exist by then are left out, as they change nothing.
+func (e *Engine) explainCommit() string {
+	var inserts, updates, deletes, drops int
+	var created []string
+	lines := []string{fmt.Sprintf("COMMIT of transaction %s would apply:", e.currentTxID)}
+	for _, table := range e.txTables() {
+		tree, exists := e.tables[table]
+		_, dropped := e.txDroppedTables[table]
+		if dropped && !exists {
+			continue // Dropped a table created and emptied within the transaction
+		}
+		var parts []string
+		if dropped {
+			drops++
+			parts = append(parts, "drop")
+			tree, exists = nil, false
+		}
+		kvs := e.txChanges[table]
+		if len(kvs) > 0 && !exists {
+			created = append(created, table)
+			parts = append(parts, "create")
+		}
+		committed := func(key string) bool {
+			if !exists {
+				return false
+			}
+			_, ok := tree.Get(key)
+			return ok
+		}
+		ins, upd, del := 0, 0, 0
+		for key := range kvs {
+			if committed(key) {
+				upd++
+			} else {
+				ins++
+			}
+		}
+		for key := range e.txDeletes[table] {
+			if _, inTx := kvs[key]; inTx || committed(key) {
+				del++
 			}
 		}
+		if ins > 0 {
+			parts = append(parts, fmt.Sprintf("%d insert(s)", ins))
+		}
+		if upd > 0 {
+			parts = append(parts, fmt.Sprintf("%d update(s)", upd))
+		}
+		if del > 0 {
+			parts = append(parts, fmt.Sprintf("%d delete(s)", del))
+		}
+		if len(parts) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s: %s", table, strings.Join(parts, ", ")))
+		}
+		inserts, updates, deletes = inserts+ins, updates+upd, deletes+del
+	}
+	lines = append(lines, fmt.Sprintf("Total: %d insert(s), %d update(s), %d delete(s), %d drop(s)", inserts, updates, deletes, drops))
+	if len(created) > 0 {
+		lines = append(lines, "Tables created: "+strings.Join(created, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
 
-		e.wal.CommitTx(txIDToCommit) // Updated WAL call
-		e.currentTxID = ""
-		e.txChanges = nil
-		e.txDeletes = nil
-		e.txDroppedTables = nil
-		return fmt.Sprintf("Transaction %s committed.", txIDToCommit)
+// txTables returns every table the current transaction drops, writes or
+// deletes from, in sorted order. COMMIT visits tables in this order so that
+// each table is handled (drop, then writes, then deletes) in one
+// deterministic pass and its log records are reproducible. This is the
+// global order in which a commit would take per-table locks.
+func (e *Engine) txTables() []string {
+	tables := make(map[string]struct{}, len(e.txChanges)+len(e.txDeletes)+len(e.txDroppedTables))
+	for name := range e.txDroppedTables {
+		tables[name] = struct{}{}
+	}
+	for name := range e.txChanges {
+		tables[name] = struct{}{}
+	}
+	for name := range e.txDeletes {
+		tables[name] = struct{}{}
+	}
+	return slices.Sorted(maps.Keys(tables))
+}
 
-	case *RollbackStatement:
-		_ = s // Acknowledge 's' is declared but not directly used
-		if e.currentTxID == "" {
-			return "Error: No active transaction to rollback."
-		}
-		txIDToRollback := e.currentTxID
+// rollback discards the active transaction's buffers, logs the rollback and
+// returns the transaction ID.
+func (e *Engine) rollback() string {
+	txIDToRollback := e.currentTxID
 
-		e.currentTxID = ""
-		e.txChanges = nil
-		e.txDeletes = nil
-		e.txDroppedTables = nil
+	e.currentTxID = ""
+	e.txChanges = nil
+	e.txDeletes = nil
+	e.txDroppedTables = nil
+	if !e.opts.CompactCommits { // Nothing of the transaction was logged
 		e.wal.RollbackTx(txIDToRollback) // Updated WAL call
-		return fmt.Sprintf("Transaction %s rolled back.", txIDToRollback)
+	}
+	return txIDToRollback
+}
 
-	case *ShowTablesStatement: // Handle new SHOW TABLES statement
-		return e.showTables()
+// expireIdleTx rolls back the active transaction if it has been idle for
+// longer than IdleTxTimeout. The rollback is reported by the next statement.
+// The check runs at the start of every Execute, so an abandoned transaction
+// is released as soon as any caller uses the engine again.
+func (e *Engine) expireIdleTx() {
+	if e.opts.IdleTxTimeout <= 0 || e.currentTxID == "" {
+		return
+	}
+	if e.opts.Now().Sub(e.txLastActive) > e.opts.IdleTxTimeout {
+		e.expiredTxID = e.rollback()
+	}
+}
 
-	default:
-		if e.currentTxID == "" {
-			return e.executeAutocommit(stmt)
-		} else {
-			return e.executeInTransaction(stmt)
+// nextVal increments the named sequence and returns its new value. Sequences
+// are not transactional: the increment is logged and applied immediately, even
+// inside a transaction, so a value is never handed out twice.
+func (e *Engine) nextVal(sequence string) string {
+	tree, ok := e.tables[sequenceTable]
+	if !ok {
+		tree = e.newTree()
+		e.tables[sequenceTable] = tree
+	}
+
+	next := int64(1)
+	if current, exists := tree.Get(sequence); exists {
+		n, err := strconv.ParseInt(current, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("Error: Sequence '%s' has a non-numeric value %q", sequence, current)
 		}
+		next = n + 1
+		tree.Update(sequence, strconv.FormatInt(next, 10))
+	} else {
+		tree.Insert(sequence, strconv.FormatInt(next, 10))
+	}
+	e.wal.Append("", sequenceTable, sequence, strconv.FormatInt(next, 10))
+	e.tableChanged(sequenceTable)
+	return strconv.FormatInt(next, 10)
+}
+
+// rememberCommit records txID as committed, evicting the oldest entry once
+// more than maxRecentCommits IDs are tracked.
+func (e *Engine) rememberCommit(txID string) {
+	e.committedTxIDs[txID] = struct{}{}
+	e.committedTxOrder = append(e.committedTxOrder, txID)
+	if len(e.committedTxOrder) > maxRecentCommits {
+		oldest := e.committedTxOrder[0]
+		e.committedTxOrder = e.committedTxOrder[1:]
+		delete(e.committedTxIDs, oldest)
 	}
 }
 
 func (e *Engine) executeAutocommit(stmt Statement) string {
 	switch s := stmt.(type) {
 	case *InsertStatement:
+		if msg := e.checkDuplicates(s); msg != "" {
+			return msg
+		}
 		tree, ok := e.tables[s.Table]
 		if !ok {
-			tree = NewBPlusTree()
+			tree = e.newTree()
 			e.tables[s.Table] = tree
 		}
 		insertedCount := 0
 		for _, kv := range s.Values {
-			didInsert := tree.Insert(kv.Key, kv.Value)
+			stored := e.encodeValue(s.Table, kv.Value)
+			didInsert := tree.Insert(kv.Key, stored)
+			if !didInsert && e.opts.OnDuplicate == OverwriteDuplicates {
+				didInsert = tree.Update(kv.Key, stored)
+			}
 			if didInsert {
-				e.wal.Append("", s.Table, kv.Key, kv.Value) // Updated WAL call (empty txID)
+				e.wal.Append("", s.Table, kv.Key, stored) // Updated WAL call (empty txID)
+				e.touchKey(s.Table, kv.Key)
 				insertedCount++
 			}
 
 		}
+		e.evictKeys(s.Table)
 		if insertedCount == 0 && len(s.Values) > 0 {
-			return "No new keys inserted (they might already exist)"
+			return e.message(MsgNoneInserted, s.Table)
 		}
-		return fmt.Sprintf("Inserted %d key(s) into table '%s'", insertedCount, s.Table)
+		return e.message(MsgInserted, insertedCount, s.Table)
 
 	case *SelectStatement:
-		tree, ok := e.tables[s.Table]
-		if !ok {
-			return fmt.Sprintf("Table '%s' not found", s.Table)
-		}
-		var sb strings.Builder
-		if len(s.Keys) > 0 {
-			foundResults := false
-			for _, key := range s.Keys {
-				val, ok := tree.Get(key)
-				if ok {
-					sb.WriteString(fmt.Sprintf("%s: %s\n", key, val))
-					foundResults = true
-				}
-			}
-			if !foundResults {
-				return "No results"
-			}
-			return strings.TrimRight(sb.String(), "\n")
-		} else {
-			results := tree.RangeQuery("", "")
-			if len(results) == 0 {
-				return "No results"
-			}
-			keys := make([]string, 0, len(results))
-			for k := range results {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-
-			for _, k := range keys {
-				sb.WriteString(fmt.Sprintf("%s: %s\n", k, results[k]))
-			}
-			return strings.TrimRight(sb.String(), "\n")
-		}
+		return e.executeSelect(s)
 
 	case *DeleteStatement:
 		tree, ok := e.tables[s.Table]
@@ -207,18 +1180,22 @@ func (e *Engine) executeAutocommit(stmt Statement) string {
 			return fmt.Sprintf("Table '%s' not found", s.Table)
 		}
 
-		deletedCount := 0
+		var deleted, absent []string
 		for _, key := range s.Keys {
 			if tree.Delete(key) {
 				e.wal.Delete("", s.Table, key) // Updated WAL call (empty txID)
-				deletedCount++
+				e.forgetKey(s.Table, key)
+				deleted = append(deleted, key)
+			} else {
+				absent = append(absent, key)
 			}
 		}
 
-		if deletedCount > 0 {
-			return fmt.Sprintf("Deleted %d key(s) from table '%s'", deletedCount, s.Table)
+		resp := e.message(MsgNoneDeleted, s.Table)
+		if len(deleted) > 0 {
+			resp = e.message(MsgDeleted, len(deleted), s.Table)
 		}
-		return "No key(s) found to delete in table '" + s.Table + "'"
+		return deleteReport(s, resp, deleted, absent)
 
 	case *DropStatement:
 		_, ok := e.tables[s.Table]
@@ -226,6 +1203,7 @@ func (e *Engine) executeAutocommit(stmt Statement) string {
 			return fmt.Sprintf("Table '%s' not found", s.Table)
 		}
 		delete(e.tables, s.Table)
+		delete(e.lru, s.Table)
 		e.wal.DropTable("", s.Table) // Updated WAL call (empty txID)
 		return fmt.Sprintf("Table '%s' dropped", s.Table)
 
@@ -236,15 +1214,17 @@ func (e *Engine) executeAutocommit(stmt Statement) string {
 		}
 		updatedCount := 0
 		for _, kv := range s.Values {
-			if tree.Update(kv.Key, kv.Value) {
-				e.wal.Append("", s.Table, kv.Key, kv.Value) // Updated WAL call (empty txID)
+			stored := e.encodeValue(s.Table, kv.Value)
+			if tree.Update(kv.Key, stored) {
+				e.wal.Append("", s.Table, kv.Key, stored) // Updated WAL call (empty txID)
+				e.touchKey(s.Table, kv.Key)
 				updatedCount++
 			}
 		}
 		if updatedCount > 0 {
-			return fmt.Sprintf("Updated %d key(s) in table '%s'", updatedCount, s.Table)
+			return e.message(MsgUpdated, updatedCount, s.Table)
 		}
-		return "No keys found to update"
+		return e.message(MsgNoneUpdated, s.Table)
 
 	default:
 		return fmt.Errorf("unsupported statement in autocommit mode: %s", stmt.StmtType()).Error()
@@ -258,12 +1238,21 @@ func (e *Engine) executeInTransaction(stmt Statement) string {
 			return fmt.Sprintf("Table '%s' marked for drop within this transaction, cannot insert into it", s.Table)
 		}
 
+		if msg := e.checkDuplicates(s); msg != "" {
+			return msg
+		}
+
 		if _, ok := e.txChanges[s.Table]; !ok {
 			e.txChanges[s.Table] = make(map[string]string)
 		}
 
 		insertedOrUpdatedCount := 0
 		for _, kv := range s.Values { // kv is correctly defined here for each iteration
+			if e.opts.OnDuplicate == SkipDuplicates {
+				if _, _, visible := e.getVisible(s.Table, kv.Key); visible {
+					continue
+				}
+			}
 			if _, ok := e.txDeletes[s.Table]; ok {
 				delete(e.txDeletes[s.Table], kv.Key)
 			}
@@ -288,78 +1277,12 @@ func (e *Engine) executeInTransaction(stmt Statement) string {
 			e.txChanges[s.Table][kv.Key] = kv.Value
 		}
 		if insertedOrUpdatedCount == 0 && len(s.Values) > 0 {
-			return "No new keys inserted or values updated (they might already exist with the same value)"
+			return e.message(MsgNoneBuffered, s.Table)
 		}
-		return fmt.Sprintf("Buffered %d key(s) for insert/update into table '%s'", len(s.Values), s.Table)
+		return e.message(MsgBufferedWrite, insertedOrUpdatedCount, s.Table)
 
 	case *SelectStatement:
-		if _, droppedInTx := e.txDroppedTables[s.Table]; droppedInTx {
-			return fmt.Sprintf("Table '%s' dropped within this transaction", s.Table)
-		}
-
-		type combinedEntry struct {
-			Value  string
-			FromTx bool
-		}
-		combinedData := make(map[string]combinedEntry)
-
-		tree, ok := e.tables[s.Table]
-		if ok {
-			allKeysValues := tree.RangeQuery("", "")
-			for k, v := range allKeysValues {
-				combinedData[k] = combinedEntry{Value: v, FromTx: false}
-			}
-		}
-
-		if delKeys, ok := e.txDeletes[s.Table]; ok {
-			for key := range delKeys {
-				delete(combinedData, key)
-			}
-		}
-
-		if txKVs, ok := e.txChanges[s.Table]; ok {
-			for k, v := range txKVs {
-				combinedData[k] = combinedEntry{Value: v, FromTx: true}
-			}
-		}
-
-		var sb strings.Builder
-		if len(s.Keys) > 0 {
-			foundResults := false
-			for _, key := range s.Keys {
-				if entry, ok := combinedData[key]; ok {
-					if entry.FromTx {
-						sb.WriteString(fmt.Sprintf("%s: [%s] %s\n", key, e.currentTxID, entry.Value))
-					} else {
-						sb.WriteString(fmt.Sprintf("%s: %s\n", key, entry.Value))
-					}
-					foundResults = true
-				}
-			}
-			if !foundResults {
-				return "No results"
-			}
-			return strings.TrimRight(sb.String(), "\n")
-		} else {
-			if len(combinedData) == 0 {
-				return "No results"
-			}
-			keys := make([]string, 0, len(combinedData))
-			for k := range combinedData {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-
-			for _, k := range keys {
-				entry := combinedData[k]
-				if entry.FromTx {
-					sb.WriteString(fmt.Sprintf("%s: [%s] %s\n", k, e.currentTxID, entry.Value))
-				} else {
-					sb.WriteString(fmt.Sprintf("%s: %s\n", k, entry.Value))
-				}
-			}
-			return strings.TrimRight(sb.String(), "\n")
-		}
+		return e.executeSelect(s)
 
 	case *DeleteStatement:
 		if _, droppedInTx := e.txDroppedTables[s.Table]; droppedInTx {
@@ -374,7 +1297,7 @@ func (e *Engine) executeInTransaction(stmt Statement) string {
 		if _, ok := e.txDeletes[s.Table]; !ok {
 			e.txDeletes[s.Table] = make(map[string]struct{})
 		}
-		deletedCount := 0
+		var deleted, absent []string
 		for _, key := range s.Keys {
 			var existsInMain bool
 			if tree, ok := e.tables[s.Table]; ok {
@@ -390,13 +1313,16 @@ func (e *Engine) executeInTransaction(stmt Statement) string {
 				if existsInTxChanges {
 					delete(e.txChanges[s.Table], key)
 				}
-				deletedCount++
+				deleted = append(deleted, key)
+			} else {
+				absent = append(absent, key)
 			}
 		}
-		if deletedCount > 0 {
-			return fmt.Sprintf("Buffered %d key(s) for deletion from table '%s'", deletedCount, s.Table)
+		resp := e.message(MsgNoneDeleted, s.Table)
+		if len(deleted) > 0 {
+			resp = e.message(MsgBufferedDelete, len(deleted), s.Table)
 		}
-		return "No key(s) found to delete in table '" + s.Table + "'"
+		return deleteReport(s, resp, deleted, absent)
 
 	case *DropStatement:
 		if _, ok := e.tables[s.Table]; !ok {
@@ -445,9 +1371,9 @@ func (e *Engine) executeInTransaction(stmt Statement) string {
 			}
 		}
 		if updatedCount > 0 {
-			return fmt.Sprintf("Buffered %d key(s) for update in table '%s'", updatedCount, s.Table)
+			return e.message(MsgBufferedUpdate, updatedCount, s.Table)
 		}
-		return "No keys found to update"
+		return e.message(MsgNoneUpdated, s.Table)
 
 	default:
 		return fmt.Errorf("unsupported statement in transaction mode: %s", stmt.StmtType()).Error()
@@ -463,6 +1389,9 @@ func (e *Engine) showTables() string {
 
 	// Add tables from the main engine state, respecting txDrops
 	for tableName := range e.tables {
+		if isReservedTable(tableName) {
+			continue
+		}
 		if _, dropped := e.txDroppedTables[tableName]; !dropped {
 			visibleTables[tableName] = false // Not transactional, from main state
 		}
@@ -500,3 +1429,227 @@ func (e *Engine) showTables() string {
 	}
 	return strings.TrimRight(sb.String(), "\n")
 }
+
+// showTableSizes lists every committed table with its key count and estimated
+// size in bytes, largest first.
+func (e *Engine) showTableSizes() string {
+	type tableSize struct {
+		name  string
+		count int
+		bytes int
+	}
+	sizes := make([]tableSize, 0, len(e.tables))
+	for tableName, tree := range e.tables {
+		if isReservedTable(tableName) {
+			continue
+		}
+		sizes = append(sizes, tableSize{name: tableName, count: tree.Count(), bytes: tree.SizeBytes()})
+	}
+	if len(sizes) == 0 {
+		return "No tables found."
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].bytes != sizes[j].bytes {
+			return sizes[i].bytes > sizes[j].bytes
+		}
+		return sizes[i].name < sizes[j].name
+	})
+
+	var sb strings.Builder
+	sb.WriteString("Table sizes:\n")
+	for _, ts := range sizes {
+		sb.WriteString(fmt.Sprintf("- %s: %d key(s), %d bytes\n", ts.name, ts.count, ts.bytes))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// A CHECKPOINT is recommended once the log is both this many times larger
+// than the live data it replays to and at least analyzeMinLogBytes long, so
+// that small logs never trigger the advice.
+const (
+	analyzeRatioThreshold = 4.0
+	analyzeMinLogBytes    = 64 << 10
+)
+
+// shouldCheckpoint reports whether a log of logBytes holding liveBytes of
+// live data is worth compacting.
+func shouldCheckpoint(logBytes, liveBytes int64) bool {
+	if logBytes < analyzeMinLogBytes {
+		return false
+	}
+	return liveBytes == 0 || float64(logBytes)/float64(liveBytes) >= analyzeRatioThreshold
+}
+
+// analyze reports the log size, the estimated size of the live data in all
+// tables, their ratio, and whether a CHECKPOINT is recommended.
+func (e *Engine) analyze() string {
+	logBytes, err := e.wal.Size()
+	if err != nil {
+		return "Error: cannot stat WAL: " + err.Error()
+	}
+	var liveBytes int64
+	for _, tree := range e.tables {
+		liveBytes += int64(tree.SizeBytes())
+	}
+
+	ratio := "n/a"
+	if liveBytes > 0 {
+		ratio = fmt.Sprintf("%.1f", float64(logBytes)/float64(liveBytes))
+	}
+	recommendation := "none"
+	if shouldCheckpoint(logBytes, liveBytes) {
+		recommendation = "consider CHECKPOINT"
+	}
+	return fmt.Sprintf("WAL size: %d bytes\nLive data: %d bytes\nRatio: %s\nRecommendation: %s",
+		logBytes, liveBytes, ratio, recommendation)
+}
+
+// PrintTree writes the node layout of table's committed tree to w, one line
+// per level, for debugging. It holds the engine lock while walking the tree,
+// so it is safe to call while other goroutines write to the engine.
+func (e *Engine) PrintTree(table string, w io.Writer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tree, ok := e.tables[table]
+	if !ok || isReservedTable(table) {
+		return fmt.Errorf("table '%s' not found", table)
+	}
+	tree.WriteTree(w)
+	return nil
+}
+
+// TableStats returns the shape metrics of table's committed tree, or false
+// if there is no such table.
+func (e *Engine) TableStats(table string) (TreeStats, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tree, ok := e.tables[table]
+	if !ok || isReservedTable(table) {
+		return TreeStats{}, false
+	}
+	return tree.Stats(), true
+}
+
+// Rebuild replaces the committed tree of table with a freshly bulk-loaded
+// copy holding the same pairs, undoing the sparse nodes left behind by many
+// inserts and deletes. Only the in-memory layout changes; nothing is logged.
+func (e *Engine) Rebuild(table string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _, err := e.rebuild(table)
+	return err
+}
+
+// rebuild is Rebuild, returning the table's statistics before and after.
+func (e *Engine) rebuild(table string) (before, after TreeStats, err error) {
+	tree, ok := e.tables[table]
+	if !ok || isReservedTable(table) {
+		return before, after, fmt.Errorf("table '%s' not found", table)
+	}
+	pairs := make([]KeyValue, 0, tree.Count())
+	tree.Ascend(func(key, value string) bool {
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		return true
+	})
+	fresh := e.newTree()
+	if err := fresh.BulkLoad(pairs); err != nil {
+		return before, after, err
+	}
+	before = tree.Stats()
+	e.tables[table] = fresh
+	return before, fresh.Stats(), nil
+}
+
+// Scan returns every row of table, in key order, for which filter returns
+// true. The filter runs during the leaf-chain walk, so rejected rows are never
+// collected. A nil filter keeps every row. Inside a transaction the scan sees
+// the transaction's buffered changes. A missing table yields no rows.
+func (e *Engine) Scan(table string, filter func(key, value string) bool) []KeyValue {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rows []KeyValue
+	e.scanVisible(table, func(key, value string, fromTx bool) bool {
+		if filter == nil || filter(key, value) {
+			rows = append(rows, KeyValue{Key: key, Value: value})
+		}
+		return true
+	})
+	return rows
+}
+
+// scanVisible calls fn for each row of table visible to the current
+// transaction (or the committed state outside one) in ascending key order,
+// overlaying buffered transaction changes and deletes on the committed tree.
+// fromTx reports whether the value comes from the transaction buffer.
+// Iteration stops early if fn returns false. It returns false if the table
+// does not exist.
+func (e *Engine) scanVisible(table string, fn func(key, value string, fromTx bool) bool) bool {
+	return e.scanVisibleFrom(table, "", fn)
+}
+
+// scanVisibleFrom is scanVisible restricted to keys >= start. The tree walk
+// starts at the leaf holding start rather than at the first leaf.
+func (e *Engine) scanVisibleFrom(table, start string, fn func(key, value string, fromTx bool) bool) bool {
+	if _, dropped := e.txDroppedTables[table]; dropped {
+		return false
+	}
+	tree, inMain := e.tables[table]
+	txKVs, inTx := e.txChanges[table]
+	if !inMain && !inTx {
+		return false
+	}
+	txDeletes := e.txDeletes[table]
+
+	txKeys := make([]string, 0, len(txKVs))
+	for k := range txKVs {
+		if k >= start {
+			txKeys = append(txKeys, k)
+		}
+	}
+	sort.Strings(txKeys)
+
+	// Merge the sorted buffered keys into the ordered tree walk
+	next := 0
+	stopped := false
+	emitTxBefore := func(limit string) bool {
+		for next < len(txKeys) && txKeys[next] < limit {
+			k := txKeys[next]
+			next++
+			if !fn(k, txKVs[k], true) {
+				return false
+			}
+		}
+		return true
+	}
+	if inMain {
+		tree.AscendFrom(start, func(key, value string) bool {
+			if !emitTxBefore(key) {
+				stopped = true
+				return false
+			}
+			if next < len(txKeys) && txKeys[next] == key {
+				return true // Overridden by the transaction, emitted with the next key
+			}
+			if _, deleted := txDeletes[key]; deleted {
+				return true
+			}
+			if !fn(key, e.decodeValue(table, value), false) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+	}
+	if !stopped {
+		for ; next < len(txKeys); next++ {
+			k := txKeys[next]
+			if !fn(k, txKVs[k], true) {
+				break
+			}
+		}
+	}
+	return true
+}
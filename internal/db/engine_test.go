@@ -1,23 +1,47 @@
 package db
 
 import (
+	"bytes"
+	"errors"
 	"fmt" // Import fmt for Sprintf
+	"io"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // setupTestEngine creates a new Engine instance for testing and ensures cleanup.
-func setupTestEngine(t *testing.T) *Engine {
+func setupTestEngine(t testing.TB) *Engine {
+	t.Helper()
+	return setupTestEngineWithOptions(t, EngineOptions{})
+}
+
+// sequentialTxIDs returns a NewTxID option generating tx_1, tx_2, and so on.
+func sequentialTxIDs() func() string {
+	n := 0
+	return func() string {
+		n++
+		return fmt.Sprintf("tx_%d", n)
+	}
+}
+
+// setupTestEngineWithOptions is setupTestEngine for an engine with non-default options.
+func setupTestEngineWithOptions(t testing.TB, opts EngineOptions) *Engine {
 	t.Helper()
 
 	logPath := "test_wal.log"
 	_ = os.Remove(logPath)
 
-	engine := NewEngine(logPath)
+	engine := NewEngineWithOptions(logPath, opts)
 
 	t.Cleanup(func() {
+		_ = engine.Close()
 		_ = os.Remove(logPath)
+		_ = os.Remove(logPath + ".lock")
 	})
 	return engine
 }
@@ -87,6 +111,32 @@ func TestEngineDelete(t *testing.T) {
 	}
 }
 
+func TestEngineDeleteReturning(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3) INTO t`)
+
+	expected := "Deleted 2 key(s) from table 't'\nDeleted: c, a\nNot found: x, y"
+	if resp := e.Execute(`DELETE c, x, a, y FROM t RETURNING`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+	expected = "No key(s) found to delete in table 't'\nDeleted: (none)\nNot found: a"
+	if resp := e.Execute(`DELETE a FROM t returning;`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (d, 4) INTO t`)
+	expected = "Buffered 2 key(s) for deletion from table 't'\nDeleted: b, d\nNot found: z"
+	if resp := e.Execute(`DELETE b, d, z FROM t RETURNING`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	if _, err := Parse(`DELETE a FROM t RETURNING extra`); err == nil {
+		t.Error("Expected an error for tokens after RETURNING")
+	}
+}
+
 func TestEngineDropTable(t *testing.T) {
 	e := setupTestEngine(t)
 	e.Execute(`INSERT (k, v) INTO table_to_drop`)
@@ -122,21 +172,56 @@ func TestEngineUpdate(t *testing.T) {
 	}
 }
 
-func TestEngineTransactionIsolation(t *testing.T) {
+func TestEngineConditionalUpdate(t *testing.T) {
 	e := setupTestEngine(t)
+	e.Execute(`INSERT (balance_a, 100), (balance_b, 50) INTO accounts`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`UPDATE accounts SET (balance_a, 70), (balance_b, 80) IF (balance_a, 100), (balance_b, 60)`,
+			"Error: Conflict in table 'accounts': 'balance_b' is '50', expected '60'. No keys were updated."},
+		{`UPDATE accounts SET (balance_a, 70) IF (balance_a, 1), (missing, 0)`,
+			"Error: Conflict in table 'accounts': 'balance_a' is '100', expected '1', 'missing' does not exist. No keys were updated."},
+		{`SELECT * FROM accounts`, "balance_a: 100\nbalance_b: 50"},
+		{`UPDATE accounts SET (balance_a, 70), (balance_b, 80) IF (balance_a, 100), (balance_b, 50)`, "Updated 2 key(s) in table 'accounts'"},
+		{`SELECT * FROM accounts`, "balance_a: 70\nbalance_b: 80"},
+		{`UPDATE accounts SET (balance_a, 1) IF`, "Parse error: invalid UPDATE syntax: no (key, expected value) pairs after IF"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	// Inside a transaction the guards see its own buffered writes
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE accounts SET (balance_a, 60)`)
+	cmd := `UPDATE accounts SET (balance_b, 90) IF (balance_a, 60)`
+	if resp := e.Execute(cmd); strings.HasPrefix(resp, "Error") {
+		t.Errorf("%s: expected the guard to match the buffered value, got %q", cmd, resp)
+	}
+	e.Execute(`COMMIT`)
+	if resp, expected := e.Execute(`SELECT * FROM accounts`), "balance_a: 60\nbalance_b: 90"; resp != expected {
+		t.Errorf("Expected %q after commit, got %q", expected, resp)
+	}
+}
+
+func TestEngineTransactionIsolation(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
 
 	txResp := e.Execute(`BEGIN`)
-	if !strings.HasPrefix(txResp, "Transaction started: tx_") {
+	if txResp != "Transaction started: tx_1" {
 		t.Fatalf("Expected transaction to start, got %q", txResp)
 	}
-	txID := strings.TrimPrefix(txResp, "Transaction started: ")
 
 	e.Execute(`INSERT (tx_key1, tx_val1), (common_key, tx_common_val) INTO tx_table`)
 
 	selectResp := e.Execute(`SELECT * FROM tx_table`)
 	expectedInTx := []string{
-		fmt.Sprintf("common_key: [tx_%s] tx_common_val", strings.TrimPrefix(txID, "tx_")),
-		fmt.Sprintf("tx_key1: [tx_%s] tx_val1", strings.TrimPrefix(txID, "tx_")),
+		"common_key: [tx_1] tx_common_val",
+		"tx_key1: [tx_1] tx_val1",
 	}
 	for _, line := range expectedInTx {
 		if !strings.Contains(selectResp, line) {
@@ -152,8 +237,8 @@ func TestEngineTransactionIsolation(t *testing.T) {
 
 	selectResp = e.Execute(`SELECT * FROM tx_table`)
 	expectedInTxAfterUpdate := []string{
-		fmt.Sprintf("common_key: [tx_%s] tx_common_val_updated", strings.TrimPrefix(txID, "tx_")),
-		fmt.Sprintf("tx_key1: [tx_%s] tx_val1_updated", strings.TrimPrefix(txID, "tx_")),
+		"common_key: [tx_1] tx_common_val_updated",
+		"tx_key1: [tx_1] tx_val1_updated",
 	}
 	for _, line := range expectedInTxAfterUpdate {
 		if !strings.Contains(selectResp, line) {
@@ -168,7 +253,7 @@ func TestEngineTransactionIsolation(t *testing.T) {
 		t.Errorf("Expected tx_key1 to be deleted in transaction, but it's still present:\n%s", selectResp)
 	}
 	expectedInTxAfterDelete := []string{
-		fmt.Sprintf("common_key: [tx_%s] tx_common_val_updated", strings.TrimPrefix(txID, "tx_")),
+		"common_key: [tx_1] tx_common_val_updated",
 	}
 	for _, line := range expectedInTxAfterDelete {
 		if !strings.Contains(selectResp, line) {
@@ -180,7 +265,7 @@ func TestEngineTransactionIsolation(t *testing.T) {
 	}
 
 	commitResp := e.Execute(`COMMIT`)
-	if commitResp != fmt.Sprintf("Transaction %s committed.", txID) {
+	if commitResp != "Transaction tx_1 committed." {
 		t.Fatalf("Expected commit success, got %q", commitResp)
 	}
 
@@ -201,10 +286,9 @@ func TestEngineTransactionIsolation(t *testing.T) {
 	}
 
 	txResp = e.Execute(`BEGIN`)
-	if !strings.HasPrefix(txResp, "Transaction started: tx_") {
+	if txResp != "Transaction started: tx_2" {
 		t.Fatalf("Expected transaction to start, got %q", txResp)
 	}
-	txIDRollback := strings.TrimPrefix(txResp, "Transaction started: ")
 
 	e.Execute(`INSERT (rollback_key, rollback_val) INTO tx_table`)
 	e.Execute(`UPDATE tx_table SET (common_key, rolled_back_val)`)
@@ -215,7 +299,7 @@ func TestEngineTransactionIsolation(t *testing.T) {
 		t.Errorf("Expected common_key to be deleted in rollback transaction, but it's present:\n%s", selectResp)
 	}
 	expectedInRollbackTx := []string{
-		fmt.Sprintf("rollback_key: [tx_%s] rollback_val", strings.TrimPrefix(txIDRollback, "tx_")),
+		"rollback_key: [tx_2] rollback_val",
 	}
 	for _, line := range expectedInRollbackTx {
 		if !strings.Contains(selectResp, line) {
@@ -227,7 +311,7 @@ func TestEngineTransactionIsolation(t *testing.T) {
 	}
 
 	rollbackResp := e.Execute(`ROLLBACK`)
-	if rollbackResp != fmt.Sprintf("Transaction %s rolled back.", txIDRollback) {
+	if rollbackResp != "Transaction tx_2 rolled back." {
 		t.Fatalf("Expected rollback success, got %q", rollbackResp)
 	}
 
@@ -249,15 +333,14 @@ func TestEngineTransactionIsolation(t *testing.T) {
 }
 
 func TestEngineMixedOperations(t *testing.T) {
-	e := setupTestEngine(t)
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
 
 	e.Execute(`INSERT (k1, v1), (k2, v2_orig) INTO mixed_table`)
 
 	txResp := e.Execute(`BEGIN`)
-	if !strings.HasPrefix(txResp, "Transaction started: tx_") {
+	if txResp != "Transaction started: tx_1" {
 		t.Fatalf("Expected transaction to start, got %q", txResp)
 	}
-	txID := strings.TrimPrefix(txResp, "Transaction started: ")
 
 	e.Execute(`INSERT (k3, v3_tx) INTO mixed_table`)
 	e.Execute(`UPDATE mixed_table SET (k2, v2_tx_updated)`)
@@ -265,8 +348,8 @@ func TestEngineMixedOperations(t *testing.T) {
 
 	selectResp := e.Execute(`SELECT * FROM mixed_table`)
 	expectedInTx := []string{
-		fmt.Sprintf("k2: [tx_%s] v2_tx_updated", strings.TrimPrefix(txID, "tx_")),
-		fmt.Sprintf("k3: [tx_%s] v3_tx", strings.TrimPrefix(txID, "tx_")),
+		"k2: [tx_1] v2_tx_updated",
+		"k3: [tx_1] v3_tx",
 	}
 
 	for _, line := range expectedInTx {
@@ -372,7 +455,7 @@ func TestEngineInsertOnlyNewKeys(t *testing.T) {
 }
 
 func TestEngineShowTables(t *testing.T) {
-	e := setupTestEngine(t)
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
 
 	// Scenario 1: No tables initially
 	resp := e.Execute(`SHOW TABLES`)
@@ -390,11 +473,10 @@ func TestEngineShowTables(t *testing.T) {
 	}
 
 	// Scenario 3: New table created in a transaction
-	txResp := e.Execute(`BEGIN`)
-	txID := strings.TrimPrefix(txResp, "Transaction started: ")
+	e.Execute(`BEGIN`)
 	e.Execute(`INSERT (c,3) INTO tx_table_new`)
 	resp = e.Execute(`SHOW TABLES`)
-	expected = fmt.Sprintf("Tables:\n- table1\n- table2\n- [%s] tx_table_new", strings.TrimPrefix(txID, "tx_"))
+	expected = "Tables:\n- table1\n- table2\n- [1] tx_table_new"
 	if strings.TrimSpace(resp) != expected {
 		t.Errorf("Expected tables with new transactional table:\n%q\nGot:\n%q", expected, resp)
 	}
@@ -403,7 +485,7 @@ func TestEngineShowTables(t *testing.T) {
 	e.Execute(`UPDATE table1 SET (a, 100)`) // Update an existing table
 	resp = e.Execute(`SHOW TABLES`)
 	// table1 should still be listed without prefix as its update is still buffered, not a new table.
-	expected = fmt.Sprintf("Tables:\n- [%s] table1\n- table2\n- [%s] tx_table_new", strings.TrimPrefix(txID, "tx_"), strings.TrimPrefix(txID, "tx_"))
+	expected = "Tables:\n- [1] table1\n- table2\n- [1] tx_table_new"
 	if strings.TrimSpace(resp) != expected {
 		t.Errorf("Expected tables with updated existing table:\n%q\nGot:\n%q", expected, resp)
 	}
@@ -419,7 +501,7 @@ func TestEngineShowTables(t *testing.T) {
 	// Scenario 6: Table dropped in a transaction (should not show)
 	e.Execute(`DROP table2`)
 	resp = e.Execute(`SHOW TABLES`)
-	expected = fmt.Sprintf("Tables:\n- [%s] table1\n- [%s] tx_table_new", strings.TrimPrefix(txID, "tx_"), strings.TrimPrefix(txID, "tx_"))
+	expected = "Tables:\n- [1] table1\n- [1] tx_table_new"
 	if strings.TrimSpace(resp) != expected {
 		t.Errorf("Expected tables with dropped table in transaction:\n%q\nGot:\n%q", expected, resp)
 	}
@@ -434,12 +516,11 @@ func TestEngineShowTables(t *testing.T) {
 
 	// Scenario 8: Rollback transaction (start new transaction for this)
 	e.Execute(`INSERT (x,y) INTO perm_table`) // Create another permanent table
-	txResp = e.Execute(`BEGIN`)
-	txIDRollback := strings.TrimPrefix(txResp, "Transaction started: ")
+	e.Execute(`BEGIN`)
 	e.Execute(`INSERT (d,4) INTO rollback_table`)
 	e.Execute(`DROP tx_table_new`) // Drop a previously committed table
 	resp = e.Execute(`SHOW TABLES`)
-	expected = fmt.Sprintf("Tables:\n- perm_table\n- [%s] rollback_table\n- table1", strings.TrimPrefix(txIDRollback, "tx_"))
+	expected = "Tables:\n- perm_table\n- [2] rollback_table\n- table1"
 	if strings.TrimSpace(resp) != expected {
 		t.Errorf("Expected tables before rollback:\n%q\nGot:\n%q", expected, resp)
 	}
@@ -461,3 +542,1232 @@ func TestEngineShowTables(t *testing.T) {
 		t.Errorf("Expected parse error for invalid SHOW syntax, got %q", resp)
 	}
 }
+
+func TestEngineDuplicateCommit(t *testing.T) {
+	e := setupTestEngine(t)
+
+	txID := strings.TrimPrefix(e.Execute(`BEGIN`), "Transaction started: ")
+	e.Execute(`INSERT (a, 1) INTO dup_table`)
+
+	resp := e.Execute("COMMIT " + txID)
+	if resp != fmt.Sprintf("Transaction %s committed.", txID) {
+		t.Fatalf("Expected commit success, got %q", resp)
+	}
+
+	// A retry of the same COMMIT must not fail or re-apply anything
+	resp = e.Execute("COMMIT " + txID)
+	if resp != fmt.Sprintf("Transaction %s already committed.", txID) {
+		t.Fatalf("Expected duplicate commit to be a no-op, got %q", resp)
+	}
+
+	resp = e.Execute(`COMMIT tx_unknown`)
+	if resp != "Error: Unknown transaction tx_unknown." {
+		t.Errorf("Expected unknown transaction error, got %q", resp)
+	}
+
+	resp = e.Execute(`COMMIT`)
+	if resp != "Error: No active transaction to commit." {
+		t.Errorf("Expected no active transaction error, got %q", resp)
+	}
+
+	resp = e.Execute(`SELECT * FROM dup_table`)
+	if resp != "a: 1" {
+		t.Errorf("Expected committed data to be unchanged, got %q", resp)
+	}
+}
+
+func TestEngineShowTableSizes(t *testing.T) {
+	e := setupTestEngine(t)
+
+	resp := e.Execute(`SHOW TABLE SIZES`)
+	if resp != "No tables found." {
+		t.Errorf("Expected 'No tables found.', got %q", resp)
+	}
+
+	e.Execute(`INSERT (a, 1) INTO small`)                        // 2 bytes
+	e.Execute(`INSERT (key1, value1), (key2, value2) INTO big`)  // 20 bytes
+	e.Execute(`INSERT (k1, v1), (k2, v2), (k3, v3) INTO medium`) // 12 bytes
+	e.Execute(`INSERT (x, 12345678901) INTO also_medium`)        // 12 bytes, sorted by name on a tie
+	resp = e.Execute(`SHOW TABLE SIZES`)
+	expected := "Table sizes:\n" +
+		"- big: 2 key(s), 20 bytes\n" +
+		"- also_medium: 1 key(s), 12 bytes\n" +
+		"- medium: 3 key(s), 12 bytes\n" +
+		"- small: 1 key(s), 2 bytes"
+	if resp != expected {
+		t.Errorf("Expected table sizes:\n%q\nGot:\n%q", expected, resp)
+	}
+}
+
+func TestEngineEscapedDelimiters(t *testing.T) {
+	e := setupTestEngine(t)
+
+	resp := e.Execute(`INSERT (k1, a\,b), (k\(2\), f\(x\)) INTO esc_table`)
+	if resp != "Inserted 2 key(s) into table 'esc_table'" {
+		t.Fatalf("Expected 2 keys inserted, got %q", resp)
+	}
+
+	resp = e.Execute(`SELECT k1 FROM esc_table`)
+	if resp != "k1: a,b" {
+		t.Errorf("Expected escaped comma to be stored literally, got %q", resp)
+	}
+
+	resp = e.Execute(`SELECT k\(2\) FROM esc_table`)
+	if resp != "k(2): f(x)" {
+		t.Errorf("Expected escaped parentheses to be stored literally, got %q", resp)
+	}
+
+	resp = e.Execute(`UPDATE esc_table SET (k1, c\,d\\)`)
+	if resp != "Updated 1 key(s) in table 'esc_table'" {
+		t.Fatalf("Expected 1 key updated, got %q", resp)
+	}
+	resp = e.Execute(`SELECT k1 FROM esc_table`)
+	if resp != `k1: c,d\` {
+		t.Errorf("Expected escaped backslash to be stored literally, got %q", resp)
+	}
+
+	resp = e.Execute(`DELETE k\(2\) FROM esc_table`)
+	if resp != "Deleted 1 key(s) from table 'esc_table'" {
+		t.Errorf("Expected escaped key to be deleted, got %q", resp)
+	}
+}
+
+func TestEngineQuotedKeys(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT ('first name', John), ('a,b', 1) INTO t`)
+	e.Execute(`UPDATE t SET ('first name', 'Jane Doe')`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t`, "a,b: 1\nfirst name: Jane Doe"},
+		{`SELECT 'first name' FROM t`, "first name: Jane Doe"},
+		{`SELECT VERSIONS OF 'first name' IN t`, "1: John\n3: Jane Doe"},
+		{`DELETE 'a,b' FROM t`, "Deleted 1 key(s) from table 't'"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestEngineNextVal(t *testing.T) {
+	e := setupTestEngine(t)
+
+	for want := 1; want <= 3; want++ {
+		if resp := e.Execute(`NEXTVAL order_id`); resp != fmt.Sprint(want) {
+			t.Fatalf("Expected NEXTVAL to return %d, got %q", want, resp)
+		}
+	}
+	if resp := e.Execute(`NEXTVAL invoice_id`); resp != "1" {
+		t.Errorf("Expected an independent sequence to start at 1, got %q", resp)
+	}
+	if resp := e.Execute(`SHOW TABLES`); resp != "No tables found." {
+		t.Errorf("Expected the sequence table to be hidden, got %q", resp)
+	}
+
+	resp := e.Execute(`NEXTVAL`)
+	if !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected parse error for NEXTVAL without a name, got %q", resp)
+	}
+
+	// The sequence table cannot be written or dropped, so values never repeat
+	for _, query := range []string{
+		`UPDATE __sequences SET (order_id, abc)`,
+		`DELETE order_id FROM __sequences`,
+		`DROP __sequences`,
+	} {
+		if resp := e.Execute(query); resp != "Error: '__sequences' is a reserved name" {
+			t.Errorf("%s: expected the sequence table to be refused, got %q", query, resp)
+		}
+	}
+
+	// Values must keep increasing after a restart
+	e.Close()
+	restarted := NewEngine("test_wal.log")
+	defer restarted.Close()
+	if resp := restarted.Execute(`NEXTVAL order_id`); resp != "4" {
+		t.Errorf("Expected NEXTVAL to continue at 4 after restart, got %q", resp)
+	}
+}
+
+func TestEngineIdleTxTimeout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := setupTestEngineWithOptions(t, EngineOptions{
+		IdleTxTimeout: time.Minute,
+		Now:           func() time.Time { return now },
+	})
+
+	e.Execute(`INSERT (a, 1) INTO idle_table`)
+	txID := strings.TrimPrefix(e.Execute(`BEGIN`), "Transaction started: ")
+	e.Execute(`INSERT (b, 2) INTO idle_table`)
+
+	// Activity within the timeout keeps the transaction alive
+	now = now.Add(50 * time.Second)
+	e.Execute(`INSERT (c, 3) INTO idle_table`)
+	now = now.Add(50 * time.Second)
+	resp := e.Execute(`SELECT * FROM idle_table`)
+	if !strings.Contains(resp, "c: ["+txID+"] 3") {
+		t.Fatalf("Expected transaction to still be active, got:\n%s", resp)
+	}
+
+	// Idling past the timeout rolls the transaction back
+	now = now.Add(61 * time.Second)
+	resp = e.Execute(`COMMIT`)
+	expected := fmt.Sprintf("Error: Transaction %s was rolled back after being idle for more than 1m0s.", txID)
+	if resp != expected {
+		t.Fatalf("Expected %q, got %q", expected, resp)
+	}
+
+	resp = e.Execute(`SELECT * FROM idle_table`)
+	if resp != "a: 1" {
+		t.Errorf("Expected buffered changes to be discarded, got %q", resp)
+	}
+	resp = e.Execute(`COMMIT`)
+	if resp != "Error: No active transaction to commit." {
+		t.Errorf("Expected the error to be reported only once, got %q", resp)
+	}
+}
+
+// fakeSyncer counts Sync calls and returns a fixed error.
+type fakeSyncer struct {
+	calls int
+	err   error
+}
+
+func (f *fakeSyncer) Sync() error {
+	f.calls++
+	return f.err
+}
+
+func TestEngineBarrier(t *testing.T) {
+	e := setupTestEngine(t)
+	syncer := &fakeSyncer{}
+	e.wal.syncer = syncer
+
+	e.Execute(`INSERT (a, 1) INTO barrier_table`)
+	if err := e.Barrier(); err != nil {
+		t.Fatalf("Barrier returned unexpected error: %v", err)
+	}
+	if syncer.calls != 1 {
+		t.Errorf("Expected Barrier to sync once, got %d calls", syncer.calls)
+	}
+
+	syncer.err = fmt.Errorf("disk full")
+	if err := e.Barrier(); err != syncer.err {
+		t.Errorf("Expected Barrier to return the sync error, got %v", err)
+	}
+
+	resp := e.Execute(`SYNC`)
+	if resp != "Error: WAL sync failed: disk full" {
+		t.Errorf("Expected SYNC to report the sync error, got %q", resp)
+	}
+	syncer.err = nil
+	resp = e.Execute(`SYNC`)
+	if resp != "WAL synced to disk." {
+		t.Errorf("Expected SYNC to succeed, got %q", resp)
+	}
+	if syncer.calls != 4 {
+		t.Errorf("Expected 4 sync calls in total, got %d", syncer.calls)
+	}
+}
+
+func TestEngineScan(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3), (d, 4), (e, 5) INTO scan_table`)
+
+	isEven := func(key, value string) bool {
+		n, err := strconv.Atoi(value)
+		return err == nil && n%2 == 0
+	}
+
+	rows := e.Scan("scan_table", isEven)
+	expected := []KeyValue{{Key: "b", Value: "2"}, {Key: "d", Value: "4"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Scan = %v, expected %v", rows, expected)
+	}
+
+	// Inside a transaction the scan sees buffered changes
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (f, 6), (aa, 10) INTO scan_table`)
+	e.Execute(`UPDATE scan_table SET (c, 8)`)
+	e.Execute(`DELETE b FROM scan_table`)
+	rows = e.Scan("scan_table", isEven)
+	expected = []KeyValue{{Key: "aa", Value: "10"}, {Key: "c", Value: "8"}, {Key: "d", Value: "4"}, {Key: "f", Value: "6"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Scan in transaction = %v, expected %v", rows, expected)
+	}
+	e.Execute(`ROLLBACK`)
+
+	if rows := e.Scan("scan_table", nil); len(rows) != 5 {
+		t.Errorf("Expected a nil filter to return all 5 rows, got %v", rows)
+	}
+	if rows := e.Scan("missing_table", nil); rows != nil {
+		t.Errorf("Expected no rows for a missing table, got %v", rows)
+	}
+}
+
+func TestEngineInsertSelect(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a1, x), (a2, y), (b1, z) INTO source_table`)
+	e.Execute(`INSERT (a2, existing) INTO target_table`)
+
+	resp := e.Execute(`INSERT INTO target_table SELECT * FROM source_table WHERE key LIKE 'a%'`)
+	if resp != "Inserted 1 key(s) into table 'target_table'" {
+		t.Fatalf("Expected 1 new key inserted, got %q", resp)
+	}
+	resp = e.Execute(`SELECT * FROM target_table`)
+	if resp != "a1: x\na2: existing" {
+		t.Errorf("Expected exactly the matching rows without overwriting, got %q", resp)
+	}
+
+	// Inside a transaction the copied rows are buffered like any other insert
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT INTO copy_table SELECT b1 FROM source_table`)
+	e.Execute(`COMMIT`)
+	if resp := e.Execute(`SELECT * FROM copy_table`); resp != "b1: z" {
+		t.Errorf("Expected the copied row after commit, got %q", resp)
+	}
+
+	resp = e.Execute(`INSERT INTO target_table SELECT * FROM missing_table`)
+	if resp != "Table 'missing_table' not found" {
+		t.Errorf("Expected missing source table error, got %q", resp)
+	}
+	resp = e.Execute(`INSERT INTO target_table`)
+	if !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected a parse error, got %q", resp)
+	}
+}
+
+func TestEngineDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     DuplicatePolicy
+		wantErr    bool
+		wantValues map[string]string // expected contents of dup_table afterwards
+	}{
+		{"Skip", SkipDuplicates, false, map[string]string{"a": "1", "b": "new"}},
+		{"ErrorOnDuplicate", ErrorOnDuplicate, true, map[string]string{"a": "1"}},
+		{"Overwrite", OverwriteDuplicates, false, map[string]string{"a": "new", "b": "new"}},
+	}
+
+	for _, tt := range tests {
+		for _, inTx := range []bool{false, true} {
+			name := tt.name + "/autocommit"
+			if inTx {
+				name = tt.name + "/transaction"
+			}
+			t.Run(name, func(t *testing.T) {
+				e := setupTestEngineWithOptions(t, EngineOptions{OnDuplicate: tt.policy})
+				e.Execute(`INSERT (a, 1) INTO dup_table`)
+
+				if inTx {
+					e.Execute(`BEGIN`)
+				}
+				resp := e.Execute(`INSERT (a, new), (b, new) INTO dup_table`)
+				if got := strings.HasPrefix(resp, "Error:"); got != tt.wantErr {
+					t.Fatalf("Expected error = %v, got %q", tt.wantErr, resp)
+				}
+				if inTx {
+					e.Execute(`COMMIT`)
+				}
+
+				rows := e.Scan("dup_table", nil)
+				if len(rows) != len(tt.wantValues) {
+					t.Fatalf("Expected %d rows, got %v", len(tt.wantValues), rows)
+				}
+				for _, row := range rows {
+					if tt.wantValues[row.Key] != row.Value {
+						t.Errorf("Expected %s = %q, got %q", row.Key, tt.wantValues[row.Key], row.Value)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEngineCompactOnClose(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{CompactOnClose: true})
+	e.Execute(`INSERT (b, 1), (a, 1), (gone, 1) INTO users`)
+	e.Execute(`UPDATE users SET (a, 2)`)
+	e.Execute(`DELETE gone FROM users`)
+	e.Execute(`INSERT (x, 1) INTO scratch`)
+	e.Execute(`DROP scratch`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (c, 3) INTO users`)
+	e.Execute(`COMMIT`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (d, 4) INTO users`)
+	e.Execute(`ROLLBACK`)
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read compacted log: %v", err)
+	}
+	want := "SET users a 2\nSET users b 1\nSET users c 3\n"
+	if string(data) != want {
+		t.Fatalf("Expected compacted log:\n%s\ngot:\n%s", want, data)
+	}
+
+	// The compacted log replays to the same state and accepts new writes
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	e.Execute(`INSERT (e, 5) INTO users`)
+	resp := e.Execute(`SELECT * FROM users`)
+	for _, line := range []string{"a: 2", "b: 1", "c: 3", "e: 5"} {
+		if !strings.Contains(resp, line) {
+			t.Errorf("Expected %q after restart, got:\n%s", line, resp)
+		}
+	}
+}
+
+func TestEngineCompactOnCloseSkipsOpenTransaction(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{CompactOnClose: true})
+	e.Execute(`INSERT (a, 1) INTO users`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (b, 2) INTO users`)
+	if err := e.Checkpoint(); !errors.Is(err, ErrTxActive) {
+		t.Fatalf("Expected ErrTxActive from Checkpoint, got %v", err)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(data), "BEGIN_TX") {
+		t.Errorf("Expected the log not to be compacted with a transaction open, got:\n%s", data)
+	}
+}
+
+func TestEnginePoolNodes(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{PreallocTables: 8, PoolNodes: true})
+	for i := 0; i < 50; i++ {
+		e.Execute(fmt.Sprintf("INSERT (k%02d, %d) INTO pooled", i, i))
+	}
+	for i := 0; i < 50; i += 2 {
+		e.Execute(fmt.Sprintf("DELETE k%02d FROM pooled", i))
+	}
+	if e.tables["pooled"].pool == nil {
+		t.Fatal("Expected tables to use a node pool")
+	}
+	if got := len(e.Scan("pooled", nil)); got != 25 {
+		t.Errorf("Expected 25 keys left, got %d", got)
+	}
+	if err := e.tables["pooled"].Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestEngineCommitDurableBeforeApply(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	e.Execute(`INSERT (x, 1) INTO old`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (c, 3) INTO t`)
+	e.Execute(`UPDATE t SET (a, 10)`)
+	e.Execute(`DELETE b FROM t`)
+	e.Execute(`DROP old`)
+
+	// Simulate a crash after the commit is logged but before memory is updated
+	e.logCommit(e.currentTxID)
+	if _, ok := e.tables["old"]; !ok {
+		t.Fatal("Expected the in-memory tables to be untouched by logCommit")
+	}
+	e.wal.Close()
+
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 10\nc: 3" {
+		t.Errorf("Expected the full committed state after replay, got %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM old`); resp != "Table 'old' not found" {
+		t.Errorf("Expected the dropped table to stay dropped, got %q", resp)
+	}
+}
+
+func TestEngineGenerate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := setupTestEngineWithOptions(t, EngineOptions{
+		Now: func() time.Time {
+			now = now.Add(1500 * time.Millisecond) // every reading is 1.5s after the last
+			return now
+		},
+	})
+	resp := e.Execute(`GENERATE 250 INTO synth`)
+	if resp != "Inserted 250 key(s) into table 'synth' in 1.5s" {
+		t.Fatalf("Unexpected GENERATE response: %q", resp)
+	}
+
+	rows := e.Scan("synth", nil)
+	if len(rows) != 250 {
+		t.Fatalf("Expected 250 keys, got %d", len(rows))
+	}
+	if rows[0].Key != "key_000001" || rows[0].Value != "value_000001" || rows[249].Key != "key_000250" {
+		t.Errorf("Unexpected generated keys: first %v, last %v", rows[0], rows[249])
+	}
+
+	for _, query := range []string{`GENERATE 0 INTO synth`, `GENERATE ten INTO synth`, `GENERATE 10 synth`} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Parse error:") {
+			t.Errorf("%s: expected a parse error, got %q", query, resp)
+		}
+	}
+
+	// The batched log records are all written
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if got := len(e.Scan("synth", nil)); got != 250 {
+		t.Errorf("Expected 250 keys after restart, got %d", got)
+	}
+}
+
+func TestEngineInsertBatch(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	resp := e.InsertBatch("t", []KeyValue{{Key: "a", Value: "dup"}, {Key: "b", Value: "2"}})
+	if resp != "Inserted 1 key(s) into table 't'" {
+		t.Errorf("Unexpected InsertBatch response: %q", resp)
+	}
+
+	e.Execute(`BEGIN`)
+	e.InsertBatch("t", []KeyValue{{Key: "c", Value: "3"}})
+	e.Execute(`ROLLBACK`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1\nb: 2" {
+		t.Errorf("Expected InsertBatch to respect the duplicate policy and the transaction, got %q", resp)
+	}
+}
+
+func TestEngineCompactNow(t *testing.T) {
+	e := setupTestEngine(t)
+	for i := 0; i < 5; i++ {
+		e.Execute(fmt.Sprintf("UPDATE t SET (a, %d)", i))
+		e.Execute(fmt.Sprintf("INSERT (a, %d) INTO t", i))
+	}
+	e.Execute(`INSERT (b, 1), (c, 1) INTO t`)
+	e.Execute(`DELETE c FROM t`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (d, 4) INTO t`)
+	e.Execute(`UPDATE t SET (a, rolled-back)`)
+	e.Execute(`ROLLBACK`)
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE t SET (b, 2)`)
+	e.Execute(`COMMIT`)
+
+	if err := e.CompactNow(); err != nil {
+		t.Fatalf("CompactNow: %v", err)
+	}
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read compacted log: %v", err)
+	}
+	if want := "SET t a 4\nSET t b 2\n"; string(data) != want {
+		t.Fatalf("Expected compacted log:\n%s\ngot:\n%s", want, data)
+	}
+
+	// The WAL continues on the compacted file
+	e.Execute(`INSERT (e, 5) INTO t`)
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 4\nb: 2\ne: 5" {
+		t.Errorf("Unexpected state after restart: %q", resp)
+	}
+
+	e.Execute(`BEGIN`)
+	if err := e.CompactNow(); !errors.Is(err, ErrTxActive) {
+		t.Errorf("Expected ErrTxActive inside a transaction, got %v", err)
+	}
+}
+
+func TestEngineGzipCodec(t *testing.T) {
+	opts := EngineOptions{Codecs: map[string]Codec{"docs": GzipCodec{Threshold: 64}}}
+	e := setupTestEngineWithOptions(t, opts)
+	large := strings.Repeat("abc", 200)
+	e.Execute(fmt.Sprintf(`INSERT (big, %s), (small, tiny) INTO docs`, large))
+	e.Execute(`INSERT (big, ` + large + `) INTO plain`)
+
+	if stored, _ := e.tables["docs"].Get("big"); len(stored) >= len(large) {
+		t.Errorf("Expected a compressed stored value, got %d bytes", len(stored))
+	}
+	if stored, _ := e.tables["docs"].Get("small"); stored != "tiny" {
+		t.Errorf("Expected a small value to be stored raw, got %q", stored)
+	}
+	if stored, _ := e.tables["plain"].Get("big"); stored != large {
+		t.Error("Expected a table without a codec to store values as is")
+	}
+	want := "big: " + large + "\nsmall: tiny"
+	if resp := e.Execute(`SELECT * FROM docs`); resp != want {
+		t.Errorf("Unexpected scan result: %q", resp)
+	}
+
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE docs SET (small, ` + large + `)`)
+	e.Execute(`COMMIT`)
+	if resp := e.Execute(`SELECT small FROM docs`); resp != "small: "+large {
+		t.Errorf("Unexpected value after commit: %q", resp)
+	}
+
+	e.Close()
+	e = NewEngineWithOptions("test_wal.log", opts)
+	defer e.Close()
+	if resp := e.Execute(`SELECT big, small FROM docs`); resp != "big: "+large+"\nsmall: "+large {
+		t.Errorf("Unexpected values after restart: %q", resp)
+	}
+}
+
+func TestGzipCodecEscapesMarker(t *testing.T) {
+	codec := GzipCodec{Threshold: 1 << 10}
+	for _, value := range []string{"", "plain", codecMarker, codecGzip + "x", codecRaw} {
+		got, err := codec.Decode(codec.Encode(value))
+		if err != nil || got != value {
+			t.Errorf("Round trip of %q gave %q, %v", value, got, err)
+		}
+	}
+}
+
+func TestEngineSpecialCharacterKeys(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (-1, neg), (a.b.c, dotted), (user:42, colon), (plain, p) INTO t`)
+
+	if resp := e.Execute(`SELECT -1, a.b.c, user:42 FROM t`); resp != "-1: neg\na.b.c: dotted\nuser:42: colon" {
+		t.Errorf("Unexpected SELECT result: %q", resp)
+	}
+	e.Execute(`DELETE -1, a.b.c, user:42 FROM t`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "plain: p" {
+		t.Errorf("Expected only plain to remain, got %q", resp)
+	}
+}
+
+func TestEngineReplayProgress(t *testing.T) {
+	e := setupTestEngine(t)
+	values := make([]KeyValue, 3000)
+	for i := range values {
+		values[i] = KeyValue{Key: strconv.Itoa(i), Value: strings.Repeat("v", 1000)}
+	}
+	e.InsertBatch("t", values)
+	e.Close()
+
+	info, err := os.Stat("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	var reports [][2]int64
+	e = NewEngineWithOptions("test_wal.log", EngineOptions{
+		ReplayProgress: func(bytesRead, totalBytes int64) {
+			reports = append(reports, [2]int64{bytesRead, totalBytes})
+		},
+	})
+	defer e.Close()
+
+	if len(reports) < 3 {
+		t.Fatalf("Expected several progress reports for a %d byte log, got %d", info.Size(), len(reports))
+	}
+	for i, r := range reports {
+		if r[1] != info.Size() {
+			t.Errorf("Report %d: total %d, want %d", i, r[1], info.Size())
+		}
+		if i > 0 && r[0] <= reports[i-1][0] {
+			t.Errorf("Report %d: progress %d did not increase from %d", i, r[0], reports[i-1][0])
+		}
+	}
+	if last := reports[len(reports)-1]; last[0] != last[1] {
+		t.Errorf("Expected the final report to be complete, got %d/%d", last[0], last[1])
+	}
+	if resp := e.Execute(`SELECT 2999 FROM t`); resp != "2999: "+strings.Repeat("v", 1000) {
+		t.Errorf("Unexpected value after replay: %.20q", resp)
+	}
+}
+
+func TestEngineStrictTables(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{StrictTables: true})
+
+	if resp := e.Execute(`INSERT (a, 1) INTO t`); !strings.HasPrefix(resp, "Error: table 't' does not exist") {
+		t.Errorf("Expected INSERT into an uncreated table to fail, got %q", resp)
+	}
+	if resp := e.Execute(`UPDATE t SET (a, 1)`); !strings.HasPrefix(resp, "Error: table 't' does not exist") {
+		t.Errorf("Expected UPDATE of an uncreated table to fail, got %q", resp)
+	}
+	e.Execute(`BEGIN`)
+	if resp := e.Execute(`INSERT (a, 1) INTO t`); !strings.HasPrefix(resp, "Error: table 't' does not exist") {
+		t.Errorf("Expected INSERT in a transaction to fail, got %q", resp)
+	}
+	if resp := e.Execute(`CREATE TABLE t`); resp != "Error: CREATE TABLE is not allowed inside a transaction." {
+		t.Errorf("Unexpected CREATE TABLE response in a transaction: %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+	if resp := e.Execute(`SHOW TABLES`); resp != "No tables found." {
+		t.Errorf("Expected no tables to be created, got %q", resp)
+	}
+
+	if resp := e.Execute(`CREATE TABLE t`); resp != "Table 't' created" {
+		t.Fatalf("Unexpected CREATE TABLE response: %q", resp)
+	}
+	if resp := e.Execute(`CREATE TABLE t`); resp != "Error: table 't' already exists" {
+		t.Errorf("Expected a duplicate CREATE TABLE to fail, got %q", resp)
+	}
+	if resp := e.Execute(`INSERT (a, 1) INTO t`); resp != "Inserted 1 key(s) into table 't'" {
+		t.Errorf("Expected INSERT after CREATE TABLE to succeed, got %q", resp)
+	}
+	e.Execute(`BEGIN`)
+	if resp := e.Execute(`UPDATE t SET (a, 2)`); strings.HasPrefix(resp, "Error") {
+		t.Errorf("Expected UPDATE in a transaction to succeed, got %q", resp)
+	}
+	e.Execute(`COMMIT`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 2" {
+		t.Errorf("Unexpected table contents: %q", resp)
+	}
+}
+
+func TestEngineKeyValidator(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{KeyValidator: IdentifierKey})
+
+	if resp := e.Execute(`INSERT (user_1, a), (x, b) INTO t`); resp != "Inserted 2 key(s) into table 't'" {
+		t.Fatalf("Expected valid keys to be accepted, got %q", resp)
+	}
+	for _, cmd := range []string{
+		`INSERT (ok, 1), ("has space", 2) INTO t`,
+		`INSERT (1st, 1) INTO t`,
+		`UPDATE t SET (user_1, c), (user:1, d)`,
+	} {
+		if resp := e.Execute(cmd); !strings.HasPrefix(resp, "Error: key ") {
+			t.Errorf("%s: expected the validator's error, got %q", cmd, resp)
+		}
+	}
+	if got := e.Execute(`SELECT * FROM t`); got != "user_1: a\nx: b" {
+		t.Errorf("Expected rejected statements to change nothing, got %q", got)
+	}
+
+	e.Execute(`BEGIN`)
+	if resp := e.Execute(`INSERT (bad-key, 1) INTO t`); !strings.HasPrefix(resp, "Error: key ") {
+		t.Errorf("Expected the validator to apply inside a transaction, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+}
+
+func TestEngineAppend(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (log, start) INTO t`)
+
+	if resp := e.Execute(`APPEND (log, ' more'), (new, first), (log, '!') IN t`); resp != "Appended to 2 key(s) in table 't'" {
+		t.Fatalf("Unexpected APPEND response: %q", resp)
+	}
+	if got := e.Execute(`SELECT log, new FROM t`); got != "log: start more!\nnew: first" {
+		t.Errorf("Expected appended values, got %q", got)
+	}
+
+	e.Execute(`BEGIN`)
+	e.Execute(`APPEND (log, ' tx') IN t`)
+	e.Execute(`ROLLBACK`)
+	if got := e.Execute(`SELECT log FROM t`); got != "log: start more!" {
+		t.Errorf("Expected a rolled back APPEND to change nothing, got %q", got)
+	}
+
+	// Concurrent appends are serialized by the engine lock, so none is lost
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				e.Execute(`APPEND (counter, x) IN t`)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := e.Execute(`SELECT counter FROM t`); got != "counter: "+strings.Repeat("x", 400) {
+		t.Errorf("Expected 400 appended characters, got %d", len(got)-len("counter: "))
+	}
+
+	// Appends are logged as the resulting values
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if got := e.Execute(`SELECT log FROM t`); got != "log: start more!" {
+		t.Errorf("Expected the appended value after a restart, got %q", got)
+	}
+}
+
+func TestEngineIncrDecr(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (hits, 41), (name, bob) INTO t`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`INCR hits IN t`, "42"},
+		{`INCR hits IN t BY 8`, "50"},
+		{`DECR new IN t`, "-1"}, // a missing key counts as 0
+		{`DECR new IN t BY 4`, "-5"},
+		{`INCR name IN t`, `Error: value of key 'name' is not an integer: "bob"`},
+		{`INCR hits IN t BY -1`, `Parse error: invalid INCR syntax: BY must be a positive integer, got "-1"`},
+		{`SELECT * FROM t`, "hits: 50\nname: bob\nnew: -5"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	e.Execute(`INSERT (big, 9223372036854775807) INTO t`)
+	if resp := e.Execute(`INCR big IN t`); resp != "Error: INCR of key 'big' would overflow" {
+		t.Errorf("Expected an overflow error, got %q", resp)
+	}
+}
+
+func TestEngineSwap(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`SWAP a b IN t`, "Swapped 'a' and 'b' in table 't'"},
+		{`SELECT * FROM t`, "a: 2\nb: 1"},
+		{`SWAP a missing IN t`, "Error: key 'missing' not found in table 't'"},
+		{`SWAP missing a IN t`, "Error: key 'missing' not found in table 't'"},
+		{`SWAP a b IN nope`, "Table 'nope' not found"},
+		{`SWAP a IN t`, "Parse error: invalid SWAP syntax: expected 'SWAP <key1> <key2> IN <table_name>'"},
+		{`SELECT * FROM t`, "a: 2\nb: 1"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	// Both writes are logged, so the swap survives a restart
+	e.Close()
+	restarted := NewEngine("test_wal.log")
+	defer restarted.Close()
+	if resp := restarted.Execute(`SELECT * FROM t`); resp != "a: 2\nb: 1" {
+		t.Errorf("Expected the swap to be replayed, got %q", resp)
+	}
+}
+
+func TestEngineMove(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
+	e.Execute(`INSERT (job1, queued), (job2, queued), (job3, queued) INTO pending`)
+	e.Execute(`INSERT (job3, done) INTO finished`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`MOVE job1 FROM pending TO running`, "Moved 'job1' from table 'pending' to table 'running'"},
+		{`SELECT * FROM pending`, "job2: queued\njob3: queued"},
+		{`SELECT * FROM running`, "job1: queued"},
+		{`MOVE job1 FROM pending TO running`, "Error: key 'job1' not found in table 'pending'"},
+		{`MOVE job3 FROM pending TO finished`, "Error: key 'job3' already exists in table 'finished'; use MOVE ... OVERWRITE to replace it"},
+		{`MOVE job3 FROM pending TO finished OVERWRITE`, "Moved 'job3' from table 'pending' to table 'finished'"},
+		{`SELECT * FROM finished`, "job3: queued"},
+		{`MOVE job2 FROM nope TO running`, "Table 'nope' not found"},
+		{`MOVE job2 FROM pending TO pending`, "Error: MOVE needs two different tables"},
+		{`MOVE job2 FROM pending`, "Parse error: invalid MOVE syntax: expected 'MOVE <key> FROM <table_name> TO <table_name> [OVERWRITE]'"},
+
+		// Inside a transaction the move is buffered and undone by ROLLBACK
+		{`BEGIN`, "Transaction started: tx_3"}, // tx_1 and tx_2 logged the two moves above
+		{`MOVE job2 FROM pending TO running`, "Moved 'job2' from table 'pending' to table 'running'"},
+		{`SELECT * FROM running`, "job1: queued\njob2: [tx_3] queued"},
+		{`ROLLBACK`, "Transaction tx_3 rolled back."},
+		{`SELECT * FROM pending`, "job2: queued"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	// The move is logged as one transaction and replayed as a whole
+	e.Close()
+	restarted := NewEngine("test_wal.log")
+	defer restarted.Close()
+	for table, expected := range map[string]string{"pending": "job2: queued", "running": "job1: queued", "finished": "job3: queued"} {
+		if resp := restarted.Execute(`SELECT * FROM ` + table); resp != expected {
+			t.Errorf("After restart, %s: expected %q, got %q", table, expected, resp)
+		}
+	}
+}
+
+func TestShouldCheckpoint(t *testing.T) {
+	tests := []struct {
+		logBytes, liveBytes int64
+		want                bool
+	}{
+		{1 << 20, 1 << 20, false},     // fresh log, ratio 1
+		{1 << 20, 300 << 10, false},   // ratio below the threshold
+		{1 << 20, 256 << 10, true},    // ratio exactly at the threshold
+		{1 << 20, 0, true},            // a large log with nothing left in it
+		{32 << 10, 1 << 10, false},    // small logs are never worth it
+		{analyzeMinLogBytes, 1, true}, // the size floor is inclusive
+	}
+	for _, tt := range tests {
+		if got := shouldCheckpoint(tt.logBytes, tt.liveBytes); got != tt.want {
+			t.Errorf("shouldCheckpoint(%d, %d) = %v, want %v", tt.logBytes, tt.liveBytes, got, tt.want)
+		}
+	}
+}
+
+func TestEngineAnalyze(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+
+	info, err := os.Stat("test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("WAL size: %d bytes\nLive data: 2 bytes\nRatio: %.1f\nRecommendation: none", info.Size(), float64(info.Size())/2)
+	if resp := e.Execute(`ANALYZE`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+}
+
+func TestEngineCreateTableSurvivesRestart(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`CREATE TABLE empty`)
+	e.Close()
+	e = NewEngine("test_wal.log")
+	if _, ok := e.tables["empty"]; !ok {
+		t.Fatal("Expected the empty table to be replayed")
+	}
+
+	// Checkpointing keeps empty tables as well
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if _, ok := e.tables["empty"]; !ok {
+		t.Error("Expected the empty table to survive a checkpoint")
+	}
+	if resp := e.Execute(`CREATE TABLE __views`); resp != "Error: '__views' is a reserved name" {
+		t.Errorf("Expected a reserved name to be rejected, got %q", resp)
+	}
+}
+
+func TestEngineCommitOrderIsDeterministic(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (x, 0) INTO t1`)
+	e.Execute(`INSERT (x, 0) INTO t3`)
+	before, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+
+	// Touch the tables in reverse order; COMMIT must still visit them sorted
+	e.Execute(`BEGIN`)
+	e.Execute(`DELETE x FROM t3`)
+	e.Execute(`INSERT (b, 2), (a, 1) INTO t2`)
+	e.Execute(`INSERT (z, 9), (y, 8) INTO t3`)
+	e.Execute(`DROP t1`)
+	resp := e.Execute(`COMMIT`)
+	txID := strings.TrimSuffix(strings.TrimPrefix(resp, "Transaction "), " committed.")
+
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	want := strings.Join([]string{
+		"BEGIN_TX " + txID,
+		"DROP TABLE " + txID + " t1",
+		"SET " + txID + " t2 a 1",
+		"SET " + txID + " t2 b 2",
+		"SET " + txID + " t3 y 8",
+		"SET " + txID + " t3 z 9",
+		"DELETE " + txID + " t3 x",
+		"COMMIT_TX " + txID,
+	}, "\n") + "\n"
+	if got := string(data[len(before):]); got != want {
+		t.Errorf("Unexpected commit records:\n%s\nwant:\n%s", got, want)
+	}
+	if resp := e.Execute(`SELECT * FROM t1`); resp != "Table 't1' not found" {
+		t.Errorf("Expected t1 to be dropped, got %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM t3`); resp != "y: 8\nz: 9" {
+		t.Errorf("Unexpected t3 after commit: %q", resp)
+	}
+}
+
+func TestEngineReservedWordTableName(t *testing.T) {
+	e := setupTestEngine(t)
+	if resp := e.Execute(`CREATE TABLE "SELECT"`); resp != "Table 'SELECT' created" {
+		t.Fatalf("Unexpected CREATE TABLE response: %q", resp)
+	}
+	e.Execute("INSERT (`FROM`, 1), (plain, 2) INTO `SELECT`")
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM "SELECT"`, "FROM: 1\nplain: 2"},
+		{`SELECT "FROM" FROM "SELECT"`, "FROM: 1"},
+		{`SHOW TABLES`, "Tables:\n- SELECT"},
+		{`DELETE "FROM" FROM "SELECT"`, "Deleted 1 key(s) from table 'SELECT'"},
+		{`SELECT * FROM "SELECT"`, "plain: 2"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestEngineReorganize(t *testing.T) {
+	e := setupTestEngine(t)
+
+	// Churn: insert many keys, then delete most of them
+	for i := 0; i < 300; i++ {
+		e.Execute(fmt.Sprintf("INSERT (k%03d, v%d) INTO t", i, i))
+	}
+	for i := 0; i < 300; i++ {
+		if i%5 != 0 {
+			e.Execute(fmt.Sprintf("DELETE k%03d FROM t", i))
+		}
+	}
+	want := e.Execute(`SELECT * FROM t`)
+	before, _ := e.TableStats("t")
+
+	if err := e.Rebuild("t"); err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	after, _ := e.TableStats("t")
+	if after.FillFactor <= before.FillFactor {
+		t.Errorf("Expected the fill factor to improve, got %.2f -> %.2f", before.FillFactor, after.FillFactor)
+	}
+	if after.Keys != 60 {
+		t.Errorf("Expected 60 keys after Rebuild, got %d", after.Keys)
+	}
+	if err := e.tables["t"].Validate(); err != nil {
+		t.Errorf("Validate after Rebuild: %v", err)
+	}
+	if got := e.Execute(`SELECT * FROM t`); got != want {
+		t.Errorf("Expected Rebuild to preserve all pairs, got:\n%s", got)
+	}
+
+	if resp := e.Execute(`REORGANIZE t`); !strings.HasPrefix(resp, "Table 't' reorganized: 60 key(s)") {
+		t.Errorf("Unexpected REORGANIZE response: %q", resp)
+	}
+	if resp := e.Execute(`REORGANIZE missing`); resp != "Error: table 'missing' not found" {
+		t.Errorf("Expected an error for a missing table, got %q", resp)
+	}
+}
+
+func TestEngineExplainCommit(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute("INSERT (a, 1), (b, 2), (c, 3) INTO t")
+	e.Execute("INSERT (x, 1) INTO old")
+
+	if resp := e.Execute("EXPLAIN COMMIT"); resp != "Error: No active transaction to explain." {
+		t.Errorf("Expected an error outside a transaction, got %q", resp)
+	}
+
+	e.Execute("BEGIN")
+	txID := e.currentTxID
+	e.Execute("INSERT (d, 4) INTO t")             // insert
+	e.Execute("UPDATE t SET (a, 10)")             // update
+	e.Execute("INSERT (e, 5) INTO t")             // inserted, then deleted again
+	e.Execute("DELETE b, e FROM t")               // one delete of a committed key
+	e.Execute("DROP old")                         // drop
+	e.Execute("INSERT (n, 1), (m, 2) INTO fresh") // create
+	e.Execute("DROP old2")                        // not a table
+
+	expected := "COMMIT of transaction " + txID + " would apply:\n" +
+		"  fresh: create, 2 insert(s)\n" +
+		"  old: drop\n" +
+		"  t: 1 insert(s), 1 update(s), 1 delete(s)\n" +
+		"Total: 3 insert(s), 1 update(s), 1 delete(s), 1 drop(s)\n" +
+		"Tables created: fresh"
+	if resp := e.Execute("EXPLAIN COMMIT"); resp != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, resp)
+	}
+	if e.currentTxID != txID || e.tables["old"] == nil {
+		t.Error("Expected EXPLAIN COMMIT to leave the transaction open and uncommitted")
+	}
+
+	e.Execute("COMMIT")
+	if resp := e.Execute("SELECT * FROM t"); resp != "a: 10\nc: 3\nd: 4" {
+		t.Errorf("Expected the commit to match the preview, got %q", resp)
+	}
+	if resp := e.Execute("SELECT * FROM fresh"); resp != "m: 2\nn: 1" {
+		t.Errorf("Expected the created table after commit, got %q", resp)
+	}
+}
+
+func TestEngineVacuum(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute("INSERT (a, 1) INTO t")
+	e.Execute("BEGIN")
+	e.Execute("INSERT (b, 2) INTO t")
+	if resp := e.Execute("VACUUM"); resp != "Error: VACUUM is not allowed inside a transaction." {
+		t.Errorf("Expected VACUUM to be refused inside a transaction, got %q", resp)
+	}
+	e.Execute("ROLLBACK")
+
+	if resp := e.Execute("VACUUM"); resp != "Log vacuumed: removed 2 record(s) of uncommitted transactions" {
+		t.Errorf("Unexpected VACUUM response %q", resp)
+	}
+	if resp := e.Execute("VACUUM"); resp != "Log vacuumed: removed 0 record(s) of uncommitted transactions" {
+		t.Errorf("Expected a second VACUUM to remove nothing, got %q", resp)
+	}
+	if resp := e.Execute("SELECT * FROM t"); resp != "a: 1" {
+		t.Errorf("Expected the data to be unchanged, got %q", resp)
+	}
+}
+
+// TestEnginePrintTreeDuringWrites dumps a tree while another goroutine
+// writes to it; run with -race to check that PrintTree is synchronized.
+func TestEnginePrintTreeDuringWrites(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (k000, v) INTO t`)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i < 200; i++ {
+			e.Execute(fmt.Sprintf(`INSERT (k%03d, v) INTO t`, i))
+		}
+	}()
+	for {
+		var out bytes.Buffer
+		if err := e.PrintTree("t", &out); err != nil {
+			t.Fatalf("PrintTree failed: %v", err)
+		}
+		if !strings.HasPrefix(out.String(), "Level 0: ") {
+			t.Fatalf("Unexpected tree dump: %q", out.String())
+		}
+		select {
+		case <-done:
+			if err := e.PrintTree("missing", io.Discard); err == nil {
+				t.Error("Expected an error for a missing table")
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestEngineSealLog(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	if err := e.SealLog(); err != nil {
+		t.Fatalf("SealLog failed: %v", err)
+	}
+	e.Execute(`UPDATE t SET (a, 10)`)
+
+	e.Close()
+	restarted := NewEngine("test_wal.log")
+	defer restarted.Close()
+	if resp := restarted.Execute(`SELECT * FROM t`); resp != "a: 10\nb: 2" {
+		t.Errorf("Expected the sealed log to replay, got %q", resp)
+	}
+}
+
+func TestEngineCompactCommits(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{CompactCommits: true})
+	e.Execute(`INSERT (a, 1), (b, 2) INTO users`)
+	e.Execute(`BEGIN`)
+	e.Execute(`UPDATE users SET (a, 10)`)
+	e.Execute(`DELETE b FROM users`)
+	e.Execute(`INSERT (c, 3) INTO users`)
+	e.Execute(`COMMIT`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (d, 4) INTO users`)
+	e.Execute(`ROLLBACK`)
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	want := "SET users a 1\nSET users b 2\nBEGIN_BATCH\nSET users a 10\nSET users c 3\nDELETE users b\nEND_BATCH\n"
+	if string(data) != want {
+		t.Fatalf("Expected log:\n%s\ngot:\n%s", want, data)
+	}
+
+	e = NewEngineWithOptions("test_wal.log", EngineOptions{CompactCommits: true})
+	defer e.Close()
+	if resp, expected := e.Execute(`SELECT * FROM users`), "a: 10\nc: 3"; resp != expected {
+		t.Errorf("Expected %q after restart, got %q", expected, resp)
+	}
+	if versions, _ := e.wal.KeyVersions("users", "a"); !reflect.DeepEqual(versions, []KeyVersion{{Seq: 1, Value: "1"}, {Seq: 7, Value: "10"}}) {
+		t.Errorf("Unexpected versions of a: %+v", versions)
+	}
+	if value, ok, _ := e.wal.LastValue("users", "b"); ok {
+		t.Errorf("Expected b to be deleted by the batch, got %q", value)
+	}
+}
+
+func TestEngineCompactCommitsMoveAndSyncFailure(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{CompactCommits: true})
+	e.Execute(`INSERT (a, 1) INTO src`)
+	if resp := e.Execute(`MOVE a FROM src TO dst`); resp != "Moved 'a' from table 'src' to table 'dst'" {
+		t.Fatalf("Unexpected MOVE response: %q", resp)
+	}
+
+	syncer := &fakeSyncer{err: fmt.Errorf("disk full")}
+	e.wal.syncer = syncer
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (b, 2) INTO dst`)
+	if resp := e.Execute(`COMMIT`); resp != "Error: COMMIT failed: disk full. The transaction is still open." {
+		t.Errorf("Expected COMMIT to fail, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+	if resp := e.Execute(`MOVE a FROM dst TO src`); resp != "Error: MOVE failed: disk full" {
+		t.Errorf("Expected MOVE to fail, got %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM dst`); resp != "a: 1" {
+		t.Errorf("Expected the failed writes to change nothing, got %q", resp)
+	}
+	syncer.err = nil
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile("test_wal.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if want := "SET src a 1\nBEGIN_BATCH\nDELETE src a\nSET dst a 1\nEND_BATCH\n"; !strings.HasPrefix(string(data), want) {
+		t.Errorf("Expected MOVE to be logged as a batch:\n%s\ngot:\n%s", want, data)
+	}
+}
+
+func TestEngineCompactCommitsTornBatch(t *testing.T) {
+	// A crash during COMMIT left a batch without its END_BATCH, and its last
+	// record half written
+	log := "SET users a 1\nBEGIN_BATCH\nSET users a 10\nSET users b 2"
+	if err := os.WriteFile("test_wal.log", []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("test_wal.log")
+	defer os.Remove("test_wal.log.lock")
+	e := NewEngineWithOptions("test_wal.log", EngineOptions{CompactCommits: true})
+	if stats := e.RecoveryStats(); stats.IncompleteTxs != 1 {
+		t.Errorf("Expected the batch to be reported as incomplete, got %+v", stats)
+	}
+	e.Execute(`INSERT (c, 3) INTO users`)
+	if value, ok, _ := e.wal.LastValue("users", "a"); !ok || value != "1" {
+		t.Errorf("Expected the last value of a to be \"1\", got %q, %v", value, ok)
+	}
+	e.Close()
+
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if resp, expected := e.Execute(`SELECT * FROM users`), "a: 1\nc: 3"; resp != expected {
+		t.Errorf("Expected %q after restart, got %q", expected, resp)
+	}
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SetTypedOutput toggles annotating each SELECT value with its inferred type,
+// e.g. "age: 123 (int)". It only affects how results are displayed.
+func (e *Engine) SetTypedOutput(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.typedOutput = enabled
+	if e.queryCache != nil {
+		e.queryCache.clear() // Cached results were rendered with the old setting
+	}
+}
+
+// SetValueWidth truncates displayed SELECT values to at most width characters,
+// marking cut values with an ellipsis. Zero or a negative width disables
+// truncation. Stored data is not affected.
+func (e *Engine) SetValueWidth(width int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.valueWidth = width
+	if e.queryCache != nil {
+		e.queryCache.clear() // Cached results were rendered with the old setting
+	}
+}
+
+// formatRow renders a single result row. fromTx marks a value buffered in the
+// current transaction, which is prefixed with the transaction ID.
+func (e *Engine) formatRow(key, value string, fromTx bool) string {
+	display := TruncateValue(value, e.valueWidth)
+	if e.typedOutput {
+		display = fmt.Sprintf("%s (%s)", display, InferType(value))
+	}
+	if fromTx {
+		return fmt.Sprintf("%s: [%s] %s", key, e.currentTxID, display)
+	}
+	return fmt.Sprintf("%s: %s", key, display)
+}
+
+// InferType classifies a stored value as "int", "float" or "string" based on
+// whether it parses as a number.
+func InferType(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "int"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "float"
+	}
+	return "string"
+}
+
+// TruncateValue shortens value to at most width characters, replacing the
+// last kept character with "…" when anything was cut. A width of zero or less
+// returns value unchanged.
+func TruncateValue(value string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:width-1]) + "…"
+}
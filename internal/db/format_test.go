@@ -0,0 +1,84 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestInferType(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"123", "int"},
+		{"-42", "int"},
+		{"0", "int"},
+		{"3.14", "float"},
+		{"-0.5", "float"},
+		{"1e10", "float"},
+		{"abc", "string"},
+		{"12abc", "string"},
+		{"", "string"},
+	}
+
+	for _, tt := range tests {
+		if got := InferType(tt.value); got != tt.expected {
+			t.Errorf("InferType(%q) = %q, expected %q", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestEngineTypedOutput(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (age, 123), (height, 1.85), (name, bob) INTO people`)
+
+	e.SetTypedOutput(true)
+	resp := e.Execute(`SELECT * FROM people`)
+	expected := "age: 123 (int)\nheight: 1.85 (float)\nname: bob (string)"
+	if resp != expected {
+		t.Errorf("Expected typed output:\n%q\nGot:\n%q", expected, resp)
+	}
+
+	e.SetTypedOutput(false)
+	resp = e.Execute(`SELECT age FROM people`)
+	if resp != "age: 123" {
+		t.Errorf("Expected plain output after disabling typed mode, got %q", resp)
+	}
+}
+
+func TestTruncateValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		width    int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"truncated", 5, "trun…"},
+		{"abc", 1, "…"},
+		{"unlimited", 0, "unlimited"},
+		{"négligé", 4, "nég…"}, // counts characters, not bytes
+	}
+
+	for _, tt := range tests {
+		if got := TruncateValue(tt.value, tt.width); got != tt.expected {
+			t.Errorf("TruncateValue(%q, %d) = %q, expected %q", tt.value, tt.width, got, tt.expected)
+		}
+	}
+}
+
+func TestEngineValueWidth(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (long, abcdefghij), (short, abc) INTO wide_table`)
+
+	e.SetValueWidth(5)
+	resp := e.Execute(`SELECT * FROM wide_table`)
+	if resp != "long: abcd…\nshort: abc" {
+		t.Errorf("Expected truncated display, got %q", resp)
+	}
+
+	e.SetValueWidth(0)
+	resp = e.Execute(`SELECT long FROM wide_table`)
+	if resp != "long: abcdefghij" {
+		t.Errorf("Expected the stored value to be intact, got %q", resp)
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ImportMode decides what an import does with keys that already exist in
+// the target table.
+type ImportMode int
+
+const (
+	// ImportReplace overwrites the value of existing keys with the imported
+	// one. Within the input, the last occurrence of a key wins.
+	ImportReplace ImportMode = iota
+	// ImportMerge only adds keys that are absent from the table, leaving
+	// existing values intact, like INSERT. Within the input, the first
+	// occurrence of a key wins.
+	ImportMerge
+)
+
+// ImportJSON imports rows into table from r, which holds a JSON array of
+// {"key": ..., "value": ...} objects as produced by SELECT ... FORMAT JSON.
+// It returns the number of keys written. Like any INSERT it runs inside the
+// current transaction if one is open.
+func (e *Engine) ImportJSON(r io.Reader, table string, mode ImportMode) (int, error) {
+	var rows []struct {
+		Key   *string `json:"key"`
+		Value *string `json:"value"`
+	}
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return 0, fmt.Errorf("import JSON: %w", err)
+	}
+	values := make([]KeyValue, 0, len(rows))
+	for i, row := range rows {
+		if row.Key == nil || row.Value == nil {
+			return 0, fmt.Errorf("import JSON: row %d: expected \"key\" and \"value\"", i+1)
+		}
+		values = append(values, KeyValue{Key: *row.Key, Value: *row.Value})
+	}
+	return e.importRows(table, values, mode)
+}
+
+// ImportCSV imports rows into table from r, a CSV file with one key,value
+// record per line. A first record of exactly "key,value" is taken as a
+// header and skipped. It returns the number of keys written.
+func (e *Engine) ImportCSV(r io.Reader, table string, mode ImportMode) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("import CSV: %w", err)
+	}
+	if len(records) > 0 && records[0][0] == "key" && records[0][1] == "value" {
+		records = records[1:]
+	}
+	values := make([]KeyValue, 0, len(records))
+	for _, record := range records {
+		values = append(values, KeyValue{Key: record[0], Value: record[1]})
+	}
+	return e.importRows(table, values, mode)
+}
+
+// importRows writes values into table according to mode: keys not yet
+// visible are inserted, and visible ones are updated (ImportReplace) or
+// skipped (ImportMerge). The log records are written in one batch.
+func (e *Engine) importRows(table string, values []KeyValue, mode ImportMode) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Collapse repeated keys so each key is written at most once
+	index := make(map[string]int, len(values))
+	var unique []KeyValue
+	for _, kv := range e.normalizeKeys(table, values) {
+		if i, seen := index[kv.Key]; seen {
+			if mode == ImportReplace {
+				unique[i].Value = kv.Value
+			}
+			continue
+		}
+		index[kv.Key] = len(unique)
+		unique = append(unique, kv)
+	}
+
+	var inserts, updates []KeyValue
+	for _, kv := range unique {
+		if _, _, visible := e.getVisible(table, kv.Key); !visible {
+			inserts = append(inserts, kv)
+		} else if mode == ImportReplace {
+			updates = append(updates, kv)
+		}
+	}
+
+	var resp string
+	if err := e.batch(func() {
+		if len(inserts) > 0 {
+			if resp = e.executeData(&InsertStatement{Table: table, Values: inserts}); !e.wroteRows(resp) {
+				return
+			}
+		}
+		if len(updates) > 0 {
+			resp = e.executeData(&UpdateStatement{Table: table, Values: updates})
+		}
+	}); err != nil {
+		return 0, fmt.Errorf("import: WAL write failed: %w", err)
+	}
+	if resp != "" && !e.wroteRows(resp) {
+		return 0, errors.New(resp)
+	}
+	return len(inserts) + len(updates), nil
+}
+
+// wroteRows reports whether an INSERT or UPDATE response, in autocommit mode
+// or inside a transaction, means the rows were written.
+func (e *Engine) wroteRows(resp string) bool {
+	return e.isMessage(resp, MsgInserted, MsgUpdated, MsgBufferedWrite, MsgBufferedUpdate, MsgBufferedDelete)
+}
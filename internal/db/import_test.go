@@ -0,0 +1,75 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportMergeKeepsExistingValues(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, local), (b, local) INTO t`)
+
+	n, err := e.ImportJSON(strings.NewReader(`[{"key":"b","value":"file"},{"key":"c","value":"file"},{"key":"c","value":"later"}]`), "t", ImportMerge)
+	if err != nil {
+		t.Fatalf("ImportJSON: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 key written, got %d", n)
+	}
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: local\nb: local\nc: file" {
+		t.Errorf("Unexpected table after merge import: %q", resp)
+	}
+
+	n, err = e.ImportCSV(strings.NewReader("key,value\na,csv\nd,csv\n"), "t", ImportMerge)
+	if err != nil {
+		t.Fatalf("ImportCSV: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 key written, got %d", n)
+	}
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: local\nb: local\nc: file\nd: csv" {
+		t.Errorf("Unexpected table after CSV merge import: %q", resp)
+	}
+}
+
+func TestImportReplaceOverwrites(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, local), (b, local) INTO t`)
+
+	n, err := e.ImportCSV(strings.NewReader("b,file\nc,file\nc,\"later, quoted\"\n"), "t", ImportReplace)
+	if err != nil {
+		t.Fatalf("ImportCSV: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 keys written, got %d", n)
+	}
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: local\nb: file\nc: later, quoted" {
+		t.Errorf("Unexpected table after replace import: %q", resp)
+	}
+
+	// Imports survive a restart, and run inside an open transaction
+	e.Execute(`BEGIN`)
+	if _, err := e.ImportJSON(strings.NewReader(`[{"key":"a","value":"tx"}]`), "t", ImportReplace); err != nil {
+		t.Fatalf("ImportJSON in a transaction: %v", err)
+	}
+	e.Execute(`ROLLBACK`)
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: local\nb: file\nc: later, quoted" {
+		t.Errorf("Unexpected table after restart: %q", resp)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{StrictTables: true})
+	if _, err := e.ImportJSON(strings.NewReader(`[{"key":"a"}]`), "t", ImportMerge); err == nil {
+		t.Error("Expected an error for a row without a value")
+	}
+	if _, err := e.ImportCSV(strings.NewReader("a,b,c\n"), "t", ImportMerge); err == nil {
+		t.Error("Expected an error for a record with three fields")
+	}
+	if _, err := e.ImportCSV(strings.NewReader("a,1\n"), "t", ImportMerge); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected strict mode to reject the import, got %v", err)
+	}
+}
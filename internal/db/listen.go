@@ -0,0 +1,66 @@
+package db
+
+import "sync"
+
+// ChangeEvent tells a Listener that committed data in Table changed, by an
+// autocommit write, a COMMIT, a DROP or CREATE TABLE. It carries no rows:
+// the listener re-reads what it needs.
+type ChangeEvent struct {
+	Table string
+}
+
+// Listener receives a ChangeEvent on C after each committed change to the
+// table it listens on, like LISTEN/NOTIFY in Postgres. Events are coalesced:
+// C holds at most one pending event, so a slow listener sees that the table
+// changed, not how many times.
+type Listener struct {
+	C <-chan ChangeEvent
+
+	engine *Engine
+	table  string
+	ch     chan ChangeEvent
+	once   sync.Once
+}
+
+// Listen subscribes to committed changes of table. Close the listener when
+// done with it to unsubscribe.
+func (e *Engine) Listen(table string) *Listener {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch := make(chan ChangeEvent, 1)
+	l := &Listener{C: ch, engine: e, table: table, ch: ch}
+	if e.listeners == nil {
+		e.listeners = make(map[string]map[*Listener]struct{})
+	}
+	if e.listeners[table] == nil {
+		e.listeners[table] = make(map[*Listener]struct{})
+	}
+	e.listeners[table][l] = struct{}{}
+	return l
+}
+
+// Close unsubscribes the listener and closes C. It is safe to call more than
+// once.
+func (l *Listener) Close() {
+	l.once.Do(func() {
+		e := l.engine
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.listeners[l.table], l)
+		if len(e.listeners[l.table]) == 0 {
+			delete(e.listeners, l.table)
+		}
+		close(l.ch)
+	})
+}
+
+// notifyListeners sends a ChangeEvent to every listener on table without
+// blocking; a listener with an event already pending is skipped.
+func (e *Engine) notifyListeners(table string) {
+	for l := range e.listeners[table] {
+		select {
+		case l.ch <- ChangeEvent{Table: table}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListenReceivesCommittedChanges(t *testing.T) {
+	e := setupTestEngine(t)
+	l := e.Listen("t")
+	other := e.Listen("other")
+	defer other.Close()
+
+	expectEvent := func(what string) {
+		t.Helper()
+		select {
+		case ev := <-l.C:
+			if ev.Table != "t" {
+				t.Errorf("%s: expected an event for t, got %+v", what, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected a change event", what)
+		}
+	}
+	expectNone := func(what string) {
+		t.Helper()
+		select {
+		case ev := <-l.C:
+			t.Errorf("%s: expected no event, got %+v", what, ev)
+		default:
+		}
+	}
+
+	// A write committed by another goroutine is delivered to the listener
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Execute(`BEGIN`)
+		e.Execute(`INSERT (a, 1) INTO t`)
+		e.Execute(`COMMIT`)
+	}()
+	expectEvent("COMMIT")
+	<-done
+
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (b, 2) INTO t`)
+	expectNone("uncommitted write")
+	e.Execute(`ROLLBACK`)
+	expectNone("ROLLBACK")
+
+	e.Execute(`UPDATE t SET (a, 3)`)
+	expectEvent("autocommit UPDATE")
+	e.Execute(`INSERT (x, 1) INTO other`)
+	expectNone("write to another table")
+
+	// Events are coalesced while the listener is not reading
+	e.Execute(`INSERT (c, 3) INTO t`)
+	e.Execute(`DELETE c FROM t`)
+	expectEvent("coalesced writes")
+	expectNone("coalesced writes")
+
+	l.Close()
+	l.Close()
+	if _, open := <-l.C; open {
+		t.Error("Expected C to be closed")
+	}
+	e.Execute(`INSERT (d, 4) INTO t`) // must not send on the closed channel
+	if _, ok := e.listeners["t"]; ok {
+		t.Error("Expected the closed listener to be unregistered")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package db
+
+import "os"
+
+// lockFile is a no-op on platforms without flock; concurrent writers are not
+// detected there.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without flock.
+func unlockFile(f *os.File) error {
+	return nil
+}
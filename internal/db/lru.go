@@ -0,0 +1,90 @@
+package db
+
+import "container/list"
+
+// keyLRU records the order in which a table's keys were last written or
+// looked up, for evicting keys once the table holds more than MaxKeys.
+type keyLRU struct {
+	order *list.List // of keys, most recently used first
+	elems map[string]*list.Element
+}
+
+func newKeyLRU() *keyLRU {
+	return &keyLRU{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+// touch marks key as the most recently used.
+func (l *keyLRU) touch(key string) {
+	if elem, ok := l.elems[key]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.elems[key] = l.order.PushFront(key)
+}
+
+func (l *keyLRU) remove(key string) {
+	if elem, ok := l.elems[key]; ok {
+		l.order.Remove(elem)
+		delete(l.elems, key)
+	}
+}
+
+// oldest returns the least recently used key.
+func (l *keyLRU) oldest() (string, bool) {
+	elem := l.order.Back()
+	if elem == nil {
+		return "", false
+	}
+	return elem.Value.(string), true
+}
+
+// tracksKeys reports whether the keys of table are bounded by MaxKeys.
+// Reserved tables are never evicted from.
+func (e *Engine) tracksKeys(table string) bool {
+	return e.opts.MaxKeys > 0 && !isReservedTable(table)
+}
+
+// touchKey marks key in table as the most recently used.
+func (e *Engine) touchKey(table, key string) {
+	if !e.tracksKeys(table) {
+		return
+	}
+	l, ok := e.lru[table]
+	if !ok {
+		l = newKeyLRU()
+		e.lru[table] = l
+	}
+	l.touch(key)
+}
+
+// forgetKey stops tracking a deleted key.
+func (e *Engine) forgetKey(table, key string) {
+	if l, ok := e.lru[table]; ok {
+		l.remove(key)
+	}
+}
+
+// evictKeys deletes the least recently used keys of table until it holds
+// at most MaxKeys. Evictions are logged as ordinary DELETEs, so replay ends
+// up with the same keys. It returns how many keys were evicted.
+func (e *Engine) evictKeys(table string) int {
+	tree, ok := e.tables[table]
+	if !ok || !e.tracksKeys(table) {
+		return 0
+	}
+	evicted := 0
+	for tree.Count() > e.opts.MaxKeys {
+		key, ok := e.lru[table].oldest()
+		if !ok {
+			break
+		}
+		tree.Delete(key)
+		e.wal.Delete("", table, key)
+		e.forgetKey(table, key)
+		evicted++
+	}
+	if evicted > 0 {
+		e.tableChanged(table)
+	}
+	return evicted
+}
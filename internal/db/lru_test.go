@@ -0,0 +1,33 @@
+package db
+
+import "testing"
+
+func TestEngineMaxKeysEvictsLeastRecentlyUsed(t *testing.T) {
+	opts := EngineOptions{MaxKeys: 3, NewTxID: sequentialTxIDs()}
+	e := setupTestEngineWithOptions(t, opts)
+
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3) INTO t`)
+	e.Execute(`SELECT a FROM t`) // b is now the least recently used
+	if resp := e.Execute(`INSERT (d, 4) INTO t`); resp != "Inserted 1 key(s) into table 't'" {
+		t.Fatalf("Unexpected INSERT response: %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1\nc: 3\nd: 4" {
+		t.Errorf("Expected b to be evicted, got %q", resp)
+	}
+
+	e.Execute(`UPDATE t SET (c, 30)`) // a is now the least recently used
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (e, 5) INTO t`)
+	e.Execute(`COMMIT`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "c: 30\nd: 4\ne: 5" {
+		t.Errorf("Expected a to be evicted at COMMIT, got %q", resp)
+	}
+
+	// Evictions are logged, so the evicted keys stay gone after a restart
+	e.Close()
+	restarted := NewEngineWithOptions("test_wal.log", opts)
+	defer restarted.Close()
+	if resp := restarted.Execute(`SELECT * FROM t`); resp != "c: 30\nd: 4\ne: 5" {
+		t.Errorf("Expected the evictions to be replayed, got %q", resp)
+	}
+}
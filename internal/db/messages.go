@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MessageID names a response that reports how many keys a statement
+// affected. EngineOptions.Messages maps IDs to replacement templates.
+type MessageID string
+
+const (
+	MsgInserted       MessageID = "inserted"        // count, table
+	MsgNoneInserted   MessageID = "none_inserted"   // table
+	MsgUpdated        MessageID = "updated"         // count, table
+	MsgNoneUpdated    MessageID = "none_updated"    // table
+	MsgDeleted        MessageID = "deleted"         // count, table
+	MsgNoneDeleted    MessageID = "none_deleted"    // table
+	MsgAppended       MessageID = "appended"        // count, table
+	MsgBufferedWrite  MessageID = "buffered_write"  // count, table
+	MsgNoneBuffered   MessageID = "none_buffered"   // table
+	MsgBufferedUpdate MessageID = "buffered_update" // count, table
+	MsgBufferedDelete MessageID = "buffered_delete" // count, table
+)
+
+// defaultMessages are the English templates used for every ID that
+// EngineOptions.Messages does not override.
+var defaultMessages = map[MessageID]string{
+	MsgInserted:       "Inserted %d key(s) into table '%s'",
+	MsgNoneInserted:   "No new keys inserted (they might already exist)",
+	MsgUpdated:        "Updated %d key(s) in table '%s'",
+	MsgNoneUpdated:    "No keys found to update",
+	MsgDeleted:        "Deleted %d key(s) from table '%s'",
+	MsgNoneDeleted:    "No key(s) found to delete in table '%s'",
+	MsgAppended:       "Appended to %d key(s) in table '%s'",
+	MsgBufferedWrite:  "Buffered %d key(s) for insert/update into table '%s'",
+	MsgNoneBuffered:   "No new keys inserted or values updated (they might already exist with the same value)",
+	MsgBufferedUpdate: "Buffered %d key(s) for update in table '%s'",
+	MsgBufferedDelete: "Buffered %d key(s) for deletion from table '%s'",
+}
+
+// messageTemplate returns the template for id, preferring the engine's
+// configured one.
+func (e *Engine) messageTemplate(id MessageID) string {
+	if tmpl, ok := e.opts.Messages[id]; ok {
+		return tmpl
+	}
+	return defaultMessages[id]
+}
+
+// message renders the response id with args. A template without verbs, like
+// the defaults that leave out the table, is returned as is.
+func (e *Engine) message(id MessageID, args ...any) string {
+	tmpl := e.messageTemplate(id)
+	if !strings.Contains(tmpl, "%") {
+		return tmpl
+	}
+	return fmt.Sprintf(tmpl, args...)
+}
+
+// templateVerb matches the fmt verbs a message template may use, with an
+// optional explicit argument index.
+var templateVerb = regexp.MustCompile(`%(\[\d+\])?[ds]`)
+
+// isMessage reports whether resp was rendered from the template of one of
+// ids, so code can recognize a response however the templates are worded.
+func (e *Engine) isMessage(resp string, ids ...MessageID) bool {
+	for _, id := range ids {
+		tmpl := e.messageTemplate(id)
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		last := 0
+		for _, loc := range templateVerb.FindAllStringIndex(tmpl, -1) {
+			pattern.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+			if tmpl[loc[1]-1] == 'd' {
+				pattern.WriteString(`-?\d+`)
+			} else {
+				pattern.WriteString(`(?s:.*)`)
+			}
+			last = loc[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(tmpl[last:]) + "$")
+		if matched, _ := regexp.MatchString(pattern.String(), resp); matched {
+			return true
+		}
+	}
+	return false
+}
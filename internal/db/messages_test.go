@@ -0,0 +1,74 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultMessages(t *testing.T) {
+	e := &Engine{}
+	tests := []struct {
+		got      string
+		expected string
+	}{
+		{e.message(MsgInserted, 3, "t"), "Inserted 3 key(s) into table 't'"},
+		{e.message(MsgNoneInserted, "t"), "No new keys inserted (they might already exist)"},
+		{e.message(MsgUpdated, 2, "t"), "Updated 2 key(s) in table 't'"},
+		{e.message(MsgNoneUpdated, "t"), "No keys found to update"},
+		{e.message(MsgDeleted, 1, "t"), "Deleted 1 key(s) from table 't'"},
+		{e.message(MsgNoneDeleted, "t"), "No key(s) found to delete in table 't'"},
+		{e.message(MsgAppended, 2, "t"), "Appended to 2 key(s) in table 't'"},
+		{e.message(MsgBufferedWrite, 2, "t"), "Buffered 2 key(s) for insert/update into table 't'"},
+		{e.message(MsgNoneBuffered, "t"), "No new keys inserted or values updated (they might already exist with the same value)"},
+		{e.message(MsgBufferedUpdate, 2, "t"), "Buffered 2 key(s) for update in table 't'"},
+		{e.message(MsgBufferedDelete, 2, "t"), "Buffered 2 key(s) for deletion from table 't'"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("expected %q\ngot      %q", tt.expected, tt.got)
+		}
+	}
+	if len(tests) != len(defaultMessages) {
+		t.Errorf("Expected a case for each of the %d default messages, got %d", len(defaultMessages), len(tests))
+	}
+}
+
+func TestCustomMessages(t *testing.T) {
+	opts := EngineOptions{
+		NewTxID: sequentialTxIDs(),
+		Messages: map[MessageID]string{
+			MsgInserted:       "%[2]s: +%[1]d",
+			MsgNoneInserted:   "%s: +0",
+			MsgDeleted:        "%[2]s: -%[1]d",
+			MsgUpdated:        "%d Schlüssel in Tabelle '%s' aktualisiert",
+			MsgBufferedDelete: "%[2]s: -%[1]d (pending)",
+		},
+	}
+	e := setupTestEngineWithOptions(t, opts)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`INSERT (a, 1), (b, 2) INTO t`, "t: +2"},
+		{`INSERT (a, 1) INTO t`, "t: +0"},
+		{`UPDATE t SET (a, 10)`, "1 Schlüssel in Tabelle 't' aktualisiert"},
+		{`DELETE b FROM t`, "t: -1"},
+		{`UPDATE t SET (zzz, 1)`, "No keys found to update"}, // not overridden
+		{`MOVE a FROM t TO u`, "Moved 'a' from table 't' to table 'u'"},
+		{`BEGIN`, "Transaction started: tx_2"},
+		{`MOVE a FROM u TO t`, "Moved 'a' from table 'u' to table 't'"},
+		{`DELETE a FROM t`, "t: -1 (pending)"},
+		{`ROLLBACK`, "Transaction tx_2 rolled back."},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	// Imports recognize the custom responses as successful writes
+	if n, err := e.ImportCSV(strings.NewReader("c,3\n"), "t", ImportMerge); err != nil || n != 1 {
+		t.Errorf("Expected the import to write 1 row, got %d, %v", n, err)
+	}
+}
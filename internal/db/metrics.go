@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EngineStats is a snapshot of engine activity, for monitoring.
+type EngineStats struct {
+	Statements int64            // Execute calls, including those that failed to parse
+	Errors     int64            // Execute calls that failed
+	Ops        map[string]int64 // statements executed, by type ("SELECT", "INSERT", ...)
+	Tables     int              // tables, not counting reserved ones
+	WALBytes   int64            // size of the write-ahead log
+}
+
+// Stats returns a snapshot of the engine's activity since it was opened.
+func (e *Engine) Stats() EngineStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := EngineStats{
+		Statements: e.statements,
+		Errors:     e.failedStatements,
+		Ops:        maps.Clone(e.ops),
+	}
+	for name := range e.tables {
+		if !isReservedTable(name) {
+			stats.Tables++
+		}
+	}
+	if size, err := e.wal.Size(); err == nil {
+		stats.WALBytes = size
+	}
+	return stats
+}
+
+// String formats the snapshot as space-separated key=value pairs, with the
+// statement counts by type as ops_<type>=n in sorted order.
+func (s EngineStats) String() string {
+	fields := []string{
+		fmt.Sprintf("statements=%d", s.Statements),
+		fmt.Sprintf("errors=%d", s.Errors),
+		fmt.Sprintf("tables=%d", s.Tables),
+		fmt.Sprintf("wal_bytes=%d", s.WALBytes),
+	}
+	for _, op := range slices.Sorted(maps.Keys(s.Ops)) {
+		name := strings.ToLower(strings.ReplaceAll(op, " ", "_"))
+		fields = append(fields, fmt.Sprintf("ops_%s=%d", name, s.Ops[op]))
+	}
+	return strings.Join(fields, " ")
+}
+
+// countStatement records an Execute call for Stats. stmt is nil if cmd did
+// not parse. The caller holds e.mu.
+func (e *Engine) countStatement(stmt Statement, resp string) {
+	e.statements++
+	if stmt == nil || statementFailed(stmt, resp) {
+		e.failedStatements++
+	}
+	if stmt != nil {
+		if e.ops == nil {
+			e.ops = make(map[string]int64)
+		}
+		e.ops[stmt.StmtType()]++
+	}
+}
+
+// startMetrics writes a Stats line to w every interval until stopMetrics.
+func (e *Engine) startMetrics(w io.Writer, interval time.Duration) {
+	e.metricsStop = make(chan struct{})
+	e.metricsDone = make(chan struct{})
+	go func() {
+		defer close(e.metricsDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.metricsStop:
+				return
+			case <-ticker.C:
+				now := e.opts.Now().UTC().Format(time.RFC3339)
+				_, _ = fmt.Fprintf(w, "time=%s %s\n", now, e.Stats())
+			}
+		}
+	}()
+}
+
+// stopMetrics stops the metrics goroutine, if any, and waits for it to
+// exit. It must be called without holding e.mu, which the goroutine takes.
+func (e *Engine) stopMetrics() {
+	if e.metricsStop == nil {
+		return
+	}
+	e.metricsOnce.Do(func() { close(e.metricsStop) })
+	<-e.metricsDone
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEngineStats(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute("INSERT (a, 1), (b, 2) INTO t")
+	e.Execute("INSERT (c, 3) INTO u")
+	e.Execute("SELECT * FROM t")
+	e.Execute("SELEC * FROM t")
+	e.Execute("COMMIT")
+
+	stats := e.Stats()
+	if stats.Statements != 5 || stats.Errors != 2 || stats.Tables != 2 || stats.WALBytes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	expected := fmt.Sprintf("statements=5 errors=2 tables=2 wal_bytes=%d ops_commit=1 ops_insert=2 ops_select=1", stats.WALBytes)
+	if got := stats.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the metrics goroutine to write to
+// while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEngineMetricsWriter(t *testing.T) {
+	var out syncBuffer
+	e := setupTestEngineWithOptions(t, EngineOptions{MetricsWriter: &out, MetricsInterval: time.Millisecond})
+	e.Execute("INSERT (a, 1) INTO t")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "ops_insert=1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a metrics line, got %q", out.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	line := strings.SplitN(out.String(), "\n", 2)[0]
+	if !strings.HasPrefix(line, "time=") || !strings.Contains(line, " statements=") {
+		t.Errorf("Unexpected metrics line %q", line)
+	}
+
+	// Close waits for the goroutine to exit, so nothing is written after it
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-e.metricsDone:
+	default:
+		t.Fatal("Expected the metrics goroutine to have exited after Close")
+	}
+	written := out.String()
+	time.Sleep(10 * time.Millisecond)
+	if out.String() != written {
+		t.Error("Expected no metrics to be written after Close")
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// KeyNormalization controls how keys are normalized before they are stored
+// or looked up, so that keys typed differently can resolve to the same entry.
+type KeyNormalization int
+
+const (
+	// ExactKeys compares keys byte for byte.
+	ExactKeys KeyNormalization = iota
+	// NFCKeys normalizes keys to Unicode NFC, so canonically equal keys such
+	// as "café" with a precomposed é and with e plus a combining accent
+	// are the same key.
+	NFCKeys
+	// FoldedKeys also case-folds keys, so "Café" and "CAFÉ" are the same key.
+	FoldedKeys
+)
+
+// normalizeKey applies the NormalizeKeys option to a key of table. Keys of
+// internal tables are left as they are.
+func (e *Engine) normalizeKey(table, key string) string {
+	if isReservedTable(table) {
+		return key
+	}
+	switch e.opts.NormalizeKeys {
+	case NFCKeys:
+		return norm.NFC.String(key)
+	case FoldedKeys:
+		return norm.NFC.String(cases.Fold().String(key))
+	}
+	return key
+}
+
+// normalizeKeys returns values with normalizeKey applied to every key. It
+// copies values rather than change the caller's slice.
+func (e *Engine) normalizeKeys(table string, values []KeyValue) []KeyValue {
+	if e.opts.NormalizeKeys == ExactKeys {
+		return values
+	}
+	normalized := make([]KeyValue, len(values))
+	for i, kv := range values {
+		normalized[i] = KeyValue{Key: e.normalizeKey(table, kv.Key), Value: kv.Value}
+	}
+	return normalized
+}
+
+// normalizeStatement normalizes the keys a parsed statement writes or looks
+// up, and the key operands of its WHERE clause other than REGEXP patterns.
+func (e *Engine) normalizeStatement(stmt Statement) {
+	if e.opts.NormalizeKeys == ExactKeys {
+		return
+	}
+	switch s := stmt.(type) {
+	case *InsertStatement:
+		s.Values = e.normalizeKeys(s.Table, s.Values)
+		if s.Source != nil {
+			e.normalizeStatement(s.Source)
+		}
+	case *UpdateStatement:
+		s.Values = e.normalizeKeys(s.Table, s.Values)
+		if s.Expected != nil {
+			expected := make(map[string]string, len(s.Expected))
+			for key, value := range s.Expected {
+				expected[e.normalizeKey(s.Table, key)] = value
+			}
+			s.Expected = expected
+		}
+	case *AppendStatement:
+		s.Values = e.normalizeKeys(s.Table, s.Values)
+	case *IncrStatement:
+		s.Key = e.normalizeKey(s.Table, s.Key)
+	case *SwapStatement:
+		s.Key1 = e.normalizeKey(s.Table, s.Key1)
+		s.Key2 = e.normalizeKey(s.Table, s.Key2)
+	case *MoveStatement:
+		s.Key = e.normalizeKey(s.From, s.Key)
+	case *DeleteStatement:
+		for i, key := range s.Keys {
+			s.Keys[i] = e.normalizeKey(s.Table, key)
+		}
+	case *SelectStatement:
+		for i, key := range s.Keys {
+			s.Keys[i] = e.normalizeKey(s.Table, key)
+		}
+		if s.After != "" {
+			s.After = e.normalizeKey(s.Table, s.After)
+		}
+		if p := s.Where; p != nil && p.Field == "KEY" && p.Op != "REGEXP" && p.Op != "EXISTS IN" {
+			p.Operand = e.normalizeKey(s.Table, p.Operand)
+			if p.High != "" {
+				p.High = e.normalizeKey(s.Table, p.High)
+			}
+		}
+	case *VersionsStatement:
+		s.Key = e.normalizeKey(s.Table, s.Key)
+	case *ExplainKeyStatement:
+		s.Key = e.normalizeKey(s.Table, s.Key)
+	case *ExplainStatement:
+		e.normalizeStatement(s.Query)
+	case *DeclareCursorStatement:
+		e.normalizeStatement(s.Query)
+	}
+}
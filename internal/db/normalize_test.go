@@ -0,0 +1,63 @@
+package db
+
+import "testing"
+
+// Canonically equal spellings of "café": precomposed é, and e followed by a
+// combining acute accent.
+const (
+	cafeComposed   = "caf\u00e9"
+	cafeDecomposed = "cafe\u0301"
+)
+
+func TestNormalizeKeys(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NormalizeKeys: NFCKeys})
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`INSERT (` + cafeComposed + `, 1) INTO t`, "Inserted 1 key(s) into table 't'"},
+		{`INSERT (` + cafeDecomposed + `, 2) INTO t`, "No new keys inserted (they might already exist)"},
+		{`SELECT ` + cafeDecomposed + ` FROM t`, cafeComposed + ": 1"},
+		{`UPDATE t SET (` + cafeDecomposed + `, 3)`, "Updated 1 key(s) in table 't'"},
+		{`SELECT * FROM t WHERE key = '` + cafeDecomposed + `'`, cafeComposed + ": 3"},
+		{`SELECT Caf` + "\u00e9" + ` FROM t`, "No results"}, // NFC does not fold case
+		{`DELETE ` + cafeDecomposed + ` FROM t`, "Deleted 1 key(s) from table 't'"},
+		{`SELECT * FROM t`, "No results"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+
+	if err := e.SetBytes("t", cafeDecomposed, []byte("4")); err != nil {
+		t.Fatalf("SetBytes: %v", err)
+	}
+	if value, ok := e.GetBytes("t", cafeComposed); !ok || string(value) != "4" {
+		t.Errorf("GetBytes(%q) = %q, %v; expected \"4\", true", cafeComposed, value, ok)
+	}
+}
+
+func TestFoldedKeys(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NormalizeKeys: FoldedKeys})
+	e.Execute(`INSERT (CAF` + "\u00c9" + `, 1) INTO t`)
+
+	cmd := `SELECT ` + cafeDecomposed + ` FROM t`
+	if resp, expected := e.Execute(cmd), cafeComposed+": 1"; resp != expected {
+		t.Errorf("%s:\nexpected %q\ngot      %q", cmd, expected, resp)
+	}
+}
+
+func TestNormalizeKeysOnReplay(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (` + cafeDecomposed + `, 1) INTO t`)
+	e.Close()
+
+	e = NewEngineWithOptions("test_wal.log", EngineOptions{NormalizeKeys: NFCKeys})
+	defer e.Close()
+	cmd := `SELECT * FROM t`
+	if resp, expected := e.Execute(cmd), cafeComposed+": 1"; resp != expected {
+		t.Errorf("%s:\nexpected %q\ngot      %q", cmd, expected, resp)
+	}
+}
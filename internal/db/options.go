@@ -0,0 +1,177 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// EngineOptions configures optional Engine behavior. The zero value matches
+// NewEngine's defaults.
+type EngineOptions struct {
+	// IdleTxTimeout rolls back a transaction that has received no statements
+	// for longer than this duration. Zero disables the timeout.
+	IdleTxTimeout time.Duration
+
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced in tests to control the clock.
+	Now func() time.Time
+
+	// NewTxID returns the ID of a new transaction. It defaults to "tx_"
+	// followed by the current time in nanoseconds, and can be replaced in
+	// tests with a deterministic sequence. IDs must be unique within a log.
+	NewTxID func() string
+
+	// OnDuplicate decides what INSERT does with a key that already exists.
+	// The zero value, SkipDuplicates, keeps the existing value.
+	OnDuplicate DuplicatePolicy
+
+	// CompactOnClose runs a Checkpoint when the engine is closed, so the next
+	// startup replays a minimal log. Close skips it while a transaction is open.
+	CompactOnClose bool
+
+	// PreallocTables sizes the engine's table map for this many tables up
+	// front, for workloads whose scale is known in advance.
+	PreallocTables int
+
+	// PoolNodes gives every table a B+ tree node free list (see
+	// NewBPlusTreeWithNodePool), reducing GC pressure from node churn in
+	// insert/delete-heavy workloads.
+	PoolNodes bool
+
+	// AppendSplits gives every table's B+ tree append-style splits (see
+	// NewBPlusTreeWithAppendSplits), which keeps leaves full when keys are
+	// mostly inserted in increasing order, as in bulk loads.
+	AppendSplits bool
+
+	// BusyTimeout is how long opening the log waits for another process to
+	// release its lock before failing with ErrDatabaseLocked, like SQLite's
+	// busy_timeout. Zero fails immediately.
+	BusyTimeout time.Duration
+
+	// Codecs maps a table name to the Codec its values are stored with, for
+	// example a GzipCodec for tables holding large values. Values are encoded
+	// in both the tree and the log, so a table's codec must not change between
+	// runs over the same log.
+	Codecs map[string]Codec
+
+	// ReplayProgress, if set, is called while the log is replayed on startup
+	// with the bytes read so far and the log's size, so a CLI can show
+	// progress for large logs. See WAL.ReplayWithProgress.
+	ReplayProgress func(bytesRead, totalBytes int64)
+
+	// StrictTables makes INSERT and UPDATE fail on a table that does not
+	// exist instead of creating it, so tables must be made with CREATE TABLE.
+	StrictTables bool
+
+	// QueryCacheSize caches the results of up to this many distinct SELECT
+	// statements, for workloads that repeat the same queries. An entry is
+	// dropped whenever a table or view it reads from is written. SELECTs in
+	// a transaction bypass the cache. Zero disables it.
+	QueryCacheSize int
+
+	// KeyValidator, if set, is called for every key written by INSERT or
+	// UPDATE; a non-nil error rejects the whole statement with that error.
+	// IdentifierKey is a ready-made validator. The default accepts any key.
+	KeyValidator func(key string) error
+
+	// AuditWriter, if set, receives one JSON line per Execute call with the
+	// time, the statement text, whether it succeeded and its response. It
+	// is meant for auditing, not recovery: statements that fail to parse or
+	// are rejected are recorded too.
+	AuditWriter io.Writer
+
+	// AuditRedactValues keeps values out of the audit log: values in the
+	// statement text are replaced by "?" and responses are not recorded.
+	AuditRedactValues bool
+
+	// MaxKeys bounds every table to this many keys, turning the engine into
+	// a cache: once a write leaves a table with more keys, the least recently
+	// used ones are deleted, and the deletions are logged. Keys count as used
+	// when written or looked up by key in a SELECT; scans do not count. The
+	// order is not persisted, so after a restart keys start out in key order.
+	// Zero disables the bound.
+	MaxKeys int
+
+	// MetricsWriter, if set together with MetricsInterval, receives a line of
+	// key=value pairs with the engine's Stats every MetricsInterval, from a
+	// goroutine that Close stops.
+	MetricsWriter   io.Writer
+	MetricsInterval time.Duration
+
+	// Messages replaces the templates of the responses that report how many
+	// keys a statement affected, such as "Inserted %d key(s) into table '%s'",
+	// for a translated UI or a terser format. Templates are fmt format
+	// strings using only %d and %s, with the same arguments as the defaults
+	// (see the MessageID constants); explicit indexes such as %[2]s reorder
+	// or skip them. IDs without an entry keep the default text.
+	Messages map[MessageID]string
+
+	// BlockOnReplicaError makes a write to a replica (see Engine.AddReplica)
+	// that fails be retried until it succeeds, stalling every statement
+	// meanwhile, instead of dropping the replica.
+	BlockOnReplicaError bool
+
+	// OnReplicaError, if set, is called with a replica and the error of the
+	// write that failed when the replica is dropped.
+	OnReplicaError func(replica io.Writer, err error)
+
+	// NormalizeKeys normalizes keys before they are stored or looked up, so
+	// internationalized keys typed two different ways resolve to the same
+	// entry. Keys read from the log are normalized too, so the option can be
+	// turned on for an existing log; keys that then collide keep the value
+	// of the last one in key order. The zero value, ExactKeys, compares keys
+	// byte for byte.
+	NormalizeKeys KeyNormalization
+
+	// CompactCommits logs a committed transaction as plain autocommit
+	// records between a BEGIN_BATCH and an END_BATCH record, written
+	// together at COMMIT, instead of records tagged with its ID between
+	// BEGIN_TX and COMMIT_TX. The log is smaller, and BEGIN and ROLLBACK log
+	// nothing. Replay applies a batch only once its END_BATCH is read, so a
+	// crash during COMMIT still loses the whole transaction; on startup such
+	// a batch is closed with an ABORT_BATCH record. PREPARE TRANSACTION
+	// still logs the transactional records, which COMMIT PREPARED needs.
+	CompactCommits bool
+
+	// PerTableLogs treats the log path as a directory and logs every table
+	// to a file of its own in it, with a separate commit log deciding which
+	// transactions committed (see OpenPerTableWAL). Replay then loads the
+	// tables in parallel, and dropping or checkpointing a table rewrites
+	// only its file. PREPARE TRANSACTION, AS OF SEQUENCE, SELECT VERSIONS
+	// OF, VACUUM and sealing the log are not supported, and it cannot be
+	// combined with CompactCommits.
+	PerTableLogs bool
+}
+
+// IdentifierKey is a KeyValidator that accepts only identifier-like keys:
+// a non-empty run of ASCII letters, digits and underscores that does not
+// start with a digit.
+func IdentifierKey(key string) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return fmt.Errorf("key %q is not an identifier: unexpected %q", key, r)
+		}
+	}
+	return nil
+}
+
+// DuplicatePolicy controls how INSERT treats keys that already exist in the
+// target table, both in autocommit mode and inside a transaction.
+type DuplicatePolicy int
+
+const (
+	// SkipDuplicates leaves existing keys untouched and inserts the rest.
+	SkipDuplicates DuplicatePolicy = iota
+	// ErrorOnDuplicate rejects the whole INSERT if any key already exists.
+	ErrorOnDuplicate
+	// OverwriteDuplicates replaces the value of existing keys.
+	OverwriteDuplicates
+)
@@ -3,23 +3,129 @@ package db
 import (
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 var pairRegex = regexp.MustCompile(`\(\s*([^)]+?)\s*,\s*([^)]+?)\s*\)`)
 
+// Backslash escapes let keys and values contain literal parentheses and commas.
+// They are swapped for private-use runes before tokenizing, so the tokenizer and
+// pairRegex never see them as delimiters, and restored by unescape.
+var (
+	escapeReplacer   = strings.NewReplacer(`\\`, "\uE003", `\(`, "\uE000", `\)`, "\uE001", `\,`, "\uE002")
+	unescapeReplacer = strings.NewReplacer("\uE003", `\`, "\uE000", "(", "\uE001", ")", "\uE002", ",")
+	reescapeReplacer = strings.NewReplacer("\uE003", `\\`, "\uE000", `\(`, "\uE001", `\)`, "\uE002", `\,`)
+
+	// escapeDelimiters hides the delimiters inside a quoted span the same way.
+	escapeDelimiters = strings.NewReplacer("(", "\uE000", ")", "\uE001", ",", "\uE002")
+)
+
+// errUnsupportedStatement is returned by Parse for a statement whose leading
+// keyword is not a built-in one.
+var errUnsupportedStatement = errors.New("unsupported statement")
+
 func Parse(input string) (Statement, error) {
-	tokens := tokenize(input)
+	tokens := statementTokens(input)
 
 	if len(tokens) == 0 {
 		return nil, errors.New("empty input")
 	}
 
+	stmt, err := parseTokens(tokens)
+	if errors.Is(err, errUnsupportedStatement) {
+		return nil, err
+	}
+	// A missing quote or parenthesis makes the statement parsers fail in
+	// confusing ways, or even succeed, so it is reported first
+	if quoteErr := checkQuotes(input); quoteErr != nil {
+		return nil, quoteErr
+	}
+	if parenErr := checkParens(input); parenErr != nil {
+		return nil, parenErr
+	}
+	return stmt, err
+}
+
+// checkQuotes reports the first quote that opens a quoted span, by starting
+// a token, but is never closed, with its 1-based position. A quote inside a
+// word is an ordinary character, as in the tokenizer.
+func checkQuotes(input string) error {
+	runes := []rune(input)
+	tokenStart := true
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`\(),`, runes[i+1]):
+			i++ // An escaped character
+			tokenStart = false
+		case isQuote(r) && tokenStart:
+			end := closingQuote(runes, i)
+			if end < 0 {
+				return fmt.Errorf("unterminated quote: %c at position %d is never closed", r, i+1)
+			}
+			i = end
+		case r == '(' || r == ')' || r == ',' || unicode.IsSpace(r):
+			tokenStart = true
+		default:
+			tokenStart = false
+		}
+	}
+	return nil
+}
+
+// checkParens reports the first parenthesis in input without a partner,
+// with its 1-based position. Parentheses escaped with a backslash or inside
+// a quoted span are skipped, as the tokenizer does.
+func checkParens(input string) error {
+	runes := []rune(input)
+	var open []int // positions of the parentheses not closed yet
+	tokenStart := true
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`\(),`, runes[i+1]):
+			i++ // An escaped character
+			tokenStart = false
+		case isQuote(r) && tokenStart:
+			if end := closingQuote(runes, i); end >= 0 {
+				i = end
+			} else {
+				tokenStart = false
+			}
+		case r == '(':
+			open = append(open, i)
+			tokenStart = true
+		case r == ')':
+			if len(open) == 0 {
+				return fmt.Errorf("unbalanced parentheses: ')' at position %d has no matching '('", i+1)
+			}
+			open = open[:len(open)-1]
+			tokenStart = true
+		case r == ',' || unicode.IsSpace(r):
+			tokenStart = true
+		default:
+			tokenStart = false
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unbalanced parentheses: '(' at position %d is never closed", open[len(open)-1]+1)
+	}
+	return nil
+}
+
+// parseTokens parses a tokenized statement by its leading keyword.
+func parseTokens(tokens []string) (Statement, error) {
 	switch strings.ToUpper(tokens[0]) {
 	case "INSERT":
 		return parseInsert(tokens)
 	case "SELECT":
+		if len(tokens) >= 3 && strings.ToUpper(tokens[1]) == "VERSIONS" && strings.ToUpper(tokens[2]) == "OF" {
+			return parseVersions(tokens)
+		}
 		return parseSelect(tokens)
 	case "DELETE":
 		return parseDelete(tokens)
@@ -33,21 +139,152 @@ func Parse(input string) (Statement, error) {
 		return parseCommit(tokens)
 	case "ROLLBACK":
 		return parseRollback(tokens)
+	case "PREPARE":
+		if len(tokens) != 3 || strings.ToUpper(tokens[1]) != "TRANSACTION" || unquote(tokens[2]) == "" {
+			return nil, errors.New("invalid PREPARE syntax: expected 'PREPARE TRANSACTION <gid>'")
+		}
+		return &PrepareStatement{GID: unquote(tokens[2])}, nil
 	case "SHOW":
 		return parseShow(tokens)
+	case "NEXTVAL":
+		return parseNextVal(tokens)
+	case "SYNC":
+		return parseSync(tokens)
+	case "ANALYZE":
+		return parseAnalyze(tokens)
+	case "CREATE":
+		return parseCreate(tokens)
+	case "EXPLAIN":
+		return parseExplain(tokens)
+	case "GENERATE":
+		return parseGenerate(tokens)
+	case "RUN":
+		return parseRun(tokens)
+	case "REORGANIZE":
+		return parseReorganize(tokens)
+	case "VACUUM":
+		if len(tokens) != 1 {
+			return nil, errors.New("invalid VACUUM syntax: expected 'VACUUM'")
+		}
+		return &VacuumStatement{}, nil
+	case "APPEND":
+		return parseAppend(tokens)
+	case "INCR", "DECR":
+		return parseIncr(tokens)
+	case "SWAP":
+		return parseSwap(tokens)
+	case "MOVE":
+		return parseMove(tokens)
+	case "DECLARE":
+		return parseDeclare(tokens)
+	case "FETCH":
+		return parseFetch(tokens)
+	case "CLOSE":
+		return parseClose(tokens)
 	default:
-		return nil, fmt.Errorf("unsupported statement: %s", tokens[0])
+		return nil, fmt.Errorf("%w: %s", errUnsupportedStatement, tokens[0])
 	}
 }
 
+// statementTokens tokenizes a single statement for parsing.
+func statementTokens(input string) []string {
+	// Allow a single trailing semicolon, as in "SELECT * FROM t;"
+	input = strings.TrimSpace(input)
+	input = strings.TrimSpace(strings.TrimSuffix(input, ";"))
+	return tokenize(escapeReplacer.Replace(input))
+}
+
+// unescape restores the characters hidden by escapeReplacer.
+func unescape(s string) string {
+	return unescapeReplacer.Replace(s)
+}
+
+// tokenize splits input on whitespace, with "(", ")" and "," as tokens of
+// their own. A span quoted with single or double quotes or backticks that
+// starts a token is kept as a single token, quotes included, even if it
+// contains spaces or delimiters, so a quoted keyword such as "FROM" is never
+// mistaken for one and a literal such as ' more' keeps its spaces.
 func tokenize(input string) []string {
-	input = strings.ReplaceAll(input, "(", " ( ")
-	input = strings.ReplaceAll(input, ")", " ) ")
-	input = strings.ReplaceAll(input, ",", " , ")
-	return strings.Fields(input)
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case isQuote(r) && current.Len() == 0:
+			end := closingQuote(runes, i)
+			if end < 0 {
+				current.WriteRune(r) // Not a quoted span, just a quote character
+				continue
+			}
+			// Hide delimiters inside the quotes like backslash escapes do
+			tokens = append(tokens, string(r)+escapeDelimiters.Replace(string(runes[i+1:end]))+string(r))
+			i = end
+		case r == '(' || r == ')' || r == ',':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isQuote reports whether r opens a quoted span: a literal in single quotes
+// or an identifier in double quotes or backticks.
+func isQuote(r rune) bool {
+	return r == '\'' || r == '"' || r == '`'
+}
+
+// closingQuote returns the index of the quote closing the span opened at
+// runes[open]: the next matching quote that ends a token. It returns -1 if
+// there is none.
+func closingQuote(runes []rune, open int) int {
+	for j := open + 1; j < len(runes); j++ {
+		if runes[j] != runes[open] {
+			continue
+		}
+		if j+1 == len(runes) || unicode.IsSpace(runes[j+1]) || strings.ContainsRune("(),;", runes[j+1]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// identifier returns the table, view, sequence or key name written as tok.
+// Names may be quoted with double quotes or backticks, which lets them be
+// keywords or contain spaces and delimiters: SELECT * FROM "FROM".
+func identifier(tok string) string {
+	if len(tok) >= 2 && (tok[0] == '"' || tok[0] == '`') && tok[len(tok)-1] == tok[0] {
+		tok = tok[1 : len(tok)-1]
+	}
+	return unescape(tok)
+}
+
+// keyLiteral returns the key written as tok. A key may be single-quoted like
+// a value, or double- or backtick-quoted like an identifier.
+func keyLiteral(tok string) string {
+	if len(tok) >= 2 && tok[0] == '\'' && tok[len(tok)-1] == '\'' {
+		return unquote(tok)
+	}
+	return identifier(tok)
 }
 
 func parseInsert(tokens []string) (Statement, error) {
+	// Alternative format: INSERT INTO tablename SELECT ...
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "INTO" {
+		return parseInsertSelect(tokens)
+	}
+
 	// Expected format: INSERT (key1, value1), (key2, value2) INTO tablename
 	// Minimum tokens: INSERT (k, v) INTO t (8 tokens)
 	if len(tokens) < 8 {
@@ -78,7 +315,7 @@ func parseInsert(tokens []string) (Statement, error) {
 	if intoIndex+1 >= len(tokens) {
 		return nil, errors.New("invalid INSERT syntax: expected table name after INTO")
 	}
-	table := tokens[intoIndex+1]
+	table := identifier(tokens[intoIndex+1])
 
 	// Check for any unexpected tokens after the table name
 	if intoIndex+2 < len(tokens) {
@@ -99,8 +336,8 @@ func parseInsert(tokens []string) (Statement, error) {
 		if len(match) != 3 { // Full match, capture group 1 (key), capture group 2 (value)
 			return nil, errors.New("invalid match format for key-value pairs")
 		}
-		key := strings.TrimSpace(match[1])
-		value := strings.TrimSpace(match[2])
+		key := keyLiteral(strings.TrimSpace(match[1]))
+		value := unquote(strings.TrimSpace(match[2])) // Quote a value to keep spaces and delimiters in it
 		values = append(values, KeyValue{Key: key, Value: value})
 	}
 
@@ -110,6 +347,95 @@ func parseInsert(tokens []string) (Statement, error) {
 	}, nil
 }
 
+func parseAppend(tokens []string) (Statement, error) {
+	// Expected format: APPEND (key1, suffix1), (key2, suffix2) IN tablename
+	if len(tokens) < 8 || strings.ToUpper(tokens[len(tokens)-2]) != "IN" {
+		return nil, errors.New("invalid APPEND syntax: expected 'APPEND (<key>, <suffix>), ... IN <table_name>'")
+	}
+	matches := pairRegex.FindAllStringSubmatch(strings.Join(tokens[1:len(tokens)-2], ""), -1)
+	if len(matches) == 0 {
+		return nil, errors.New("invalid APPEND syntax: no valid (key, suffix) pairs found")
+	}
+	values := make([]KeyValue, 0, len(matches))
+	for _, match := range matches {
+		// The suffix is a literal: quote it to keep leading or trailing spaces
+		values = append(values, KeyValue{Key: keyLiteral(match[1]), Value: unquote(match[2])})
+	}
+	return &AppendStatement{Table: identifier(tokens[len(tokens)-1]), Values: values}, nil
+}
+
+func parseIncr(tokens []string) (Statement, error) {
+	// Expected format: INCR|DECR key IN tablename [BY n]
+	op := strings.ToUpper(tokens[0])
+	if (len(tokens) != 4 && len(tokens) != 6) || strings.ToUpper(tokens[2]) != "IN" ||
+		(len(tokens) == 6 && strings.ToUpper(tokens[4]) != "BY") {
+		return nil, fmt.Errorf("invalid %s syntax: expected '%s <key> IN <table_name> [BY <n>]'", op, op)
+	}
+	by := int64(1)
+	if len(tokens) == 6 {
+		n, err := strconv.ParseInt(tokens[5], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s syntax: BY must be a positive integer, got %q", op, tokens[5])
+		}
+		by = n
+	}
+	if op == "DECR" {
+		by = -by
+	}
+	return &IncrStatement{Key: identifier(tokens[1]), Table: identifier(tokens[3]), By: by, Decr: op == "DECR"}, nil
+}
+
+func parseSwap(tokens []string) (Statement, error) {
+	// Expected format: SWAP key1 key2 IN tablename
+	if len(tokens) != 5 || strings.ToUpper(tokens[3]) != "IN" {
+		return nil, fmt.Errorf("invalid SWAP syntax: expected 'SWAP <key1> <key2> IN <table_name>'")
+	}
+	return &SwapStatement{Key1: identifier(tokens[1]), Key2: identifier(tokens[2]), Table: identifier(tokens[4])}, nil
+}
+
+func parseVersions(tokens []string) (Statement, error) {
+	// Expected format: SELECT VERSIONS OF '<key>' IN <table>
+	if len(tokens) != 6 || strings.ToUpper(tokens[4]) != "IN" {
+		return nil, errors.New("invalid SELECT VERSIONS syntax: expected 'SELECT VERSIONS OF '<key>' IN <table_name>'")
+	}
+	return &VersionsStatement{Key: unquote(tokens[3]), Table: identifier(tokens[5])}, nil
+}
+
+func parseMove(tokens []string) (Statement, error) {
+	// Expected format: MOVE key FROM table TO table [OVERWRITE]
+	if (len(tokens) != 6 && len(tokens) != 7) || strings.ToUpper(tokens[2]) != "FROM" || strings.ToUpper(tokens[4]) != "TO" ||
+		(len(tokens) == 7 && strings.ToUpper(tokens[6]) != "OVERWRITE") {
+		return nil, fmt.Errorf("invalid MOVE syntax: expected 'MOVE <key> FROM <table_name> TO <table_name> [OVERWRITE]'")
+	}
+	return &MoveStatement{
+		Key:       identifier(tokens[1]),
+		From:      identifier(tokens[3]),
+		To:        identifier(tokens[5]),
+		Overwrite: len(tokens) == 7,
+	}, nil
+}
+
+func parseInsertSelect(tokens []string) (Statement, error) {
+	// Expected format: INSERT INTO tablename SELECT <keys> FROM source [WHERE ...]
+	if len(tokens) < 4 || strings.ToUpper(tokens[3]) != "SELECT" {
+		return nil, errors.New("invalid INSERT syntax: expected INSERT INTO <table_name> SELECT ...")
+	}
+	source, err := parseSelect(tokens[3:])
+	if err != nil {
+		return nil, err
+	}
+	if q := source.(*SelectStatement); q.PrefixSep != "" || q.Aggregate != "" {
+		return nil, errors.New("invalid INSERT syntax: cannot insert the result of an aggregate")
+	}
+	return &InsertStatement{
+		Table:  identifier(tokens[2]),
+		Source: source.(*SelectStatement),
+	}, nil
+}
+
+// valueFuncs are the scalar functions SELECT can apply to values.
+var valueFuncs = map[string]bool{"UPPER": true, "LOWER": true, "LENGTH": true, "TRIM": true}
+
 func parseSelect(tokens []string) (Statement, error) {
 	fromIndex := -1
 	for i := 0; i < len(tokens); i++ {
@@ -132,45 +458,348 @@ func parseSelect(tokens []string) (Statement, error) {
 	if fromIndex+1 >= len(tokens) {
 		return nil, errors.New("expected table name after FROM")
 	}
-	table := tokens[fromIndex+1]
+	table := identifier(tokens[fromIndex+1])
 	// No need for `if table == ""` check here because `strings.Fields` ensures non-empty tokens.
 
-	// Check if there are any unexpected tokens after the table name
-	if fromIndex+2 < len(tokens) {
-		return nil, errors.New("unexpected token after table name. SELECT statement does not support WHERE clause anymore")
+	// Optional clauses after the table name
+	format := ""
+	var where *Predicate
+	after := ""
+	limit, every, asOf := 0, 0, 0
+	for i := fromIndex + 2; i < len(tokens); {
+		switch strings.ToUpper(tokens[i]) {
+		case "WHERE":
+			if where != nil {
+				return nil, errors.New("invalid SELECT syntax: only one WHERE clause is allowed")
+			}
+			pred, consumed, err := parsePredicate(tokens[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			where = pred
+			i += 1 + consumed
+		case "FORMAT":
+			if i+1 >= len(tokens) || strings.ToUpper(tokens[i+1]) != "JSON" {
+				return nil, errors.New("invalid SELECT syntax: expected FORMAT JSON")
+			}
+			format = "JSON"
+			i += 2
+		case "AFTER":
+			if i+1 >= len(tokens) {
+				return nil, errors.New("invalid SELECT syntax: expected AFTER '<key>'")
+			}
+			after = unquote(tokens[i+1])
+			i += 2
+		case "LIMIT":
+			if i+1 >= len(tokens) {
+				return nil, errors.New("invalid SELECT syntax: expected LIMIT <count>")
+			}
+			n, err := strconv.Atoi(tokens[i+1])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid SELECT syntax: LIMIT must be a positive integer, got %q", tokens[i+1])
+			}
+			limit = n
+			i += 2
+		case "EVERY":
+			if i+1 >= len(tokens) {
+				return nil, errors.New("invalid SELECT syntax: expected EVERY <n>")
+			}
+			n, err := strconv.Atoi(tokens[i+1])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid SELECT syntax: EVERY must be a positive integer, got %q", tokens[i+1])
+			}
+			every = n
+			i += 2
+		case "AS":
+			if i+3 >= len(tokens) || strings.ToUpper(tokens[i+1]) != "OF" || strings.ToUpper(tokens[i+2]) != "SEQUENCE" {
+				return nil, errors.New("invalid SELECT syntax: expected AS OF SEQUENCE <n>")
+			}
+			n, err := strconv.Atoi(tokens[i+3])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid SELECT syntax: AS OF SEQUENCE must be a positive integer, got %q", tokens[i+3])
+			}
+			asOf = n
+			i += 4
+		default:
+			return nil, fmt.Errorf("invalid SELECT syntax: unexpected token %q after table name", tokens[i])
+		}
 	}
 
 	var keys []string
+	var prefixSep string
 	// The tokens between "SELECT" (tokens[0]) and "FROM" (tokens[fromIndex]) are the selected columns
 	columnTokens := tokens[1:fromIndex]
 
-	if len(columnTokens) == 1 && columnTokens[0] == "*" {
+	// SELECT DISTINCT ON value <keys_or_*> FROM ...
+	distinctOnValue := false
+	if len(columnTokens) > 1 && strings.ToUpper(columnTokens[0]) == "DISTINCT" && columnTokens[1] != "," {
+		if len(columnTokens) < 4 || strings.ToUpper(columnTokens[1]) != "ON" || strings.ToUpper(columnTokens[2]) != "VALUE" {
+			return nil, errors.New("invalid SELECT syntax: expected DISTINCT ON value <keys_or_*>")
+		}
+		distinctOnValue = true
+		columnTokens = columnTokens[3:]
+	}
+
+	// SELECT COUNT(*) | SUM(value) | AVG(value) FROM ...: one number over the matched rows
+	aggregate := ""
+	if len(columnTokens) == 4 && columnTokens[1] == "(" && columnTokens[3] == ")" {
+		fn, arg := strings.ToUpper(columnTokens[0]), strings.ToUpper(columnTokens[2])
+		if (fn == "COUNT" && arg == "*") || ((fn == "SUM" || fn == "AVG") && arg == "VALUE") {
+			if distinctOnValue || after != "" || limit > 0 {
+				return nil, fmt.Errorf("invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with %s", fn)
+			}
+			aggregate = fn
+			columnTokens = []string{"*"}
+		}
+	}
+
+	// SELECT LCP FROM ...: the longest common prefix of the matched keys. A
+	// key named "lcp" can still be selected by quoting it.
+	if len(columnTokens) == 1 && strings.ToUpper(columnTokens[0]) == "LCP" {
+		if distinctOnValue || after != "" || limit > 0 {
+			return nil, errors.New("invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with LCP")
+		}
+		aggregate = "LCP"
+		columnTokens = []string{"*"}
+	}
+
+	// SELECT BUCKETS n FROM ...: the matched keys split into n key ranges of
+	// about equal counts
+	buckets := 0
+	if len(columnTokens) == 2 && strings.ToUpper(columnTokens[0]) == "BUCKETS" {
+		n, err := strconv.Atoi(columnTokens[1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SELECT syntax: BUCKETS must be a positive integer, got %q", columnTokens[1])
+		}
+		if distinctOnValue || after != "" || limit > 0 {
+			return nil, errors.New("invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with BUCKETS")
+		}
+		aggregate, buckets = "BUCKETS", n
+		columnTokens = []string{"*"}
+	}
+
+	// SELECT TOP n BY value [ASC|DESC] FROM ...: the n rows with the largest or smallest values
+	top, topAsc := 0, false
+	if len(columnTokens) > 1 && strings.ToUpper(columnTokens[0]) == "TOP" && columnTokens[1] != "," {
+		if len(columnTokens) < 4 || len(columnTokens) > 5 || strings.ToUpper(columnTokens[2]) != "BY" ||
+			strings.ToUpper(columnTokens[3]) != "VALUE" {
+			return nil, errors.New("invalid SELECT syntax: expected TOP <n> BY value [ASC|DESC]")
+		}
+		n, err := strconv.Atoi(columnTokens[1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SELECT syntax: TOP must be a positive integer, got %q", columnTokens[1])
+		}
+		if len(columnTokens) == 5 {
+			switch strings.ToUpper(columnTokens[4]) {
+			case "ASC":
+				topAsc = true
+			case "DESC":
+			default:
+				return nil, fmt.Errorf("invalid SELECT syntax: expected ASC or DESC after TOP, got %q", columnTokens[4])
+			}
+		}
+		if limit > 0 {
+			return nil, errors.New("invalid SELECT syntax: TOP cannot be combined with LIMIT")
+		}
+		top = n
+		columnTokens = []string{"*"}
+	}
+
+	// SELECT <func>(value) FROM ...: all keys, with the function applied to their values
+	valueFunc := ""
+	if len(columnTokens) == 4 && columnTokens[1] == "(" && strings.ToUpper(columnTokens[2]) == "VALUE" && columnTokens[3] == ")" {
+		valueFunc = strings.ToUpper(columnTokens[0])
+		if !valueFuncs[valueFunc] {
+			return nil, fmt.Errorf("invalid SELECT syntax: unknown function %s, expected UPPER, LOWER, LENGTH or TRIM", columnTokens[0])
+		}
+		columnTokens = []string{"*"}
+	}
+
+	// SELECT SEGMENT n OF key [DELIMITER '<sep>'] FROM ...: all keys, with a segment of each key as the value
+	segment, segmentSep := 0, ""
+	if len(columnTokens) > 1 && strings.ToUpper(columnTokens[0]) == "SEGMENT" && columnTokens[1] != "," {
+		if (len(columnTokens) != 4 && len(columnTokens) != 6) || strings.ToUpper(columnTokens[2]) != "OF" ||
+			strings.ToUpper(columnTokens[3]) != "KEY" || (len(columnTokens) == 6 && strings.ToUpper(columnTokens[4]) != "DELIMITER") {
+			return nil, errors.New("invalid SELECT syntax: expected SEGMENT <n> OF key [DELIMITER '<separator>']")
+		}
+		n, err := strconv.Atoi(columnTokens[1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SELECT syntax: SEGMENT must be a positive integer, got %q", columnTokens[1])
+		}
+		segment, segmentSep = n, ":"
+		if len(columnTokens) == 6 {
+			if segmentSep = unquote(columnTokens[5]); segmentSep == "" {
+				return nil, errors.New("invalid SELECT syntax: DELIMITER must not be empty")
+			}
+		}
+		columnTokens = []string{"*"}
+	}
+
+	// SELECT PREFIX <prefix> FROM ...: the keys starting with prefix, as a
+	// range scan like WHERE key STARTS WITH
+	if len(columnTokens) == 2 && strings.ToUpper(columnTokens[0]) == "PREFIX" {
+		if where != nil {
+			return nil, errors.New("invalid SELECT syntax: PREFIX cannot be combined with WHERE")
+		}
+		where = &Predicate{Field: "KEY", Op: "STARTS WITH", Operand: unquote(columnTokens[1])}
+		columnTokens = []string{"*"}
+	}
+
+	if every > 0 && (aggregate != "" || (len(columnTokens) > 1 && strings.ToUpper(columnTokens[0]) == "COUNT")) {
+		return nil, errors.New("invalid SELECT syntax: EVERY cannot be combined with an aggregate")
+	}
+
+	if len(columnTokens) > 1 && strings.ToUpper(columnTokens[0]) == "COUNT" && columnTokens[1] == "(" {
+		// SELECT COUNT ( DISTINCT PREFIX '<sep>' ) FROM ...
+		if len(columnTokens) != 6 || strings.ToUpper(columnTokens[2]) != "DISTINCT" ||
+			strings.ToUpper(columnTokens[3]) != "PREFIX" || columnTokens[5] != ")" {
+			return nil, errors.New("invalid SELECT syntax: expected COUNT(DISTINCT PREFIX '<separator>')")
+		}
+		prefixSep = unquote(columnTokens[4])
+		if prefixSep == "" {
+			return nil, errors.New("invalid SELECT syntax: PREFIX separator must not be empty")
+		}
+		if distinctOnValue {
+			return nil, errors.New("invalid SELECT syntax: DISTINCT ON value cannot be combined with COUNT")
+		}
+		if after != "" || limit > 0 {
+			return nil, errors.New("invalid SELECT syntax: AFTER and LIMIT cannot be combined with COUNT")
+		}
+	} else if len(columnTokens) == 1 && columnTokens[0] == "*" {
 		// SELECT * FROM ...
 		// keys will remain empty, which signifies "all keys" in engine.go
 	} else {
 		// SELECT key1, key2 FROM ...
-		// Join the column tokens and then split by "," to handle ["key1", ",", "key2"] correctly
-		joinedKeys := strings.Join(columnTokens, "")
-		parsedKeys := strings.Split(joinedKeys, ",")
-		for _, k := range parsedKeys {
-			trimmedKey := strings.TrimSpace(k)
-			if trimmedKey != "" {
-				keys = append(keys, trimmedKey)
-			}
+		parsedKeys, err := parseKeyList(columnTokens)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SELECT syntax: %w", err)
 		}
+		keys = parsedKeys
 		if len(keys) == 0 { // This might happen if input was just "SELECT FROM test" or similar malformed query
 			return nil, errors.New("invalid SELECT syntax: no keys specified")
 		}
 	}
 
 	return &SelectStatement{
-		Table: table,
-		Keys:  keys,
+		Table:           table,
+		Keys:            keys,
+		Where:           where,
+		Format:          format,
+		PrefixSep:       prefixSep,
+		Aggregate:       aggregate,
+		Buckets:         buckets,
+		DistinctOnValue: distinctOnValue,
+		ValueFunc:       valueFunc,
+		Segment:         segment,
+		SegmentSep:      segmentSep,
+		After:           after,
+		Limit:           limit,
+		Every:           every,
+		Top:             top,
+		TopAsc:          topAsc,
+		AsOf:            asOf,
 	}, nil
 }
 
+// parseKeyList parses a comma-separated key list such as ["a", ",", "b"].
+// Any token other than a comma is taken verbatim as a key, so keys like "-1",
+// "a.b.c" or "user:42" need no quoting. Empty entries are skipped; two keys
+// without a comma between them are rejected rather than joined together.
+func parseKeyList(tokens []string) ([]string, error) {
+	var keys []string
+	expectKey := true
+	for _, tok := range tokens {
+		if tok == "," {
+			expectKey = true
+			continue
+		}
+		if !expectKey {
+			return nil, fmt.Errorf("expected ',' before %q", tok)
+		}
+		keys = append(keys, keyLiteral(tok))
+		expectKey = false
+	}
+	return keys, nil
+}
+
+// parsePredicate parses the condition following WHERE:
+//
+//	(key | value) = <literal>
+//	(key | value) LIKE <pattern>
+//	(key | value) STARTS WITH <prefix>
+//	(key | value) ENDS WITH <suffix>
+//	(key | value) IS [NOT] EMPTY
+//	value IS DUPLICATED
+//	EXISTS IN <table>
+//
+// It returns the predicate and the number of tokens consumed.
+func parsePredicate(tokens []string) (*Predicate, int, error) {
+	if len(tokens) < 3 {
+		return nil, 0, errors.New("invalid WHERE syntax: expected (key|value) <operator> <operand>")
+	}
+	field := strings.ToUpper(tokens[0])
+	if field == "EXISTS" {
+		// Semi-join: rows whose key also exists in another table
+		if strings.ToUpper(tokens[1]) != "IN" {
+			return nil, 0, errors.New("invalid WHERE syntax: expected EXISTS IN <table_name>")
+		}
+		return &Predicate{Field: "KEY", Op: "EXISTS IN", Operand: identifier(tokens[2])}, 3, nil
+	}
+	if field != "KEY" && field != "VALUE" {
+		return nil, 0, fmt.Errorf("invalid WHERE syntax: expected key or value, got %q", tokens[0])
+	}
+	op := strings.ToUpper(tokens[1])
+	switch op {
+	case "=", "LIKE":
+		return &Predicate{Field: field, Op: op, Operand: unquote(tokens[2])}, 3, nil
+	case "REGEXP":
+		pattern := unquote(tokens[2])
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, 0, fmt.Errorf("invalid WHERE syntax: invalid REGEXP pattern %q: %v", pattern, err)
+		}
+		return &Predicate{Field: field, Op: op, Operand: pattern}, 3, nil
+	case "IS":
+		// IS EMPTY or IS NOT EMPTY: no operand, matches on zero-length fields
+		if strings.ToUpper(tokens[2]) == "EMPTY" {
+			return &Predicate{Field: field, Op: "IS EMPTY"}, 3, nil
+		}
+		if len(tokens) >= 4 && strings.ToUpper(tokens[2]) == "NOT" && strings.ToUpper(tokens[3]) == "EMPTY" {
+			return &Predicate{Field: field, Op: "IS NOT EMPTY"}, 4, nil
+		}
+		if strings.ToUpper(tokens[2]) == "DUPLICATED" {
+			// Keys are unique, so only values can be duplicated
+			if field != "VALUE" {
+				return nil, 0, errors.New("invalid WHERE syntax: IS DUPLICATED applies to value only")
+			}
+			return &Predicate{Field: field, Op: "IS DUPLICATED"}, 3, nil
+		}
+		return nil, 0, errors.New("invalid WHERE syntax: expected IS EMPTY, IS NOT EMPTY or IS DUPLICATED")
+	case "BETWEEN":
+		if len(tokens) < 5 || strings.ToUpper(tokens[3]) != "AND" {
+			return nil, 0, errors.New("invalid WHERE syntax: expected BETWEEN <low> AND <high>")
+		}
+		return &Predicate{Field: field, Op: op, Operand: unquote(tokens[2]), High: unquote(tokens[4])}, 5, nil
+	case "STARTS", "ENDS":
+		if len(tokens) < 4 || strings.ToUpper(tokens[2]) != "WITH" {
+			return nil, 0, fmt.Errorf("invalid WHERE syntax: expected %s WITH <operand>", op)
+		}
+		return &Predicate{Field: field, Op: op + " WITH", Operand: unquote(tokens[3])}, 4, nil
+	default:
+		return nil, 0, fmt.Errorf("invalid WHERE syntax: unsupported operator %q", tokens[1])
+	}
+}
+
+// unquote strips one pair of matching single or double quotes around a
+// literal and restores escaped characters.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	return unescape(s)
+}
+
 func parseDelete(tokens []string) (Statement, error) {
-	// Expected format: DELETE key1, key2 FROM tableName
+	// Expected format: DELETE key1, key2 FROM tableName [RETURNING]
 	if len(tokens) < 4 { // Minimum: DELETE key FROM table
 		return nil, errors.New("invalid DELETE syntax: expected DELETE <keys> FROM <table_name>")
 	}
@@ -197,25 +826,18 @@ func parseDelete(tokens []string) (Statement, error) {
 	if fromIndex+1 >= len(tokens) {
 		return nil, errors.New("invalid DELETE syntax: expected table name after FROM")
 	}
-	table := tokens[fromIndex+1]
+	table := identifier(tokens[fromIndex+1])
 
 	// Check for any unexpected tokens after the table name
-	if fromIndex+2 < len(tokens) {
+	returning := fromIndex+3 == len(tokens) && strings.ToUpper(tokens[fromIndex+2]) == "RETURNING"
+	if fromIndex+2 < len(tokens) && !returning {
 		return nil, errors.New("invalid DELETE syntax: unexpected tokens after table name")
 	}
 
-	var keys []string
 	// The tokens between "DELETE" (tokens[0]) and "FROM" (tokens[fromIndex]) are the keys to delete
-	keyTokens := tokens[1:fromIndex]
-
-	// Join the key tokens and then split by "," to handle ["key1", ",", "key2"] correctly
-	joinedKeys := strings.Join(keyTokens, "")
-	parsedKeys := strings.Split(joinedKeys, ",")
-	for _, k := range parsedKeys {
-		trimmedKey := strings.TrimSpace(k)
-		if trimmedKey != "" {
-			keys = append(keys, trimmedKey)
-		}
+	keys, err := parseKeyList(tokens[1:fromIndex])
+	if err != nil {
+		return nil, fmt.Errorf("invalid DELETE syntax: %w", err)
 	}
 
 	if len(keys) == 0 {
@@ -223,16 +845,23 @@ func parseDelete(tokens []string) (Statement, error) {
 	}
 
 	return &DeleteStatement{
-		Table: table,
-		Keys:  keys,
+		Table:     table,
+		Keys:      keys,
+		Returning: returning,
 	}, nil
 }
 
 func parseDrop(tokens []string) (Statement, error) {
+	if len(tokens) == 3 && strings.ToUpper(tokens[0]) == "DROP" && strings.ToUpper(tokens[1]) == "VIEW" {
+		return &DropViewStatement{Name: identifier(tokens[2])}, nil
+	}
+	if len(tokens) == 3 && strings.ToUpper(tokens[2]) == "CASCADE" {
+		return &DropStatement{Table: identifier(tokens[1]), Cascade: true}, nil
+	}
 	if len(tokens) != 2 || strings.ToUpper(tokens[0]) != "DROP" {
-		return nil, errors.New("expected DROP table_name")
+		return nil, errors.New("expected DROP table_name [CASCADE]")
 	}
-	return &DropStatement{Table: tokens[1]}, nil
+	return &DropStatement{Table: identifier(tokens[1])}, nil
 }
 
 func parseUpdate(tokens []string) (Statement, error) {
@@ -245,7 +874,7 @@ func parseUpdate(tokens []string) (Statement, error) {
 		return nil, errors.New("expected UPDATE keyword")
 	}
 
-	table := tokens[1]
+	table := identifier(tokens[1])
 	if table == "" {
 		return nil, errors.New("invalid UPDATE syntax: expected table name after UPDATE")
 	}
@@ -265,34 +894,69 @@ func parseUpdate(tokens []string) (Statement, error) {
 		return nil, errors.New("invalid UPDATE syntax: SET keyword in wrong position")
 	}
 
-	// The key-value pairs are the tokens after "SET"
+	// The key-value pairs are the tokens after "SET", up to an optional IF
+	// outside of any pair
 	valuesTokens := tokens[setIndex+1:]
+	var guardTokens []string
+	depth := 0
+	for i, tok := range valuesTokens {
+		switch {
+		case tok == "(":
+			depth++
+		case tok == ")":
+			depth--
+		case depth == 0 && strings.ToUpper(tok) == "IF":
+			valuesTokens, guardTokens = valuesTokens[:i], valuesTokens[i+1:]
+			if len(guardTokens) == 0 {
+				return nil, errors.New("invalid UPDATE syntax: no (key, expected value) pairs after IF")
+			}
+		}
+		if guardTokens != nil {
+			break
+		}
+	}
 	if len(valuesTokens) == 0 {
 		return nil, errors.New("invalid UPDATE syntax: no key-value pairs after SET")
 	}
 
-	rawValues := strings.Join(valuesTokens, "")
-	matches := pairRegex.FindAllStringSubmatch(rawValues, -1)
-	if len(matches) == 0 {
+	values, err := parseUpdatePairs(valuesTokens)
+	if err != nil {
 		return nil, errors.New("invalid UPDATE syntax: no valid (key, value) pairs found after SET")
 	}
-
-	var values []KeyValue
-	for _, match := range matches {
-		if len(match) != 3 {
-			return nil, errors.New("invalid match format for key-value pairs")
+	var expected map[string]string
+	if guardTokens != nil {
+		guards, err := parseUpdatePairs(guardTokens)
+		if err != nil {
+			return nil, errors.New("invalid UPDATE syntax: no valid (key, expected value) pairs found after IF")
+		}
+		expected = make(map[string]string, len(guards))
+		for _, kv := range guards {
+			expected[kv.Key] = kv.Value
 		}
-		key := strings.TrimSpace(match[1])
-		value := strings.TrimSpace(match[2])
-		values = append(values, KeyValue{Key: key, Value: value})
 	}
 
 	return &UpdateStatement{
-		Table:  table,
-		Values: values,
+		Table:    table,
+		Values:   values,
+		Expected: expected,
 	}, nil
 }
 
+// parseUpdatePairs parses the (key, value) pairs of an UPDATE.
+func parseUpdatePairs(tokens []string) ([]KeyValue, error) {
+	matches := pairRegex.FindAllStringSubmatch(strings.Join(tokens, ""), -1)
+	if len(matches) == 0 {
+		return nil, errors.New("no valid (key, value) pairs")
+	}
+	var values []KeyValue
+	for _, match := range matches {
+		key := keyLiteral(strings.TrimSpace(match[1]))
+		value := unquote(strings.TrimSpace(match[2])) // Quote a value to keep spaces and delimiters in it
+		values = append(values, KeyValue{Key: key, Value: value})
+	}
+	return values, nil
+}
+
 func parseBegin(tokens []string) (Statement, error) {
 	if len(tokens) != 1 || strings.ToUpper(tokens[0]) != "BEGIN" {
 		return nil, errors.New("invalid BEGIN syntax: expected 'BEGIN'")
@@ -301,13 +965,30 @@ func parseBegin(tokens []string) (Statement, error) {
 }
 
 func parseCommit(tokens []string) (Statement, error) {
-	if len(tokens) != 1 || strings.ToUpper(tokens[0]) != "COMMIT" {
-		return nil, errors.New("invalid COMMIT syntax: expected 'COMMIT'")
+	// Expected format: COMMIT [txID] or COMMIT PREPARED gid
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "PREPARED" {
+		if len(tokens) != 3 {
+			return nil, errors.New("invalid COMMIT PREPARED syntax: expected 'COMMIT PREPARED <gid>'")
+		}
+		return &CommitPreparedStatement{GID: unquote(tokens[2])}, nil
+	}
+	if len(tokens) < 1 || len(tokens) > 2 || strings.ToUpper(tokens[0]) != "COMMIT" {
+		return nil, errors.New("invalid COMMIT syntax: expected 'COMMIT' or 'COMMIT <tx_id>'")
+	}
+	if len(tokens) == 2 {
+		return &CommitStatement{TxID: tokens[1]}, nil
 	}
 	return &CommitStatement{}, nil
 }
 
 func parseRollback(tokens []string) (Statement, error) {
+	// Expected format: ROLLBACK or ROLLBACK PREPARED gid
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "PREPARED" {
+		if len(tokens) != 3 {
+			return nil, errors.New("invalid ROLLBACK PREPARED syntax: expected 'ROLLBACK PREPARED <gid>'")
+		}
+		return &RollbackPreparedStatement{GID: unquote(tokens[2])}, nil
+	}
 	if len(tokens) != 1 || strings.ToUpper(tokens[0]) != "ROLLBACK" {
 		return nil, errors.New("invalid ROLLBACK syntax: expected 'ROLLBACK'")
 	}
@@ -318,5 +999,238 @@ func parseShow(tokens []string) (Statement, error) {
 	if len(tokens) == 2 && strings.ToUpper(tokens[0]) == "SHOW" && strings.ToUpper(tokens[1]) == "TABLES" {
 		return &ShowTablesStatement{}, nil
 	}
-	return nil, errors.New("invalid SHOW syntax: expected 'SHOW TABLES'")
+	if len(tokens) == 3 && strings.ToUpper(tokens[0]) == "SHOW" && strings.ToUpper(tokens[1]) == "TABLE" && strings.ToUpper(tokens[2]) == "SIZES" {
+		return &ShowTableSizesStatement{}, nil
+	}
+	return nil, errors.New("invalid SHOW syntax: expected 'SHOW TABLES' or 'SHOW TABLE SIZES'")
+}
+
+func parseNextVal(tokens []string) (Statement, error) {
+	if len(tokens) != 2 || strings.ToUpper(tokens[0]) != "NEXTVAL" {
+		return nil, errors.New("invalid NEXTVAL syntax: expected 'NEXTVAL <sequence_name>'")
+	}
+	return &NextValStatement{Sequence: identifier(tokens[1])}, nil
+}
+
+func parseSync(tokens []string) (Statement, error) {
+	if len(tokens) != 1 || strings.ToUpper(tokens[0]) != "SYNC" {
+		return nil, errors.New("invalid SYNC syntax: expected 'SYNC'")
+	}
+	return &SyncStatement{}, nil
+}
+
+func parseAnalyze(tokens []string) (Statement, error) {
+	if len(tokens) != 1 {
+		return nil, errors.New("invalid ANALYZE syntax: expected 'ANALYZE'")
+	}
+	return &AnalyzeStatement{}, nil
+}
+
+func parseCreate(tokens []string) (Statement, error) {
+	// Expected format: CREATE TABLE name
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "TABLE" {
+		if len(tokens) != 3 {
+			return nil, errors.New("invalid CREATE TABLE syntax: expected 'CREATE TABLE <name>'")
+		}
+		return &CreateTableStatement{Table: identifier(tokens[2])}, nil
+	}
+
+	// Expected format: CREATE VIEW name AS SELECT ...
+	if len(tokens) < 5 || strings.ToUpper(tokens[1]) != "VIEW" || strings.ToUpper(tokens[3]) != "AS" ||
+		strings.ToUpper(tokens[4]) != "SELECT" {
+		return nil, errors.New("invalid CREATE syntax: expected 'CREATE TABLE <name>' or 'CREATE VIEW <name> AS SELECT ...'")
+	}
+	query, err := parseSelect(tokens[4:])
+	if err != nil {
+		return nil, err
+	}
+	if q := query.(*SelectStatement); q.PrefixSep != "" || q.Aggregate != "" || q.Format != "" {
+		return nil, errors.New("invalid CREATE VIEW syntax: a view must select rows, without COUNT, SUM, AVG or FORMAT")
+	}
+	return &CreateViewStatement{
+		Name:       identifier(tokens[2]),
+		Query:      query.(*SelectStatement),
+		Definition: reescapeReplacer.Replace(strings.Join(tokens[4:], " ")),
+	}, nil
+}
+
+func parseExplain(tokens []string) (Statement, error) {
+	// Expected format: EXPLAIN KEY '<key>' IN <table>
+	if len(tokens) >= 2 && strings.ToUpper(tokens[1]) == "KEY" {
+		if len(tokens) != 5 || strings.ToUpper(tokens[3]) != "IN" {
+			return nil, errors.New("invalid EXPLAIN KEY syntax: expected 'EXPLAIN KEY '<key>' IN <table_name>'")
+		}
+		return &ExplainKeyStatement{Key: unquote(tokens[2]), Table: identifier(tokens[4])}, nil
+	}
+
+	// Expected format: EXPLAIN COMMIT
+	if len(tokens) == 2 && strings.ToUpper(tokens[1]) == "COMMIT" {
+		return &ExplainCommitStatement{}, nil
+	}
+
+	// Expected format: EXPLAIN SELECT ...
+	if len(tokens) < 2 || strings.ToUpper(tokens[1]) != "SELECT" {
+		return nil, errors.New("invalid EXPLAIN syntax: expected 'EXPLAIN SELECT ...', 'EXPLAIN KEY '<key>' IN <table_name>' or 'EXPLAIN COMMIT'")
+	}
+	query, err := parseSelect(tokens[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &ExplainStatement{Query: query.(*SelectStatement)}, nil
+}
+
+func parseGenerate(tokens []string) (Statement, error) {
+	// Expected format: GENERATE <count> INTO tablename
+	if len(tokens) != 4 || strings.ToUpper(tokens[2]) != "INTO" {
+		return nil, errors.New("invalid GENERATE syntax: expected 'GENERATE <count> INTO <table_name>'")
+	}
+	count, err := strconv.Atoi(tokens[1])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid GENERATE syntax: count must be a positive integer, got %q", tokens[1])
+	}
+	return &GenerateStatement{Count: count, Table: identifier(tokens[3])}, nil
+}
+
+func parseRun(tokens []string) (Statement, error) {
+	// Expected format: RUN '<file>' [ATOMIC]
+	if len(tokens) < 2 || len(tokens) > 3 || (len(tokens) == 3 && strings.ToUpper(tokens[2]) != "ATOMIC") {
+		return nil, errors.New("invalid RUN syntax: expected 'RUN '<file>' [ATOMIC]'")
+	}
+	path := unquote(tokens[1])
+	if path == "" {
+		return nil, errors.New("invalid RUN syntax: empty file name")
+	}
+	return &RunStatement{Path: path, Atomic: len(tokens) == 3}, nil
+}
+
+func parseReorganize(tokens []string) (Statement, error) {
+	if len(tokens) != 2 {
+		return nil, errors.New("invalid REORGANIZE syntax: expected 'REORGANIZE <table_name>'")
+	}
+	return &ReorganizeStatement{Table: identifier(tokens[1])}, nil
+}
+
+func parseDeclare(tokens []string) (Statement, error) {
+	// Expected format: DECLARE name [CURSOR] FOR SELECT ...
+	rest := tokens[min(2, len(tokens)):]
+	if len(rest) > 0 && strings.ToUpper(rest[0]) == "CURSOR" {
+		rest = rest[1:]
+	}
+	if len(rest) < 2 || strings.ToUpper(rest[0]) != "FOR" || strings.ToUpper(rest[1]) != "SELECT" {
+		return nil, errors.New("invalid DECLARE syntax: expected 'DECLARE <cursor> [CURSOR] FOR SELECT ...'")
+	}
+	query, err := parseSelect(rest[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &DeclareCursorStatement{Name: identifier(tokens[1]), Query: query.(*SelectStatement)}, nil
+}
+
+func parseFetch(tokens []string) (Statement, error) {
+	// Expected format: FETCH n FROM name
+	if len(tokens) != 4 || strings.ToUpper(tokens[2]) != "FROM" {
+		return nil, errors.New("invalid FETCH syntax: expected 'FETCH <n> FROM <cursor>'")
+	}
+	n, err := strconv.Atoi(tokens[1])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid FETCH syntax: count must be a positive integer, got %q", tokens[1])
+	}
+	return &FetchStatement{Name: identifier(tokens[3]), Count: n}, nil
+}
+
+func parseClose(tokens []string) (Statement, error) {
+	if len(tokens) != 2 {
+		return nil, errors.New("invalid CLOSE syntax: expected 'CLOSE <cursor>'")
+	}
+	return &CloseCursorStatement{Name: identifier(tokens[1])}, nil
+}
+
+// ParseError is the error for one statement of a ParseAll input that
+// failed to parse.
+type ParseError struct {
+	Index int    // zero-based position of the statement among the input's statements
+	Line  int    // line on which the statement starts, counting from 1
+	Text  string // the statement as written, without its semicolon
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("statement %d (line %d): %v", e.Index+1, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ParseAll parses a stream of semicolon-separated statements, independently
+// of any engine. A semicolon inside a quoted identifier does not end a
+// statement, and empty statements are skipped. It returns the statements that
+// parsed, in order, and for the others a *ParseError each, joined into one
+// error.
+func ParseAll(r io.Reader) ([]Statement, error) {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseScript(string(input), Parse)
+	var statements []Statement
+	for _, p := range parsed {
+		statements = append(statements, p.stmt)
+	}
+	return statements, err
+}
+
+// parseScript parses the statements of a script like ParseAll, keeping the
+// text and line of each statement that parsed. Each statement is parsed
+// with parse, which is Parse or an engine's parse to allow custom commands.
+func parseScript(input string, parse func(string) (Statement, error)) ([]scriptStatement, error) {
+	var statements []scriptStatement
+	var errs []error
+	index := 0
+	for _, text := range splitStatements(input) {
+		if strings.TrimSpace(text.text) == "" {
+			continue
+		}
+		stmt, err := parse(text.text)
+		if err != nil {
+			errs = append(errs, &ParseError{Index: index, Line: text.line, Text: strings.TrimSpace(text.text), Err: err})
+		} else {
+			text.stmt = stmt
+			statements = append(statements, text)
+		}
+		index++
+	}
+	return statements, errors.Join(errs...)
+}
+
+// scriptStatement is one statement of a script and the line it starts on.
+type scriptStatement struct {
+	text string
+	line int
+	stmt Statement // set once parsed
+}
+
+// splitStatements splits input at semicolons outside of quoted spans, using
+// the same quoting rules as tokenize.
+func splitStatements(input string) []scriptStatement {
+	var statements []scriptStatement
+	runes := []rune(input)
+	start, line, startLine := 0, 1, 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if startLine == 0 && !unicode.IsSpace(r) {
+			startLine = line // The statement starts at its first non-space character
+		}
+		switch {
+		case r == '\n':
+			line++
+		case isQuote(r) && (i == 0 || unicode.IsSpace(runes[i-1]) || strings.ContainsRune("(),", runes[i-1])):
+			if end := closingQuote(runes, i); end >= 0 {
+				line += strings.Count(string(runes[i:end]), "\n")
+				i = end
+			}
+		case r == ';':
+			statements = append(statements, scriptStatement{text: string(runes[start:i]), line: startLine})
+			start, startLine = i+1, 0
+		}
+	}
+	return append(statements, scriptStatement{text: string(runes[start:]), line: startLine})
 }
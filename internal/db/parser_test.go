@@ -0,0 +1,207 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTrailingSemicolon(t *testing.T) {
+	statements := []string{
+		`INSERT (a, 1) INTO t`,
+		`INSERT INTO t2 SELECT * FROM t`,
+		`SELECT * FROM t`,
+		`SELECT a FROM t WHERE value = 1 FORMAT JSON`,
+		`DELETE a FROM t`,
+		`DROP t`,
+		`UPDATE t SET (a, 2)`,
+		`BEGIN`,
+		`COMMIT`,
+		`ROLLBACK`,
+		`SHOW TABLES`,
+		`SHOW TABLE SIZES`,
+		`NEXTVAL seq`,
+		`SYNC`,
+	}
+	for _, stmt := range statements {
+		want, err := Parse(stmt)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", stmt, err)
+		}
+		for _, input := range []string{stmt + ";", stmt + " ; ", "  " + stmt + ";\n"} {
+			got, err := Parse(input)
+			if err != nil {
+				t.Errorf("Parse(%q): %v", input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", input, got, want)
+			}
+		}
+	}
+
+	if _, err := Parse(`;`); err == nil {
+		t.Error("Expected an error for a lone semicolon")
+	}
+}
+
+func TestParseSpecialCharacterKeys(t *testing.T) {
+	keys := []string{"-1", "a.b.c", "user:42", "-.:", "distinct"}
+	stmt, err := Parse(`SELECT -1, a.b.c, user:42, -.:, distinct FROM t`)
+	if err != nil {
+		t.Fatalf("Parse SELECT: %v", err)
+	}
+	if got := stmt.(*SelectStatement).Keys; !reflect.DeepEqual(got, keys) {
+		t.Errorf("SELECT keys = %q, want %q", got, keys)
+	}
+	stmt, err = Parse(`DELETE -1,a.b.c , user:42, -.:, distinct FROM t`)
+	if err != nil {
+		t.Fatalf("Parse DELETE: %v", err)
+	}
+	if got := stmt.(*DeleteStatement).Keys; !reflect.DeepEqual(got, keys) {
+		t.Errorf("DELETE keys = %q, want %q", got, keys)
+	}
+
+	for _, input := range []string{`SELECT a b FROM t`, `DELETE a b FROM t`} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Expected an error for keys without a comma in %q", input)
+		}
+	}
+}
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Statement
+	}{
+		{`SELECT * FROM "FROM"`, &SelectStatement{Table: "FROM"}},
+		{"SELECT `SELECT`, \"a b\", \"x,(y)\" FROM `WHERE`", &SelectStatement{Table: "WHERE", Keys: []string{"SELECT", "a b", "x,(y)"}}},
+		{`INSERT ("INTO", 1), ("a b", 2) INTO "INTO"`, &InsertStatement{Table: "INTO", Values: []KeyValue{{"INTO", "1"}, {"a b", "2"}}}},
+		{`DELETE "FROM" FROM "FROM"`, &DeleteStatement{Table: "FROM", Keys: []string{"FROM"}}},
+		{`UPDATE "SET" SET ("SET", 1)`, &UpdateStatement{Table: "SET", Values: []KeyValue{{"SET", "1"}}}},
+		{`DROP "DROP"`, &DropStatement{Table: "DROP"}},
+		{`CREATE TABLE "TABLE"`, &CreateTableStatement{Table: "TABLE"}},
+		{`SELECT * FROM t WHERE value = "a b"`, &SelectStatement{Table: "t", Where: &Predicate{Field: "VALUE", Op: "=", Operand: "a b"}}},
+		{`SELECT * FROM t WHERE value = 'a, (b)'`, &SelectStatement{Table: "t", Where: &Predicate{Field: "VALUE", Op: "=", Operand: "a, (b)"}}},
+		{`APPEND (log, ' more'), ("a b", x) IN "IN"`, &AppendStatement{Table: "IN", Values: []KeyValue{{"log", " more"}, {"a b", "x"}}}},
+		// A quote inside a word is an ordinary character
+		{`INSERT (b, say"hi") INTO t`, &InsertStatement{Table: "t", Values: []KeyValue{{"b", `say"hi"`}}}},
+		{`SELECT a"b FROM t`, &SelectStatement{Table: "t", Keys: []string{`a"b`}}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	script := "INSERT (a, 1) INTO t;\n" +
+		"-- not a comment, just a broken statement\n" +
+		"SELECT * FROM \"semi;colon\";\n" +
+		"\n;  ;\n" +
+		"DELETE a FROM t"
+	statements, err := ParseAll(strings.NewReader(script))
+
+	want := []Statement{
+		&InsertStatement{Table: "t", Values: []KeyValue{{"a", "1"}}},
+		&DeleteStatement{Table: "t", Keys: []string{"a"}},
+	}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("ParseAll statements = %+v, want %+v", statements, want)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a *ParseError, got %v", err)
+	}
+	if parseErr.Index != 1 || parseErr.Line != 2 {
+		t.Errorf("Expected the error at statement index 1, line 2, got index %d, line %d", parseErr.Index, parseErr.Line)
+	}
+	if !strings.HasPrefix(err.Error(), "statement 2 (line 2): unsupported statement: --") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+
+	statements, err = ParseAll(strings.NewReader("SELECT * FROM t;\nSHOW TABLES;\nSELECT \"x;y\" FROM \"a;b\";"))
+	if err != nil {
+		t.Fatalf("ParseAll: %v", err)
+	}
+	if len(statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(statements))
+	}
+	if _, ok := statements[1].(*ShowTablesStatement); !ok {
+		t.Errorf("Expected a SHOW TABLES statement, got %T", statements[1])
+	}
+	if s, ok := statements[2].(*SelectStatement); !ok || s.Table != "a;b" || s.Keys[0] != "x;y" {
+		t.Errorf("Expected quoted semicolons to stay inside names, got %+v", statements[2])
+	}
+}
+
+func TestParseQuotedValues(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Statement
+	}{
+		{`INSERT (name, "John Smith"), (desc, 'a,b') INTO t`, &InsertStatement{Table: "t", Values: []KeyValue{{"name", "John Smith"}, {"desc", "a,b"}}}},
+		{`INSERT (note, '(see below)'), (empty, '') INTO t`, &InsertStatement{Table: "t", Values: []KeyValue{{"note", "(see below)"}, {"empty", ""}}}},
+		{`UPDATE t SET (name, 'Jane Doe'), (n, 1)`, &UpdateStatement{Table: "t", Values: []KeyValue{{"name", "Jane Doe"}, {"n", "1"}}}},
+		{`INSERT (a, it's) INTO t`, &InsertStatement{Table: "t", Values: []KeyValue{{"a", "it's"}}}}, // a quote inside a word
+		{`UPDATE t SET (a, 2), (b, 'x y') IF (a, 1), (b, 'old value')`, &UpdateStatement{Table: "t",
+			Values: []KeyValue{{"a", "2"}, {"b", "x y"}}, Expected: map[string]string{"a": "1", "b": "old value"}}},
+		{`INSERT ('first name', x), ("last name", y) INTO t`, &InsertStatement{Table: "t", Values: []KeyValue{{"first name", "x"}, {"last name", "y"}}}},
+		{`UPDATE t SET ('first name', 'Jane Doe')`, &UpdateStatement{Table: "t", Values: []KeyValue{{"first name", "Jane Doe"}}}},
+		{`UPDATE t SET (if, 2) if (if, 1)`, &UpdateStatement{Table: "t", Values: []KeyValue{{"if", "2"}}, Expected: map[string]string{"if": "1"}}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+
+	for input, want := range map[string]string{
+		`INSERT (name, "John Smith) INTO t`: `unterminated quote: " at position 15 is never closed`,
+		`UPDATE t SET (a, 'x y)`:            `unterminated quote: ' at position 18 is never closed`,
+		"SELECT * FROM `t":                  "unterminated quote: ` at position 15 is never closed",
+	} {
+		if _, err := Parse(input); err == nil || err.Error() != want {
+			t.Errorf("Parse(%s): expected error %q, got %v", input, want, err)
+		}
+	}
+}
+
+func TestParseUnbalancedParentheses(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string // "" if the statement parses
+	}{
+		{`INSERT (a, 1 INTO t`, "unbalanced parentheses: '(' at position 8 is never closed"},
+		{`INSERT (a, 1)) INTO t`, "unbalanced parentheses: ')' at position 14 has no matching '('"},
+		{`INSERT a, 1) INTO t`, "unbalanced parentheses: ')' at position 12 has no matching '('"},
+		{`UPDATE t SET (a, 1), (b, 2`, "unbalanced parentheses: '(' at position 22 is never closed"},
+		{`SELECT COUNT(* FROM t`, "unbalanced parentheses: '(' at position 13 is never closed"},
+		{`INSERT (a, '1)') INTO t`, ""},            // quoted
+		{`INSERT (a, 1\)) INTO t`, ""},             // escaped
+		{`INSERT (a, "(") INTO t`, ""},             // quoted
+		{`FROB (a`, "unsupported statement: FROB"}, // left to custom commands
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("Parse(%q): unexpected error %v", tt.input, err)
+			}
+		} else if err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%q): expected error %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
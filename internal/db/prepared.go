@@ -0,0 +1,88 @@
+package db
+
+import "fmt"
+
+// prepare runs PREPARE TRANSACTION: it logs the current transaction's
+// changes followed by a PREPARE_TX record, which syncs the log, and sets
+// the transaction aside under gid. Its changes stay invisible until COMMIT
+// PREPARED applies them; they take no locks, so keys it writes can still be
+// changed by others in between. The engine is then free for a new
+// transaction. If the log cannot be synced the transaction stays open, as
+// it cannot be reported prepared before the prepare is durable.
+func (e *Engine) prepare(gid string) string {
+	if e.currentTxID == "" {
+		return "Error: No active transaction to prepare."
+	}
+	if _, exists := e.prepared[gid]; exists {
+		return fmt.Sprintf("Error: Prepared transaction '%s' already exists.", gid)
+	}
+	if e.opts.PerTableLogs {
+		return fmt.Sprintf("Error: PREPARE TRANSACTION is %v.", ErrPerTableLogs)
+	}
+	txID := e.currentTxID
+	if e.opts.CompactCommits {
+		e.wal.BeginTx(txID) // Not logged by BEGIN, but Replay needs it for a prepared transaction
+	}
+	e.logChanges(txID)
+	if err := e.wal.PrepareTx(txID, gid); err != nil {
+		return fmt.Sprintf("Error: PREPARE TRANSACTION failed: %v. The transaction is still open.", err)
+	}
+
+	if e.prepared == nil {
+		e.prepared = make(map[string]*preparedTx)
+	}
+	e.prepared[gid] = &preparedTx{
+		txID:    txID,
+		gid:     gid,
+		changes: e.txChanges,
+		deletes: e.txDeletes,
+		drops:   e.txDroppedTables,
+	}
+	e.currentTxID = ""
+	e.txChanges = nil
+	e.txDeletes = nil
+	e.txDroppedTables = nil
+	return fmt.Sprintf("Transaction %s prepared as '%s'.", txID, gid)
+}
+
+// finishPrepared runs COMMIT PREPARED (commit true) and ROLLBACK PREPARED.
+// A commit logs the transaction's COMMIT_TX and applies its changes as
+// COMMIT does.
+func (e *Engine) finishPrepared(gid string, commit bool) string {
+	p, ok := e.prepared[gid]
+	if !ok {
+		return fmt.Sprintf("Error: Unknown prepared transaction '%s'.", gid)
+	}
+	if !commit {
+		e.wal.RollbackTx(p.txID)
+		delete(e.prepared, gid)
+		return fmt.Sprintf("Prepared transaction '%s' rolled back.", gid)
+	}
+	if e.currentTxID != "" {
+		return "Error: COMMIT PREPARED is not allowed inside a transaction."
+	}
+
+	if err := e.wal.CommitTx(p.txID); err != nil {
+		return fmt.Sprintf("Error: COMMIT PREPARED failed: %v. The transaction is still prepared.", err)
+	}
+	e.txChanges, e.txDeletes, e.txDroppedTables = p.changes, p.deletes, p.drops
+	e.applyCommit()
+	e.txChanges, e.txDeletes, e.txDroppedTables = nil, nil, nil
+	e.rememberCommit(p.txID)
+	delete(e.prepared, gid)
+	return fmt.Sprintf("Prepared transaction '%s' committed.", gid)
+}
+
+// restorePrepared reloads a prepared transaction found by replay, decoding
+// its values as they are held in the transaction buffers.
+func (e *Engine) restorePrepared(p preparedTx) {
+	for table, kvs := range p.changes {
+		for key, value := range kvs {
+			kvs[key] = e.decodeValue(table, value)
+		}
+	}
+	if e.prepared == nil {
+		e.prepared = make(map[string]*preparedTx)
+	}
+	e.prepared[p.gid] = &p
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnginePreparedTransactions(t *testing.T) {
+	opts := EngineOptions{NewTxID: sequentialTxIDs()}
+	e := setupTestEngineWithOptions(t, opts)
+	e.Execute(`INSERT (alice, 100), (bob, 50) INTO accounts`)
+
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{`PREPARE TRANSACTION 'transfer-1'`, "Error: No active transaction to prepare."},
+		{`BEGIN`, "Transaction started: tx_1"},
+		{`UPDATE accounts SET (alice, 70), (bob, 80)`, "Buffered 2 key(s) for update in table 'accounts'"},
+		{`PREPARE TRANSACTION 'transfer-1'`, "Transaction tx_1 prepared as 'transfer-1'."},
+		{`SELECT * FROM accounts`, "alice: 100\nbob: 50"}, // not visible until committed
+		{`BEGIN`, "Transaction started: tx_2"},
+		{`DELETE bob FROM accounts`, "Buffered 1 key(s) for deletion from table 'accounts'"},
+		{`PREPARE TRANSACTION 'transfer-1'`, "Error: Prepared transaction 'transfer-1' already exists."},
+		{`PREPARE TRANSACTION 'cleanup'`, "Transaction tx_2 prepared as 'cleanup'."},
+		{`COMMIT PREPARED 'nope'`, "Error: Unknown prepared transaction 'nope'."},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+	if err := e.Checkpoint(); !errors.Is(err, ErrTxPrepared) {
+		t.Errorf("Expected Checkpoint to fail with ErrTxPrepared, got %v", err)
+	}
+
+	// Prepared transactions survive a restart and can be finished afterwards
+	e.Close()
+	restarted := NewEngineWithOptions("test_wal.log", opts)
+	defer restarted.Close()
+	if stats := restarted.RecoveryStats(); stats.IncompleteTxs != 0 {
+		t.Errorf("Prepared transactions must not count as incomplete, got %+v", stats)
+	}
+	tests = []struct {
+		cmd      string
+		expected string
+	}{
+		{`SELECT * FROM accounts`, "alice: 100\nbob: 50"},
+		{`COMMIT PREPARED 'transfer-1'`, "Prepared transaction 'transfer-1' committed."},
+		{`SELECT * FROM accounts`, "alice: 70\nbob: 80"},
+		{`ROLLBACK PREPARED cleanup`, "Prepared transaction 'cleanup' rolled back."},
+		{`COMMIT PREPARED 'transfer-1'`, "Error: Unknown prepared transaction 'transfer-1'."},
+		{`COMMIT PREPARED`, "Parse error: invalid COMMIT PREPARED syntax: expected 'COMMIT PREPARED <gid>'"},
+	}
+	for _, tt := range tests {
+		if resp := restarted.Execute(tt.cmd); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.cmd, tt.expected, resp)
+		}
+	}
+	if err := restarted.Checkpoint(); err != nil {
+		t.Errorf("Checkpoint after finishing the prepared transactions: %v", err)
+	}
+}
+
+func TestEnginePrepareSyncFailure(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
+	syncer := &fakeSyncer{err: errors.New("disk full")}
+	e.wal.syncer = syncer
+
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	if resp := e.Execute(`PREPARE TRANSACTION 'p'`); resp != "Error: PREPARE TRANSACTION failed: disk full. The transaction is still open." {
+		t.Fatalf("Expected PREPARE to fail, got %q", resp)
+	}
+	if resp := e.Execute(`COMMIT PREPARED 'p'`); resp != "Error: Unknown prepared transaction 'p'." {
+		t.Errorf("Expected no prepared transaction, got %q", resp)
+	}
+
+	syncer.err = nil
+	if resp := e.Execute(`PREPARE TRANSACTION 'p'`); resp != "Transaction tx_1 prepared as 'p'." {
+		t.Errorf("Expected a retried PREPARE to succeed, got %q", resp)
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+)
+
+// queryCache is a least-recently-used cache of SELECT results, keyed by the
+// normalized statement text. Each entry remembers the tables and views its
+// result was read from and is dropped as soon as one of them is written.
+type queryCache struct {
+	size    int
+	order   *list.List // of *cachedResult, most recently used first
+	entries map[string]*list.Element
+}
+
+type cachedResult struct {
+	statement string
+	result    string
+	tables    []string // tables and views the result depends on
+}
+
+func newQueryCache(size int) *queryCache {
+	return &queryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *queryCache) get(statement string) (string, bool) {
+	elem, ok := c.entries[statement]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedResult).result, true
+}
+
+func (c *queryCache) put(statement, result string, tables []string) {
+	if elem, ok := c.entries[statement]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[statement] = c.order.PushFront(&cachedResult{statement: statement, result: result, tables: tables})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResult).statement)
+	}
+}
+
+// invalidate drops every entry that depends on table.
+func (c *queryCache) invalidate(table string) {
+	for statement, elem := range c.entries {
+		if slices.Contains(elem.Value.(*cachedResult).tables, table) {
+			c.order.Remove(elem)
+			delete(c.entries, statement)
+		}
+	}
+}
+
+func (c *queryCache) clear() {
+	c.order.Init()
+	clear(c.entries)
+}
+
+// normalizeStatement reduces a statement to the cache key used for it: its
+// tokens as Parse sees them, so spacing and a trailing semicolon do not
+// matter but quoted literals stay as written. Tokens are joined with a NUL,
+// which unlike a space cannot make two different token lists look alike.
+// Case is kept, since literals are case sensitive.
+func normalizeStatement(cmd string) string {
+	return strings.Join(statementTokens(cmd), "\x00")
+}
+
+// cachedSelect runs a SELECT outside a transaction through the query cache.
+func (e *Engine) cachedSelect(cmd string, s *SelectStatement) string {
+	statement := normalizeStatement(cmd)
+	if result, ok := e.queryCache.get(statement); ok {
+		return result
+	}
+	result := e.executeSelect(s)
+	if e.canceled() != nil {
+		return result // A canceled aggregate's error says nothing about the data
+	}
+	e.queryCache.put(statement, result, e.selectDependencies(s, nil))
+	return result
+}
+
+// selectDependencies appends to deps every table and view s reads from,
+// following views to their base tables and EXISTS IN to its other table.
+func (e *Engine) selectDependencies(s *SelectStatement, deps []string) []string {
+	for _, source := range viewSources(s) {
+		if slices.Contains(deps, source) {
+			continue // Already followed
+		}
+		deps = append(deps, source)
+		if view, ok, err := e.lookupView(source); err == nil && ok {
+			deps = e.selectDependencies(view, deps)
+		}
+	}
+	return deps
+}
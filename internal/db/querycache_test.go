@@ -0,0 +1,116 @@
+package db
+
+import "testing"
+
+func TestQueryCacheServesRepeatedSelects(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{QueryCacheSize: 8})
+	e.Execute(`INSERT (a, 1) INTO t`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1" {
+		t.Fatalf("Unexpected first result: %q", resp)
+	}
+
+	// Change the tree behind the engine's back: a cache hit still returns the
+	// old result, for any spelling of the same statement
+	e.tables["t"].Insert("b", "2")
+	for _, query := range []string{`SELECT * FROM t`, `  SELECT  *  FROM t ;`} {
+		if resp := e.Execute(query); resp != "a: 1" {
+			t.Errorf("Expected %q to be served from the cache, got %q", query, resp)
+		}
+	}
+
+	// A write to the table invalidates it
+	e.Execute(`INSERT (c, 3) INTO t`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1\nb: 2\nc: 3" {
+		t.Errorf("Expected a fresh result after INSERT, got %q", resp)
+	}
+
+	// ...but a write to another table does not
+	e.tables["t"].Insert("d", "4")
+	e.Execute(`INSERT (x, 1) INTO other`)
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1\nb: 2\nc: 3" {
+		t.Errorf("Expected the cached result to survive a write to another table, got %q", resp)
+	}
+}
+
+func TestQueryCacheKeepsQuotedWhitespace(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{QueryCacheSize: 8})
+	e.Execute(`INSERT (a, 'x y'), (b, 'x  y') INTO t`)
+	for query, expected := range map[string]string{
+		`SELECT * FROM t WHERE value = 'x y'`:  "a: x y",
+		`SELECT * FROM t WHERE value = 'x  y'`: "b: x  y",
+	} {
+		for range 2 { // The second run is served from the cache
+			if resp := e.Execute(query); resp != expected {
+				t.Errorf("%s:\nexpected %q\ngot      %q", query, expected, resp)
+			}
+		}
+	}
+}
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{QueryCacheSize: 8})
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	e.Execute(`INSERT (b, x) INTO other`)
+	e.Execute(`CREATE VIEW v AS SELECT * FROM t WHERE EXISTS IN other`)
+
+	tests := []struct {
+		write    string
+		query    string
+		expected string
+	}{
+		{`UPDATE t SET (b, 20)`, `SELECT * FROM v`, "b: 20"},
+		{`INSERT (a, y) INTO other`, `SELECT * FROM v`, "a: 1\nb: 20"},
+		{`DELETE a FROM t`, `SELECT * FROM t`, "b: 20"},
+		{`DROP VIEW v`, `SELECT * FROM v`, "Table 'v' not found"},
+		{`CREATE TABLE v`, `SELECT * FROM v`, "No results"},
+		{`DROP t`, `SELECT * FROM t`, "Table 't' not found"},
+	}
+	for _, tt := range tests {
+		e.Execute(tt.query) // cache the result from before the write
+		e.Execute(tt.write)
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("After %s, %s:\nexpected %q\ngot      %q", tt.write, tt.query, tt.expected, resp)
+		}
+	}
+
+	// Transactions bypass the cache, and COMMIT invalidates what it wrote
+	e.Execute(`INSERT (k, 1) INTO t2`)
+	e.Execute(`SELECT * FROM t2`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (m, 2) INTO t2`)
+	if resp := e.Execute(`SELECT * FROM t2`); resp != "k: 1\nm: ["+e.currentTxID+"] 2" {
+		t.Errorf("Expected the transaction's view inside BEGIN, got %q", resp)
+	}
+	e.Execute(`COMMIT`)
+	if resp := e.Execute(`SELECT * FROM t2`); resp != "k: 1\nm: 2" {
+		t.Errorf("Expected the committed rows after COMMIT, got %q", resp)
+	}
+
+	// Changing the display settings drops results rendered with the old ones
+	e.SetValueWidth(1)
+	if resp := e.Execute(`SELECT * FROM t2`); resp != "k: 1\nm: 2" {
+		t.Errorf("Unexpected result with width 1: %q", resp)
+	}
+	e.SetTypedOutput(true)
+	if resp := e.Execute(`SELECT * FROM t2`); resp != "k: 1 (int)\nm: 2 (int)" {
+		t.Errorf("Expected typed output after changing the setting, got %q", resp)
+	}
+}
+
+func TestQueryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{QueryCacheSize: 2})
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3) INTO t`)
+	e.Execute(`SELECT a FROM t`)
+	e.Execute(`SELECT b FROM t`)
+	e.Execute(`SELECT a FROM t`) // a is now more recently used than b
+	e.Execute(`SELECT c FROM t`) // evicts b
+
+	if e.queryCache.order.Len() != 2 {
+		t.Fatalf("Expected 2 cached entries, got %d", e.queryCache.order.Len())
+	}
+	for query, cached := range map[string]bool{`SELECT a FROM t`: true, `SELECT b FROM t`: false, `SELECT c FROM t`: true} {
+		if _, ok := e.queryCache.get(normalizeStatement(query)); ok != cached {
+			t.Errorf("%s: cached = %v, want %v", query, ok, cached)
+		}
+	}
+}
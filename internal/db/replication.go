@@ -0,0 +1,106 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// AddReplica starts streaming the log to w, for a follower that writes the
+// stream to its own log and replays it. w first receives a snapshot of the
+// committed tables in the format Checkpoint writes, then, synchronously
+// after each statement, the records it logged, so a follower that has
+// replayed everything written to it has the primary's committed state.
+//
+// A write error drops the replica and is passed to
+// EngineOptions.OnReplicaError, unless EngineOptions.BlockOnReplicaError is
+// set. AddReplica fails while a
+// transaction is open or prepared, whose earlier records the snapshot
+// cannot include.
+func (e *Engine) AddReplica(w io.Writer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.currentTxID != "" {
+		return ErrTxActive
+	}
+	if len(e.prepared) > 0 {
+		return ErrTxPrepared
+	}
+
+	names := make([]string, 0, len(e.tables))
+	snapshot := make(map[string][][2]string, len(e.tables))
+	for name, tree := range e.tables {
+		names = append(names, name)
+		tree.Ascend(func(key, value string) bool {
+			snapshot[name] = append(snapshot[name], [2]string{key, value})
+			return true
+		})
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	writeSnapshot(&buf, names, snapshot)
+	if err := e.writeReplica(w, buf.Bytes()); err != nil {
+		return fmt.Errorf("replica snapshot: %w", err)
+	}
+
+	if e.wal.shipped == nil {
+		e.wal.shipped = new(bytes.Buffer)
+	}
+	e.replicas = append(e.replicas, w)
+	return nil
+}
+
+// shipToReplicas writes the records logged since the last call to every
+// replica. Inside a Batch it does nothing, as the records are not in the log
+// file yet and a crash could lose them; see batch. The caller holds e.mu.
+func (e *Engine) shipToReplicas() {
+	if e.wal.shipped == nil || e.wal.shipped.Len() == 0 || e.wal.inBatch() {
+		return
+	}
+	records := e.wal.shipped.Bytes()
+	e.replicas = slices.DeleteFunc(e.replicas, func(w io.Writer) bool {
+		if err := e.writeReplica(w, records); err != nil {
+			if e.opts.OnReplicaError != nil {
+				e.opts.OnReplicaError(w, err)
+			}
+			return true
+		}
+		return false
+	})
+	e.wal.shipped.Reset()
+	if len(e.replicas) == 0 {
+		e.wal.shipped = nil
+	}
+}
+
+// batch runs fn in a WAL Batch and ships the records it logged to the
+// replicas once the batch is flushed to the log.
+func (e *Engine) batch(fn func()) error {
+	if err := e.wal.Batch(fn); err != nil {
+		return err
+	}
+	e.shipToReplicas()
+	return nil
+}
+
+// writeReplica writes records to a replica. With BlockOnReplicaError set, a
+// failed write is retried with exponential backoff until it succeeds,
+// stalling the engine meanwhile; otherwise the error is returned.
+func (e *Engine) writeReplica(w io.Writer, records []byte) error {
+	backoff := time.Millisecond
+	for {
+		n, err := w.Write(records)
+		if err == nil {
+			return nil
+		}
+		if !e.opts.BlockOnReplicaError {
+			return err
+		}
+		records = records[n:]
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBusyBackoff)
+	}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplicaConverges(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	e.Execute(`INSERT (x, 1) INTO gone`)
+
+	followerLog := filepath.Join(t.TempDir(), "follower.log")
+	stream, err := os.Create(followerLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddReplica(stream); err != nil {
+		t.Fatalf("AddReplica: %v", err)
+	}
+	e.Execute(`UPDATE t SET (a, 10)`)
+	e.Execute(`BEGIN`)
+	e.Execute(`DELETE b FROM t`)
+	e.Execute(`INSERT (c, 3) INTO t`)
+	e.Execute(`COMMIT`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (lost, 1) INTO t`)
+	e.Execute(`ROLLBACK`)
+	e.Execute(`DROP gone`)
+	e.Execute(`CREATE TABLE empty`)
+	e.Execute(`APPEND (c, 0) IN t`)
+	stream.Close()
+
+	follower := NewEngine(followerLog)
+	defer follower.Close()
+	for _, cmd := range []string{`SHOW TABLES`, `SELECT * FROM t`, `SELECT * FROM empty`, `SELECT * FROM gone`} {
+		if want, got := e.Execute(cmd), follower.Execute(cmd); got != want {
+			t.Errorf("%s:\nexpected %q\ngot      %q", cmd, want, got)
+		}
+	}
+}
+
+// failingWriter fails the next failures writes, then records what it is given.
+type failingWriter struct {
+	failures int
+	written  []byte
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.failures > 0 {
+		w.failures--
+		return 0, errors.New("replica unavailable")
+	}
+	w.written = append(w.written, p...)
+	return len(p), nil
+}
+
+func TestReplicaWriteErrors(t *testing.T) {
+	e := setupTestEngine(t)
+	var reported error
+	e.opts.OnReplicaError = func(_ io.Writer, err error) { reported = err }
+	dropped := &failingWriter{}
+	if err := e.AddReplica(dropped); err != nil {
+		t.Fatalf("AddReplica: %v", err)
+	}
+	dropped.failures = 1
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`INSERT (b, 2) INTO t`)
+	if len(dropped.written) != 0 || len(e.replicas) != 0 {
+		t.Errorf("expected the failing replica to be dropped, it received %q", dropped.written)
+	}
+	if reported == nil || reported.Error() != "replica unavailable" {
+		t.Errorf("expected the write error to be reported, got %v", reported)
+	}
+
+	e.opts.BlockOnReplicaError = true
+	blocked := &failingWriter{}
+	if err := e.AddReplica(blocked); err != nil {
+		t.Fatalf("AddReplica: %v", err)
+	}
+	blocked.failures = 2
+	e.Execute(`INSERT (c, 3) INTO t`)
+	expected := "SET t a 1\nSET t b 2\nSET t c 3\n"
+	if string(blocked.written) != expected {
+		t.Errorf("expected the blocking replica to receive %q, got %q", expected, blocked.written)
+	}
+
+	e.Execute(`BEGIN`)
+	if err := e.AddReplica(&failingWriter{}); !errors.Is(err, ErrTxActive) {
+		t.Errorf("expected ErrTxActive during a transaction, got %v", err)
+	}
+}
+
+// logCheckingWriter fails the test if a record it is given is not in the
+// primary's log file yet.
+type logCheckingWriter struct {
+	t    *testing.T
+	path string
+}
+
+func (w *logCheckingWriter) Write(p []byte) (int, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, p) {
+		w.t.Errorf("replica received %q before it was in the log", p)
+	}
+	return len(p), nil
+}
+
+func TestReplicaShippedAfterBatchIsFlushed(t *testing.T) {
+	e := setupTestEngine(t)
+	if err := e.AddReplica(&logCheckingWriter{t: t, path: "test_wal.log"}); err != nil {
+		t.Fatalf("AddReplica: %v", err)
+	}
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`APPEND (a, 2), (b, 3) IN t`)
+	e.Execute(`GENERATE 100 INTO big`)
+	if resp := e.InsertBatch("t", []KeyValue{{"c", "4"}}); resp != "Inserted 1 key(s) into table 't'" {
+		t.Errorf("Unexpected InsertBatch response: %q", resp)
+	}
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runScript executes the statements of the script file named by s, stopping
+// at the first one that fails. The whole file is parsed first, so a syntax
+// error anywhere means nothing is executed. With s.Atomic the statements run
+// inside one transaction that is committed only if all of them succeed.
+// Results of individual statements, such as SELECT rows, are not returned.
+func (e *Engine) runScript(s *RunStatement) string {
+	input, err := os.ReadFile(s.Path)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	statements, err := parseScript(string(input), e.parse)
+	if err != nil {
+		return fmt.Sprintf("Error: '%s' was not run: %v", s.Path, err)
+	}
+	for _, st := range statements {
+		switch st.stmt.(type) {
+		case *RunStatement:
+			return fmt.Sprintf("Error: '%s' line %d: RUN is not allowed inside a script", s.Path, st.line)
+		case *BeginStatement, *CommitStatement, *RollbackStatement:
+			if s.Atomic {
+				return fmt.Sprintf("Error: '%s' line %d: %s is not allowed in a RUN ATOMIC script", s.Path, st.line, st.stmt.StmtType())
+			}
+		}
+	}
+
+	if !s.Atomic {
+		for i, st := range statements {
+			if resp := e.execute(st.text, st.stmt); statementFailed(st.stmt, resp) {
+				return fmt.Sprintf("Error: '%s' statement %d (line %d) failed after %d statement(s) ran: %s", s.Path, i+1, st.line, i, resp)
+			}
+		}
+		return fmt.Sprintf("Ran %d statement(s) from '%s'", len(statements), s.Path)
+	}
+
+	if e.currentTxID != "" {
+		return "Error: RUN ATOMIC is not allowed inside a transaction."
+	}
+	e.execute("BEGIN", &BeginStatement{})
+	for i, st := range statements {
+		if resp := e.execute(st.text, st.stmt); statementFailed(st.stmt, resp) {
+			e.rollback()
+			return fmt.Sprintf("Error: '%s' statement %d (line %d) failed: %s; no changes were applied", s.Path, i+1, st.line, resp)
+		}
+	}
+	txID := e.currentTxID
+	if err := e.commit(); err != nil {
+		e.rollback()
+		return fmt.Sprintf("Error: '%s' could not be committed, so its transaction was rolled back: %v", s.Path, err)
+	}
+	return fmt.Sprintf("Ran %d statement(s) from '%s' in transaction %s", len(statements), s.Path, txID)
+}
+
+// statementFailed reports whether the response execute returned for stmt
+// describes a failure. Reads never fail a script: their output is rows that
+// could look like anything, and they change nothing.
+func statementFailed(stmt Statement, resp string) bool {
+	switch stmt.(type) {
+	case *SelectStatement, *ExplainStatement, *ShowTablesStatement, *ShowTableSizesStatement:
+		return false
+	}
+	return strings.HasPrefix(resp, "Error") || strings.HasPrefix(resp, "Parse error: ") ||
+		strings.HasPrefix(resp, "unsupported statement") ||
+		(strings.HasPrefix(resp, "Table '") && (strings.HasSuffix(resp, "' not found") || strings.Contains(resp, "marked for drop")))
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeScript writes a script file for RUN and returns its path.
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "batch.sql")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunAtomicRollsBackOnFailure(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+
+	path := writeScript(t, "INSERT (b, 2) INTO t;\nUPDATE t SET (a, 10);\nUPDATE missing SET (x, 1);\nINSERT (c, 3) INTO t;\n")
+	resp := e.Execute("RUN '" + path + "' ATOMIC")
+	if !strings.HasPrefix(resp, "Error: ") || !strings.Contains(resp, "statement 3 (line 3)") {
+		t.Fatalf("Expected the third statement to fail, got %q", resp)
+	}
+	if got := e.Execute(`SELECT * FROM t`); got != "a: 1" {
+		t.Errorf("Expected the table to be unchanged, got %q", got)
+	}
+	if resp := e.Execute(`BEGIN`); !strings.HasPrefix(resp, "Transaction started") {
+		t.Errorf("Expected no transaction to be left open, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	// Nothing of the failed script is replayed after a restart
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if got := e.Execute(`SELECT * FROM t`); got != "a: 1" {
+		t.Errorf("Expected the table to be unchanged after a restart, got %q", got)
+	}
+}
+
+func TestRunAtomicCommits(t *testing.T) {
+	e := setupTestEngine(t)
+
+	path := writeScript(t, "INSERT (a, 1), (b, 2) INTO t;\nSELECT * FROM t;\nDELETE b FROM t;\n")
+	resp := e.Execute("RUN '" + path + "' ATOMIC;")
+	if !strings.HasPrefix(resp, "Ran 3 statement(s)") {
+		t.Fatalf("Expected the script to run, got %q", resp)
+	}
+	if got := e.Execute(`SELECT * FROM t`); got != "a: 1" {
+		t.Errorf("Expected the script's changes to be committed, got %q", got)
+	}
+
+	for _, script := range []string{"INSERT (x, 1) INTO t;\nCOMMIT;", "INSERT (x, 1) INTO t;\nSELEC * FROM t;"} {
+		if resp := e.Execute("RUN '" + writeScript(t, script) + "' ATOMIC"); !strings.HasPrefix(resp, "Error: ") {
+			t.Errorf("Expected script %q to be rejected, got %q", script, resp)
+		}
+	}
+	if got := e.Execute(`SELECT x FROM t`); strings.Contains(got, "x: 1") {
+		t.Errorf("Expected rejected scripts not to run, got %q", got)
+	}
+}
+
+func TestRunAtomicCommitFailure(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{NewTxID: sequentialTxIDs()})
+	e.wal.syncer = &fakeSyncer{err: errors.New("disk full")}
+
+	path := writeScript(t, "INSERT (a, 1) INTO t;\n")
+	expected := "Error: '" + path + "' could not be committed, so its transaction was rolled back: disk full"
+	if resp := e.Execute("RUN '" + path + "' ATOMIC"); resp != expected {
+		t.Errorf("Expected the failed COMMIT to be reported, got %q", resp)
+	}
+	if e.currentTxID != "" {
+		t.Errorf("Expected no transaction to be left open, got %s", e.currentTxID)
+	}
+	if got := e.Execute(`SELECT * FROM t`); got != "Table 't' not found" {
+		t.Errorf("Expected nothing to be applied, got %q", got)
+	}
+}
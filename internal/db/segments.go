@@ -0,0 +1,318 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrPerTableLogs is returned by the log operations that need a single log
+// file, such as AS OF SEQUENCE reads, Vacuum and Seal, on a per-table log.
+var ErrPerTableLogs = errors.New("not supported with per-table logs")
+
+// commitLogName is the file in a per-table log's directory that decides
+// which transactions committed; segmentDirName is the subdirectory holding
+// one segment file per table.
+const (
+	commitLogName  = "commits.log"
+	segmentDirName = "tables"
+)
+
+// tableSegments is the per-table part of a log opened with OpenPerTableWAL.
+// Each table's records go to its own segment file, in the same format as a
+// single-file log. A transaction's records in a segment are preceded by a
+// BEGIN_TX and followed by a COMMIT_TX or ROLLBACK_TX, so every segment can
+// be replayed on its own; the COMMIT_TX in the commit log, written after
+// the segments are synced, decides whether the transaction committed.
+type tableSegments struct {
+	dir      string                         // the segment directory
+	files    map[string]*os.File            // open segment files by table
+	txTables map[string]map[string]struct{} // txID -> tables whose segment has a BEGIN_TX for it
+	err      error                          // first write error since the last sync
+}
+
+// OpenPerTableWAL opens a log laid out as a directory: every table's records
+// go to a segment file of their own under dir/tables, next to a commit log
+// dir/commits.log that records which transactions committed. Dropping or
+// compacting a table then only rewrites that table's segment, and Replay
+// loads the segments in parallel. The directory is created if needed and
+// locked like OpenWAL locks a single-file log.
+//
+// Operations that rely on the order of records across tables, namely
+// PrepareTx, batches (BeginBatch), Vacuum, Seal, LastValue, KeyVersions and
+// ReplayUpTo, fail with ErrPerTableLogs or must not be used.
+func OpenPerTableWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(filepath.Join(dir, segmentDirName), 0755); err != nil {
+		return nil, err
+	}
+	w, err := OpenWAL(filepath.Join(dir, commitLogName))
+	if err != nil {
+		return nil, err
+	}
+	w.segments = &tableSegments{
+		dir:      filepath.Join(dir, segmentDirName),
+		files:    make(map[string]*os.File),
+		txTables: make(map[string]map[string]struct{}),
+	}
+	return w, nil
+}
+
+// segmentPath returns the path of table's segment. Table names are escaped,
+// so any name maps to a single file in the segment directory.
+func (s *tableSegments) segmentPath(table string) string {
+	return filepath.Join(s.dir, url.PathEscape(table)+".log")
+}
+
+// segmentTable returns the table whose segment file is called name, or false
+// if name is not a segment file.
+func segmentTable(name string) (string, bool) {
+	escaped, ok := strings.CutSuffix(name, ".log")
+	if !ok {
+		return "", false
+	}
+	table, err := url.PathUnescape(escaped)
+	return table, err == nil
+}
+
+// file returns table's segment file, opening it on first use.
+func (s *tableSegments) file(table string) (*os.File, error) {
+	if f, ok := s.files[table]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(s.segmentPath(table), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[table] = f
+	return f, nil
+}
+
+// write appends a record of table logged under txID, which is empty for
+// autocommit, to the table's segment, preceded by a BEGIN_TX for txID if
+// it is the transaction's first record there. A write error is kept for
+// the next sync to report.
+func (s *tableSegments) write(txID, table, format string, args ...any) {
+	f, err := s.file(table)
+	if err == nil && txID != "" {
+		if _, begun := s.txTables[txID][table]; !begun {
+			if s.txTables[txID] == nil {
+				s.txTables[txID] = make(map[string]struct{})
+			}
+			s.txTables[txID][table] = struct{}{}
+			_, err = fmt.Fprintf(f, "BEGIN_TX %s\n", txID)
+		}
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(f, format, args...)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// end writes record, a COMMIT_TX or ROLLBACK_TX, to the segment of every
+// table the transaction txID has records in.
+func (s *tableSegments) end(txID, record string) {
+	for table := range s.txTables[txID] {
+		f, err := s.file(table)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%s %s\n", record, txID)
+		}
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	delete(s.txTables, txID)
+}
+
+// rollback ends the transaction txID in its segments without committing it.
+func (s *tableSegments) rollback(txID string) {
+	s.end(txID, "ROLLBACK_TX")
+}
+
+// sync reports the first write error since the last sync, then syncs every
+// open segment to stable storage.
+func (s *tableSegments) sync() error {
+	if err := s.err; err != nil {
+		s.err = nil
+		return err
+	}
+	for _, f := range s.files {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// size returns the total size of the segment files.
+func (s *tableSegments) size() (int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		if _, ok := segmentTable(entry.Name()); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// forget closes table's segment file, if open, so the next write reopens
+// the file at its path.
+func (s *tableSegments) forget(table string) error {
+	f, ok := s.files[table]
+	if !ok {
+		return nil
+	}
+	delete(s.files, table)
+	return f.Close()
+}
+
+// close closes every open segment file.
+func (s *tableSegments) close() error {
+	var err error
+	for table := range s.files {
+		if closeErr := s.forget(table); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// commitSegments commits txID in a per-table log: it ends the transaction
+// in its segments and syncs them, then logs the COMMIT_TX in the commit log
+// and syncs that. A crash before the second sync leaves the transaction
+// uncommitted in every segment alike.
+func (w *WAL) commitSegments(txID string) error {
+	w.segments.end(txID, "COMMIT_TX")
+	if err := w.segments.sync(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "COMMIT_TX %s\n", txID)
+	w.ship("COMMIT_TX %s\n", txID)
+	return w.syncer.Sync()
+}
+
+// replaySegments is replay for a per-table log. It reads the committed
+// transactions from the commit log, then replays every segment in its own
+// goroutine. ReplayStats count per segment, so a transaction that spans
+// several tables is counted once for each of them.
+func (w *WAL) replaySegments(progress func(bytesRead, totalBytes int64)) (map[string][][2]string, ReplayStats, error) {
+	var stats ReplayStats
+	commits := make(map[string]struct{})
+	err := w.scanLines(func(line string) {
+		if parts, ok := splitWALFields(line); ok && len(parts) == 2 && strings.ToUpper(parts[0]) == "COMMIT_TX" {
+			commits[parts[1]] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+
+	entries, err := os.ReadDir(w.segments.dir)
+	if err != nil {
+		return nil, stats, err
+	}
+	var totalBytes, bytesRead int64
+	sizes := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		if _, ok := segmentTable(entry.Name()); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, stats, err
+		}
+		sizes[entry.Name()] = info.Size()
+		totalBytes += info.Size()
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	result := make(map[string][][2]string)
+	for name, size := range sizes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tables, segmentStats, _, err := replayFile(filepath.Join(w.segments.dir, name), nil, 0, commits)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("segment %s: %w", name, err)
+				}
+				return
+			}
+			for table, kvs := range tables {
+				result[table] = kvs
+			}
+			stats.StrayCommits += segmentStats.StrayCommits
+			stats.DuplicateCommits += segmentStats.DuplicateCommits
+			stats.IncompleteTxs += segmentStats.IncompleteTxs
+			bytesRead += size
+			if progress != nil {
+				progress(bytesRead, totalBytes)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, stats, firstErr
+	}
+	return result, stats, nil
+}
+
+// compactSegments is Compact for a per-table log. Every table in names gets
+// a segment holding only its entries, the segments of other tables are
+// removed, and the commit log, which the new segments no longer need, is
+// emptied last, so a crash part way leaves every segment replayable.
+func (w *WAL) compactSegments(names []string, tables map[string][][2]string) error {
+	s := w.segments
+	keep := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		keep[name] = struct{}{}
+		err := replaceFile(s.segmentPath(name), func(out *bufio.Writer) error {
+			writeSnapshot(out, []string{name}, tables)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.forget(name); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		table, ok := segmentTable(entry.Name())
+		if _, kept := keep[table]; !ok || kept {
+			continue
+		}
+		if err := s.forget(table); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	clear(s.txTables)
+	return w.rewrite(func(*bufio.Writer) error { return nil })
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupPerTableEngine returns an engine with PerTableLogs over a fresh
+// directory, and the directory.
+func setupPerTableEngine(t *testing.T) (*Engine, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "db")
+	e := NewEngineWithOptions(dir, EngineOptions{PerTableLogs: true, NewTxID: sequentialTxIDs()})
+	t.Cleanup(func() { _ = e.Close() })
+	return e, dir
+}
+
+// segmentContents returns the contents of table's segment in dir.
+func segmentContents(t *testing.T, dir, table string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, segmentDirName, table+".log"))
+	if err != nil {
+		t.Fatalf("Reading the segment of %s: %v", table, err)
+	}
+	return string(data)
+}
+
+func TestPerTableLogsSeparateFiles(t *testing.T) {
+	e, dir := setupPerTableEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t1`)
+	e.Execute(`INSERT (b, 2) INTO t2`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (c, 3) INTO t1`)
+	e.Execute(`INSERT (d, 4) INTO t2`)
+	e.Execute(`COMMIT`)
+
+	tests := []struct {
+		table    string
+		expected string
+	}{
+		{"t1", "SET t1 a 1\nBEGIN_TX tx_1\nSET tx_1 t1 c 3\nCOMMIT_TX tx_1\n"},
+		{"t2", "SET t2 b 2\nBEGIN_TX tx_1\nSET tx_1 t2 d 4\nCOMMIT_TX tx_1\n"},
+	}
+	for _, tt := range tests {
+		if got := segmentContents(t, dir, tt.table); got != tt.expected {
+			t.Errorf("Segment of %s:\nexpected %q\ngot      %q", tt.table, tt.expected, got)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, commitLogName))
+	if err != nil || string(data) != "COMMIT_TX tx_1\n" {
+		t.Errorf("Expected only the commit in the commit log, got %q (%v)", data, err)
+	}
+
+	e.Close()
+	e = NewEngineWithOptions(dir, EngineOptions{PerTableLogs: true})
+	defer e.Close()
+	for query, expected := range map[string]string{
+		`SELECT * FROM t1`: "a: 1\nc: 3",
+		`SELECT * FROM t2`: "b: 2\nd: 4",
+	} {
+		if resp := e.Execute(query); resp != expected {
+			t.Errorf("After restart, %s:\nexpected %q\ngot      %q", query, expected, resp)
+		}
+	}
+}
+
+func TestPerTableLogsReplaySkipsUncommitted(t *testing.T) {
+	e, dir := setupPerTableEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (b, 2) INTO t`)
+	e.Execute(`ROLLBACK`)
+	e.Close()
+
+	// A transaction committed in its segment whose commit never reached the
+	// commit log, as after a crash between the two syncs
+	f, err := os.OpenFile(filepath.Join(dir, segmentDirName, "t.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("BEGIN_TX tx_9\nSET tx_9 t c 3\nCOMMIT_TX tx_9\n")
+	f.Close()
+
+	e = NewEngineWithOptions(dir, EngineOptions{PerTableLogs: true})
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM t`); resp != "a: 1" {
+		t.Errorf("Expected only the committed row, got %q", resp)
+	}
+	if stats := e.RecoveryStats(); stats.IncompleteTxs != 1 {
+		t.Errorf("Expected the cut-off transaction to count as incomplete, got %+v", stats)
+	}
+}
+
+func TestPerTableLogsDropAndCheckpoint(t *testing.T) {
+	e, dir := setupPerTableEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t1`)
+	e.Execute(`INSERT (x, 1) INTO t2`)
+	e.Execute(`DROP t2`)
+	if got := segmentContents(t, dir, "t1"); got != "SET t1 a 1\nSET t1 b 2\n" {
+		t.Errorf("Expected DROP to leave t1's segment alone, got %q", got)
+	}
+	if got := segmentContents(t, dir, "t2"); !strings.HasSuffix(got, "DROP TABLE t2\n") {
+		t.Errorf("Expected the DROP in t2's segment, got %q", got)
+	}
+
+	e.Execute(`DELETE a FROM t1`)
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if got := segmentContents(t, dir, "t1"); got != "SET t1 b 2\n" {
+		t.Errorf("Expected a compacted segment, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, segmentDirName, "t2.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the dropped table's segment to be removed, got %v", err)
+	}
+
+	// Writes after the checkpoint go to the new segment
+	e.Execute(`INSERT (c, 3) INTO t1`)
+	e.Close()
+	e = NewEngineWithOptions(dir, EngineOptions{PerTableLogs: true})
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM t1`); resp != "b: 2\nc: 3" {
+		t.Errorf("Unexpected t1 after restart: %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM t2`); resp != "Table 't2' not found" {
+		t.Errorf("Expected t2 to stay dropped, got %q", resp)
+	}
+}
+
+func TestPerTableLogsUnsupported(t *testing.T) {
+	e, _ := setupPerTableEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`BEGIN`)
+	if resp := e.Execute(`PREPARE TRANSACTION 'g'`); !strings.HasPrefix(resp, "Error: ") {
+		t.Errorf("Expected PREPARE TRANSACTION to fail, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+	if _, err := e.Vacuum(); !errors.Is(err, ErrPerTableLogs) {
+		t.Errorf("Expected Vacuum to fail with ErrPerTableLogs, got %v", err)
+	}
+	if err := e.SealLog(); !errors.Is(err, ErrPerTableLogs) {
+		t.Errorf("Expected SealLog to fail with ErrPerTableLogs, got %v", err)
+	}
+}
@@ -0,0 +1,791 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// resultRow is a single key/value pair produced by a SELECT.
+type resultRow struct {
+	Key    string
+	Value  string
+	FromTx bool // value is buffered in the current transaction
+}
+
+// executeSelect runs a SELECT against the data visible to the current
+// transaction, or the committed data outside one.
+func (e *Engine) executeSelect(s *SelectStatement) string {
+	if s.AsOf > 0 {
+		return e.selectAsOf(s)
+	}
+	if s.PrefixSep != "" {
+		count, err := e.countDistinctPrefixes(s)
+		if err != nil {
+			return err.Error()
+		}
+		return strconv.Itoa(count)
+	}
+	if s.Aggregate == "LCP" {
+		prefix, err := e.longestCommonPrefix(s)
+		if err != nil {
+			return err.Error()
+		}
+		return prefix
+	}
+	if s.Aggregate == "BUCKETS" {
+		result, err := e.keyBuckets(s)
+		if err != nil {
+			return err.Error()
+		}
+		return result
+	}
+	if s.Aggregate != "" {
+		result, err := e.aggregate(s)
+		if err != nil {
+			return err.Error()
+		}
+		return result
+	}
+	rows, err := e.selectRows(s)
+	if err != nil {
+		return err.Error()
+	}
+	return e.renderRows(rows, s.Format)
+}
+
+// streamRows calls fn for every row matched by s's WHERE in key order,
+// stopping at the first error or when the statement's context (see
+// ExecuteContext) is canceled. Over a table it streams straight from the
+// tree, so only the running state kept by fn uses memory; a view's rows are
+// collected first.
+func (e *Engine) streamRows(s *SelectStatement, fn func(key, value string) error) error {
+	if _, droppedInTx := e.txDroppedTables[s.Table]; droppedInTx {
+		return fmt.Errorf("Table '%s' dropped within this transaction", s.Table)
+	}
+	if e.isView(s.Table) {
+		rows, err := e.selectRows(&SelectStatement{Table: s.Table, Where: s.Where})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := e.canceled(); err != nil {
+				return err
+			}
+			if err := fn(row.Key, row.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	match, err := e.compilePredicate(s.Table, s.Where)
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	exists := e.scanTable(s, func(key, value string, fromTx bool) bool {
+		if fnErr = e.canceled(); fnErr != nil {
+			return false
+		}
+		if match(key, value) {
+			fnErr = fn(key, value)
+		}
+		return fnErr == nil
+	})
+	if !exists {
+		return fmt.Errorf("Table '%s' not found", s.Table)
+	}
+	return fnErr
+}
+
+// canceled returns an error once the context of the statement being executed
+// is done, and nil otherwise.
+func (e *Engine) canceled() error {
+	if e.ctx == nil {
+		return nil
+	}
+	if err := e.ctx.Err(); err != nil {
+		return fmt.Errorf("Error: query canceled: %v", err)
+	}
+	return nil
+}
+
+// countDistinctPrefixes counts the distinct key prefixes up to the first
+// s.PrefixSep among the rows matched by s, in a single pass over the table.
+// A key without the separator counts as its own prefix.
+func (e *Engine) countDistinctPrefixes(s *SelectStatement) (int, error) {
+	prefixes := make(map[string]struct{})
+	err := e.streamRows(s, func(key, _ string) error {
+		prefix, _, _ := strings.Cut(key, s.PrefixSep)
+		prefixes[prefix] = struct{}{}
+		return nil
+	})
+	return len(prefixes), err
+}
+
+// longestCommonPrefix returns the longest common prefix of the keys matched
+// by s, narrowing it with each key in a single pass. It is "" if the keys
+// share no prefix and "No results" if no key matches.
+func (e *Engine) longestCommonPrefix(s *SelectStatement) (string, error) {
+	var prefix string
+	first := true
+	err := e.streamRows(s, func(key, _ string) error {
+		if first {
+			prefix, first = key, false
+			return nil
+		}
+		n := 0
+		for n < len(prefix) && n < len(key) && prefix[n] == key[n] {
+			n++
+		}
+		for n > 0 && n < len(prefix) && !utf8.RuneStart(prefix[n]) {
+			n-- // Never split a multi-byte character
+		}
+		prefix = prefix[:n]
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if first {
+		return "No results", nil
+	}
+	return prefix, nil
+}
+
+// keyBuckets splits the keys matched by s, in key order, into s.Buckets
+// ranges whose counts differ by at most one, as a guide for sharding or
+// range partitioning. The keys are counted in a first pass and the bucket
+// boundaries emitted in a second, so only the current bucket is held in
+// memory. Each bucket is reported as "<first> .. <last>: <count>"; with
+// fewer keys than buckets, only the non-empty buckets are listed.
+func (e *Engine) keyBuckets(s *SelectStatement) (string, error) {
+	total := 0
+	if err := e.streamRows(s, func(string, string) error {
+		total++
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if total == 0 {
+		return "No results", nil
+	}
+
+	// Bucket i holds keys number i*total/Buckets up to (i+1)*total/Buckets;
+	// the buckets left empty when there are fewer keys than buckets are skipped
+	bucket, seen := 0, 0
+	end := func() int { return (bucket + 1) * total / s.Buckets }
+	for end() == 0 {
+		bucket++
+	}
+	var lines []string
+	var first string
+	start := 0
+	err := e.streamRows(s, func(key, _ string) error {
+		if seen == start {
+			first = key
+		}
+		seen++
+		if seen == end() {
+			lines = append(lines, fmt.Sprintf("%s .. %s: %d", first, key, seen-start))
+			start = seen
+			for bucket < s.Buckets-1 && end() == seen {
+				bucket++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// aggregate computes COUNT(*), SUM(value) or AVG(value) over the rows matched
+// by s with running totals, in constant memory. COUNT is reported as
+// "count: N" for quick sanity checks; SUM of integers is exact and
+// printed as an integer; once a value has a fractional part, or the integer
+// sum would overflow an int64, the sum is a float. Values that are not
+// numbers make SUM and AVG fail.
+func (e *Engine) aggregate(s *SelectStatement) (string, error) {
+	var count, intSum int64
+	var floatSum float64
+	allInts := true
+	err := e.streamRows(s, func(key, value string) error {
+		count++
+		if s.Aggregate == "COUNT" {
+			return nil
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			if next := intSum + n; (n > 0 && next < intSum) || (n < 0 && next > intSum) {
+				allInts = false // The exact sum would overflow, so report the float sum
+			} else {
+				intSum = next
+			}
+			floatSum += float64(n)
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("Error: value of key '%s' is not a number: %q", key, value)
+		}
+		allInts = false
+		floatSum += f
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case s.Aggregate == "COUNT":
+		return fmt.Sprintf("count: %d", count), nil
+	case s.Aggregate == "SUM" && allInts:
+		return strconv.FormatInt(intSum, 10), nil
+	case s.Aggregate == "SUM":
+		return strconv.FormatFloat(floatSum, 'g', -1, 64), nil
+	case count == 0:
+		return "No results", nil
+	default:
+		return strconv.FormatFloat(floatSum/float64(count), 'g', -1, 64), nil
+	}
+}
+
+// scanRange returns the key range a SELECT over a whole table can be
+// narrowed to, from a WHERE key STARTS WITH or key BETWEEN predicate: the
+// first key to seek to and whether a key at or after it is still in range.
+// ok is false for other predicates, which are evaluated on every row of a
+// full scan.
+func scanRange(s *SelectStatement) (start string, inRange func(key string) bool, ok bool) {
+	if s.Where == nil || s.Where.Field != "KEY" {
+		return "", nil, false
+	}
+	switch p := s.Where; p.Op {
+	case "STARTS WITH":
+		return p.Operand, func(key string) bool { return strings.HasPrefix(key, p.Operand) }, true
+	case "BETWEEN":
+		return p.Operand, func(key string) bool { return key <= p.High }, true
+	}
+	return "", nil, false
+}
+
+// estimatedRows returns " ~n rows" with the number of committed keys in
+// the key range of a range scan, from the tree's subtree sizes rather than
+// a scan, or "" if the table has no tree yet.
+func (e *Engine) estimatedRows(s *SelectStatement) string {
+	tree, ok := e.tables[s.Table]
+	if !ok {
+		return ""
+	}
+	var n int
+	if p := s.Where; p.Op == "BETWEEN" {
+		n = tree.EstimateRangeCount(p.Operand, p.High)
+	} else if end := prefixEnd(p.Operand); end == "" {
+		n = tree.Count() - tree.countBelow(p.Operand, false)
+	} else {
+		n = tree.countBelow(end, false) - tree.countBelow(p.Operand, false)
+	}
+	return fmt.Sprintf(" ~%d rows", n)
+}
+
+// prefixEnd returns the smallest string greater than every string that
+// starts with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// scanTable walks the rows of s.Table that s can match, like scanVisible.
+// With a key range it is a range scan: it seeks to the start of the range
+// and stops at the first key past it. With AFTER it seeks to the AFTER key
+// instead when that is further along; the rows up to and including it are
+// dropped by rowFilter.
+func (e *Engine) scanTable(s *SelectStatement, fn func(key, value string, fromTx bool) bool) bool {
+	rangeStart, inRange, hasRange := scanRange(s)
+	start := max(rangeStart, s.After)
+	if !hasRange && start == "" {
+		return e.scanVisible(s.Table, fn)
+	}
+	return e.scanVisibleFrom(s.Table, start, func(key, value string, fromTx bool) bool {
+		if hasRange && !inRange(key) {
+			return false
+		}
+		return fn(key, value, fromTx)
+	})
+}
+
+// explainSelect describes how a SELECT would be executed, one step per
+// line, without running it. A view's own query is shown indented below it.
+func (e *Engine) explainSelect(s *SelectStatement) string {
+	var lines []string
+	if s.AsOf > 0 {
+		lines = append(lines, fmt.Sprintf("REPLAY LOG TO SEQUENCE %d", s.AsOf))
+	}
+	view, isView, err := e.lookupView(s.Table)
+	if err != nil {
+		return err.Error()
+	}
+	_, _, rangeScan := scanRange(s)
+	rangeScan = rangeScan && !isView && len(s.Keys) == 0
+	switch {
+	case isView:
+		lines = append(lines, fmt.Sprintf("VIEW %s", s.Table))
+	case len(s.Keys) > 0:
+		lines = append(lines, fmt.Sprintf("KEY LOOKUP %s (%d keys)", s.Table, len(s.Keys)))
+	case rangeScan && s.Where.Op == "BETWEEN":
+		lines = append(lines, fmt.Sprintf("RANGE SCAN %s (key BETWEEN '%s' AND '%s')%s", s.Table, s.Where.Operand, s.Where.High, e.estimatedRows(s)))
+	case rangeScan:
+		lines = append(lines, fmt.Sprintf("PREFIX SCAN %s (key STARTS WITH '%s')%s", s.Table, s.Where.Operand, e.estimatedRows(s)))
+	default:
+		lines = append(lines, fmt.Sprintf("FULL SCAN %s", s.Table))
+	}
+	if isView && len(s.Keys) > 0 {
+		lines = append(lines, fmt.Sprintf("KEYS %s", strings.Join(s.Keys, ", ")))
+	}
+	if s.After != "" {
+		if isView || len(s.Keys) > 0 {
+			lines = append(lines, fmt.Sprintf("FILTER key > '%s'", s.After))
+		} else {
+			lines = append(lines, fmt.Sprintf("SEEK AFTER '%s'", s.After))
+		}
+	}
+	if s.Where != nil && !rangeScan { // A range scan already applies its predicate
+		if strings.HasPrefix(s.Where.Op, "IS ") {
+			lines = append(lines, fmt.Sprintf("FILTER %s %s", strings.ToLower(s.Where.Field), s.Where.Op))
+		} else if s.Where.Op == "BETWEEN" {
+			lines = append(lines, fmt.Sprintf("FILTER %s BETWEEN '%s' AND '%s'", strings.ToLower(s.Where.Field), s.Where.Operand, s.Where.High))
+		} else {
+			lines = append(lines, fmt.Sprintf("FILTER %s %s '%s'", strings.ToLower(s.Where.Field), s.Where.Op, s.Where.Operand))
+		}
+	}
+	if s.Where != nil && s.Where.Op == "IS DUPLICATED" && s.Top == 0 && s.Aggregate == "" {
+		lines = append(lines, "GROUP BY value")
+	}
+	if s.DistinctOnValue {
+		lines = append(lines, "DISTINCT ON value")
+	}
+	if s.PrefixSep != "" {
+		lines = append(lines, fmt.Sprintf("COUNT DISTINCT PREFIX '%s'", s.PrefixSep))
+	}
+	switch s.Aggregate {
+	case "COUNT":
+		lines = append(lines, "AGGREGATE COUNT(*)")
+	case "SUM", "AVG":
+		lines = append(lines, fmt.Sprintf("AGGREGATE %s(value)", s.Aggregate))
+	case "LCP":
+		lines = append(lines, "AGGREGATE LCP(key)")
+	case "BUCKETS":
+		lines = append(lines, fmt.Sprintf("AGGREGATE BUCKETS %d(key)", s.Buckets))
+	}
+	if s.Every > 0 {
+		lines = append(lines, fmt.Sprintf("EVERY %d", s.Every))
+	}
+	if s.Limit > 0 {
+		lines = append(lines, fmt.Sprintf("LIMIT %d", s.Limit))
+	}
+	if s.Top > 0 {
+		direction := "DESC"
+		if s.TopAsc {
+			direction = "ASC"
+		}
+		lines = append(lines, fmt.Sprintf("TOP %d BY value %s", s.Top, direction))
+	}
+	if s.ValueFunc != "" {
+		lines = append(lines, fmt.Sprintf("TRANSFORM %s(value)", s.ValueFunc))
+	}
+	if s.Segment > 0 {
+		lines = append(lines, fmt.Sprintf("PROJECT SEGMENT %d OF key DELIMITER '%s'", s.Segment, s.SegmentSep))
+	}
+	if isView {
+		for _, line := range strings.Split(e.explainSelect(view), "\n") {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// explainKey shows the nodes of table's committed tree that a lookup of key
+// visits, one line per level from the root down, with the child taken at
+// each internal node and whether the leaf holds the key.
+func (e *Engine) explainKey(key, table string) string {
+	tree, ok := e.tables[table]
+	if !ok || isReservedTable(table) {
+		return fmt.Sprintf("Table '%s' not found", table)
+	}
+	var lines []string
+	for depth, step := range tree.Path(key) {
+		indent := strings.Repeat("  ", depth)
+		if !step.Leaf {
+			lines = append(lines, fmt.Sprintf("%sNODE %q -> child %d", indent, step.Keys, step.Child))
+			continue
+		}
+		result := "not found"
+		if slices.Contains(step.Keys, key) {
+			result = "found"
+		}
+		lines = append(lines, fmt.Sprintf("%sLEAF %q -> '%s' %s", indent, step.Keys, key, result))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// selectRows collects the rows matched by a SELECT. The error text is the
+// message shown to the user.
+func (e *Engine) selectRows(s *SelectStatement) ([]resultRow, error) {
+	if s.AsOf > 0 {
+		return e.selectRowsAsOf(s)
+	}
+	if view, ok, err := e.lookupView(s.Table); err != nil {
+		return nil, err
+	} else if ok {
+		return e.selectFromView(s, view)
+	}
+	if _, droppedInTx := e.txDroppedTables[s.Table]; droppedInTx {
+		return nil, fmt.Errorf("Table '%s' dropped within this transaction", s.Table)
+	}
+	match, err := e.rowFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []resultRow
+	var exists bool
+	if len(s.Keys) > 0 {
+		exists = e.tableVisible(s.Table)
+		if exists {
+			for _, key := range s.Keys {
+				value, fromTx, ok := e.getVisible(s.Table, key)
+				if ok && !fromTx {
+					e.touchKey(s.Table, key)
+				}
+				if ok && match(key, value) {
+					rows = append(rows, resultRow{Key: key, Value: value, FromTx: fromTx})
+				}
+			}
+		}
+	} else if s.Top > 0 {
+		top := newTopRows(s.Top, s.TopAsc)
+		exists = e.scanTable(s, func(key, value string, fromTx bool) bool {
+			if match(key, value) {
+				top.offer(resultRow{Key: key, Value: value, FromTx: fromTx})
+			}
+			return true
+		})
+		rows = top.sorted()
+	} else {
+		exists = e.scanTable(s, func(key, value string, fromTx bool) bool {
+			if match(key, value) {
+				rows = append(rows, resultRow{Key: key, Value: value, FromTx: fromTx})
+			}
+			// Stop the scan once the page is full, unless the rows are regrouped by value
+			return s.Limit == 0 || len(rows) < s.Limit || (s.Where != nil && s.Where.Op == "IS DUPLICATED")
+		})
+	}
+	if !exists {
+		if e.currentTxID != "" {
+			return nil, nil // A transaction reads a missing table as empty, as it always has
+		}
+		return nil, fmt.Errorf("Table '%s' not found", s.Table)
+	}
+	groupDuplicates(rows, s)
+	rows = limitRows(rows, s.Limit)
+	applyValueFunc(rows, s.ValueFunc)
+	applyKeySegment(rows, s.Segment, s.SegmentSep)
+	return rows, nil
+}
+
+// limitRows truncates rows to at most limit rows; zero means no limit.
+func limitRows(rows []resultRow, limit int) []resultRow {
+	if limit > 0 && len(rows) > limit {
+		return rows[:limit]
+	}
+	return rows
+}
+
+// applyValueFunc replaces each row's value with fn(value) for a SELECT
+// <fn>(value). It only changes the result rows, never stored data.
+func applyValueFunc(rows []resultRow, fn string) {
+	var transform func(string) string
+	switch fn {
+	case "UPPER":
+		transform = strings.ToUpper
+	case "LOWER":
+		transform = strings.ToLower
+	case "LENGTH":
+		transform = func(v string) string { return strconv.Itoa(utf8.RuneCountInString(v)) }
+	case "TRIM":
+		transform = strings.TrimSpace
+	default:
+		return
+	}
+	for i := range rows {
+		rows[i].Value = transform(rows[i].Value)
+	}
+}
+
+// applyKeySegment replaces each row's value with the nth segment of its key
+// for a SELECT SEGMENT n OF key, or "" if the key has fewer than n segments.
+// n is zero when there is no SEGMENT clause.
+func applyKeySegment(rows []resultRow, n int, sep string) {
+	if n == 0 {
+		return
+	}
+	for i := range rows {
+		rows[i].Value = ""
+		if segments := strings.SplitN(rows[i].Key, sep, n+1); len(segments) >= n {
+			rows[i].Value = segments[n-1]
+		}
+	}
+}
+
+// rowFilter returns the filter deciding which rows a SELECT returns, in the
+// order they are visited: its AFTER bound, its WHERE predicate, for
+// DISTINCT ON value dropping rows whose value has already been returned,
+// and for EVERY n keeping only every nth row that got that far.
+func (e *Engine) rowFilter(s *SelectStatement) (func(key, value string) bool, error) {
+	match, err := e.compilePredicate(s.Table, s.Where)
+	if err != nil {
+		return nil, err
+	}
+	if s.After != "" {
+		where := match
+		match = func(key, value string) bool {
+			return key > s.After && where(key, value)
+		}
+	}
+	if s.DistinctOnValue {
+		seen := make(map[string]struct{})
+		distinct := match
+		match = func(key, value string) bool {
+			if !distinct(key, value) {
+				return false
+			}
+			if _, dup := seen[value]; dup {
+				return false
+			}
+			seen[value] = struct{}{}
+			return true
+		}
+	}
+	if s.Every > 1 {
+		matched := 0
+		sampled := match
+		match = func(key, value string) bool {
+			if !sampled(key, value) {
+				return false
+			}
+			matched++
+			return (matched-1)%s.Every == 0
+		}
+	}
+	return match, nil
+}
+
+// compilePredicate turns a WHERE predicate on the rows of table into a row
+// filter. A nil predicate matches every row.
+func (e *Engine) compilePredicate(table string, p *Predicate) (func(key, value string) bool, error) {
+	if p == nil {
+		return func(key, value string) bool { return true }, nil
+	}
+	field := func(key, value string) string {
+		if p.Field == "VALUE" {
+			return value
+		}
+		return key
+	}
+
+	switch p.Op {
+	case "=":
+		return func(key, value string) bool { return field(key, value) == p.Operand }, nil
+	case "LIKE":
+		re, err := likeToRegexp(p.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(key, value string) bool { return re.MatchString(field(key, value)) }, nil
+	case "REGEXP":
+		// Unanchored, like the REGEXP operator of other databases: use ^ and $ to match the whole field
+		re, err := regexp.Compile(p.Operand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REGEXP pattern %q: %v", p.Operand, err)
+		}
+		return func(key, value string) bool { return re.MatchString(field(key, value)) }, nil
+	case "STARTS WITH":
+		return func(key, value string) bool { return strings.HasPrefix(field(key, value), p.Operand) }, nil
+	case "BETWEEN":
+		return func(key, value string) bool {
+			f := field(key, value)
+			return p.Operand <= f && f <= p.High
+		}, nil
+	case "ENDS WITH":
+		return func(key, value string) bool { return strings.HasSuffix(field(key, value), p.Operand) }, nil
+	case "EXISTS IN":
+		return e.existsIn(p.Operand)
+	case "IS EMPTY":
+		return func(key, value string) bool { return field(key, value) == "" }, nil
+	case "IS NOT EMPTY":
+		return func(key, value string) bool { return field(key, value) != "" }, nil
+	case "IS DUPLICATED":
+		counts, err := e.valueCounts(table)
+		if err != nil {
+			return nil, err
+		}
+		return func(key, value string) bool { return counts[value] > 1 }, nil
+	default:
+		return nil, fmt.Errorf("unsupported WHERE operator %s", p.Op)
+	}
+}
+
+// valueCounts counts how many keys of table, which may be a table or a
+// view, hold each value. It is the first pass of IS DUPLICATED.
+func (e *Engine) valueCounts(table string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if view, ok, err := e.lookupView(table); err != nil {
+		return nil, err
+	} else if ok {
+		rows, err := e.selectFromView(&SelectStatement{Table: table}, view)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.Value]++
+		}
+		return counts, nil
+	}
+	if !e.scanTable(&SelectStatement{Table: table}, func(_, value string, _ bool) bool {
+		counts[value]++
+		return true
+	}) {
+		return nil, fmt.Errorf("Table '%s' not found", table)
+	}
+	return counts, nil
+}
+
+// groupDuplicates orders the rows of a WHERE value IS DUPLICATED query by
+// value, keeping key order within each group, so duplicates are listed
+// together. TOP already orders rows by value.
+func groupDuplicates(rows []resultRow, s *SelectStatement) {
+	if s.Where != nil && s.Where.Op == "IS DUPLICATED" && s.Top == 0 {
+		slices.SortStableFunc(rows, func(a, b resultRow) int { return strings.Compare(a.Value, b.Value) })
+	}
+}
+
+// existsIn returns a filter matching rows whose key is also visible in
+// table (a semi-join), which may be a table or a view.
+func (e *Engine) existsIn(table string) (func(key, value string) bool, error) {
+	if view, ok, err := e.lookupView(table); err != nil {
+		return nil, err
+	} else if ok {
+		rows, err := e.selectFromView(&SelectStatement{Table: table}, view)
+		if err != nil {
+			return nil, err
+		}
+		keys := make(map[string]struct{}, len(rows))
+		for _, row := range rows {
+			keys[row.Key] = struct{}{}
+		}
+		return func(key, value string) bool {
+			_, ok := keys[key]
+			return ok
+		}, nil
+	}
+	if !e.tableVisible(table) {
+		return nil, fmt.Errorf("Table '%s' not found", table)
+	}
+	return func(key, value string) bool {
+		_, _, ok := e.getVisible(table, key)
+		return ok
+	}, nil
+}
+
+// likeToRegexp converts a SQL LIKE pattern, where % matches any run of
+// characters and _ matches exactly one, into an anchored regular expression.
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// tableVisible reports whether table exists for the current transaction, or
+// in the committed state outside one.
+func (e *Engine) tableVisible(table string) bool {
+	if _, dropped := e.txDroppedTables[table]; dropped {
+		return false
+	}
+	_, inMain := e.tables[table]
+	_, inTx := e.txChanges[table]
+	return inMain || inTx
+}
+
+// getVisible looks up key in table as seen by the current transaction.
+// fromTx reports whether the value comes from the transaction buffer.
+func (e *Engine) getVisible(table, key string) (value string, fromTx bool, ok bool) {
+	if _, dropped := e.txDroppedTables[table]; dropped {
+		return "", false, false
+	}
+	if v, ok := e.txChanges[table][key]; ok {
+		return v, true, true
+	}
+	if _, deleted := e.txDeletes[table][key]; deleted {
+		return "", false, false
+	}
+	if tree, ok := e.tables[table]; ok {
+		if v, ok := tree.Get(key); ok {
+			return e.decodeValue(table, v), false, true
+		}
+	}
+	return "", false, false
+}
+
+// renderRows formats SELECT results, either as "key: value" lines or, for
+// FORMAT JSON, as a JSON array of {"key": ..., "value": ...} objects.
+func (e *Engine) renderRows(rows []resultRow, format string) string {
+	if format == "JSON" {
+		type jsonRow struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		out := make([]jsonRow, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, jsonRow{Key: r.Key, Value: r.Value})
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(out); err != nil {
+			return "Error: " + err.Error()
+		}
+		return strings.TrimRight(buf.String(), "\n")
+	}
+
+	if len(rows) == 0 {
+		return "No results"
+	}
+	var sb strings.Builder
+	for _, r := range rows {
+		sb.WriteString(e.formatRow(r.Key, r.Value, r.FromTx) + "\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,716 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSelectFormatJSON(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, say"hi") INTO json_table`)
+
+	resp := e.Execute(`SELECT * FROM json_table FORMAT JSON`)
+	expected := `[{"key":"a","value":"1"},{"key":"b","value":"say\"hi\""}]`
+	if resp != expected {
+		t.Fatalf("Expected %s, got %s", expected, resp)
+	}
+
+	var decoded []map[string]string
+	if err := json.Unmarshal([]byte(resp), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got error %v for %s", err, resp)
+	}
+	if decoded[1]["value"] != `say"hi"` {
+		t.Errorf("Expected the quote to round-trip, got %q", decoded[1]["value"])
+	}
+
+	resp = e.Execute(`SELECT b, missing FROM json_table format json`)
+	if resp != `[{"key":"b","value":"say\"hi\""}]` {
+		t.Errorf("Expected only the existing key, got %s", resp)
+	}
+
+	resp = e.Execute(`SELECT missing FROM json_table FORMAT JSON`)
+	if resp != "[]" {
+		t.Errorf("Expected an empty JSON array, got %s", resp)
+	}
+
+	resp = e.Execute(`SELECT * FROM json_table FORMAT XML`)
+	if !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected a parse error for an unsupported format, got %q", resp)
+	}
+}
+
+func TestSelectMissingTableInTransaction(t *testing.T) {
+	e := setupTestEngine(t)
+	if resp := e.Execute(`SELECT * FROM missing`); resp != "Table 'missing' not found" {
+		t.Errorf("Expected the table to be reported missing outside a transaction, got %q", resp)
+	}
+	e.Execute(`BEGIN`)
+	defer e.Execute(`ROLLBACK`)
+	for _, query := range []string{`SELECT * FROM missing`, `SELECT a FROM missing`} {
+		if resp := e.Execute(query); resp != "No results" {
+			t.Errorf("%s in a transaction: expected %q, got %q", query, "No results", resp)
+		}
+	}
+}
+
+func TestRenderRowsJSONEscaping(t *testing.T) {
+	e := setupTestEngine(t)
+	rows := []resultRow{{Key: "k\n1", Value: "line1\nline2\t\"q\" <b>"}}
+	resp := e.renderRows(rows, "JSON")
+	expected := `[{"key":"k\n1","value":"line1\nline2\t\"q\" <b>"}]`
+	if resp != expected {
+		t.Errorf("Expected %s, got %s", expected, resp)
+	}
+}
+
+func TestSelectWhere(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (apple, red), (apricot, orange), (banana, yellow), (avocado, green), (cherry, red) INTO fruit`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM fruit WHERE key LIKE 'a%'`, "apple: red\napricot: orange\navocado: green"},
+		{`SELECT * FROM fruit WHERE key LIKE '_pple'`, "apple: red"},
+		{`SELECT * FROM fruit WHERE value = red`, "apple: red\ncherry: red"},
+		{`SELECT * FROM fruit WHERE value LIKE "%e%"`, "apple: red\napricot: orange\navocado: green\nbanana: yellow\ncherry: red"},
+		{`SELECT apple, banana FROM fruit WHERE value = 'red'`, "apple: red"},
+		{`SELECT * FROM fruit WHERE key = kiwi`, "No results"},
+		{`SELECT * FROM fruit WHERE key LIKE 'a%' FORMAT JSON`, `[{"key":"apple","value":"red"},{"key":"apricot","value":"orange"},{"key":"avocado","value":"green"}]`},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	for _, query := range []string{
+		`SELECT * FROM fruit WHERE key`,
+		`SELECT * FROM fruit WHERE name = apple`,
+		`SELECT * FROM fruit WHERE key ~ apple`,
+		`SELECT * FROM fruit WHERE key = a WHERE key = b`,
+	} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Parse error:") {
+			t.Errorf("%s: expected a parse error, got %q", query, resp)
+		}
+	}
+}
+
+func TestSelectCountDistinctPrefix(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (user:1:name, Alice), (user:1:email, a@x), (user:2:name, Bob), (order:7, 3), (order:8, 5), (config, on) INTO app`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT COUNT(DISTINCT PREFIX ':') FROM app`, "3"}, // user, order, config
+		{`SELECT COUNT(DISTINCT PREFIX ':') FROM app WHERE key LIKE 'user%'`, "1"},
+		{`SELECT COUNT(DISTINCT PREFIX "1:") FROM app`, "5"}, // both user:1:* keys share "user:"
+		{`SELECT COUNT(DISTINCT PREFIX ':') FROM missing`, "Table 'missing' not found"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	// Prefixes buffered in a transaction are counted too
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (session:abc, 1) INTO app`)
+	if resp := e.Execute(`SELECT COUNT(DISTINCT PREFIX ':') FROM app`); resp != "4" {
+		t.Errorf("Expected 4 prefixes inside the transaction, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	for _, query := range []string{
+		`SELECT COUNT(DISTINCT PREFIX) FROM app`,
+		`SELECT COUNT(PREFIX ':') FROM app`,
+		`SELECT COUNT(DISTINCT PREFIX '') FROM app`,
+		`INSERT INTO copy SELECT COUNT(DISTINCT PREFIX ':') FROM app`,
+	} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Parse error:") {
+			t.Errorf("%s: expected a parse error, got %q", query, resp)
+		}
+	}
+}
+
+func TestSelectDistinctOnValue(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (cherry, red), (apple, red), (banana, yellow), (lemon, yellow), (lime, green) INTO fruit`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT DISTINCT ON value * FROM fruit`, "apple: red\nbanana: yellow\nlime: green"},
+		{`select distinct on VALUE * from fruit where key like 'l%'`, "lemon: yellow\nlime: green"},
+		{`SELECT DISTINCT ON value lemon, banana, cherry FROM fruit`, "lemon: yellow\ncherry: red"},
+		{`SELECT DISTINCT ON value * FROM fruit FORMAT JSON`, `[{"key":"apple","value":"red"},{"key":"banana","value":"yellow"},{"key":"lime","value":"green"}]`},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	for _, query := range []string{
+		`SELECT DISTINCT * FROM fruit`,
+		`SELECT DISTINCT ON key * FROM fruit`,
+		`SELECT DISTINCT ON value FROM fruit`,
+		`SELECT DISTINCT ON value COUNT(DISTINCT PREFIX ':') FROM fruit`,
+	} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Parse error:") {
+			t.Errorf("%s: expected a parse error, got %q", query, resp)
+		}
+	}
+}
+
+func TestSelectStartsEndsWith(t *testing.T) {
+	e := setupTestEngine(t)
+	// Enough keys for a multi-level tree, so the prefix scan has to seek
+	for i := 0; i < 30; i++ {
+		e.Execute(fmt.Sprintf("INSERT (item:%02d, v%d) INTO t", i, i))
+	}
+	e.Execute(`INSERT (user:1:name, Alice), (user:1:mail, a@x), (user:2:name, Bob), (userx, 0), (zed:name, z) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t WHERE key STARTS WITH 'user:'`, "user:1:mail: a@x\nuser:1:name: Alice\nuser:2:name: Bob"},
+		{`SELECT * FROM t WHERE key ENDS WITH ':name'`, "user:1:name: Alice\nuser:2:name: Bob\nzed:name: z"},
+		{`SELECT * FROM t WHERE value STARTS WITH A`, "user:1:name: Alice"},
+		{`SELECT * FROM t WHERE value ends with "@x"`, "user:1:mail: a@x"},
+		{`SELECT * FROM t WHERE key STARTS WITH 'nope'`, "No results"},
+		{`SELECT COUNT(DISTINCT PREFIX ':') FROM t WHERE key STARTS WITH 'item:'`, "1"},
+		{`EXPLAIN SELECT * FROM t WHERE key STARTS WITH 'user:'`, "PREFIX SCAN t (key STARTS WITH 'user:') ~3 rows"},
+		{`EXPLAIN SELECT * FROM t WHERE key ENDS WITH ':name'`, "FULL SCAN t\nFILTER key ENDS WITH ':name'"},
+		{`EXPLAIN SELECT * FROM t WHERE value STARTS WITH 'A'`, "FULL SCAN t\nFILTER value STARTS WITH 'A'"},
+		{`EXPLAIN SELECT a, b FROM t WHERE key STARTS WITH 'a'`, "KEY LOOKUP t (2 keys)\nFILTER key STARTS WITH 'a'"},
+		{`SELECT PREFIX user: FROM t`, "user:1:mail: a@x\nuser:1:name: Alice\nuser:2:name: Bob"},
+		{`SELECT PREFIX 'user' FROM t LIMIT 1`, "user:1:mail: a@x"},
+		{`SELECT PREFIX nope FROM t`, "No results"},
+		{`EXPLAIN SELECT PREFIX user: FROM t`, "PREFIX SCAN t (key STARTS WITH 'user:') ~3 rows"},
+		{`SELECT PREFIX a FROM t WHERE value = 'x'`, "Parse error: invalid SELECT syntax: PREFIX cannot be combined with WHERE"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	if all, resp := e.Execute(`SELECT * FROM t`), e.Execute(`SELECT PREFIX '' FROM t`); resp != all {
+		t.Errorf("Expected an empty PREFIX to return every row:\n%q\ngot:\n%q", all, resp)
+	}
+
+	// The prefix scan sees keys buffered in a transaction and hides deleted ones
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (user:0:name, Zoe) INTO t`)
+	e.Execute(`DELETE user:2:name FROM t`)
+	resp := e.Execute(`SELECT * FROM t WHERE key STARTS WITH 'user:'`)
+	txID := strings.Split(strings.Split(resp, "[")[1], "]")[0]
+	expected := "user:0:name: [" + txID + "] Zoe\nuser:1:mail: a@x\nuser:1:name: Alice"
+	if resp != expected {
+		t.Errorf("Expected prefix scan inside the transaction:\n%q\ngot:\n%q", expected, resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	if resp := e.Execute(`SELECT * FROM t WHERE key STARTS 'user:'`); !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected a parse error for STARTS without WITH, got %q", resp)
+	}
+}
+
+func TestExplainView(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`CREATE VIEW v AS SELECT * FROM t WHERE key STARTS WITH 'a'`)
+	expected := "VIEW v\nFILTER value = '1'\n  PREFIX SCAN t (key STARTS WITH 'a') ~1 rows"
+	if resp := e.Execute(`EXPLAIN SELECT * FROM v WHERE value = 1`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+}
+
+func TestExplainKey(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3), (d, 4), (e, 5) INTO t`)
+
+	expected := "NODE [\"c\"] -> child 1\n  LEAF [\"c\" \"d\" \"e\"] -> 'd' found"
+	if resp := e.Execute(`EXPLAIN KEY 'd' IN t`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+	expected = "NODE [\"c\"] -> child 0\n  LEAF [\"a\" \"b\"] -> 'ab' not found"
+	if resp := e.Execute(`EXPLAIN KEY ab IN t`); resp != expected {
+		t.Errorf("expected %q\ngot      %q", expected, resp)
+	}
+	if resp := e.Execute(`EXPLAIN KEY 'a' IN missing`); resp != "Table 'missing' not found" {
+		t.Errorf("Expected a missing table error, got %q", resp)
+	}
+}
+
+func TestSelectValueFunctions(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, Hello), (b, wOrLd), (c, héllo) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT UPPER(value) FROM t`, "a: HELLO\nb: WORLD\nc: HÉLLO"},
+		{`SELECT lower(VALUE) FROM t`, "a: hello\nb: world\nc: héllo"},
+		{`SELECT LENGTH(value) FROM t WHERE key = c`, "c: 5"}, // runes, not bytes
+		{`SELECT TRIM(value) FROM t WHERE key = a`, "a: Hello"},
+		{`SELECT UPPER(value) FROM t FORMAT JSON`, `[{"key":"a","value":"HELLO"},{"key":"b","value":"WORLD"},{"key":"c","value":"HÉLLO"}]`},
+		{`SELECT * FROM t WHERE key = b`, "b: wOrLd"}, // storage is unchanged
+		{`EXPLAIN SELECT UPPER(value) FROM t`, "FULL SCAN t\nTRANSFORM UPPER(value)"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	// The transformed values can be copied into another table
+	e.Execute(`INSERT INTO upper_t SELECT UPPER(value) FROM t`)
+	if resp := e.Execute(`SELECT * FROM upper_t WHERE key = b`); resp != "b: WORLD" {
+		t.Errorf("Expected INSERT ... SELECT to copy transformed values, got %q", resp)
+	}
+
+	if resp := e.Execute(`SELECT REVERSE(value) FROM t`); !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected a parse error for an unknown function, got %q", resp)
+	}
+}
+
+func TestSelectWhereExistsIn(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3), (d, 4) INTO t1`)
+	e.Execute(`INSERT (b, x), (d, y), (z, w) INTO t2`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t1 WHERE EXISTS IN t2`, "b: 2\nd: 4"},
+		{`SELECT a, b FROM t1 WHERE exists in t2`, "b: 2"},
+		{`SELECT * FROM t2 WHERE EXISTS IN t1`, "b: x\nd: y"},
+		{`SELECT * FROM t1 WHERE EXISTS IN missing`, "Table 'missing' not found"},
+		{`EXPLAIN SELECT * FROM t1 WHERE EXISTS IN t2`, "FULL SCAN t1\nFILTER key EXISTS IN 't2'"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	// The semi-join sees the transaction's view of both tables
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (a, q) INTO t2`)
+	e.Execute(`DELETE d FROM t2`)
+	resp := e.Execute(`SELECT * FROM t1 WHERE EXISTS IN t2`)
+	if resp != "a: 1\nb: 2" {
+		t.Errorf("Expected the transaction's view inside BEGIN, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	// Views work as the other side too
+	e.Execute(`CREATE VIEW late AS SELECT * FROM t1 WHERE key STARTS WITH 'c'`)
+	if resp := e.Execute(`SELECT * FROM t1 WHERE EXISTS IN late`); resp != "c: 3" {
+		t.Errorf("Expected a semi-join against a view, got %q", resp)
+	}
+
+	if resp := e.Execute(`SELECT * FROM t1 WHERE EXISTS t2`); !strings.HasPrefix(resp, "Parse error:") {
+		t.Errorf("Expected a parse error for EXISTS without IN, got %q", resp)
+	}
+}
+
+func TestSelectKeysetPagination(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`GENERATE 95 INTO t`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (key_000050a, tx) INTO t`) // buffered keys are paged in order too
+	e.Execute(`DELETE key_000010 FROM t`)
+
+	var pages [][]string
+	after := ""
+	for {
+		query := `SELECT * FROM t LIMIT 20`
+		if after != "" {
+			query = fmt.Sprintf(`SELECT * FROM t AFTER '%s' LIMIT 20`, after)
+		}
+		resp := e.Execute(query)
+		if resp == "" || strings.HasPrefix(resp, "No") {
+			break
+		}
+		var keys []string
+		for _, line := range strings.Split(resp, "\n") {
+			key, _, _ := strings.Cut(line, ": ")
+			keys = append(keys, key)
+		}
+		pages = append(pages, keys)
+		after = keys[len(keys)-1]
+		if len(pages) > 10 {
+			t.Fatal("Pagination did not terminate")
+		}
+	}
+
+	var all []string
+	for i, page := range pages {
+		if i < len(pages)-1 && len(page) != 20 {
+			t.Errorf("Page %d has %d rows, want 20", i, len(page))
+		}
+		all = append(all, page...)
+	}
+	if len(all) != 95 {
+		t.Fatalf("Expected 95 rows over all pages, got %d", len(all))
+	}
+	if !sort.StringsAreSorted(all) {
+		t.Error("Expected pages to be contiguous and in key order")
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i] == all[i-1] {
+			t.Errorf("Key %s appears on two pages", all[i])
+		}
+	}
+	e.Execute(`ROLLBACK`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t AFTER key_000093`, "key_000094: value_000094\nkey_000095: value_000095"},
+		{`SELECT * FROM t WHERE key STARTS WITH key_00001 AFTER key_000017 LIMIT 5`, "key_000018: value_000018\nkey_000019: value_000019"},
+		{`SELECT key_000003, key_000001, key_000002 FROM t AFTER key_000001 LIMIT 1`, "key_000003: value_000003"},
+		{`EXPLAIN SELECT * FROM t AFTER 'k' LIMIT 5`, "FULL SCAN t\nSEEK AFTER 'k'\nLIMIT 5"},
+		{`SELECT * FROM t LIMIT 0`, "Parse error: invalid SELECT syntax: LIMIT must be a positive integer, got \"0\""},
+		{`SELECT COUNT(DISTINCT PREFIX '_') FROM t LIMIT 5`, "Parse error: invalid SELECT syntax: AFTER and LIMIT cannot be combined with COUNT"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectWhereIsEmpty(t *testing.T) {
+	e := setupTestEngine(t)
+	e.InsertBatch("t", []KeyValue{{"a", ""}, {"b", "filled"}, {"c", ""}, {"d", " "}})
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t WHERE value IS EMPTY`, "a: \nc: "},
+		{`SELECT * FROM t WHERE value is empty`, "a: \nc: "},
+		{`SELECT * FROM t WHERE value = ''`, "a: \nc: "},
+		{`SELECT * FROM t WHERE value IS NOT EMPTY`, "b: filled\nd:  "},
+		{`SELECT a, b FROM t WHERE value IS EMPTY FORMAT JSON`, `[{"key":"a","value":""}]`},
+		{`EXPLAIN SELECT * FROM t WHERE value IS EMPTY`, "FULL SCAN t\nFILTER value IS EMPTY"},
+		{`SELECT * FROM t WHERE value IS NULL`, "Parse error: invalid WHERE syntax: expected IS EMPTY, IS NOT EMPTY or IS DUPLICATED"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectTop(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (ann, 30), (bob, 4), (cid, 100), (dee, 30), (eve, n/a), (fay, -2.5), (top, 7) INTO scores`)
+	e.Execute(`CREATE VIEW positive AS SELECT * FROM scores WHERE value LIKE '%0'`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT TOP 3 BY value DESC FROM scores`, "cid: 100\nann: 30\ndee: 30"}, // numeric, ties by key
+		{`SELECT TOP 3 BY value FROM scores`, "cid: 100\nann: 30\ndee: 30"},      // DESC is the default
+		{`SELECT top 2 by VALUE asc FROM scores`, "fay: -2.5\nbob: 4"},
+		{`SELECT TOP 10 BY value ASC FROM scores`, "fay: -2.5\nbob: 4\ntop: 7\nann: 30\ndee: 30\ncid: 100\neve: n/a"},
+		{`SELECT TOP 1 BY value DESC FROM scores WHERE key STARTS WITH d`, "dee: 30"},
+		{`SELECT TOP 1 BY value FROM positive`, "cid: 100"},
+		{`SELECT top FROM scores`, "top: 7"}, // still a key name
+		{`EXPLAIN SELECT TOP 3 BY value FROM scores`, "FULL SCAN scores\nTOP 3 BY value DESC"},
+		{`SELECT TOP 0 BY value FROM scores`, `Parse error: invalid SELECT syntax: TOP must be a positive integer, got "0"`},
+		{`SELECT TOP 3 BY value FROM scores LIMIT 2`, "Parse error: invalid SELECT syntax: TOP cannot be combined with LIMIT"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectKeySegment(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (user:42:email, a@x.io), (user:7:name, Ann), (order/9/total, 12), (plain, p), (segment, s) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT SEGMENT 2 OF key FROM t WHERE key STARTS WITH 'user:'`, "user:42:email: 42\nuser:7:name: 7"},
+		{`SELECT segment 3 of KEY FROM t WHERE key STARTS WITH 'user:'`, "user:42:email: email\nuser:7:name: name"},
+		{`SELECT SEGMENT 2 OF key DELIMITER '/' FROM t WHERE key STARTS WITH 'order'`, "order/9/total: 9"},
+		{`SELECT SEGMENT 2 OF key FROM t WHERE key = plain`, "plain: "}, // too few segments
+		{`SELECT SEGMENT 1 OF key FROM t LIMIT 1`, "order/9/total: order/9/total"},
+		{`SELECT segment FROM t`, "segment: s"}, // still a key name
+		{`EXPLAIN SELECT SEGMENT 2 OF key FROM t`, "FULL SCAN t\nPROJECT SEGMENT 2 OF key DELIMITER ':'"},
+		{`SELECT SEGMENT 0 OF key FROM t`, `Parse error: invalid SELECT syntax: SEGMENT must be a positive integer, got "0"`},
+		{`SELECT SEGMENT 2 OF value FROM t`, "Parse error: invalid SELECT syntax: expected SEGMENT <n> OF key [DELIMITER '<separator>']"},
+		{`SELECT SEGMENT 2 OF key DELIMITER '' FROM t`, "Parse error: invalid SELECT syntax: DELIMITER must not be empty"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+	if got := e.Execute(`SELECT * FROM t WHERE key = user:42:email`); got != "user:42:email: a@x.io" {
+		t.Errorf("Expected stored values to be unchanged, got %q", got)
+	}
+}
+
+func TestSelectRegexp(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (user:1, ann@x.io), (user:42, bob), (user:x, cid@y.org), (admin:7, dee@x.io) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM t WHERE key REGEXP '^user:[0-9]+$'`, "user:1: ann@x.io\nuser:42: bob"},
+		{`SELECT * FROM t WHERE value regexp '@x\.io$'`, "admin:7: dee@x.io\nuser:1: ann@x.io"},
+		{`SELECT * FROM t WHERE key REGEXP '[0-9]'`, "admin:7: dee@x.io\nuser:1: ann@x.io\nuser:42: bob"}, // unanchored
+		{`SELECT COUNT(*) FROM t WHERE key REGEXP '^user:'`, "count: 3"},
+		{`EXPLAIN SELECT * FROM t WHERE key REGEXP '^u'`, "FULL SCAN t\nFILTER key REGEXP '^u'"},
+		{`SELECT * FROM t WHERE key REGEXP '^user:[0-9+$'`, "Parse error: invalid WHERE syntax: invalid REGEXP pattern \"^user:[0-9+$\": error parsing regexp: missing closing ]: `[0-9+$`"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectLongestCommonPrefix(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (user:1:name, a), (user:1:email, b), (user:12:name, c), (order:7, d), (lcp, e), (café, f), (cafè, g) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT LCP FROM t WHERE key LIKE 'user:%'`, "user:1"},
+		{`SELECT lcp FROM t WHERE key LIKE 'user:1:%'`, "user:1:"},
+		{`SELECT LCP FROM t WHERE key = order:7`, "order:7"},
+		{`SELECT LCP FROM t WHERE key LIKE 'caf%'`, "caf"}, // é and è share their first byte
+		{`SELECT LCP FROM t`, ""},
+		{`SELECT LCP FROM t WHERE key LIKE 'nope%'`, "No results"},
+		{`SELECT "lcp" FROM t`, "lcp: e"},
+		{`EXPLAIN SELECT LCP FROM t WHERE key STARTS WITH 'user:'`, "PREFIX SCAN t (key STARTS WITH 'user:') ~3 rows\nAGGREGATE LCP(key)"},
+		{`SELECT LCP FROM t LIMIT 2`, "Parse error: invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with LCP"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectBetweenEvery(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (t00, 0), (t01, 1), (t02, 2), (t03, 3), (t04, 4), (t05, 5), (t06, 6), (t07, 7), (t08, 8), (t09, 9), (u00, x) INTO series`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM series WHERE key BETWEEN 't02' AND 't04'`, "t02: 2\nt03: 3\nt04: 4"},
+		{`SELECT * FROM series WHERE key BETWEEN t00 AND t09 EVERY 2`, "t00: 0\nt02: 2\nt04: 4\nt06: 6\nt08: 8"},
+		{`SELECT * FROM series WHERE key BETWEEN t00 AND t09 EVERY 4`, "t00: 0\nt04: 4\nt08: 8"},
+		{`SELECT * FROM series WHERE key BETWEEN t00 AND t09 EVERY 3 LIMIT 2`, "t00: 0\nt03: 3"},
+		{`SELECT * FROM series WHERE value BETWEEN 3 AND 5`, "t03: 3\nt04: 4\nt05: 5"},
+		{`SELECT * FROM series WHERE key BETWEEN t09 AND t00`, "No results"},
+		{`SELECT * FROM series EVERY 5`, "t00: 0\nt05: 5\nu00: x"},
+		{`EXPLAIN SELECT * FROM series WHERE key BETWEEN t00 AND t09 EVERY 2`, "RANGE SCAN series (key BETWEEN 't00' AND 't09') ~10 rows\nEVERY 2"},
+		{`EXPLAIN SELECT * FROM series WHERE value BETWEEN 3 AND 5`, "FULL SCAN series\nFILTER value BETWEEN '3' AND '5'"},
+		{`SELECT * FROM series WHERE key BETWEEN t00 t09`, "Parse error: invalid WHERE syntax: expected BETWEEN <low> AND <high>"},
+		{`SELECT * FROM series EVERY 0`, `Parse error: invalid SELECT syntax: EVERY must be a positive integer, got "0"`},
+		{`SELECT COUNT(*) FROM series EVERY 2`, "Parse error: invalid SELECT syntax: EVERY cannot be combined with an aggregate"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectAggregates(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3), (d, 10) INTO nums`)
+	e.Execute(`INSERT (x, 1.5), (y, 2) INTO floats`)
+	e.Execute(`INSERT (a, 1), (b, two) INTO mixed`)
+	e.Execute(`INSERT (a, 9223372036854775807), (b, 1) INTO huge`)
+	e.Execute(`CREATE TABLE empty`)
+	e.Execute(`CREATE VIEW small AS SELECT * FROM nums WHERE value LIKE '_'`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT COUNT(*) FROM nums`, "count: 4"},
+		{`SELECT SUM(value) FROM nums`, "16"},
+		{`SELECT AVG(value) FROM nums`, "4"},
+		{`SELECT sum(value) FROM nums WHERE key STARTS WITH a`, "1"},
+		{`SELECT SUM(value) FROM floats`, "3.5"},
+		{`SELECT SUM(value) FROM huge`, "9.223372036854776e+18"}, // would overflow an int64
+		{`SELECT AVG(value) FROM floats`, "1.75"},
+		{`SELECT COUNT(*) FROM small`, "count: 3"},
+		{`SELECT AVG(value) FROM small WHERE value = 3`, "3"},
+		{`SELECT COUNT(*) FROM mixed`, "count: 2"},
+		{`SELECT SUM(value) FROM mixed`, `Error: value of key 'b' is not a number: "two"`},
+		{`SELECT COUNT(*) FROM empty`, "count: 0"},
+		{`SELECT SUM(value) FROM empty`, "0"},
+		{`SELECT AVG(value) FROM empty`, "No results"},
+		{`SELECT SUM(value) FROM missing`, "Table 'missing' not found"},
+		{`EXPLAIN SELECT SUM(value) FROM nums`, "FULL SCAN nums\nAGGREGATE SUM(value)"},
+		{`SELECT SUM(value) FROM nums LIMIT 2`, "Parse error: invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with SUM"},
+		{`INSERT INTO t SELECT SUM(value) FROM nums`, "Parse error: invalid INSERT syntax: cannot insert the result of an aggregate"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	// Inside a transaction the aggregate sees buffered changes
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (e, 100) INTO nums`)
+	e.Execute(`DELETE a FROM nums`)
+	if resp := e.Execute(`SELECT SUM(value) FROM nums`); resp != "115" {
+		t.Errorf("Expected the transaction's sum, got %q", resp)
+	}
+	if resp := e.Execute(`SELECT COUNT(*) FROM nums`); resp != "count: 4" {
+		t.Errorf("Expected the transaction's count, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+	if resp := e.Execute(`SELECT COUNT(*) FROM missing`); resp != "Table 'missing' not found" {
+		t.Errorf("Expected a missing table to be reported, got %q", resp)
+	}
+}
+
+// TestSelectAggregateConstantMemory checks that an aggregate streams over the
+// table: its allocations do not grow with the number of rows.
+func TestSelectAggregateConstantMemory(t *testing.T) {
+	e := setupTestEngine(t)
+	allocs := func(rows int) float64 {
+		values := make([]KeyValue, rows)
+		for i := range values {
+			values[i] = KeyValue{Key: fmt.Sprintf("k%06d", i), Value: strconv.Itoa(i)}
+		}
+		table := fmt.Sprintf("t%d", rows)
+		e.InsertBatch(table, values)
+		query := fmt.Sprintf(`SELECT SUM(value) FROM %s`, table)
+		return testing.AllocsPerRun(5, func() { e.Execute(query) })
+	}
+	small, large := allocs(100), allocs(20000)
+	if large > small+2 {
+		t.Errorf("SUM allocated %.0f times over 20000 rows but %.0f over 100; expected constant", large, small)
+	}
+}
+
+func BenchmarkAggregateSum(b *testing.B) {
+	e := setupTestEngine(b)
+	values := make([]KeyValue, 200000)
+	for i := range values {
+		values[i] = KeyValue{Key: fmt.Sprintf("k%06d", i), Value: strconv.Itoa(i)}
+	}
+	e.InsertBatch("huge", values)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Execute(`SELECT SUM(value) FROM huge`)
+	}
+}
+
+func TestSelectAggregateCanceled(t *testing.T) {
+	e := setupTestEngineWithOptions(t, EngineOptions{QueryCacheSize: 8})
+	e.Execute(`INSERT (a, 1), (b, 2) INTO nums`)
+	e.Execute(`CREATE VIEW all_nums AS SELECT * FROM nums`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, query := range []string{`SELECT SUM(value) FROM nums`, `SELECT COUNT(*) FROM all_nums`} {
+		if resp := e.ExecuteContext(ctx, query); resp != "Error: query canceled: context canceled" {
+			t.Errorf("%s: expected the aggregate to be canceled, got %q", query, resp)
+		}
+	}
+	// The canceled result is not cached for later statements
+	if resp := e.Execute(`SELECT SUM(value) FROM nums`); resp != "3" {
+		t.Errorf("Expected the sum after a canceled query, got %q", resp)
+	}
+}
+
+func TestSelectBuckets(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2), (c, 3), (d, 4), (e, 5), (f, 6), (g, 7), (h, 8) INTO t`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT BUCKETS 4 FROM t`, "a .. b: 2\nc .. d: 2\ne .. f: 2\ng .. h: 2"},
+		{`SELECT BUCKETS 3 FROM t`, "a .. b: 2\nc .. e: 3\nf .. h: 3"},
+		{`SELECT buckets 1 FROM t WHERE key BETWEEN c AND e`, "c .. e: 3"},
+		{`SELECT BUCKETS 4 FROM t WHERE key BETWEEN a AND b`, "a .. a: 1\nb .. b: 1"}, // fewer keys than buckets
+		{`SELECT BUCKETS 4 FROM t WHERE key = z`, "No results"},
+		{`EXPLAIN SELECT BUCKETS 4 FROM t`, "FULL SCAN t\nAGGREGATE BUCKETS 4(key)"},
+		{`SELECT BUCKETS 0 FROM t`, `Parse error: invalid SELECT syntax: BUCKETS must be a positive integer, got "0"`},
+		{`SELECT BUCKETS 2 FROM t LIMIT 1`, "Parse error: invalid SELECT syntax: DISTINCT ON, AFTER and LIMIT cannot be combined with BUCKETS"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestSelectWhereIsDuplicated(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (u1, bob@x), (u2, amy@x), (u3, carl@x), (u4, amy@x), (u5, bob@x) INTO users`)
+	e.Execute(`INSERT (a, same), (b, same), (c, unique) INTO small`)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM small WHERE value IS DUPLICATED`, "a: same\nb: same"},
+		{`SELECT * FROM users WHERE value IS DUPLICATED`, "u2: amy@x\nu4: amy@x\nu1: bob@x\nu5: bob@x"},
+		{`SELECT * FROM users WHERE value IS DUPLICATED LIMIT 2`, "u2: amy@x\nu4: amy@x"},
+		{`SELECT u1, u3 FROM users WHERE value IS DUPLICATED`, "u1: bob@x"},
+		{`SELECT COUNT(*) FROM users WHERE value IS DUPLICATED`, "count: 4"},
+		{`EXPLAIN SELECT * FROM users WHERE value IS DUPLICATED`, "FULL SCAN users\nFILTER value IS DUPLICATED\nGROUP BY value"},
+		{`SELECT * FROM users WHERE key IS DUPLICATED`, "Parse error: invalid WHERE syntax: IS DUPLICATED applies to value only"},
+		{`DECLARE c CURSOR FOR SELECT * FROM users WHERE value IS DUPLICATED`, "Error: a cursor pages in key order, which WHERE value IS DUPLICATED does not keep"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"user:": "user;", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"container/heap"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// topRows keeps the best n rows offered to it for SELECT TOP n BY value,
+// so only n rows are held however many are scanned. It is a heap whose
+// root is the worst row kept, which a better row replaces.
+type topRows struct {
+	n    int
+	asc  bool
+	rows []resultRow
+}
+
+func newTopRows(n int, asc bool) *topRows {
+	return &topRows{n: n, asc: asc, rows: make([]resultRow, 0, n)}
+}
+
+// offer considers row for the result.
+func (t *topRows) offer(row resultRow) {
+	if len(t.rows) < t.n {
+		heap.Push(t, row)
+	} else if t.better(row, t.rows[0]) {
+		t.rows[0] = row
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the kept rows, best first.
+func (t *topRows) sorted() []resultRow {
+	sort.Slice(t.rows, func(i, j int) bool { return t.better(t.rows[i], t.rows[j]) })
+	return t.rows
+}
+
+// better reports whether a ranks before b: by value, largest first or with
+// asc smallest first, comparing numerically when both values are numbers.
+// Values that are not numbers rank after all numbers in either direction,
+// and ties are broken by key.
+func (t *topRows) better(a, b resultRow) bool {
+	af, aErr := strconv.ParseFloat(a.Value, 64)
+	bf, bErr := strconv.ParseFloat(b.Value, 64)
+	cmp := 0
+	switch {
+	case aErr == nil && bErr == nil:
+		if af < bf {
+			cmp = -1
+		} else if af > bf {
+			cmp = 1
+		}
+	case aErr == nil:
+		return true
+	case bErr == nil:
+		return false
+	default:
+		cmp = strings.Compare(a.Value, b.Value)
+	}
+	if cmp == 0 {
+		return a.Key < b.Key
+	}
+	return (cmp < 0) == t.asc
+}
+
+// heap.Interface, with the worst row at the root.
+func (t *topRows) Len() int           { return len(t.rows) }
+func (t *topRows) Less(i, j int) bool { return t.better(t.rows[j], t.rows[i]) }
+func (t *topRows) Swap(i, j int)      { t.rows[i], t.rows[j] = t.rows[j], t.rows[i] }
+func (t *topRows) Push(x any)         { t.rows = append(t.rows, x.(resultRow)) }
+func (t *topRows) Pop() any {
+	row := t.rows[len(t.rows)-1]
+	t.rows = t.rows[:len(t.rows)-1]
+	return row
+}
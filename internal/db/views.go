@@ -0,0 +1,213 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// viewTable is the reserved table holding view definitions: view name ->
+// SELECT text. Storing them as ordinary rows persists views in the WAL and
+// keeps them through checkpoints. It is hidden from SHOW TABLES.
+const viewTable = "__views"
+
+// isReservedTable reports whether name is an internal table hidden from
+// SHOW TABLES and SHOW TABLE SIZES.
+func isReservedTable(name string) bool {
+	return name == sequenceTable || name == viewTable
+}
+
+// lookupView returns the parsed query of the view called name, or false if
+// there is no such view.
+func (e *Engine) lookupView(name string) (*SelectStatement, bool, error) {
+	tree, ok := e.tables[viewTable]
+	if !ok {
+		return nil, false, nil
+	}
+	definition, ok := tree.Get(name)
+	if !ok {
+		return nil, false, nil
+	}
+	stmt, err := Parse(definition)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error: view '%s' has an invalid definition: %v", name, err)
+	}
+	query, ok := stmt.(*SelectStatement)
+	if !ok {
+		return nil, false, fmt.Errorf("Error: view '%s' has an invalid definition", name)
+	}
+	return query, true, nil
+}
+
+// isView reports whether name is a view.
+func (e *Engine) isView(name string) bool {
+	tree, ok := e.tables[viewTable]
+	if !ok {
+		return false
+	}
+	_, ok = tree.Get(name)
+	return ok
+}
+
+// createView stores a view definition. The name must not be in use by a
+// table or view, and the chain of views the query reads from must not lead
+// back to the new view.
+func (e *Engine) createView(s *CreateViewStatement) string {
+	if isReservedTable(s.Name) || strings.HasPrefix(s.Name, "__") {
+		return fmt.Sprintf("Error: '%s' is a reserved name", s.Name)
+	}
+	if e.isView(s.Name) {
+		return fmt.Sprintf("Error: view '%s' already exists", s.Name)
+	}
+	if _, ok := e.tables[s.Name]; ok {
+		return fmt.Sprintf("Error: a table named '%s' already exists", s.Name)
+	}
+
+	// Follow every table or view the query reads from, through FROM and
+	// EXISTS IN alike, to make sure none of them leads back to the new view
+	pending, seen := viewSources(s.Query), make(map[string]struct{})
+	for len(pending) > 0 {
+		source := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if source == s.Name {
+			return fmt.Sprintf("Error: view '%s' would depend on itself", s.Name)
+		}
+		if _, ok := seen[source]; ok {
+			continue
+		}
+		seen[source] = struct{}{}
+		query, ok, err := e.lookupView(source)
+		if err != nil {
+			return err.Error()
+		}
+		if ok {
+			pending = append(pending, viewSources(query)...)
+		}
+	}
+
+	tree, ok := e.tables[viewTable]
+	if !ok {
+		tree = e.newTree()
+		e.tables[viewTable] = tree
+	}
+	tree.Insert(s.Name, s.Definition)
+	e.wal.Append("", viewTable, s.Name, s.Definition)
+	e.tableChanged(s.Name)
+	return fmt.Sprintf("View '%s' created", s.Name)
+}
+
+// viewSources returns the tables or views s reads from directly: its FROM
+// table and the table of an EXISTS IN.
+func viewSources(s *SelectStatement) []string {
+	sources := []string{s.Table}
+	if s.Where != nil && s.Where.Op == "EXISTS IN" {
+		sources = append(sources, s.Where.Operand)
+	}
+	return sources
+}
+
+// dropView removes a view definition.
+func (e *Engine) dropView(name string) string {
+	if !e.isView(name) {
+		return fmt.Sprintf("View '%s' not found", name)
+	}
+	e.tables[viewTable].Delete(name)
+	e.wal.Delete("", viewTable, name)
+	e.tableChanged(name)
+	return fmt.Sprintf("View '%s' dropped", name)
+}
+
+// selectFromView runs s against the rows of view, applying s's own key list
+// and WHERE filter on top of the view's query.
+func (e *Engine) selectFromView(s *SelectStatement, view *SelectStatement) ([]resultRow, error) {
+	base, err := e.selectRows(view)
+	if err != nil {
+		return nil, err
+	}
+	match, err := e.rowFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Keys) > 0 {
+		byKey := make(map[string]resultRow, len(base))
+		for _, row := range base {
+			byKey[row.Key] = row
+		}
+		base = base[:0]
+		for _, key := range s.Keys {
+			if row, ok := byKey[key]; ok {
+				base = append(base, row)
+			}
+		}
+	}
+
+	var rows []resultRow
+	var top *topRows
+	if s.Top > 0 {
+		top = newTopRows(s.Top, s.TopAsc)
+	}
+	for _, row := range base {
+		if !match(row.Key, row.Value) {
+			continue
+		}
+		if top != nil {
+			top.offer(row)
+		} else {
+			rows = append(rows, row)
+		}
+	}
+	if top != nil {
+		rows = top.sorted()
+	}
+	groupDuplicates(rows, s)
+	rows = limitRows(rows, s.Limit)
+	applyValueFunc(rows, s.ValueFunc)
+	applyKeySegment(rows, s.Segment, s.SegmentSep)
+	return rows, nil
+}
+
+// dependentViews returns, sorted by name, the views that read from table,
+// directly or through other views or an EXISTS IN.
+func (e *Engine) dependentViews(table string) []string {
+	tree, ok := e.tables[viewTable]
+	if !ok {
+		return nil
+	}
+	var views []string
+	tree.Ascend(func(name, _ string) bool {
+		query, ok, err := e.lookupView(name)
+		if err == nil && ok && slices.Contains(e.selectDependencies(query, nil), table) {
+			views = append(views, name)
+		}
+		return true
+	})
+	return views
+}
+
+// dropCascade drops table together with the views that depend on it. The
+// removals are logged as one transaction, so after a crash either all of
+// them or none are replayed and no view is left pointing at a missing table.
+func (e *Engine) dropCascade(table string, views []string) string {
+	if _, ok := e.tables[table]; !ok {
+		return fmt.Sprintf("Table '%s' not found", table)
+	}
+	err := e.logTx(func(txID string) {
+		for _, view := range views {
+			e.wal.Delete(txID, viewTable, view)
+		}
+		e.wal.DropTable(txID, table)
+	})
+	if err != nil {
+		return fmt.Sprintf("Error: DROP CASCADE failed: %v", err)
+	}
+
+	for _, view := range views {
+		e.tables[viewTable].Delete(view)
+		e.tableChanged(view)
+	}
+	delete(e.tables, table)
+	delete(e.lru, table)
+	e.tableChanged(table)
+	return fmt.Sprintf("Table '%s' dropped, along with views %s", table, strings.Join(views, ", "))
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestViewReflectsBaseTable(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (apple, red), (banana, yellow) INTO fruit`)
+
+	if resp := e.Execute(`CREATE VIEW a_fruit AS SELECT * FROM fruit WHERE key LIKE 'a%'`); resp != "View 'a_fruit' created" {
+		t.Fatalf("Unexpected CREATE VIEW response: %q", resp)
+	}
+	if resp := e.Execute(`SELECT * FROM a_fruit`); resp != "apple: red" {
+		t.Fatalf("Expected only apple, got %q", resp)
+	}
+
+	// The view is evaluated on every query, so later writes show up
+	e.Execute(`INSERT (avocado, green) INTO fruit`)
+	e.Execute(`UPDATE fruit SET (apple, green)`)
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM a_fruit`, "apple: green\navocado: green"},
+		{`SELECT avocado, banana FROM a_fruit`, "avocado: green"},
+		{`SELECT * FROM a_fruit WHERE key = apple`, "apple: green"},
+		{`SELECT COUNT(DISTINCT PREFIX 'v') FROM a_fruit`, "2"},
+		{`SHOW TABLES`, "Tables:\n- fruit"},
+	}
+	for _, tt := range tests {
+		if resp := e.Execute(tt.query); resp != tt.expected {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tt.query, tt.expected, resp)
+		}
+	}
+
+	// Views over views, and buffered transaction changes, are visible too
+	e.Execute(`CREATE VIEW green_a AS SELECT * FROM a_fruit WHERE value = green`)
+	e.Execute(`BEGIN`)
+	e.Execute(`INSERT (apricot, green) INTO fruit`)
+	resp := e.Execute(`SELECT * FROM green_a`)
+	if !strings.Contains(resp, "apricot: [") || !strings.Contains(resp, "avocado: green") {
+		t.Errorf("Expected the view to include buffered rows, got:\n%s", resp)
+	}
+	if resp := e.Execute(`CREATE VIEW v AS SELECT * FROM fruit`); !strings.HasPrefix(resp, "Error:") {
+		t.Errorf("Expected CREATE VIEW inside a transaction to fail, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+}
+
+func TestViewErrors(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`CREATE VIEW v1 AS SELECT * FROM t`)
+	e.Execute(`CREATE VIEW v2 AS SELECT * FROM v1`)
+	e.Execute(`DROP VIEW v1`)
+	e.Execute(`CREATE VIEW v1 AS SELECT * FROM later`)
+
+	for _, query := range []string{
+		`CREATE VIEW self AS SELECT * FROM self`,
+		`CREATE VIEW later AS SELECT * FROM v2`, // later -> v2 -> v1 -> later
+		`CREATE VIEW self AS SELECT * FROM t WHERE EXISTS IN self`,
+		`CREATE VIEW later AS SELECT * FROM t WHERE EXISTS IN v2`, // through EXISTS IN
+		`CREATE VIEW t AS SELECT * FROM t`,
+		`CREATE VIEW v2 AS SELECT * FROM t`,
+		`INSERT (b, 2) INTO v2`,
+		`UPDATE v2 SET (a, 2)`,
+		`DELETE a FROM v2`,
+		`DROP v2`,
+	} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Error:") {
+			t.Errorf("%s: expected an error, got %q", query, resp)
+		}
+	}
+	for _, query := range []string{
+		`CREATE VIEW v AS SELECT COUNT(DISTINCT PREFIX ':') FROM t`,
+		`CREATE VIEW v AS SELECT * FROM t FORMAT JSON`,
+		`CREATE VIEW v SELECT * FROM t`,
+	} {
+		if resp := e.Execute(query); !strings.HasPrefix(resp, "Parse error:") {
+			t.Errorf("%s: expected a parse error, got %q", query, resp)
+		}
+	}
+	if resp := e.Execute(`DROP VIEW missing`); resp != "View 'missing' not found" {
+		t.Errorf("Unexpected DROP VIEW response: %q", resp)
+	}
+}
+
+func TestReservedTablesRejectUserWrites(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1) INTO t`)
+	e.Execute(`CREATE VIEW v AS SELECT * FROM t`)
+
+	for _, query := range []string{
+		`INSERT (v, garbage) INTO __views`,
+		`UPDATE __views SET (v, garbage)`,
+		`DELETE v FROM __views`,
+		`APPEND (v, garbage) IN __views`,
+		`INCR v IN __views`,
+		`SWAP v w IN __views`,
+		`DROP __views`,
+	} {
+		if resp := e.Execute(query); resp != "Error: '__views' is a reserved name" {
+			t.Errorf("%s: expected the reserved table to be refused, got %q", query, resp)
+		}
+	}
+	if resp := e.Execute(`SELECT * FROM v`); resp != "a: 1" {
+		t.Errorf("Expected the view to be unchanged, got %q", resp)
+	}
+}
+
+func TestViewSurvivesRestart(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a\,1, x), (b, y) INTO t`)
+	e.Execute(`CREATE VIEW v AS SELECT a\,1 FROM t WHERE value LIKE 'x%'`)
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	e.Close()
+
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	if resp := e.Execute(`SELECT * FROM v`); resp != "a,1: x" {
+		t.Errorf("Expected the view to survive a restart, got %q", resp)
+	}
+}
+
+func TestDropTableCascadesToViews(t *testing.T) {
+	e := setupTestEngine(t)
+	e.Execute(`INSERT (a, 1), (b, 2) INTO t`)
+	e.Execute(`INSERT (b, x) INTO other`)
+	e.Execute(`CREATE VIEW direct AS SELECT * FROM t`)
+	e.Execute(`CREATE VIEW nested AS SELECT a FROM direct`)
+	e.Execute(`CREATE VIEW semi AS SELECT * FROM other WHERE EXISTS IN t`)
+	e.Execute(`CREATE VIEW unrelated AS SELECT * FROM other`)
+
+	want := "Error: table 't' is used by views direct, nested, semi; use DROP t CASCADE to drop them too"
+	if resp := e.Execute(`DROP t`); resp != want {
+		t.Errorf("Unexpected DROP response: %q", resp)
+	}
+	e.Execute(`BEGIN`)
+	if resp := e.Execute(`DROP t CASCADE`); !strings.HasPrefix(resp, "Error:") {
+		t.Errorf("Expected DROP CASCADE inside a transaction to fail, got %q", resp)
+	}
+	e.Execute(`ROLLBACK`)
+
+	if resp := e.Execute(`DROP t CASCADE`); resp != "Table 't' dropped, along with views direct, nested, semi" {
+		t.Fatalf("Unexpected DROP CASCADE response: %q", resp)
+	}
+	check := func() {
+		t.Helper()
+		for _, name := range []string{"t", "direct", "nested", "semi"} {
+			if resp := e.Execute(`SELECT * FROM ` + name); resp != "Table '"+name+"' not found" {
+				t.Errorf("Expected %s to be gone, got %q", name, resp)
+			}
+		}
+		if resp := e.Execute(`SELECT * FROM unrelated`); resp != "b: x" {
+			t.Errorf("Expected the unrelated view to remain, got %q", resp)
+		}
+	}
+	check()
+
+	// The cascade is logged and replays the same way
+	e.Close()
+	e = NewEngine("test_wal.log")
+	defer e.Close()
+	check()
+
+	if resp := e.Execute(`DROP other CASCADE`); resp != "Table 'other' dropped, along with views unrelated" {
+		t.Errorf("Unexpected DROP CASCADE response: %q", resp)
+	}
+
+	// Without dependent views, CASCADE is a plain DROP
+	e.Execute(`INSERT (k, v) INTO plain`)
+	if resp := e.Execute(`DROP plain CASCADE`); resp != "Table 'plain' dropped" {
+		t.Errorf("Unexpected DROP CASCADE response without views: %q", resp)
+	}
+}
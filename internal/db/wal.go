@@ -2,95 +2,756 @@ package db
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// ErrDatabaseLocked is returned when another process already has the log open.
+var ErrDatabaseLocked = errors.New("database is locked")
+
 type WAL struct {
-	file *os.File
-	path string
+	file   *os.File
+	out    io.Writer // where records are written: file, or a buffer over it during Batch
+	lock   *os.File  // "<path>.lock" sidecar holding the single-writer lock
+	path   string
+	syncer syncer // flushes writes to stable storage; the file itself outside of tests
+
+	// shipped, if set, also receives every record written, for the engine to
+	// forward to its replicas (see Engine.AddReplica).
+	shipped *bytes.Buffer
+
+	// segments holds the per-table segment files of a log opened with
+	// OpenPerTableWAL; it is nil for a single-file log.
+	segments *tableSegments
+}
+
+// syncer is the part of *os.File the WAL needs for durability.
+type syncer interface {
+	Sync() error
 }
 
+// NewWAL is OpenWAL for callers that treat failure as fatal; it panics on error.
 func NewWAL(path string) *WAL {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	w, err := OpenWAL(path)
 	if err != nil {
 		panic(err)
 	}
+	return w
+}
+
+// OpenWAL opens the log at path for appending. Only one WAL may have a given
+// path open at a time: the writer holds an advisory lock on a "<path>.lock"
+// sidecar file until Close, and a second OpenWAL fails with ErrDatabaseLocked.
+// The sidecar is left in place after Close.
+func OpenWAL(path string) (*WAL, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		unlockFile(lock)
+		lock.Close()
+		return nil, err
+	}
+
+	return &WAL{file: f, out: f, lock: lock, path: path, syncer: f}, nil
+}
+
+// OpenWALWithBusyTimeout is OpenWAL that, while another process holds the
+// lock, keeps retrying with exponential backoff for up to timeout before
+// giving up with ErrDatabaseLocked. A zero timeout fails immediately, like
+// OpenWAL. Other errors are returned without retrying.
+func OpenWALWithBusyTimeout(path string, timeout time.Duration) (*WAL, error) {
+	return openWithBusyTimeout(func() (*WAL, error) { return OpenWAL(path) }, timeout)
+}
+
+// openWithBusyTimeout calls open until it succeeds, fails with an error
+// other than ErrDatabaseLocked, or timeout has passed.
+func openWithBusyTimeout(open func() (*WAL, error), timeout time.Duration) (*WAL, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		w, err := open()
+		if !errors.Is(err, ErrDatabaseLocked) {
+			return w, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, err
+		}
+		time.Sleep(min(backoff, remaining))
+		backoff = min(2*backoff, maxBusyBackoff)
+	}
+}
+
+// maxBusyBackoff caps the wait between lock attempts in OpenWALWithBusyTimeout.
+const maxBusyBackoff = 100 * time.Millisecond
+
+// Close closes the log and releases its lock. Closing an already closed WAL
+// is a no-op.
+func (w *WAL) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	if w.segments != nil {
+		if closeErr := w.segments.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if unlockErr := unlockFile(w.lock); err == nil {
+		err = unlockErr
+	}
+	if closeErr := w.lock.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	w.lock = nil
+	return err
+}
 
-	return &WAL{file: f, path: path}
+// walField formats a table name, key or value as a single log field. Strings
+// that are empty or contain whitespace, quotes, control characters such as
+// null bytes, or invalid UTF-8 are written as Go-quoted strings so they
+// survive the whitespace-separated format and the log stays plain text.
+func walField(s string) string {
+	if s == "" || !utf8.ValidString(s) || strings.ContainsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == '"'
+	}) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// splitWALFields splits a log line into whitespace-separated fields,
+// unquoting the ones written quoted by walField. It reports false if a
+// quoted field is malformed.
+func splitWALFields(line string) ([]string, bool) {
+	var fields []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return fields, true
+		}
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, false
+			}
+			field, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, false
+			}
+			fields = append(fields, field)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
 }
 
 // Append logs a SET operation. txID is empty for autocommit.
 func (w *WAL) Append(txID, tableName, key, value string) {
 	if txID == "" {
-		fmt.Fprintf(w.file, "SET %s %s %s\n", tableName, key, value) // Autocommit format
+		w.tableRecord(txID, tableName, "SET %s %s %s\n", walField(tableName), walField(key), walField(value)) // Autocommit format
 	} else {
-		fmt.Fprintf(w.file, "SET %s %s %s %s\n", txID, tableName, key, value) // Transactional format
+		w.tableRecord(txID, tableName, "SET %s %s %s %s\n", txID, walField(tableName), walField(key), walField(value)) // Transactional format
 	}
 }
 
 // Delete logs a DELETE operation. txID is empty for autocommit.
 func (w *WAL) Delete(txID, tableName, key string) {
 	if txID == "" {
-		fmt.Fprintf(w.file, "DELETE %s %s\n", tableName, key) // Autocommit format
+		w.tableRecord(txID, tableName, "DELETE %s %s\n", walField(tableName), walField(key)) // Autocommit format
 	} else {
-		fmt.Fprintf(w.file, "DELETE %s %s %s\n", txID, tableName, key) // Transactional format
+		w.tableRecord(txID, tableName, "DELETE %s %s %s\n", txID, walField(tableName), walField(key)) // Transactional format
 	}
 }
 
 // DropTable logs a DROP TABLE operation. txID is empty for autocommit.
 func (w *WAL) DropTable(txID, tableName string) {
 	if txID == "" {
-		fmt.Fprintf(w.file, "DROP TABLE %s\n", tableName) // Autocommit format
+		w.tableRecord(txID, tableName, "DROP TABLE %s\n", walField(tableName)) // Autocommit format
 	} else {
-		fmt.Fprintf(w.file, "DROP TABLE %s %s\n", txID, tableName) // Transactional format
+		w.tableRecord(txID, tableName, "DROP TABLE %s %s\n", txID, walField(tableName)) // Transactional format
 	}
 }
 
+// CreateTable logs a CREATE TABLE operation. Tables are only created outside
+// transactions, so there is no transactional format.
+func (w *WAL) CreateTable(tableName string) {
+	w.tableRecord("", tableName, "CREATE TABLE %s\n", walField(tableName))
+}
+
 // New functions for transaction boundaries
 func (w *WAL) BeginTx(txID string) {
-	fmt.Fprintf(w.file, "BEGIN_TX %s\n", txID)
+	if w.segments != nil {
+		w.ship("BEGIN_TX %s\n", txID) // Logged in a segment with the transaction's first record there
+		return
+	}
+	w.record("BEGIN_TX %s\n", txID)
 }
 
-func (w *WAL) CommitTx(txID string) {
-	fmt.Fprintf(w.file, "COMMIT_TX %s\n", txID)
+// CommitTx logs the commit of txID and syncs the log. The transaction is
+// only committed if the sync succeeds.
+func (w *WAL) CommitTx(txID string) error {
+	if w.segments != nil {
+		return w.commitSegments(txID)
+	}
+	w.record("COMMIT_TX %s\n", txID)
 
 	// Crucial for durability: ensure all pending writes are flushed to disk.
-	if err := w.file.Sync(); err != nil {
-		fmt.Printf("WAL Sync error during Commit: %v\n", err)
+	return w.Sync()
+}
+
+// record writes one formatted record to the log, and a copy to shipped.
+func (w *WAL) record(format string, args ...any) {
+	fmt.Fprintf(w.out, format, args...)
+	w.ship(format, args...)
+}
+
+// tableRecord is record for a record of tableName logged under txID, which
+// is empty for autocommit. A per-table log writes it to the table's segment.
+func (w *WAL) tableRecord(txID, tableName, format string, args ...any) {
+	if w.segments == nil {
+		w.record(format, args...)
+		return
+	}
+	w.segments.write(txID, tableName, format, args...)
+	w.ship(format, args...)
+}
+
+// ship writes a copy of a record to shipped, if set.
+func (w *WAL) ship(format string, args ...any) {
+	if w.shipped != nil {
+		fmt.Fprintf(w.shipped, format, args...)
 	}
 }
 
+// Sync flushes everything written to the log so far to stable storage.
+func (w *WAL) Sync() error {
+	if buf, ok := w.out.(*bufio.Writer); ok {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+	}
+	if w.segments != nil {
+		if err := w.segments.sync(); err != nil {
+			return err
+		}
+	}
+	return w.syncer.Sync()
+}
+
+// Size returns the size of the log file in bytes, not counting records
+// still buffered by a Batch in progress. For a per-table log it is the
+// total size of its files.
+func (w *WAL) Size() (int64, error) {
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if w.segments != nil {
+		segments, err := w.segments.size()
+		if err != nil {
+			return 0, err
+		}
+		size += segments
+	}
+	return size, nil
+}
+
+// Batch runs fn with the records it writes collected in a memory buffer and
+// written to the log in large chunks, instead of one write per record. The
+// buffer is flushed when fn returns (and by any Sync inside fn). A per-table
+// log writes the records as they come.
+func (w *WAL) Batch(fn func()) error {
+	if w.segments != nil {
+		fn()
+		return nil
+	}
+	buf := bufio.NewWriterSize(w.file, 64*1024)
+	w.out = buf
+	defer func() { w.out = w.file }()
+	fn()
+	return buf.Flush()
+}
+
+// inBatch reports whether a Batch is in progress, whose records may not be
+// written to the log file yet.
+func (w *WAL) inBatch() bool {
+	_, ok := w.out.(*bufio.Writer)
+	return ok
+}
+
+// PrepareTx marks the transaction txID, whose records are already logged,
+// as prepared for two-phase commit under the global ID gid, and syncs the
+// log. Replay neither applies nor discards a prepared transaction until a
+// COMMIT_TX or ROLLBACK_TX for it follows. The transaction is only prepared
+// if the sync succeeds.
+func (w *WAL) PrepareTx(txID, gid string) error {
+	if w.segments != nil {
+		return ErrPerTableLogs
+	}
+	w.record("PREPARE_TX %s %s\n", txID, walField(gid))
+	return w.Sync()
+}
+
 func (w *WAL) RollbackTx(txID string) {
-	fmt.Fprintf(w.file, "ROLLBACK_TX %s\n", txID)
+	if w.segments != nil {
+		w.segments.rollback(txID)
+		w.ship("ROLLBACK_TX %s\n", txID)
+		return
+	}
+	w.record("ROLLBACK_TX %s\n", txID)
+}
+
+// BeginBatch starts the records of a transaction committed with
+// EngineOptions.CompactCommits: plain autocommit records, which Replay
+// applies together at the END_BATCH that follows them, or not at all.
+func (w *WAL) BeginBatch() {
+	w.record("BEGIN_BATCH\n")
 }
 
+// EndBatch ends the batch started by BeginBatch, committing it, and syncs
+// the log. The batch is only committed if the sync succeeds.
+func (w *WAL) EndBatch() error {
+	w.record("END_BATCH\n")
+	return w.Sync()
+}
+
+// AbortBatch ends a batch that a crash cut off before its END_BATCH, so
+// Replay discards its records instead of taking the records written after
+// the restart for part of it. It starts with a newline to finish a record
+// the crash left half written.
+func (w *WAL) AbortBatch() error {
+	w.record("\nABORT_BATCH\n")
+	return w.Sync()
+}
+
+// Compact replaces the log with one autocommit SET record per entry in
+// tables (table -> sorted key/value pairs), plus a CREATE TABLE record for
+// each empty table, so replaying it yields the same state without the
+// history that led to it. The new log is written to a
+// temporary file, synced and renamed over the old one, so a crash leaves
+// either the old or the new log intact. The lock is held throughout.
+func (w *WAL) Compact(tables map[string][][2]string) error {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if w.segments != nil {
+		return w.compactSegments(names, tables)
+	}
+
+	return w.rewrite(func(out *bufio.Writer) error {
+		writeSnapshot(out, names, tables)
+		return nil
+	})
+}
+
+// writeSnapshot writes the records Compact logs for tables to out, for the
+// tables in names in that order.
+func writeSnapshot(out io.Writer, names []string, tables map[string][][2]string) {
+	for _, name := range names {
+		if len(tables[name]) == 0 {
+			fmt.Fprintf(out, "CREATE TABLE %s\n", walField(name))
+		}
+		for _, kv := range tables[name] {
+			fmt.Fprintf(out, "SET %s %s %s\n", walField(name), walField(kv[0]), walField(kv[1]))
+		}
+	}
+}
+
+// Vacuum rewrites the log without the records of transactions that never
+// committed: those rolled back and those abandoned by a crash, which Replay
+// skips but which would otherwise stay in the log forever. A first pass
+// collects the IDs that have a COMMIT_TX, or a PREPARE_TX and no
+// ROLLBACK_TX, a second copies every other record unchanged. It returns the
+// number of records removed. The log must not contain an open transaction,
+// whose records would be removed too.
+func (w *WAL) Vacuum() (int, error) {
+	if w.segments != nil {
+		return 0, ErrPerTableLogs
+	}
+	committed := make(map[string]struct{}) // committed, or prepared and not rolled back
+	err := w.scanLines(func(line string) {
+		parts, ok := splitWALFields(line)
+		if !ok || len(parts) < 2 {
+			return
+		}
+		switch strings.ToUpper(parts[0]) {
+		case "COMMIT_TX", "PREPARE_TX":
+			committed[parts[1]] = struct{}{}
+		case "ROLLBACK_TX":
+			delete(committed, parts[1])
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	err = w.rewrite(func(out *bufio.Writer) error {
+		return w.scanLines(func(line string) {
+			if txID := walRecordTxID(line); txID != "" {
+				if _, ok := committed[txID]; !ok {
+					removed++
+					return
+				}
+			}
+			out.WriteString(line)
+			out.WriteByte('\n')
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// walRecordTxID returns the transaction ID a log record belongs to, or ""
+// for autocommit records and lines that are not well-formed records.
+func walRecordTxID(line string) string {
+	parts, ok := splitWALFields(line)
+	if !ok || len(parts) == 0 {
+		return ""
+	}
+	switch strings.ToUpper(parts[0]) {
+	case "SET":
+		if len(parts) == 5 {
+			return parts[1]
+		}
+	case "DELETE":
+		if len(parts) == 4 {
+			return parts[1]
+		}
+	case "DROP":
+		if len(parts) == 4 && strings.ToUpper(parts[1]) == "TABLE" {
+			return parts[2]
+		}
+	case "BEGIN_TX", "COMMIT_TX", "ROLLBACK_TX":
+		if len(parts) == 2 {
+			return parts[1]
+		}
+	case "PREPARE_TX":
+		if len(parts) == 3 {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// maxRecordSize bounds the length of a log record the log readers accept. A
+// record holds a whole value, escaped, so the bufio.Scanner default of 64KB
+// would make a log with a large value, such as a blob stored with SetBytes,
+// impossible to replay.
+const maxRecordSize = 1 << 30
+
+// newRecordScanner returns a scanner over the lines of records that accepts
+// lines of up to maxRecordSize bytes.
+func newRecordScanner(records io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(records)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	return scanner
+}
+
+// scanLines calls fn for every line of the log file.
+func (w *WAL) scanLines(fn func(line string)) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, _, err := logRecords(f)
+	if err != nil {
+		return err
+	}
+	scanner := newRecordScanner(records)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Seal compresses the records logged so far with gzip, for archiving large
+// logs. Since a gzip stream cannot be appended to, new records are still
+// written uncompressed after it, and sealing again compresses those too.
+// Replay, Vacuum and LastValue read sealed logs transparently; Checkpoint
+// and Vacuum write an uncompressed log. Like Compact, the log is rewritten
+// through a temporary file, so a crash leaves the old or the new log intact.
+func (w *WAL) Seal() error {
+	if w.segments != nil {
+		return ErrPerTableLogs
+	}
+	return w.rewrite(func(out *bufio.Writer) error {
+		gz := gzip.NewWriter(out)
+		var writeErr error
+		if err := w.scanLines(func(line string) {
+			if writeErr == nil {
+				_, writeErr = io.WriteString(gz, line+"\n")
+			}
+		}); err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		return gz.Close()
+	})
+}
+
+// logRecords returns a reader over the records of a log file read from r,
+// and whether the log is sealed: a sealed log starts with a gzip stream of
+// the records sealed so far, recognized by the gzip magic bytes, which is
+// followed by the plain records appended since.
+func logRecords(r io.Reader) (io.Reader, bool, error) {
+	// A bufio.Reader is an io.ByteReader, so gzip reads no further than the
+	// end of its stream and the plain records that follow are left in br
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, false, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, false, err
+	}
+	gz.Multistream(false)
+	return io.MultiReader(gz, br), true, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rewrite replaces the log with what write produces. The new log is written
+// to a temporary file, synced and renamed over the old one, so a crash
+// leaves either the old or the new log intact; appends then continue on the
+// new file.
+func (w *WAL) rewrite(write func(out *bufio.Writer) error) error {
+	if err := replaceFile(w.path, write); err != nil {
+		return err
+	}
+
+	// Reopen so further appends go to the compacted log rather than the unlinked old file
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	w.file.Close()
+	w.file = f
+	w.out = f
+	w.syncer = f
+	return nil
+}
+
+// replaceFile replaces the file at path with what write produces, through a
+// temporary file that is synced and renamed over it.
+func replaceFile(path string, write func(out *bufio.Writer) error) error {
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	out := bufio.NewWriter(tmp)
+	if err := write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// replayProgressInterval is how many bytes Replay reads between calls to its
+// progress callback, keeping the callback's cost negligible on large logs.
+const replayProgressInterval = 1 << 20
+
 // Replay reads the WAL and reconstructs the state of all tables.
 func (w *WAL) Replay() (map[string][][2]string, error) {
-	f, err := os.Open(w.path)
+	return w.ReplayWithProgress(nil)
+}
+
+// ReplayWithProgress is like Replay but calls progress with the number of
+// bytes read so far and the log's total size, roughly every
+// replayProgressInterval bytes and once more when replay finishes. A nil
+// progress is never called.
+func (w *WAL) ReplayWithProgress(progress func(bytesRead, totalBytes int64)) (map[string][][2]string, error) {
+	tables, _, err := w.ReplayWithStats(progress)
+	return tables, err
+}
+
+// ReplayStats counts the transaction markers Replay skipped because they
+// could not have been written by a correct writer.
+type ReplayStats struct {
+	StrayCommits     int // COMMIT_TX for a transaction that was never begun
+	DuplicateCommits int // COMMIT_TX for a transaction that was already committed
+	IncompleteTxs    int // transactions begun but never prepared, committed or rolled back, including batches without an END_BATCH
+
+	openBatch bool // the log ends inside a batch; see AbortBatch
+}
+
+// preparedTx is a transaction prepared for two-phase commit that has not
+// been committed or rolled back yet, as recovered by replay. Values are
+// encoded as in the log.
+type preparedTx struct {
+	txID    string
+	gid     string
+	changes map[string]map[string]string   // table -> key -> value
+	deletes map[string]map[string]struct{} // table -> key -> {}
+	drops   map[string]struct{}            // table -> {}
+}
+
+// ReplayWithStats is ReplayWithProgress that also reports what recovery
+// skipped. A COMMIT_TX is only honored for a transaction that has a
+// BEGIN_TX and was not committed before; otherwise it is ignored, along
+// with any records logged under its ID, instead of applying them.
+func (w *WAL) ReplayWithStats(progress func(bytesRead, totalBytes int64)) (map[string][][2]string, ReplayStats, error) {
+	tables, stats, _, err := w.replay(progress, 0)
+	return tables, stats, err
+}
+
+// ReplayUpTo reconstructs the state of all tables as of sequence number seq,
+// replaying only the first seq records of the log. A record's sequence
+// number is its 1-based position in the log, so compaction renumbers them.
+// Transactions not committed within those records are left out. It fails if
+// the log has fewer than seq records.
+func (w *WAL) ReplayUpTo(seq int) (map[string][][2]string, error) {
+	if seq <= 0 {
+		return nil, fmt.Errorf("sequence must be positive, got %d", seq)
+	}
+	if w.segments != nil {
+		return nil, ErrPerTableLogs
+	}
+	tables, _, _, err := w.replay(nil, seq)
+	return tables, err
+}
+
+// replay is ReplayWithStats that also returns the prepared transactions
+// still waiting for COMMIT PREPARED or ROLLBACK PREPARED. A positive upTo
+// stops after that many records and fails if the log is shorter.
+func (w *WAL) replay(progress func(bytesRead, totalBytes int64), upTo int) (map[string][][2]string, ReplayStats, []preparedTx, error) {
+	if w.segments != nil {
+		tables, stats, err := w.replaySegments(progress)
+		return tables, stats, nil, err
+	}
+	return replayFile(w.path, progress, upTo, nil)
+}
+
+// replayFile replays the log file at path, as replay does. If commits is
+// not nil, a COMMIT_TX is only honored for the transactions in it; the
+// others are treated as never committed.
+func replayFile(path string, progress func(bytesRead, totalBytes int64), upTo int, commits map[string]struct{}) (map[string][][2]string, ReplayStats, []preparedTx, error) {
+	var stats ReplayStats
+	f, err := os.Open(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return make(map[string][][2]string), nil
+		if os.IsNotExist(err) && upTo == 0 {
+			return make(map[string][][2]string), stats, nil, nil
+		} else if os.IsNotExist(err) {
+			return nil, stats, nil, fmt.Errorf("sequence %d is beyond the end of the log (0 records)", upTo)
 		}
-		return nil, err
+		return nil, stats, nil, err
 	}
 	defer f.Close()
 
+	var totalBytes, bytesRead, lastReported int64
+	if progress != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, stats, nil, err
+		}
+		totalBytes = info.Size()
+	}
+	counted := &countingReader{r: f}
+	records, sealed, err := logRecords(counted)
+	if err != nil {
+		return nil, stats, nil, err
+	}
+
 	tablesData := make(map[string]map[string]string)                   // current state of tables
 	activeTxChanges := make(map[string]map[string]map[string]string)   // txID -> table -> key -> value
 	activeTxDeletes := make(map[string]map[string]map[string]struct{}) // txID -> table -> key -> {}
 	activeTxDroppedTables := make(map[string]map[string]struct{})      // txID -> table -> {}
+	begunTxs := make(map[string]struct{})                              // transactions begun and not yet finished
+	committedTxs := make(map[string]struct{})                          // transactions already committed
+	preparedTxs := make(map[string]string)                             // prepared transaction -> global ID
+	discardTx := func(txID string) {
+		delete(activeTxChanges, txID)
+		delete(activeTxDeletes, txID)
+		delete(activeTxDroppedTables, txID)
+	}
+	inBatch := false
+	var batch [][]string // records of the open batch, applied at its END_BATCH
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
+	scanner := newRecordScanner(records)
+	seq := 0 // records read so far
+	for (upTo == 0 || seq < upTo) && scanner.Scan() {
 		line := scanner.Text()
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			continue
+		seq++
+		if progress != nil {
+			if sealed {
+				bytesRead = counted.n // Progress through the compressed file, not its records
+			} else {
+				bytesRead += int64(len(line)) + 1 // Plus the newline the scanner strips
+			}
+			if bytesRead-lastReported >= replayProgressInterval {
+				progress(min(bytesRead, totalBytes), totalBytes)
+				lastReported = bytesRead
+			}
+		}
+		parts, ok := splitWALFields(line)
+		if !ok || len(parts) == 0 {
+			continue // Skip malformed lines, like records of an unknown shape
 		}
 
 		command := strings.ToUpper(parts[0])
+		if inBatch && command != "BEGIN_BATCH" && command != "END_BATCH" && command != "ABORT_BATCH" {
+			batch = append(batch, parts)
+			continue
+		}
 		switch command {
 		case "SET":
 			if len(parts) == 5 { // Transactional SET: SET <txID> <table_name> <key> <value>
@@ -105,14 +766,8 @@ func (w *WAL) Replay() (map[string][][2]string, error) {
 					activeTxChanges[txID][tableName] = make(map[string]string)
 				}
 				activeTxChanges[txID][tableName][key] = value
-			} else if len(parts) == 4 { // Autocommit SET: SET <table_name> <key> <value>
-				tableName := parts[1]
-				key := parts[2]
-				value := parts[3]
-				if _, ok := tablesData[tableName]; !ok {
-					tablesData[tableName] = make(map[string]string)
-				}
-				tablesData[tableName][key] = value
+			} else {
+				applyAutocommit(tablesData, parts)
 			}
 		case "DELETE":
 			if len(parts) == 4 { // Transactional DELETE: DELETE <txID> <table_name> <key>
@@ -126,12 +781,8 @@ func (w *WAL) Replay() (map[string][][2]string, error) {
 					activeTxDeletes[txID][tableName] = make(map[string]struct{})
 				}
 				activeTxDeletes[txID][tableName][key] = struct{}{}
-			} else if len(parts) == 3 { // Autocommit DELETE: DELETE <table_name> <key>
-				tableName := parts[1]
-				key := parts[2]
-				if _, ok := tablesData[tableName]; ok {
-					delete(tablesData[tableName], key)
-				}
+			} else {
+				applyAutocommit(tablesData, parts)
 			}
 		case "DROP":
 			if len(parts) == 4 && strings.ToUpper(parts[1]) == "TABLE" { // Transactional DROP: DROP TABLE <txID> <table_name>
@@ -141,15 +792,40 @@ func (w *WAL) Replay() (map[string][][2]string, error) {
 					activeTxDroppedTables[txID] = make(map[string]struct{})
 				}
 				activeTxDroppedTables[txID][tableName] = struct{}{}
-			} else if len(parts) == 3 && strings.ToUpper(parts[1]) == "TABLE" { // Autocommit DROP: DROP TABLE <table_name>
-				tableName := parts[2]
-				delete(tablesData, tableName)
+			} else {
+				applyAutocommit(tablesData, parts)
+			}
+		case "CREATE":
+			if len(parts) == 3 && strings.ToUpper(parts[1]) == "TABLE" { // CREATE TABLE <table_name>
+				if _, ok := tablesData[parts[2]]; !ok {
+					tablesData[parts[2]] = make(map[string]string)
+				}
 			}
 		case "BEGIN_TX":
-			// No action needed during replay, just marks the start
+			if len(parts) == 2 { // BEGIN_TX <txID>
+				if _, committed := committedTxs[parts[1]]; !committed {
+					begunTxs[parts[1]] = struct{}{}
+				}
+			}
 		case "COMMIT_TX":
 			if len(parts) == 2 { // COMMIT_TX <txID>
 				txID := parts[1]
+				if _, committed := committedTxs[txID]; committed {
+					stats.DuplicateCommits++
+					discardTx(txID)
+					break
+				}
+				if _, begun := begunTxs[txID]; !begun {
+					stats.StrayCommits++
+					discardTx(txID)
+					break
+				}
+				if _, ok := commits[txID]; commits != nil && !ok {
+					break // Cut off by a crash before the commit was decided; left incomplete
+				}
+				delete(begunTxs, txID)
+				delete(preparedTxs, txID)
+				committedTxs[txID] = struct{}{}
 
 				// Process drops first. This clears the slate for subsequent inserts/updates if the table is re-created.
 				if drops, ok := activeTxDroppedTables[txID]; ok {
@@ -191,23 +867,413 @@ func (w *WAL) Replay() (map[string][][2]string, error) {
 			if len(parts) == 2 { // ROLLBACK_TX <txID>
 				txID := parts[1]
 				// Discard buffered changes for this transaction
-				delete(activeTxChanges, txID)
-				delete(activeTxDeletes, txID)
-				delete(activeTxDroppedTables, txID)
+				delete(begunTxs, txID)
+				delete(preparedTxs, txID)
+				discardTx(txID)
+			}
+		case "PREPARE_TX":
+			if len(parts) == 3 { // PREPARE_TX <txID> <gid>
+				if _, begun := begunTxs[parts[1]]; begun {
+					preparedTxs[parts[1]] = parts[2]
+				}
+			}
+		case "BEGIN_BATCH", "ABORT_BATCH":
+			if inBatch {
+				stats.IncompleteTxs++ // Cut off by a crash
 			}
+			inBatch, batch = command == "BEGIN_BATCH", nil
+		case "END_BATCH":
+			if inBatch {
+				for _, record := range batch {
+					applyAutocommit(tablesData, record)
+				}
+			}
+			inBatch, batch = false, nil
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, stats, nil, err
+	}
+	if seq < upTo {
+		return nil, stats, nil, fmt.Errorf("sequence %d is beyond the end of the log (%d records)", upTo, seq)
+	}
+	var prepared []preparedTx
+	for txID, gid := range preparedTxs {
+		prepared = append(prepared, preparedTx{
+			txID:    txID,
+			gid:     gid,
+			changes: activeTxChanges[txID],
+			deletes: activeTxDeletes[txID],
+			drops:   activeTxDroppedTables[txID],
+		})
+	}
+	stats.IncompleteTxs += len(begunTxs) - len(preparedTxs)
+	if inBatch {
+		stats.IncompleteTxs++
+		stats.openBatch = true
+	}
+	if progress != nil && lastReported < totalBytes {
+		progress(totalBytes, totalBytes)
 	}
 
 	// Convert the map[string]map[string]string to map[string][][2]string
 	result := make(map[string][][2]string)
 	for tableName, kvs := range tablesData {
+		result[tableName] = make([][2]string, 0, len(kvs)) // Keep tables that are empty
 		for k, v := range kvs {
 			result[tableName] = append(result[tableName], [2]string{k, v})
 		}
 	}
-	return result, nil
+	return result, stats, prepared, nil
+}
+
+// applyAutocommit applies an autocommit SET, DELETE or DROP TABLE record,
+// split into fields, to tablesData. Records of another shape are ignored.
+func applyAutocommit(tablesData map[string]map[string]string, parts []string) {
+	switch strings.ToUpper(parts[0]) {
+	case "SET":
+		if len(parts) == 4 { // Autocommit SET: SET <table_name> <key> <value>
+			tableName := parts[1]
+			if _, ok := tablesData[tableName]; !ok {
+				tablesData[tableName] = make(map[string]string)
+			}
+			tablesData[tableName][parts[2]] = parts[3]
+		}
+	case "DELETE":
+		if len(parts) == 3 { // Autocommit DELETE: DELETE <table_name> <key>
+			if _, ok := tablesData[parts[1]]; ok {
+				delete(tablesData[parts[1]], parts[2])
+			}
+		}
+	case "DROP":
+		if len(parts) == 3 && strings.ToUpper(parts[1]) == "TABLE" { // Autocommit DROP: DROP TABLE <table_name>
+			delete(tablesData, parts[2])
+		}
+	}
+}
+
+// reverseChunkSize is how many bytes LastValue reads from the log at a time.
+const reverseChunkSize = 64 * 1024
+
+// scanLinesReverse calls fn for every line of the log file, last line first,
+// reading the file backward in chunks. It stops early when fn returns false.
+// A sealed log is read forward into memory instead.
+func (w *WAL) scanLinesReverse(fn func(line string) bool) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// A sealed log can only be read forward, so collect its lines first
+	if _, sealed, err := logRecords(f); err != nil {
+		return err
+	} else if sealed {
+		var lines []string
+		if err := w.scanLines(func(line string) { lines = append(lines, line) }); err != nil {
+			return err
+		}
+		for _, line := range slices.Backward(lines) {
+			if line != "" && !fn(line) {
+				break
+			}
+		}
+		return nil
+	}
+
+	var partial []byte // start of the line that continues into the chunk read last
+	for pos := info.Size(); pos > 0; {
+		n := min(reverseChunkSize, pos)
+		pos -= n
+		chunk := make([]byte, n, n+int64(len(partial)))
+		if _, err := f.ReadAt(chunk, pos); err != nil {
+			return err
+		}
+		buf := append(chunk, partial...)
+		for {
+			i := bytes.LastIndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			if line := string(buf[i+1:]); line != "" && !fn(line) {
+				return nil
+			}
+			buf = buf[:i]
+		}
+		partial = buf
+	}
+	if len(partial) > 0 {
+		fn(string(partial))
+	}
+	return nil
+}
+
+// LastValue returns the committed value of key in table by reading the log
+// backward from its end, stopping as soon as the newest write to the key is
+// known, so a single key can be read from a large log without replaying it.
+// It follows Replay's rules: records of transactions that were rolled back,
+// never committed or committed without a BEGIN_TX are ignored, and within a
+// transaction a DELETE of the key wins over its SETs and a DROP TABLE comes
+// first. A transaction's write takes effect at its COMMIT_TX record, so an
+// open transaction that committed later than a candidate write keeps the
+// scan going until its BEGIN_TX is reached. The value is returned as
+// stored, still encoded if the table has a codec. ok is false if the key
+// does not exist, including when there is no log yet. A sealed log (see
+// Seal) cannot be read backward and is read from the start instead.
+func (w *WAL) LastValue(table, key string) (value string, ok bool, err error) {
+	if w.segments != nil {
+		return "", false, ErrPerTableLogs
+	}
+	// txEffect is what a committed transaction does to the key, collected
+	// between its COMMIT_TX and its BEGIN_TX
+	type txEffect struct {
+		commitLine       int // position of the COMMIT_TX, counted from the end
+		set              bool
+		value            string
+		deleted, dropped bool
+	}
+	pending := make(map[string]*txEffect) // committed transactions whose BEGIN_TX is still ahead
+
+	found := false
+	foundLine := 0 // position of the newest write found, counted from the end
+	settle := func(line int, v string, exists bool) {
+		if !found || line < foundLine {
+			found, foundLine, value, ok = true, line, v, exists
+		}
+	}
+	// done reports whether no pending transaction committed after the
+	// newest write found, so nothing left to read can override it
+	done := func() bool {
+		if !found {
+			return false
+		}
+		for _, tx := range pending {
+			if tx.commitLine < foundLine {
+				return false
+			}
+		}
+		return true
+	}
+
+	lineNo := 0
+	batchLine := 0   // position of the END_BATCH of the batch being read, or 0 outside a batch
+	aborted := false // reading the records of an aborted batch
+	// settleAutocommit settles an autocommit write, which in a batch takes
+	// effect at its END_BATCH
+	settleAutocommit := func(v string, exists bool) {
+		switch {
+		case aborted:
+		case batchLine > 0:
+			settle(batchLine, v, exists) // The first found, the last in log order, wins
+		default:
+			settle(lineNo, v, exists)
+		}
+	}
+	err = w.scanLinesReverse(func(line string) bool {
+		lineNo++
+		parts, valid := splitWALFields(line)
+		if !valid || len(parts) == 0 {
+			return true
+		}
+		switch strings.ToUpper(parts[0]) {
+		case "SET":
+			if len(parts) == 5 && parts[2] == table && parts[3] == key {
+				if tx := pending[parts[1]]; tx != nil && !tx.set {
+					tx.set, tx.value = true, parts[4] // The last SET in log order wins
+				}
+			} else if len(parts) == 4 && parts[1] == table && parts[2] == key {
+				settleAutocommit(parts[3], true)
+			}
+		case "DELETE":
+			if len(parts) == 4 && parts[2] == table && parts[3] == key {
+				if tx := pending[parts[1]]; tx != nil {
+					tx.deleted = true
+				}
+			} else if len(parts) == 3 && parts[1] == table && parts[2] == key {
+				settleAutocommit("", false)
+			}
+		case "DROP":
+			if len(parts) == 4 && strings.ToUpper(parts[1]) == "TABLE" && parts[3] == table {
+				if tx := pending[parts[2]]; tx != nil {
+					tx.dropped = true
+				}
+			} else if len(parts) == 3 && strings.ToUpper(parts[1]) == "TABLE" && parts[2] == table {
+				settleAutocommit("", false)
+			}
+		case "END_BATCH":
+			batchLine = lineNo
+		case "ABORT_BATCH":
+			aborted = true
+		case "BEGIN_BATCH":
+			batchLine, aborted = 0, false
+		case "COMMIT_TX":
+			if len(parts) == 2 {
+				// An earlier COMMIT_TX of the same ID makes the later one a
+				// duplicate, whose records Replay discards
+				pending[parts[1]] = &txEffect{commitLine: lineNo}
+			}
+		case "BEGIN_TX":
+			if len(parts) == 2 {
+				if tx := pending[parts[1]]; tx != nil {
+					delete(pending, parts[1])
+					switch {
+					case tx.deleted:
+						settle(tx.commitLine, "", false)
+					case tx.set:
+						settle(tx.commitLine, tx.value, true)
+					case tx.dropped:
+						settle(tx.commitLine, "", false)
+					}
+				}
+			}
+		}
+		return !done()
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	// Transactions still pending at the start of the log were never begun,
+	// so Replay ignores them too
+	return value, ok, nil
+}
+
+// KeyVersion is one committed change to a key, as reported by KeyVersions.
+type KeyVersion struct {
+	Seq     int    // sequence number of the record that made the change: the write itself, or its transaction's COMMIT_TX
+	Value   string // the new value as stored, still encoded if the table has a codec
+	Deleted bool   // the key was deleted or its table dropped; Value is empty
+}
+
+// KeyVersions returns every committed change to key in table, oldest first,
+// by reading the whole log. Sequence numbers are as in ReplayUpTo, so
+// SELECT ... AS OF SEQUENCE Seq sees Value. It follows Replay's rules: a
+// transaction's write takes effect at its COMMIT_TX, records of
+// transactions that were rolled back, never committed or committed without
+// a BEGIN_TX are ignored, and within a transaction a DROP TABLE comes first
+// and a DELETE of the key wins over its SETs. Deleting a key that does not
+// exist is not a change. There is no version before a key is first written.
+func (w *WAL) KeyVersions(table, key string) ([]KeyVersion, error) {
+	if w.segments != nil {
+		return nil, ErrPerTableLogs
+	}
+	// txEffect is what an open transaction does to the key
+	type txEffect struct {
+		set              bool
+		value            string
+		deleted, dropped bool
+	}
+	effects := make(map[string]*txEffect) // txID -> effect, for transactions that touch the key
+	effect := func(txID string) *txEffect {
+		if effects[txID] == nil {
+			effects[txID] = &txEffect{}
+		}
+		return effects[txID]
+	}
+	begun := make(map[string]struct{})
+	committed := make(map[string]struct{})
+
+	var versions []KeyVersion
+	exists := false
+	change := func(seq int, value string, present bool) {
+		if present || exists {
+			versions = append(versions, KeyVersion{Seq: seq, Value: value, Deleted: !present})
+		}
+		exists = present
+	}
+
+	// A batch's autocommit writes take effect at its END_BATCH, where the
+	// last of them in log order is the change
+	inBatch := false
+	var batchWrite *KeyVersion
+	changeAutocommit := func(seq int, value string, present bool) {
+		if inBatch {
+			batchWrite = &KeyVersion{Value: value, Deleted: !present}
+		} else {
+			change(seq, value, present)
+		}
+	}
+
+	seq := 0
+	err := w.scanLines(func(line string) {
+		seq++
+		parts, valid := splitWALFields(line)
+		if !valid || len(parts) == 0 {
+			return
+		}
+		switch strings.ToUpper(parts[0]) {
+		case "SET":
+			if len(parts) == 5 && parts[2] == table && parts[3] == key {
+				tx := effect(parts[1])
+				tx.set, tx.value = true, parts[4]
+			} else if len(parts) == 4 && parts[1] == table && parts[2] == key {
+				changeAutocommit(seq, parts[3], true)
+			}
+		case "DELETE":
+			if len(parts) == 4 && parts[2] == table && parts[3] == key {
+				effect(parts[1]).deleted = true
+			} else if len(parts) == 3 && parts[1] == table && parts[2] == key {
+				changeAutocommit(seq, "", false)
+			}
+		case "DROP":
+			if len(parts) == 4 && strings.ToUpper(parts[1]) == "TABLE" && parts[3] == table {
+				effect(parts[2]).dropped = true
+			} else if len(parts) == 3 && strings.ToUpper(parts[1]) == "TABLE" && parts[2] == table {
+				changeAutocommit(seq, "", false)
+			}
+		case "BEGIN_BATCH", "ABORT_BATCH":
+			inBatch, batchWrite = strings.ToUpper(parts[0]) == "BEGIN_BATCH", nil
+		case "END_BATCH":
+			if inBatch && batchWrite != nil {
+				change(seq, batchWrite.Value, !batchWrite.Deleted)
+			}
+			inBatch, batchWrite = false, nil
+		case "BEGIN_TX":
+			if len(parts) == 2 {
+				if _, done := committed[parts[1]]; !done {
+					begun[parts[1]] = struct{}{}
+				}
+			}
+		case "COMMIT_TX":
+			if len(parts) != 2 {
+				break
+			}
+			txID := parts[1]
+			tx := effects[txID]
+			delete(effects, txID)
+			if _, done := committed[txID]; done {
+				break // A duplicate commit: Replay discards the records
+			}
+			if _, ok := begun[txID]; !ok {
+				break // A stray commit
+			}
+			delete(begun, txID)
+			committed[txID] = struct{}{}
+			switch {
+			case tx == nil:
+			case tx.deleted:
+				change(seq, "", false)
+			case tx.set:
+				change(seq, tx.value, true)
+			case tx.dropped:
+				change(seq, "", false)
+			}
+		case "ROLLBACK_TX":
+			if len(parts) == 2 {
+				delete(begun, parts[1])
+				delete(effects, parts[1])
+			}
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return versions, nil
 }
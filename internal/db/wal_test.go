@@ -3,17 +3,21 @@ package db
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWAL_AppendAndReplay(t *testing.T) {
 	path := "test_wal.log"
 	defer os.Remove(path) // Ensure log file is cleaned up after test
+	defer os.Remove(path + ".lock")
 
 	// --- Test Scenario 1: Basic SET and DELETE operations across tables ---
 	t.Run("BasicSetAndDelete", func(t *testing.T) {
 		_ = os.Remove(path) // Clean log file for this sub-test
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "table1", "keyA", "val1")
 		wal.Append("", "table1", "keyB", "val2")
@@ -64,6 +68,7 @@ func TestWAL_AppendAndReplay(t *testing.T) {
 	t.Run("OverwriteKey", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "users", "user1", "Alice")
 		wal.Append("", "users", "user1", "Bob") // Overwrite user1
@@ -89,6 +94,7 @@ func TestWAL_AppendAndReplay(t *testing.T) {
 	t.Run("DropTable", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "items", "item1", "apple")
 		wal.DropTable("", "items")
@@ -119,6 +125,7 @@ func TestWAL_AppendAndReplay(t *testing.T) {
 	t.Run("EmptyWAL", func(t *testing.T) {
 		_ = os.Remove(path) // Ensure no log file exists
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		replayedData, err := wal.Replay()
 		if err != nil {
@@ -133,6 +140,7 @@ func TestWAL_AppendAndReplay(t *testing.T) {
 	t.Run("MixedOperations", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "tbl1", "k1", "v1")
 		wal.Append("", "tbl2", "k2", "v2")
@@ -171,10 +179,12 @@ func TestWAL_AppendAndReplay(t *testing.T) {
 func TestWAL_Transactions(t *testing.T) {
 	path := "test_wal_tx.log"
 	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
 
 	t.Run("CommitTransaction", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		txID := "test_tx_1"
 		wal.BeginTx(txID)
@@ -221,6 +231,7 @@ func TestWAL_Transactions(t *testing.T) {
 	t.Run("RollbackTransaction", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "initial_table", "init_k", "init_v")
 
@@ -257,6 +268,7 @@ func TestWAL_Transactions(t *testing.T) {
 	t.Run("TransactionWithDrop", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "pre_existing_table", "pk1", "pv1")
 
@@ -287,6 +299,7 @@ func TestWAL_Transactions(t *testing.T) {
 	t.Run("RollbackTransactionWithDrop", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 
 		wal.Append("", "original_table", "ok1", "ov1")
 
@@ -317,6 +330,7 @@ func TestWAL_Transactions(t *testing.T) {
 	t.Run("CommitAndDeleteExistingKeyInTx", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 		wal.Append("", "items", "apple", "red")
 		wal.Append("", "items", "banana", "yellow")
 
@@ -345,6 +359,7 @@ func TestWAL_Transactions(t *testing.T) {
 	t.Run("RollbackAndDeleteExistingKeyInTx", func(t *testing.T) {
 		_ = os.Remove(path)
 		wal := NewWAL(path)
+		defer wal.Close()
 		wal.Append("", "fruits", "orange", "round")
 
 		txID := "test_tx_6"
@@ -369,3 +384,366 @@ func TestWAL_Transactions(t *testing.T) {
 		}
 	})
 }
+
+func TestWAL_Lock(t *testing.T) {
+	path := "test_wal_lock.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	first, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+
+	if _, err := OpenWAL(path); err != ErrDatabaseLocked {
+		t.Fatalf("Expected second OpenWAL to fail with %v, got %v", ErrDatabaseLocked, err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrDatabaseLocked {
+				t.Errorf("Expected NewWAL to panic with %v, got %v", ErrDatabaseLocked, r)
+			}
+		}()
+		NewWAL(path)
+	}()
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Errorf("Expected a second Close to be a no-op, got %v", err)
+	}
+
+	second, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("Expected OpenWAL to succeed after Close, got %v", err)
+	}
+	second.Close()
+}
+
+func TestWAL_BusyTimeout(t *testing.T) {
+	path := "test_wal_lock.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	first, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+
+	// Without enough time to wait, the lock error is still returned
+	start := time.Now()
+	if _, err := OpenWALWithBusyTimeout(path, 30*time.Millisecond); err != ErrDatabaseLocked {
+		t.Fatalf("Expected %v after the busy timeout, got %v", ErrDatabaseLocked, err)
+	}
+	if waited := time.Since(start); waited < 30*time.Millisecond {
+		t.Errorf("Expected to wait for the busy timeout, returned after %v", waited)
+	}
+
+	// A lock released within the timeout is picked up
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Close()
+		close(released)
+	}()
+	second, err := OpenWALWithBusyTimeout(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected OpenWALWithBusyTimeout to succeed once the lock is released, got %v", err)
+	}
+	<-released
+	second.Close()
+}
+
+func TestWAL_QuotedFields(t *testing.T) {
+	path := "test_wal.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	wal := NewWAL(path)
+	defer wal.Close()
+
+	values := map[string]string{
+		"spaces":  "SELECT * FROM t WHERE key LIKE 'a%'",
+		"empty":   "",
+		"quote":   `say "hi"`,
+		"newline": "line1\nline2",
+		"plain":   "value",
+	}
+	for key, value := range values {
+		wal.Append("", "table1", key, value)
+	}
+	wal.Append("", "my table", "a key", "v")
+	wal.Delete("", "my table", "a key")
+
+	replayedData, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	got := make(map[string]string)
+	for _, entry := range replayedData["table1"] {
+		got[entry[0]] = entry[1]
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Replayed data mismatch. Got %q, expected %q", got, values)
+	}
+	if _, ok := replayedData["my table"]; ok && len(replayedData["my table"]) > 0 {
+		t.Errorf("Expected the quoted key to be deleted, got %v", replayedData["my table"])
+	}
+}
+
+func TestWAL_StrayAndDuplicateCommits(t *testing.T) {
+	path := "test_wal.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	log := strings.Join([]string{
+		"SET t a 1",
+		"COMMIT_TX tx_never_begun", // stray, with nothing buffered
+		"SET tx_ghost t b garbage",
+		"COMMIT_TX tx_ghost", // stray, its record is discarded
+		"BEGIN_TX tx_1",
+		"SET tx_1 t c 3",
+		"COMMIT_TX tx_1",
+		"SET tx_1 t c overwritten",
+		"DELETE tx_1 t a",
+		"COMMIT_TX tx_1", // duplicate, its late records are discarded
+		"BEGIN_TX tx_2",
+		"SET tx_2 t d 4", // never committed
+		"",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal := NewWAL(path)
+	defer wal.Close()
+	replayedData, stats, err := wal.ReplayWithStats(nil)
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	got := make(map[string]string)
+	for _, entry := range replayedData["t"] {
+		got[entry[0]] = entry[1]
+	}
+	if expected := map[string]string{"a": "1", "c": "3"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Replayed data mismatch. Got %q, expected %q", got, expected)
+	}
+	if expected := (ReplayStats{StrayCommits: 2, DuplicateCommits: 1, IncompleteTxs: 1}); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestWAL_Vacuum(t *testing.T) {
+	path := "test_wal.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	wal := NewWAL(path)
+	defer wal.Close()
+	wal.Append("", "t", "a", "1")
+	wal.BeginTx("tx_dangling") // abandoned by a crash
+	wal.Append("tx_dangling", "t", "x", "lost")
+	wal.BeginTx("tx_1")
+	wal.Append("tx_1", "t", "b", "2")
+	wal.Delete("tx_1", "t", "a")
+	wal.CommitTx("tx_1")
+	wal.BeginTx("tx_2")
+	wal.Append("tx_2", "t", "c", "3")
+	wal.DropTable("tx_2", "t")
+	wal.RollbackTx("tx_2")
+	before, err := wal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := wal.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum error: %v", err)
+	}
+	if removed != 6 {
+		t.Errorf("Expected 6 records removed, got %d", removed)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SET t a 1\nBEGIN_TX tx_1\nSET tx_1 t b 2\nDELETE tx_1 t a\nCOMMIT_TX tx_1\n"
+	if string(data) != expected {
+		t.Errorf("Expected vacuumed log:\n%s\ngot:\n%s", expected, data)
+	}
+	after, err := wal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("Expected vacuum to keep the replayed state %v, got %v", before, after)
+	}
+
+	// Appends continue on the rewritten log
+	wal.Append("", "t", "d", "4")
+	if data, _ := os.ReadFile(path); !strings.HasSuffix(string(data), "SET t d 4\n") {
+		t.Errorf("Expected appends after vacuum to reach the log, got:\n%s", data)
+	}
+}
+
+func TestWAL_LastValue(t *testing.T) {
+	path := "test_wal.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	log := strings.Join([]string{
+		"SET t a 1",
+		"SET t b 1",
+		"SET t c 1",
+		"BEGIN_TX tx_1",
+		"SET tx_1 t a 2",
+		"SET t a 3", // autocommit write while tx_1 is open; tx_1 commits later and wins
+		"SET tx_1 t b 2",
+		"DELETE tx_1 t b", // a delete wins over the transaction's sets
+		"COMMIT_TX tx_1",
+		"BEGIN_TX tx_2",
+		"SET tx_2 t c rolled_back",
+		"ROLLBACK_TX tx_2",
+		"SET tx_ghost t c garbage",
+		"COMMIT_TX tx_ghost", // stray, ignored
+		"BEGIN_TX tx_3",
+		"SET tx_3 t d never_committed",
+		`SET t "e f" "with space"`,
+		"SET u x 1",
+		"DROP TABLE u",
+		"SET u y 1",
+		"DELETE u y",
+		"SET u z 2",
+		"",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal := NewWAL(path)
+	defer wal.Close()
+	tests := []struct {
+		table, key string
+		value      string
+		ok         bool
+	}{
+		{"t", "a", "2", true},
+		{"t", "b", "", false},
+		{"t", "c", "1", true},
+		{"t", "d", "", false},
+		{"t", "e f", "with space", true},
+		{"u", "x", "", false},
+		{"u", "y", "", false},
+		{"u", "z", "2", true},
+		{"missing", "a", "", false},
+	}
+	replayed, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	for _, tt := range tests {
+		value, ok, err := wal.LastValue(tt.table, tt.key)
+		if err != nil || value != tt.value || ok != tt.ok {
+			t.Errorf("LastValue(%s, %s) = %q, %v, %v; expected %q, %v", tt.table, tt.key, value, ok, err, tt.value, tt.ok)
+		}
+		// LastValue must agree with a full replay
+		replayedValue, replayedOK := "", false
+		for _, entry := range replayed[tt.table] {
+			if entry[0] == tt.key {
+				replayedValue, replayedOK = entry[1], true
+			}
+		}
+		if value != replayedValue || ok != replayedOK {
+			t.Errorf("LastValue(%s, %s) = %q, %v; Replay has %q, %v", tt.table, tt.key, value, ok, replayedValue, replayedOK)
+		}
+	}
+
+	// Records spanning several read chunks are reassembled
+	long := strings.Repeat("x", 3*reverseChunkSize)
+	wal.Append("", "t", "long", long)
+	wal.Append("", "t", "after", "1")
+	if value, ok, err := wal.LastValue("t", "long"); err != nil || !ok || value != long {
+		t.Errorf("LastValue(t, long) returned %d bytes, %v, %v; expected %d bytes", len(value), ok, err, len(long))
+	}
+}
+
+func TestWAL_Seal(t *testing.T) {
+	path := "test_wal.log"
+	_ = os.Remove(path)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	wal := NewWAL(path)
+	defer wal.Close()
+	wal.Append("", "t", "a", "1")
+	wal.Append("", "t", "b", "with space")
+	wal.BeginTx("tx_1")
+	wal.Append("tx_1", "t", "c", "3")
+	wal.CommitTx("tx_1")
+	wal.BeginTx("tx_2")
+	wal.Append("tx_2", "t", "d", "rolled back")
+	wal.RollbackTx("tx_2")
+
+	plain, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	if err := wal.Seal(); err != nil {
+		t.Fatalf("Seal error: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Fatalf("Expected a gzip-compressed log, got %q (%v)", data, err)
+	}
+	sealed, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay of the sealed log: %v", err)
+	}
+	if !reflect.DeepEqual(replayedMap(sealed), replayedMap(plain)) {
+		t.Errorf("Sealed log replayed as %v, expected %v", sealed, plain)
+	}
+
+	// Records appended after sealing follow the gzip stream uncompressed
+	wal.Append("", "t", "a", "2")
+	wal.Delete("", "t", "b")
+	mixed, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay of the mixed log: %v", err)
+	}
+	if expected := map[string]map[string]string{"t": {"a": "2", "c": "3"}}; !reflect.DeepEqual(replayedMap(mixed), expected) {
+		t.Errorf("Mixed log replayed as %v, expected %v", mixed, expected)
+	}
+	if value, ok, err := wal.LastValue("t", "c"); err != nil || !ok || value != "3" {
+		t.Errorf("LastValue(t, c) on a sealed log = %q, %v, %v", value, ok, err)
+	}
+
+	// Sealing again compresses the new records too, and Vacuum reads the sealed log
+	if err := wal.Seal(); err != nil {
+		t.Fatalf("Second Seal error: %v", err)
+	}
+	if removed, err := wal.Vacuum(); err != nil || removed != 3 {
+		t.Errorf("Vacuum of a sealed log removed %d record(s) (%v), expected 3", removed, err)
+	}
+	if vacuumed, err := wal.Replay(); err != nil || !reflect.DeepEqual(replayedMap(vacuumed), replayedMap(mixed)) {
+		t.Errorf("Vacuumed log replayed as %v (%v), expected %v", vacuumed, err, mixed)
+	}
+}
+
+// replayedMap converts Replay's result, whose order is not defined, to
+// table -> key -> value for comparisons.
+func replayedMap(tables map[string][][2]string) map[string]map[string]string {
+	result := make(map[string]map[string]string, len(tables))
+	for table, entries := range tables {
+		result[table] = make(map[string]string, len(entries))
+		for _, entry := range entries {
+			result[table][entry[0]] = entry[1]
+		}
+	}
+	return result
+}